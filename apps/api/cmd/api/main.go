@@ -9,9 +9,15 @@ import (
 	"syscall"
 	"time"
 
+	"cementops/api/internal/alerts"
 	"cementops/api/internal/config"
 	"cementops/api/internal/db"
 	"cementops/api/internal/httpapi"
+	"cementops/api/internal/mailer"
+	"cementops/api/internal/retention"
+	"cementops/api/internal/sessions"
+	"cementops/api/internal/storage"
+	"cementops/api/internal/uploads"
 )
 
 func main() {
@@ -33,9 +39,22 @@ func main() {
 		log.Fatalf("db seed: %v", err)
 	}
 
+	go alerts.NewEvaluator(pool, cfg.AlertEvalInterval).Run(ctx)
+	go mailer.NewWorker(pool, newMailSender(cfg), cfg.MailPollInterval).Run(ctx)
+	go sessions.NewSweeper(pool, cfg.SessionSweepInterval, cfg.SessionIdleTimeout).Run(ctx)
+	archiver := retention.NewArchiver(pool, retention.Policy{
+		AuditLogDays:          cfg.AuditLogRetentionDays,
+		InventoryMovementDays: cfg.InventoryMovementRetentionDays,
+		BatchSize:             cfg.RetentionBatchSize,
+	}, cfg.RetentionInterval)
+	go archiver.Run(ctx)
+	store := newStore(cfg)
+	collector := uploads.NewCollector(pool, store, httpapi.LegacyUploadsDir(), cfg.UploadGCGrace, cfg.UploadGCInterval)
+	go collector.Run(ctx)
+
 	srv := &http.Server{
 		Addr:              ":" + cfg.Port,
-		Handler:           httpapi.NewRouter(httpapi.Deps{DB: pool, Config: cfg}),
+		Handler:           httpapi.NewRouter(httpapi.Deps{DB: pool, Config: cfg, Archiver: archiver, Storage: store, Collector: collector}),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
@@ -55,3 +74,41 @@ func main() {
 	defer shutdownCancel()
 	_ = srv.Shutdown(shutdownCtx)
 }
+
+func newMailSender(cfg config.Config) mailer.Sender {
+	if cfg.MailDriver == "smtp" {
+		if cfg.SMTPHost == "" {
+			log.Fatalf("mail: MAIL_DRIVER=smtp requires SMTP_HOST")
+		}
+		return &mailer.SMTPSender{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	}
+	if cfg.MailDriver != "log" {
+		log.Printf("mail: unknown MAIL_DRIVER=%q, falling back to log", cfg.MailDriver)
+	}
+	return &mailer.LogSender{Dir: cfg.MailLogDir, From: cfg.MailFrom, LogBodies: cfg.MailLogBodies}
+}
+
+func newStore(cfg config.Config) storage.Store {
+	if cfg.StorageDriver == "s3" {
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			log.Fatalf("storage: STORAGE_DRIVER=s3 requires S3_ENDPOINT and S3_BUCKET")
+		}
+		return &storage.S3{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKeyID,
+			SecretKey: cfg.S3SecretAccessKey,
+		}
+	}
+	if cfg.StorageDriver != "local" {
+		log.Printf("storage: unknown STORAGE_DRIVER=%q, falling back to local", cfg.StorageDriver)
+	}
+	return &storage.Local{Dir: cfg.StorageDir}
+}
@@ -0,0 +1,310 @@
+// Command fakeidp is a throwaway OpenID Connect provider for exercising the
+// API's single sign-on locally. Its sign-in page lets you type whatever email,
+// name, roles and distributor id the ID token should carry, so role mapping
+// and just-in-time provisioning can be tried without a real identity provider.
+// Keys and codes live in memory; never expose it beyond localhost.
+//
+//	go run ./cmd/fakeidp -addr :9400
+//
+// and start the API with
+//
+//	OIDC_ISSUER_URL=http://localhost:9400 OIDC_CLIENT_ID=cementops \
+//	OIDC_CLIENT_SECRET=cementops-secret \
+//	OIDC_REDIRECT_URL=http://localhost:3000/api/auth/oidc/callback
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type authCode struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	claims      map[string]any
+	expires     time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	kid          string
+
+	mu    sync.Mutex
+	codes map[string]authCode
+}
+
+func main() {
+	addr := flag.String("addr", ":9400", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9400", "issuer URL (must match OIDC_ISSUER_URL)")
+	clientID := flag.String("client-id", "cementops", "accepted client_id")
+	clientSecret := flag.String("client-secret", "cementops-secret", "accepted client_secret")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("fakeidp: generate key: %v", err)
+	}
+	p := &provider{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		kid:          randomString(8),
+		codes:        map[string]authCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("GET /jwks", p.handleJWKS)
+	mux.HandleFunc("GET /authorize", p.handleAuthorizeForm)
+	mux.HandleFunc("POST /authorize", p.handleAuthorize)
+	mux.HandleFunc("POST /token", p.handleToken)
+
+	log.Printf("fakeidp: issuer %s listening on %s", p.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (p *provider) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) handleJWKS(w http.ResponseWriter, _ *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]any{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": p.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var formTmpl = template.Must(template.New("form").Parse(`<!doctype html>
+<html><head><title>Fake IdP</title>
+<style>body{font-family:sans-serif;max-width:28rem;margin:3rem auto}label{display:block;margin:.6rem 0}input{width:100%;padding:.3rem}</style>
+</head><body>
+<h2>Fake IdP sign-in</h2>
+<form method="post" action="/authorize">
+  {{range $k, $v := .Query}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}
+  <label>Subject (sub) <input name="sub" value="user-1"></label>
+  <label>Email <input name="email" value="operator@cementops.local"></label>
+  <label><input type="checkbox" name="email_verified" value="true" checked style="width:auto"> Email verified</label>
+  <label>Name <input name="name" value="SSO Operator"></label>
+  <label>Roles (comma separated) <input name="roles" value="OPERATOR"></label>
+  <label>Distributor id <input name="distributor_id" value=""></label>
+  <button type="submit">Sign in</button>
+  <button type="submit" name="deny" value="1">Deny</button>
+</form>
+</body></html>`))
+
+func (p *provider) handleAuthorizeForm(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if msg := p.checkAuthorizeParams(q); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = formTmpl.Execute(w, map[string]any{"Query": q})
+}
+
+func (p *provider) checkAuthorizeParams(q url.Values) string {
+	switch {
+	case q.Get("client_id") != p.clientID:
+		return "unknown client_id"
+	case q.Get("response_type") != "code":
+		return "response_type must be code"
+	case q.Get("redirect_uri") == "":
+		return "redirect_uri required"
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		return "S256 PKCE required"
+	}
+	return ""
+}
+
+func (p *provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	f := r.PostForm
+	if msg := p.checkAuthorizeParams(f); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(f.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	rq := redirect.Query()
+	rq.Set("state", f.Get("state"))
+	if f.Get("deny") != "" {
+		rq.Set("error", "access_denied")
+		redirect.RawQuery = rq.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+		return
+	}
+
+	claims := map[string]any{
+		"sub":            strings.TrimSpace(f.Get("sub")),
+		"email":          strings.TrimSpace(f.Get("email")),
+		"email_verified": f.Get("email_verified") == "true",
+		"name":           strings.TrimSpace(f.Get("name")),
+		"nonce":          f.Get("nonce"),
+	}
+	roles := []string{}
+	for _, role := range strings.Split(f.Get("roles"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	claims["roles"] = roles
+	if v := strings.TrimSpace(f.Get("distributor_id")); v != "" {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			claims["distributor_id"] = id
+		}
+	}
+
+	code := randomString(24)
+	p.mu.Lock()
+	p.codes[code] = authCode{
+		clientID:    f.Get("client_id"),
+		redirectURI: f.Get("redirect_uri"),
+		challenge:   f.Get("code_challenge"),
+		claims:      claims,
+		expires:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	rq.Set("code", code)
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	c, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !found || time.Now().After(c.expires) || c.clientID != clientID:
+		tokenError(w, "invalid_grant")
+		return
+	case c.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss": p.issuer,
+		"aud": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range c.claims {
+		claims[k] = v
+	}
+	idToken, err := p.sign(claims)
+	if err != nil {
+		http.Error(w, "sign failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(24),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *provider) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("fakeidp: random: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,152 @@
+// Command fakes3 is a throwaway S3-compatible object store for exercising the
+// API's S3 storage backend locally. It keeps objects in memory, understands
+// path-style PUT, GET, HEAD and DELETE on /{bucket}/{key}, and checks each
+// request's Signature Version 4 against its single access key, so signing
+// bugs show up here the way they would against MinIO or AWS. Never expose it
+// beyond localhost.
+//
+//	go run ./cmd/fakes3 -addr :9500
+//
+// and start the API with
+//
+//	STORAGE_DRIVER=s3 S3_ENDPOINT=http://localhost:9500 S3_BUCKET=cementops \
+//	S3_ACCESS_KEY_ID=cementops S3_SECRET_ACCESS_KEY=cementops-secret
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/xml"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cementops/api/internal/storage"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+type server struct {
+	region    string
+	accessKey string
+	secretKey string
+
+	mu      sync.Mutex
+	objects map[string]object // "bucket/key"
+}
+
+func main() {
+	addr := flag.String("addr", ":9500", "listen address")
+	region := flag.String("region", "us-east-1", "region expected in request signatures (must match S3_REGION)")
+	accessKey := flag.String("access-key", "cementops", "accepted access key id")
+	secretKey := flag.String("secret-key", "cementops-secret", "secret for the access key")
+	flag.Parse()
+
+	s := &server{region: *region, accessKey: *accessKey, secretKey: *secretKey, objects: map[string]object{}}
+	log.Printf("fake S3 listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<20))
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if code, msg := s.verify(r, body); code != "" {
+		log.Printf("%s %s: %s", r.Method, r.URL.Path, msg)
+		s3Error(w, http.StatusForbidden, code, msg)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" || key == "" {
+		s3Error(w, http.StatusBadRequest, "InvalidRequest", "path-style /bucket/key required")
+		return
+	}
+	name := bucket + "/" + key
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[name] = object{data: body, contentType: r.Header.Get("Content-Type"), modified: time.Now()}
+		w.Header().Set("ETag", `"`+storage.PayloadHash(body)[:32]+`"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := s.objects[name]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method")
+	}
+	log.Printf("%s %s (%d bytes)", r.Method, name, len(body))
+}
+
+// verify recomputes the request signature with the configured credentials and
+// compares it with the one sent, returning an S3 error code on mismatch.
+func (s *server) verify(r *http.Request, body []byte) (string, string) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return "AccessDenied", "missing AWS4-HMAC-SHA256 authorization"
+	}
+	if !strings.Contains(auth, "Credential="+s.accessKey+"/") {
+		return "InvalidAccessKeyId", "unknown access key"
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != storage.PayloadHash(body) {
+		return "XAmzContentSHA256Mismatch", "payload hash does not match body"
+	}
+	t, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "AccessDenied", "bad X-Amz-Date"
+	}
+	if d := time.Since(t); d > 15*time.Minute || d < -15*time.Minute {
+		return "RequestTimeTooSkewed", "request time too far from server time"
+	}
+
+	check, _ := http.NewRequest(r.Method, r.URL.String(), nil)
+	check.Host = r.Host
+	for k, v := range r.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+			check.Header[k] = v
+		}
+	}
+	storage.SignV4(check, payloadHash, s.region, s.accessKey, s.secretKey, t)
+	if subtle.ConstantTimeCompare([]byte(check.Header.Get("Authorization")), []byte(auth)) != 1 {
+		return "SignatureDoesNotMatch", "signature does not match"
+	}
+	return "", ""
+}
+
+func s3Error(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: msg})
+}
@@ -0,0 +1,415 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"cementops/api/internal/mailer"
+	"cementops/api/internal/notify"
+)
+
+// Rule types stored in alert_configs.rule_type.
+const (
+	RuleStockCritical = "STOCK_CRITICAL"
+	RuleShipmentDelay = "SHIPMENT_DELAY"
+	RuleDemandSpike   = "DEMAND_SPIKE"
+)
+
+// RuleTypes lists the rule types the evaluator knows how to check.
+var RuleTypes = []string{RuleStockCritical, RuleShipmentDelay, RuleDemandSpike}
+
+// Evaluator periodically checks the enabled alert_configs rules against live data
+// and records one open alert_events row per rule + subject until the condition clears.
+type Evaluator struct {
+	db       *pgxpool.Pool
+	interval time.Duration
+}
+
+func NewEvaluator(db *pgxpool.Pool, interval time.Duration) *Evaluator {
+	return &Evaluator{db: db, interval: interval}
+}
+
+// Run evaluates immediately and then on every tick until ctx is cancelled.
+func (e *Evaluator) Run(ctx context.Context) {
+	if e.interval <= 0 {
+		log.Printf("alerts: evaluator disabled")
+		return
+	}
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		if err := e.EvaluateOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("alerts: evaluate: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+type rule struct {
+	id         int64
+	name       string
+	ruleType   string
+	severity   string
+	roles      []string
+	users      []int64
+	channels   map[string]bool
+	params     map[string]any
+	recipients []int64
+}
+
+type match struct {
+	key        string
+	title      string
+	message    string
+	entityType string
+	entityID   string
+	metadata   map[string]any
+}
+
+// EvaluateOnce runs a single pass over all enabled rules.
+func (e *Evaluator) EvaluateOnce(ctx context.Context) error {
+	// Events of disabled rules are closed so re-enabling a rule starts from a clean slate.
+	if _, err := e.db.Exec(ctx, `
+    UPDATE alert_events SET resolved_at=now()
+    WHERE resolved_at IS NULL
+      AND alert_config_id IN (SELECT id FROM alert_configs WHERE NOT enabled)
+  `); err != nil {
+		return fmt.Errorf("resolve disabled: %w", err)
+	}
+
+	rules, err := e.loadRules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ru := range rules {
+		var matches []match
+		switch ru.ruleType {
+		case RuleStockCritical:
+			matches, err = e.checkStockCritical(ctx, ru)
+		case RuleShipmentDelay:
+			matches, err = e.checkShipmentDelay(ctx, ru)
+		case RuleDemandSpike:
+			matches, err = e.checkDemandSpike(ctx, ru)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("alerts: rule %d (%s): %v", ru.id, ru.ruleType, err)
+			continue
+		}
+		if err := e.record(ctx, ru, matches); err != nil {
+			log.Printf("alerts: rule %d (%s): record: %v", ru.id, ru.ruleType, err)
+		}
+	}
+	return nil
+}
+
+func (e *Evaluator) loadRules(ctx context.Context) ([]rule, error) {
+	rows, err := e.db.Query(ctx, `
+    SELECT id, name, rule_type, severity, recipients_roles, recipients_users, channels, params
+    FROM alert_configs
+    WHERE enabled AND rule_type <> ''
+    ORDER BY id
+  `)
+	if err != nil {
+		return nil, fmt.Errorf("load rules: %w", err)
+	}
+	defer rows.Close()
+	rules := []rule{}
+	for rows.Next() {
+		var ru rule
+		var channels, params json.RawMessage
+		if err := rows.Scan(&ru.id, &ru.name, &ru.ruleType, &ru.severity, &ru.roles, &ru.users, &channels, &params); err != nil {
+			return nil, fmt.Errorf("load rules: %w", err)
+		}
+		_ = json.Unmarshal(channels, &ru.channels)
+		_ = json.Unmarshal(params, &ru.params)
+		rules = append(rules, ru)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load rules: %w", err)
+	}
+
+	for i := range rules {
+		ids, err := e.resolveRecipients(ctx, rules[i].roles, rules[i].users)
+		if err != nil {
+			return nil, err
+		}
+		rules[i].recipients = ids
+	}
+	return rules, nil
+}
+
+// resolveRecipients expands recipient roles + explicit users into active user IDs.
+func (e *Evaluator) resolveRecipients(ctx context.Context, roles []string, users []int64) ([]int64, error) {
+	rows, err := e.db.Query(ctx, `
+    SELECT id FROM users
+    WHERE disabled_at IS NULL AND (role = ANY($1::text[]) OR id = ANY($2::bigint[]))
+    ORDER BY id
+  `, roles, users)
+	if err != nil {
+		return nil, fmt.Errorf("resolve recipients: %w", err)
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("resolve recipients: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// record upserts open events for the current matches and resolves the ones that no
+// longer match. Newly opened events are handed to deliver.
+func (e *Evaluator) record(ctx context.Context, ru rule, matches []match) error {
+	tx, err := e.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	keys := make([]string, 0, len(matches))
+	for _, m := range matches {
+		keys = append(keys, m.key)
+		meta := m.metadata
+		if meta == nil {
+			meta = map[string]any{}
+		}
+		metaBytes, _ := json.Marshal(meta)
+
+		var id int64
+		var inserted bool
+		if err := tx.QueryRow(ctx, `
+      INSERT INTO alert_events (alert_config_id, rule_type, dedup_key, severity, title, message, entity_type, entity_id, metadata, recipient_user_ids)
+      VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9::jsonb,$10)
+      ON CONFLICT (alert_config_id, dedup_key) WHERE resolved_at IS NULL
+      DO UPDATE SET message=EXCLUDED.message, metadata=EXCLUDED.metadata, last_seen_at=now()
+      RETURNING id, (xmax = 0) AS inserted
+    `, ru.id, ru.ruleType, m.key, ru.severity, m.title, m.message, m.entityType, m.entityID, string(metaBytes), ru.recipients).Scan(&id, &inserted); err != nil {
+			return err
+		}
+		if inserted {
+			if err := e.deliver(ctx, tx, ru, id, m); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+    UPDATE alert_events SET resolved_at=now()
+    WHERE alert_config_id=$1 AND resolved_at IS NULL AND NOT (dedup_key = ANY($2::text[]))
+  `, ru.id, keys); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// deliver routes a newly opened event to its recipients. The recipient list is
+// persisted on the event itself; channel fan-out hooks in here.
+func (e *Evaluator) deliver(ctx context.Context, tx pgx.Tx, ru rule, eventID int64, m match) error {
+	if len(ru.recipients) == 0 {
+		log.Printf("alerts: event %d (%s) has no active recipients", eventID, m.title)
+		return nil
+	}
+	if ru.channels["inApp"] {
+		if err := notify.ToUsers(ctx, tx, ru.recipients, notify.Notification{
+			Kind:       notify.KindAlert,
+			Title:      m.title,
+			Body:       m.message,
+			EntityType: m.entityType,
+			EntityID:   m.entityID,
+			Metadata: map[string]any{
+				"alertEventId":  eventID,
+				"alertConfigId": ru.id,
+				"ruleType":      ru.ruleType,
+				"severity":      ru.severity,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	if ru.channels["email"] {
+		if err := mailer.EnqueueToUsers(ctx, tx, ru.recipients, mailer.TemplateAlert, map[string]any{
+			"Title":      m.title,
+			"Message":    m.message,
+			"RuleName":   ru.name,
+			"Severity":   ru.severity,
+			"DetectedAt": time.Now().UTC().Format(time.RFC1123),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------- rule checks ----------
+
+// checkStockCritical flags stock rows at or below their threshold_settings critical level.
+func (e *Evaluator) checkStockCritical(ctx context.Context, ru rule) ([]match, error) {
+	rows, err := e.db.Query(ctx, `
+    SELECT w.id, w.name, s.cement_type, s.quantity_tons, t.critical_level
+    FROM stock_levels s
+    JOIN warehouses w ON w.id = s.warehouse_id
+    JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
+    WHERE s.quantity_tons <= t.critical_level AND w.archived_at IS NULL
+    ORDER BY w.id, s.cement_type
+  `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []match{}
+	for rows.Next() {
+		var wid int64
+		var wname, ct string
+		var qty, critical float64
+		if err := rows.Scan(&wid, &wname, &ct, &qty, &critical); err != nil {
+			return nil, err
+		}
+		out = append(out, match{
+			key:        fmt.Sprintf("stock:%d:%s", wid, ct),
+			title:      fmt.Sprintf("%s: %s stock critical", wname, ct),
+			message:    fmt.Sprintf("%s stock at %s is %.1f t (critical level %.1f t).", ct, wname, qty, critical),
+			entityType: "stock_levels",
+			entityID:   fmt.Sprintf("%d:%s", wid, ct),
+			metadata: map[string]any{
+				"warehouseId":   wid,
+				"cementType":    ct,
+				"quantityTons":  qty,
+				"criticalLevel": critical,
+			},
+		})
+	}
+	return out, rows.Err()
+}
+
+// checkShipmentDelay flags open shipments whose ETA passed more than the SLA ago.
+// params: {"threshold": N, "unit": "minutes"|"hours"}; defaults to 180 minutes.
+func (e *Evaluator) checkShipmentDelay(ctx context.Context, ru rule) ([]match, error) {
+	sla := paramFloat(ru.params, "threshold", 180)
+	if strings.EqualFold(paramString(ru.params, "unit"), "hours") {
+		sla *= 60
+	}
+	rows, err := e.db.Query(ctx, `
+    SELECT s.id, s.status, s.arrive_eta, w.name, d.id, d.name
+    FROM shipments s
+    JOIN warehouses w ON w.id = s.from_warehouse_id
+    JOIN distributors d ON d.id = s.to_distributor_id
+    WHERE s.status IN ('SCHEDULED','ON_DELIVERY','DELAYED')
+      AND s.arrive_eta IS NOT NULL
+      AND s.arrive_eta + ($1::double precision * INTERVAL '1 minute') < now()
+    ORDER BY s.id
+  `, sla)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	out := []match{}
+	for rows.Next() {
+		var id, did int64
+		var status, wname, dname string
+		var eta time.Time
+		if err := rows.Scan(&id, &status, &eta, &wname, &did, &dname); err != nil {
+			return nil, err
+		}
+		lateMin := int(now.Sub(eta).Minutes())
+		out = append(out, match{
+			key:        fmt.Sprintf("shipment:%d", id),
+			title:      fmt.Sprintf("Shipment #%d delayed", id),
+			message:    fmt.Sprintf("Shipment #%d from %s to %s is %d minutes past ETA (status %s).", id, wname, dname, lateMin, status),
+			entityType: "shipment",
+			entityID:   fmt.Sprintf("%d", id),
+			metadata: map[string]any{
+				"shipmentId":    id,
+				"distributorId": did,
+				"status":        status,
+				"arriveEta":     eta,
+				"minutesLate":   lateMin,
+				"slaMinutes":    sla,
+			},
+		})
+	}
+	return out, rows.Err()
+}
+
+// checkDemandSpike compares each distributor's last 7 days of sales_orders with
+// its average week over the 28 days before that.
+// params: {"threshold": pct}; defaults to 25%.
+func (e *Evaluator) checkDemandSpike(ctx context.Context, ru rule) ([]match, error) {
+	pct := paramFloat(ru.params, "threshold", 25)
+	rows, err := e.db.Query(ctx, `
+    WITH recent AS (
+      SELECT distributor_id, SUM(quantity_tons) AS qty
+      FROM sales_orders
+      WHERE order_date > CURRENT_DATE - 7
+      GROUP BY distributor_id
+    ), baseline AS (
+      SELECT distributor_id, SUM(quantity_tons) / 4.0 AS qty
+      FROM sales_orders
+      WHERE order_date > CURRENT_DATE - 35 AND order_date <= CURRENT_DATE - 7
+      GROUP BY distributor_id
+    )
+    SELECT d.id, d.name, r.qty, b.qty
+    FROM recent r
+    JOIN baseline b ON b.distributor_id = r.distributor_id
+    JOIN distributors d ON d.id = r.distributor_id
+    WHERE b.qty > 0 AND (r.qty - b.qty) / b.qty * 100 >= $1
+    ORDER BY d.id
+  `, pct)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []match{}
+	for rows.Next() {
+		var did int64
+		var dname string
+		var recent, baseline float64
+		if err := rows.Scan(&did, &dname, &recent, &baseline); err != nil {
+			return nil, err
+		}
+		growth := (recent - baseline) / baseline * 100
+		out = append(out, match{
+			key:        fmt.Sprintf("demand:%d", did),
+			title:      fmt.Sprintf("Demand spike at %s", dname),
+			message:    fmt.Sprintf("%s ordered %.1f t in the last 7 days, %.0f%% above its weekly average of %.1f t.", dname, recent, growth, baseline),
+			entityType: "distributor",
+			entityID:   fmt.Sprintf("%d", did),
+			metadata: map[string]any{
+				"distributorId": did,
+				"last7dTons":    recent,
+				"weeklyAvgTons": baseline,
+				"growthPct":     growth,
+				"thresholdPct":  pct,
+			},
+		})
+	}
+	return out, rows.Err()
+}
+
+func paramFloat(params map[string]any, key string, def float64) float64 {
+	if v, ok := params[key].(float64); ok && v > 0 {
+		return v
+	}
+	return def
+}
+
+func paramString(params map[string]any, key string) string {
+	v, _ := params[key].(string)
+	return v
+}
@@ -0,0 +1,254 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"cementops/api/internal/db"
+)
+
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	pool, err := db.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if err := db.Migrate(url, filepath.Join("..", "..", "..", "..", "db", "migrations")); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := db.Seed(ctx, pool); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	return pool
+}
+
+// testRule inserts a disabled alert config, so a real evaluator pass never
+// picks it up, and removes it with its events, notifications and emails.
+func testRule(t *testing.T, pool *pgxpool.Pool, recipients []int64) rule {
+	t.Helper()
+	ctx := context.Background()
+	name := "Alert test " + time.Now().Format("150405.000000")
+	var id int64
+	if err := pool.QueryRow(ctx, `
+    INSERT INTO alert_configs (name, enabled, severity, rule_type, channels)
+    VALUES ($1, false, 'High', $2, '{"inApp":true,"email":true}'::jsonb)
+    RETURNING id
+  `, name, RuleStockCritical).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = pool.Exec(ctx, `DELETE FROM notifications WHERE metadata->>'alertConfigId' = $1`, fmt.Sprint(id))
+		_, _ = pool.Exec(ctx, `DELETE FROM email_outbox WHERE subject LIKE '%' || $1 || '%'`, name)
+		_, _ = pool.Exec(ctx, `DELETE FROM alert_configs WHERE id=$1`, id)
+	})
+	return rule{
+		id:         id,
+		name:       name,
+		ruleType:   RuleStockCritical,
+		severity:   "High",
+		channels:   map[string]bool{"inApp": true, "email": true},
+		recipients: recipients,
+	}
+}
+
+func testMatch(ru rule, key, message string) match {
+	return match{key: key, title: ru.name + " " + key, message: message, entityType: "test", entityID: key}
+}
+
+type openEvent struct {
+	id      int64
+	message string
+}
+
+func openEvents(t *testing.T, pool *pgxpool.Pool, configID int64) map[string]openEvent {
+	t.Helper()
+	rows, err := pool.Query(context.Background(), `
+    SELECT dedup_key, id, message FROM alert_events WHERE alert_config_id=$1 AND resolved_at IS NULL
+  `, configID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := map[string]openEvent{}
+	for rows.Next() {
+		var key string
+		var ev openEvent
+		if err := rows.Scan(&key, &ev.id, &ev.message); err != nil {
+			t.Fatal(err)
+		}
+		out[key] = ev
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// deliveries counts the notifications and queued emails sent for a rule.
+func deliveries(t *testing.T, pool *pgxpool.Pool, ru rule) (notifications, emails int) {
+	t.Helper()
+	ctx := context.Background()
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM notifications WHERE metadata->>'alertConfigId' = $1`, fmt.Sprint(ru.id)).Scan(&notifications); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM email_outbox WHERE subject LIKE '%' || $1 || '%'`, ru.name).Scan(&emails); err != nil {
+		t.Fatal(err)
+	}
+	return notifications, emails
+}
+
+func TestRecordDedupAndResolve(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	e := NewEvaluator(pool, 0)
+	ru := testRule(t, pool, []int64{1})
+
+	if err := e.record(ctx, ru, []match{testMatch(ru, "a", "first"), testMatch(ru, "b", "first")}); err != nil {
+		t.Fatal(err)
+	}
+	first := openEvents(t, pool, ru.id)
+	if len(first) != 2 {
+		t.Fatalf("open events = %v, want a and b", first)
+	}
+	if n, m := deliveries(t, pool, ru); n != 2 || m != 2 {
+		t.Fatalf("deliveries = %d notifications, %d emails; want 2 and 2", n, m)
+	}
+
+	// Still matching: the open event is updated in place and not delivered
+	// again. No longer matching: the event is resolved.
+	if err := e.record(ctx, ru, []match{testMatch(ru, "a", "second")}); err != nil {
+		t.Fatal(err)
+	}
+	second := openEvents(t, pool, ru.id)
+	if len(second) != 1 || second["a"].id != first["a"].id || second["a"].message != "second" {
+		t.Fatalf("open events after second pass = %v", second)
+	}
+	if n, m := deliveries(t, pool, ru); n != 2 || m != 2 {
+		t.Fatalf("repeated match was delivered again: %d notifications, %d emails", n, m)
+	}
+	var resolved bool
+	if err := pool.QueryRow(ctx, `SELECT resolved_at IS NOT NULL FROM alert_events WHERE id=$1`, first["b"].id).Scan(&resolved); err != nil || !resolved {
+		t.Fatalf("event b resolved = %v, %v", resolved, err)
+	}
+
+	// A condition that returns opens a new event and alerts again.
+	if err := e.record(ctx, ru, []match{testMatch(ru, "a", "third"), testMatch(ru, "b", "again")}); err != nil {
+		t.Fatal(err)
+	}
+	third := openEvents(t, pool, ru.id)
+	if len(third) != 2 || third["b"].id == first["b"].id || third["a"].id != first["a"].id {
+		t.Fatalf("open events after third pass = %v", third)
+	}
+	if n, m := deliveries(t, pool, ru); n != 3 || m != 3 {
+		t.Fatalf("deliveries = %d notifications, %d emails; want 3 and 3", n, m)
+	}
+
+	if err := e.record(ctx, ru, nil); err != nil {
+		t.Fatal(err)
+	}
+	if open := openEvents(t, pool, ru.id); len(open) != 0 {
+		t.Fatalf("events still open without matches: %v", open)
+	}
+}
+
+func TestRecordDelivery(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	e := NewEvaluator(pool, 0)
+
+	// Without active recipients the event is still recorded, just not sent.
+	nobody := testRule(t, pool, []int64{})
+	if err := e.record(ctx, nobody, []match{testMatch(nobody, "a", "x")}); err != nil {
+		t.Fatal(err)
+	}
+	if open := openEvents(t, pool, nobody.id); len(open) != 1 {
+		t.Fatalf("open events = %v", open)
+	}
+	if n, m := deliveries(t, pool, nobody); n != 0 || m != 0 {
+		t.Fatalf("deliveries = %d notifications, %d emails; want none", n, m)
+	}
+
+	// Channels are honoured per rule.
+	inAppOnly := testRule(t, pool, []int64{1, 3})
+	inAppOnly.channels = map[string]bool{"inApp": true}
+	if err := e.record(ctx, inAppOnly, []match{testMatch(inAppOnly, "a", "x")}); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := deliveries(t, pool, inAppOnly); n != 2 || m != 0 {
+		t.Fatalf("deliveries = %d notifications, %d emails; want 2 and 0", n, m)
+	}
+	var recipients []int64
+	if err := pool.QueryRow(ctx, `SELECT recipient_user_ids FROM alert_events WHERE alert_config_id=$1`, inAppOnly.id).Scan(&recipients); err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 2 || recipients[0] != 1 || recipients[1] != 3 {
+		t.Fatalf("recipient_user_ids = %v", recipients)
+	}
+}
+
+func TestCheckStockCritical(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	e := NewEvaluator(pool, 0)
+	ct := "ALERT-TEST-" + time.Now().Format("150405.000000")
+	var wid int64
+	if err := pool.QueryRow(ctx, `SELECT id FROM warehouses WHERE archived_at IS NULL ORDER BY id LIMIT 1`).Scan(&wid); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = pool.Exec(ctx, `DELETE FROM threshold_settings WHERE cement_type=$1`, ct)
+		_, _ = pool.Exec(ctx, `DELETE FROM stock_levels WHERE cement_type=$1`, ct)
+	})
+	if _, err := pool.Exec(ctx, `INSERT INTO stock_levels (warehouse_id, cement_type, quantity_tons) VALUES ($1,$2,4)`, wid, ct); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec(ctx, `
+    INSERT INTO threshold_settings (warehouse_id, cement_type, min_stock, safety_stock, warning_level, critical_level, lead_time_days)
+    VALUES ($1,$2,20,15,10,5,3)
+  `, wid, ct); err != nil {
+		t.Fatal(err)
+	}
+
+	key := fmt.Sprintf("stock:%d:%s", wid, ct)
+	find := func() *match {
+		t.Helper()
+		matches, err := e.checkStockCritical(ctx, rule{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range matches {
+			if matches[i].key == key {
+				return &matches[i]
+			}
+		}
+		return nil
+	}
+	m := find()
+	if m == nil {
+		t.Fatalf("no match for %s at 4 t with critical level 5 t", key)
+	}
+	if m.metadata["quantityTons"] != 4.0 || m.metadata["criticalLevel"] != 5.0 {
+		t.Fatalf("metadata = %v", m.metadata)
+	}
+
+	if _, err := pool.Exec(ctx, `UPDATE stock_levels SET quantity_tons=6 WHERE cement_type=$1`, ct); err != nil {
+		t.Fatal(err)
+	}
+	if m := find(); m != nil {
+		t.Fatalf("stock above the critical level still matches: %+v", m)
+	}
+}
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"log"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +17,110 @@ type Config struct {
 	SessionSecret string
 	CookieSecure  bool
 	MigrationsDir string
+
+	// AlertEvalInterval controls how often alert_configs rules are evaluated.
+	// Zero disables the background evaluator.
+	AlertEvalInterval time.Duration
+
+	// Outgoing mail. MailDriver is "smtp" or "log" (the default), which only
+	// logs messages or writes them as .eml files under MailLogDir. The log
+	// driver prints message bodies only when MailLogBodies is set.
+	MailDriver       string
+	MailFrom         string
+	MailLogDir       string
+	MailLogBodies    bool
+	MailPollInterval time.Duration
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+
+	// Sessions expire SessionAbsoluteTimeout after login, or earlier once unused
+	// for SessionIdleTimeout (zero disables the idle check). The sweeper deletes
+	// expired rows every SessionSweepInterval.
+	SessionAbsoluteTimeout time.Duration
+	SessionIdleTimeout     time.Duration
+	SessionSweepInterval   time.Duration
+
+	// MFAEncryptionKey seals TOTP secrets at rest; defaults to SessionSecret.
+	MFAEncryptionKey string
+	// MFAIssuer is the account label shown in authenticator apps.
+	MFAIssuer string
+
+	// Login throttling. After LoginMaxFailures failed attempts for one email (or
+	// LoginIPMaxFailures from one client address) within LoginFailureWindow,
+	// further attempts are refused for LoginLockoutDuration. Zero disables a limit.
+	LoginMaxFailures     int
+	LoginIPMaxFailures   int
+	LoginFailureWindow   time.Duration
+	LoginLockoutDuration time.Duration
+
+	// TrustedProxies lists the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers are believed. Requests from any other peer are keyed
+	// on their socket address, so clients cannot pick their own IP.
+	TrustedProxies []netip.Prefix
+
+	// OIDC single sign-on. Disabled unless OIDCIssuerURL is set. The role is read
+	// from OIDCRoleClaim (a string or array); OIDCRoleMap translates IdP values to
+	// role keys, and when empty the values must already be role keys.
+	// OIDCDistributorClaim carries the distributor id for distributor roles.
+	// With OIDCAutoProvision, unknown users are created on first sign-in.
+	OIDCIssuerURL        string
+	OIDCClientID         string
+	OIDCClientSecret     string
+	OIDCRedirectURL      string
+	OIDCScopes           []string
+	OIDCDisplayName      string
+	OIDCRoleClaim        string
+	OIDCRoleMap          map[string]string
+	OIDCDistributorClaim string
+	OIDCAutoProvision    bool
+
+	// Password policy. Passwords shorter than PasswordMinLength, on the built-in
+	// common-password list or (with PasswordCheckBreached) found in breach
+	// corpora are rejected.
+	PasswordMinLength     int
+	PasswordCheckBreached bool
+	// PasswordResetTTL is how long a forgot-password link stays valid.
+	// AppBaseURL is the web app origin used to build that link.
+	PasswordResetTTL time.Duration
+	AppBaseURL       string
+
+	// AllowedOrigins lists the browser origins (scheme://host[:port]) that may
+	// send state-changing requests with the session cookie. Defaults to
+	// AppBaseURL.
+	AllowedOrigins []string
+
+	// Retention. audit_logs and inventory_movements rows older than the given
+	// number of days are moved to their archive tables every RetentionInterval,
+	// RetentionBatchSize rows per transaction. Zero days keeps a table's rows
+	// live; a zero interval disables the archiver.
+	AuditLogRetentionDays          int
+	InventoryMovementRetentionDays int
+	RetentionInterval              time.Duration
+	RetentionBatchSize             int
+
+	// Attachment storage. StorageDriver is "local" (the default), which keeps
+	// files under StorageDir, or "s3" for an S3-compatible bucket.
+	StorageDriver     string
+	StorageDir        string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+
+	// Attachment limits. A single upload may be at most AttachmentMaxBytes, and
+	// an issue holds at most AttachmentsPerIssue files totalling
+	// AttachmentIssueMaxBytes.
+	AttachmentMaxBytes      int64
+	AttachmentsPerIssue     int
+	AttachmentIssueMaxBytes int64
+
+	// Uploads not linked to an issue, shipment or order within UploadGCGrace
+	// are deleted every UploadGCInterval (zero disables the collector).
+	UploadGCGrace    time.Duration
+	UploadGCInterval time.Duration
 }
 
 func Load() Config {
@@ -40,13 +149,240 @@ func Load() Config {
 		migrationsDir = defaultMigrationsDir()
 	}
 
+	mailDriver := strings.ToLower(strings.TrimSpace(os.Getenv("MAIL_DRIVER")))
+	if mailDriver == "" {
+		mailDriver = "log"
+	}
+	mailFrom := strings.TrimSpace(os.Getenv("MAIL_FROM"))
+	if mailFrom == "" {
+		mailFrom = "CementOps <no-reply@cementops.local>"
+	}
+
+	mfaKey := os.Getenv("MFA_ENCRYPTION_KEY")
+	if mfaKey == "" {
+		mfaKey = sessionSecret
+	}
+	mfaIssuer := strings.TrimSpace(os.Getenv("MFA_ISSUER"))
+	if mfaIssuer == "" {
+		mfaIssuer = "CementOps"
+	}
+
+	oidcScopes := strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " "))
+	if len(oidcScopes) == 0 {
+		oidcScopes = []string{"openid", "email", "profile"}
+	}
+	oidcDisplayName := strings.TrimSpace(os.Getenv("OIDC_DISPLAY_NAME"))
+	if oidcDisplayName == "" {
+		oidcDisplayName = "Single sign-on"
+	}
+	oidcRoleClaim := strings.TrimSpace(os.Getenv("OIDC_ROLE_CLAIM"))
+	if oidcRoleClaim == "" {
+		oidcRoleClaim = "roles"
+	}
+	oidcDistributorClaim := strings.TrimSpace(os.Getenv("OIDC_DISTRIBUTOR_CLAIM"))
+	if oidcDistributorClaim == "" {
+		oidcDistributorClaim = "distributor_id"
+	}
+
+	appBaseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("APP_BASE_URL")), "/")
+	if appBaseURL == "" {
+		appBaseURL = "http://localhost:3000"
+	}
+	allowedOrigins := []string{}
+	for _, o := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if o = strings.ToLower(strings.TrimRight(strings.TrimSpace(o), "/")); o != "" {
+			allowedOrigins = append(allowedOrigins, o)
+		}
+	}
+	if len(allowedOrigins) == 0 {
+		allowedOrigins = []string{strings.ToLower(appBaseURL)}
+		// Local development is reached as both localhost and 127.0.0.1.
+		if u, err := url.Parse(appBaseURL); err == nil && u.Hostname() == "localhost" {
+			allowedOrigins = append(allowedOrigins, strings.ToLower(u.Scheme+"://127.0.0.1"+strings.TrimPrefix(u.Host, "localhost")))
+		}
+	}
+	storageDriver := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_DRIVER")))
+	if storageDriver == "" {
+		storageDriver = "local"
+	}
+	storageDir := strings.TrimSpace(os.Getenv("STORAGE_DIR"))
+	if storageDir == "" {
+		storageDir = "uploads"
+		if wd, err := os.Getwd(); err == nil {
+			storageDir = filepath.Join(wd, "uploads")
+		}
+	}
+	s3Region := strings.TrimSpace(os.Getenv("S3_REGION"))
+	if s3Region == "" {
+		s3Region = "us-east-1"
+	}
+
+	passwordResetTTL := durationEnv("PASSWORD_RESET_TTL", time.Hour)
+	if passwordResetTTL <= 0 {
+		passwordResetTTL = time.Hour
+	}
+
+	// A zero absolute timeout would log everyone out on every request.
+	sessionAbsoluteTimeout := durationEnv("SESSION_ABSOLUTE_TIMEOUT", 7*24*time.Hour)
+	if sessionAbsoluteTimeout <= 0 {
+		sessionAbsoluteTimeout = 7 * 24 * time.Hour
+	}
+
 	return Config{
 		DatabaseURL:   normalizeDatabaseURL(databaseURL),
 		Port:          port,
 		SessionSecret: sessionSecret,
 		CookieSecure:  cookieSecure,
 		MigrationsDir: migrationsDir,
+
+		AlertEvalInterval: durationEnv("ALERT_EVAL_INTERVAL", time.Minute),
+
+		MailDriver:       mailDriver,
+		MailFrom:         mailFrom,
+		MailLogDir:       strings.TrimSpace(os.Getenv("MAIL_LOG_DIR")),
+		MailLogBodies:    boolEnv("MAIL_LOG_BODIES"),
+		MailPollInterval: durationEnv("MAIL_POLL_INTERVAL", 15*time.Second),
+		SMTPHost:         strings.TrimSpace(os.Getenv("SMTP_HOST")),
+		SMTPPort:         intEnv("SMTP_PORT", 587),
+		SMTPUsername:     os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+
+		SessionAbsoluteTimeout: sessionAbsoluteTimeout,
+		SessionIdleTimeout:     durationEnv("SESSION_IDLE_TIMEOUT", 12*time.Hour),
+		SessionSweepInterval:   durationEnv("SESSION_SWEEP_INTERVAL", 10*time.Minute),
+
+		MFAEncryptionKey: mfaKey,
+		MFAIssuer:        mfaIssuer,
+
+		LoginMaxFailures:     intEnv("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:   intEnv("LOGIN_IP_MAX_FAILURES", 30),
+		LoginFailureWindow:   durationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LoginLockoutDuration: durationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+
+		TrustedProxies: prefixesEnv("TRUSTED_PROXIES"),
+
+		OIDCIssuerURL:        strings.TrimSpace(os.Getenv("OIDC_ISSUER_URL")),
+		OIDCClientID:         strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")),
+		OIDCClientSecret:     os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:      strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL")),
+		OIDCScopes:           oidcScopes,
+		OIDCDisplayName:      oidcDisplayName,
+		OIDCRoleClaim:        oidcRoleClaim,
+		OIDCRoleMap:          mapEnv("OIDC_ROLE_MAP"),
+		OIDCDistributorClaim: oidcDistributorClaim,
+		OIDCAutoProvision:    boolEnv("OIDC_AUTO_PROVISION"),
+
+		PasswordMinLength:     intEnv("PASSWORD_MIN_LENGTH", 10),
+		PasswordCheckBreached: boolEnv("PASSWORD_CHECK_BREACHED"),
+		PasswordResetTTL:      passwordResetTTL,
+		AppBaseURL:            appBaseURL,
+
+		AllowedOrigins: allowedOrigins,
+
+		AuditLogRetentionDays:          intEnv("AUDIT_LOG_RETENTION_DAYS", 365),
+		InventoryMovementRetentionDays: intEnv("INVENTORY_MOVEMENT_RETENTION_DAYS", 365),
+		RetentionInterval:              durationEnv("RETENTION_INTERVAL", 24*time.Hour),
+		RetentionBatchSize:             intEnv("RETENTION_BATCH_SIZE", 5000),
+
+		StorageDriver:     storageDriver,
+		StorageDir:        storageDir,
+		S3Endpoint:        strings.TrimSpace(os.Getenv("S3_ENDPOINT")),
+		S3Region:          s3Region,
+		S3Bucket:          strings.TrimSpace(os.Getenv("S3_BUCKET")),
+		S3AccessKeyID:     strings.TrimSpace(os.Getenv("S3_ACCESS_KEY_ID")),
+		S3SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+
+		AttachmentMaxBytes:      int64(intEnv("ATTACHMENT_MAX_BYTES", 10<<20)),
+		AttachmentsPerIssue:     intEnv("ATTACHMENTS_PER_ISSUE", 10),
+		AttachmentIssueMaxBytes: int64(intEnv("ATTACHMENT_ISSUE_MAX_BYTES", 40<<20)),
+
+		UploadGCGrace:    durationEnv("UPLOAD_GC_GRACE", 24*time.Hour),
+		UploadGCInterval: durationEnv("UPLOAD_GC_INTERVAL", time.Hour),
+	}
+}
+
+// durationEnv parses a Go duration (e.g. "90s", "5m") from the environment,
+// falling back to def when unset or invalid.
+func durationEnv(name string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("config: invalid %s=%q, using %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// intEnv parses an integer from the environment, falling back to def when unset or invalid.
+func intEnv(name string, def int) int {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("config: invalid %s=%q, using %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// boolEnv reports whether the variable is set to "1" or "true".
+func boolEnv(name string) bool {
+	v := strings.TrimSpace(os.Getenv(name))
+	return v == "1" || strings.EqualFold(v, "true")
+}
+
+// prefixesEnv parses a comma-separated list of CIDR ranges or single IP
+// addresses from the environment. Malformed entries are logged and skipped.
+func prefixesEnv(name string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				log.Printf("config: ignoring malformed %s entry %q", name, item)
+				continue
+			}
+			out = append(out, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			log.Printf("config: ignoring malformed %s entry %q", name, item)
+			continue
+		}
+		addr = addr.Unmap()
+		out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return out
+}
+
+// mapEnv parses "key=value,key=value" pairs from the environment. Malformed
+// pairs are logged and skipped.
+func mapEnv(name string) map[string]string {
+	out := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(name), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			log.Printf("config: ignoring malformed %s entry %q", name, pair)
+			continue
+		}
+		out[k] = v
 	}
+	return out
 }
 
 func defaultMigrationsDir() string {
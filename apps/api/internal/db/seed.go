@@ -25,6 +25,8 @@ func Seed(ctx context.Context, pool *pgxpool.Pool) error {
 
 	// Users (4-role model)
 	// NOTE: use upsert so dev DBs created with older seed emails/passwords can still login.
+	// These are the documented demo credentials, so they bypass the password policy
+	// that every user-chosen password goes through (see internal/password).
 	superHash, _ := bcrypt.GenerateFromPassword([]byte("super123"), bcrypt.DefaultCost)
 	mgmtHash, _ := bcrypt.GenerateFromPassword([]byte("management123"), bcrypt.DefaultCost)
 	opHash, _ := bcrypt.GenerateFromPassword([]byte("operator123"), bcrypt.DefaultCost)
@@ -59,6 +61,24 @@ func Seed(ctx context.Context, pool *pgxpool.Pool) error {
   `); err != nil {
 		return fmt.Errorf("seed plant: %w", err)
 	}
+	// Plant capacity and the last week's output at roughly 80% of it.
+	for ct, capacity := range map[string]float64{"OPC": 1200, "PPC": 900, "SRC": 400} {
+		if _, err := tx.Exec(ctx, `
+      INSERT INTO plant_capacities (plant_id, cement_type, daily_capacity_tons)
+      VALUES (1, $1, $2)
+      ON CONFLICT (plant_id, cement_type) DO NOTHING
+    `, ct, capacity); err != nil {
+			return fmt.Errorf("seed plant_capacities: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `
+      INSERT INTO plant_production (plant_id, cement_type, production_date, quantity_tons, recorded_by_user_id)
+      SELECT 1, $1, d::date, round(($2 * (0.7 + random() * 0.2))::numeric, 1), 3
+      FROM generate_series(CURRENT_DATE - 6, CURRENT_DATE - 1, INTERVAL '1 day') AS d
+      ON CONFLICT (plant_id, cement_type, production_date) DO NOTHING
+    `, ct, capacity); err != nil {
+			return fmt.Errorf("seed plant_production: %w", err)
+		}
+	}
 
 	// Warehouses
 	type point struct{ lat, lng float64 }
@@ -417,8 +437,8 @@ func Seed(ctx context.Context, pool *pgxpool.Pool) error {
 		}
 	}
 
-	// RBAC config (stored in DB, used by Administration UI)
-	// Keep JSON compact; UI can render/edit it.
+	// RBAC config (stored in DB, edited in the Administration UI and enforced by the API).
+	// Keep JSON compact; UI can render/edit it. Existing rows are left alone so edits survive restarts.
 	if _, err := tx.Exec(ctx, `
     INSERT INTO rbac_config (role, config)
     VALUES
@@ -426,10 +446,17 @@ func Seed(ctx context.Context, pool *pgxpool.Pool) error {
 	  ('MANAGEMENT',  '{"permissions":{"Planning":{"view":true,"create":false,"edit":false,"delete":false},"Operations":{"view":true,"create":false,"edit":false,"delete":false},"Executive":{"view":true,"create":false,"edit":false,"delete":false},"Administration":{"view":false,"create":false,"edit":false,"delete":false}},"sidebar":["Dashboard","Planning","Operations","Executive"]}'::jsonb),
 	  ('OPERATOR',    '{"permissions":{"Planning":{"view":false,"create":false,"edit":false,"delete":false},"Operations":{"view":true,"create":true,"edit":true,"delete":false},"Executive":{"view":false,"create":false,"edit":false,"delete":false},"Administration":{"view":false,"create":false,"edit":false,"delete":false}},"sidebar":["Dashboard","Operations"]}'::jsonb),
 	  ('DISTRIBUTOR', '{"permissions":{"Planning":{"view":false,"create":false,"edit":false,"delete":false},"Operations":{"view":false,"create":false,"edit":false,"delete":false},"Executive":{"view":false,"create":false,"edit":false,"delete":false},"Administration":{"view":false,"create":false,"edit":false,"delete":false}},"sidebar":["Dashboard","Distributor"]}'::jsonb)
-	  ON CONFLICT (role) DO UPDATE SET config = EXCLUDED.config
+	  ON CONFLICT (role) DO NOTHING
   `); err != nil {
 		return fmt.Errorf("seed rbac_config: %w", err)
 	}
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO rbac_config_versions (role, version, config)
+    SELECT role, version, config FROM rbac_config
+    ON CONFLICT (role, version) DO NOTHING
+  `); err != nil {
+		return fmt.Errorf("seed rbac_config_versions: %w", err)
+	}
 
 	// Threshold settings defaults
 	for _, w := range warehouses {
@@ -451,11 +478,11 @@ func Seed(ctx context.Context, pool *pgxpool.Pool) error {
 
 	// Alert configs defaults
 	if _, err := tx.Exec(ctx, `
-    INSERT INTO alert_configs (id, name, description, enabled, severity, recipients_roles, recipients_users, channels, params)
+    INSERT INTO alert_configs (id, name, description, enabled, severity, recipients_roles, recipients_users, channels, params, rule_type)
     VALUES
-      (1, 'Stock Critical', 'Trigger when stock drops below critical threshold.', true, 'High', ARRAY['SUPER_ADMIN','MANAGEMENT']::text[], ARRAY[1]::bigint[], '{"inApp":true,"email":true}'::jsonb, '{"threshold":20,"unit":"%"}'::jsonb),
-      (2, 'Shipment Delay', 'Notify if delivery is delayed beyond SLA.', true, 'Medium', ARRAY['OPERATOR']::text[], ARRAY[3]::bigint[], '{"inApp":true,"email":false}'::jsonb, '{"threshold":180,"unit":"minutes"}'::jsonb),
-      (3, 'Demand Spike', 'Detect sudden demand increases.', false, 'Low', ARRAY['MANAGEMENT']::text[], ARRAY[]::bigint[], '{"inApp":true,"email":true}'::jsonb, '{"threshold":25,"unit":"%"}'::jsonb)
+      (1, 'Stock Critical', 'Trigger when stock drops below critical threshold.', true, 'High', ARRAY['SUPER_ADMIN','MANAGEMENT']::text[], ARRAY[1]::bigint[], '{"inApp":true,"email":true}'::jsonb, '{"threshold":20,"unit":"%"}'::jsonb, 'STOCK_CRITICAL'),
+      (2, 'Shipment Delay', 'Notify if delivery is delayed beyond SLA.', true, 'Medium', ARRAY['OPERATOR']::text[], ARRAY[3]::bigint[], '{"inApp":true,"email":false}'::jsonb, '{"threshold":180,"unit":"minutes"}'::jsonb, 'SHIPMENT_DELAY'),
+      (3, 'Demand Spike', 'Detect sudden demand increases.', false, 'Low', ARRAY['MANAGEMENT']::text[], ARRAY[]::bigint[], '{"inApp":true,"email":true}'::jsonb, '{"threshold":25,"unit":"%"}'::jsonb, 'DEMAND_SPIKE')
     ON CONFLICT (id) DO NOTHING
   `); err != nil {
 		return fmt.Errorf("seed alert_configs: %w", err)
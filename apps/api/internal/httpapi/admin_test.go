@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"cementops/api/internal/config"
+)
+
+// testRole inserts a STAFF role with the given matrix and removes it, its
+// users and its config versions again.
+func testRole(t *testing.T, pool *pgxpool.Pool, prefix, matrix string) string {
+	t.Helper()
+	ctx := context.Background()
+	key := prefix + "_" + time.Now().Format("150405000000")
+	if _, err := pool.Exec(ctx, `INSERT INTO roles (key, name, description, kind) VALUES ($1,$1,'','STAFF')`, key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = pool.Exec(ctx, `DELETE FROM users WHERE role=$1`, key)
+		_, _ = pool.Exec(ctx, `DELETE FROM rbac_config_versions WHERE role=$1`, key)
+		_, _ = pool.Exec(ctx, `DELETE FROM rbac_config WHERE role=$1`, key)
+		_, _ = pool.Exec(ctx, `DELETE FROM roles WHERE key=$1`, key)
+	})
+	if _, err := pool.Exec(ctx, `INSERT INTO rbac_config (role, config) VALUES ($1,$2::jsonb)`, key, matrix); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestAdminDelegateLimits gives a STAFF role full Administration rights and
+// checks it still cannot reach administrator accounts or grant more than it
+// holds.
+func TestAdminDelegateLimits(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	cfg := config.Load()
+	h := NewRouter(Deps{DB: pool, Config: cfg})
+
+	delegate := testRole(t, pool, "DELEGATE", `{"permissions":{
+    "Administration":{"view":true,"create":true,"edit":true,"delete":true},
+    "Planning":{"view":true}
+  }}`)
+	target := testRole(t, pool, "TARGET", `{"permissions":{}}`)
+	var id int64
+	if err := pool.QueryRow(ctx, `
+    INSERT INTO users (name, email, password_hash, role) VALUES ('Delegate', $1, 'x', $2) RETURNING id
+  `, strings.ToLower(delegate)+"@example.test", delegate).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	do := testClient(t, pool, cfg, h, id)
+
+	forbidden := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/admin/users", `{"name":"X","email":"x-delegate@example.test","password":"Corr3ct-Horse-Battery","role":"SUPER_ADMIN"}`},
+		{http.MethodPost, "/api/admin/users", `{"name":"X","email":"x-delegate@example.test","password":"Corr3ct-Horse-Battery","role":"OPERATOR"}`},
+		{http.MethodPut, fmt.Sprintf("/api/admin/users/%d", id), fmt.Sprintf(`{"name":"Delegate","email":%q,"role":"SUPER_ADMIN"}`, strings.ToLower(delegate)+"@example.test")},
+		{http.MethodPut, "/api/admin/users/1", `{"name":"SuperAdmin","email":"superadmin@cementops.local","role":"SUPER_ADMIN"}`},
+		{http.MethodPatch, "/api/admin/users/1/status", `{"status":"DISABLED"}`},
+		{http.MethodDelete, "/api/admin/users/1", ``},
+		{http.MethodPost, "/api/admin/users/1/reset-password", `{}`},
+		{http.MethodDelete, "/api/admin/users/1/sessions", ``},
+		{http.MethodDelete, "/api/admin/users/1/mfa", ``},
+		{http.MethodPut, "/api/admin/rbac/" + delegate, `{"permissions":{"Operations":{"view":true,"edit":true}}}`},
+		{http.MethodPut, "/api/admin/rbac/" + target, `{"permissions":{"Operations":{"view":true,"edit":true}}}`},
+		{http.MethodPost, "/api/admin/roles", `{"key":"` + target + `_COPY","name":"Copy","copyFrom":"OPERATOR"}`},
+	}
+	for _, tc := range forbidden {
+		if rec := do(tc.method, tc.path, tc.body); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s %s = %d %s, want 403", tc.method, tc.path, tc.body, rec.Code, rec.Body.String())
+		}
+	}
+	var disabled bool
+	if err := pool.QueryRow(ctx, `SELECT disabled_at IS NOT NULL FROM users WHERE id=1`).Scan(&disabled); err != nil || disabled {
+		t.Fatalf("super admin disabled = %v, %v", disabled, err)
+	}
+
+	// Granting what the delegate holds itself is still allowed.
+	if rec := do(http.MethodPut, "/api/admin/rbac/"+target, `{"permissions":{"Planning":{"view":true}}}`); rec.Code != http.StatusOK {
+		t.Fatalf("grant Planning.view = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRBACGrantBeyond(t *testing.T) {
+	own := rbacConfig{Permissions: map[string]rbacActions{"Planning": {View: true, Edit: true}}}
+	prev := rbacConfig{Permissions: map[string]rbacActions{"Operations": {View: true}}}
+	cases := []struct {
+		next rbacConfig
+		want string
+	}{
+		{rbacConfig{Permissions: map[string]rbacActions{"Planning": {View: true, Edit: true}}}, ""},
+		// Keeping a permission the role already had is not a grant.
+		{rbacConfig{Permissions: map[string]rbacActions{"Operations": {View: true}}}, ""},
+		{rbacConfig{Permissions: map[string]rbacActions{"Operations": {View: true, Edit: true}}}, "Operations.edit"},
+		{rbacConfig{Permissions: map[string]rbacActions{"Planning": {Delete: true}}}, "Planning.delete"},
+		{rbacConfig{}, ""},
+	}
+	for _, tc := range cases {
+		if got := rbacGrantBeyond(own, prev, tc.next); got != tc.want {
+			t.Errorf("rbacGrantBeyond(%+v) = %q, want %q", tc.next.Permissions, got, tc.want)
+		}
+	}
+}
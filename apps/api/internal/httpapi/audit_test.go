@@ -0,0 +1,91 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestAuditChainHashFormat(t *testing.T) {
+	actor := int64(42)
+	ts := time.Date(2026, 3, 1, 9, 30, 15, 123456000, time.FixedZone("WIB", 7*3600))
+	// Each field is "<byte length>:<value>"; ts is rendered in UTC.
+	payload := "5:prev0" + "1:7" + "27:2026-03-01T02:30:15.123456Z" + "2:42" + "11:STOCK_MOVED" + "9:inventory" + "3:w-1" + "17:{\"note\": \"café\"}" + "8:10.0.0.1"
+	sum := sha256.Sum256([]byte(payload))
+	want := hex.EncodeToString(sum[:])
+
+	if got := auditChainHash("prev0", 7, ts, &actor, "STOCK_MOVED", "inventory", "w-1", `{"note": "café"}`, "10.0.0.1"); got != want {
+		t.Fatalf("auditChainHash = %s, want %s", got, want)
+	}
+	// Length prefixes keep field boundaries unambiguous.
+	a := auditChainHash("", 1, ts, nil, "AB", "C", "", "{}", "")
+	b := auditChainHash("", 1, ts, nil, "A", "BC", "", "{}", "")
+	if a == b {
+		t.Fatal("shifting a character between fields does not change the hash")
+	}
+}
+
+// TestAuditChainHashMatchesSQL checks auditChainHash against the
+// audit_log_hash function and the insert trigger that chains new rows.
+func TestAuditChainHashMatchesSQL(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	actor := int64(1)
+	vectors := []struct {
+		prev     string
+		seq      int64
+		ts       time.Time
+		actor    *int64
+		action   string
+		entity   string
+		entityID string
+		metadata string
+		ip       string
+	}{
+		{prev: "", seq: 1, ts: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), action: "LOGIN", entity: "login", metadata: "{}"},
+		{prev: "abc", seq: 99, ts: time.Date(2026, 7, 8, 23, 59, 59, 999999000, time.FixedZone("X", -5*3600)), actor: &actor, action: "UPDATE", entity: "users", entityID: "7", metadata: `{"name": "Zoë Ñandú", "n": 1.5}`, ip: "2001:db8::1"},
+		{prev: "h", seq: 3, ts: time.Date(2025, 12, 31, 0, 0, 0, 1000, time.UTC), action: "X", entity: "y", entityID: "日本", metadata: `{"a": [1, 2, {"b": null}]}`, ip: "10.0.0.1"},
+	}
+	for i, v := range vectors {
+		var sqlHash string
+		if err := pool.QueryRow(ctx, `SELECT audit_log_hash($1,$2,$3,$4,$5,$6,$7,$8::jsonb,$9)`,
+			v.prev, v.seq, v.ts, v.actor, v.action, v.entity, v.entityID, v.metadata, v.ip).Scan(&sqlHash); err != nil {
+			t.Fatal(err)
+		}
+		// Hash what the database stores, since jsonb normalises its text form.
+		var metadata string
+		if err := pool.QueryRow(ctx, `SELECT $1::jsonb::text`, v.metadata).Scan(&metadata); err != nil {
+			t.Fatal(err)
+		}
+		if got := auditChainHash(v.prev, v.seq, v.ts, v.actor, v.action, v.entity, v.entityID, metadata, v.ip); got != sqlHash {
+			t.Errorf("vector %d: Go %s, SQL %s", i, got, sqlHash)
+		}
+	}
+
+	// The trigger fills chain_seq, prev_hash and hash; roll back so the real
+	// chain is untouched.
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	for i := 0; i < 2; i++ {
+		var seq int64
+		var prev, hash, action, entityType, entityID, metadata, ip string
+		var ts time.Time
+		var actorID *int64
+		err := tx.QueryRow(ctx, `
+      INSERT INTO audit_logs (actor_user_id, action, entity_type, entity_id, metadata, ip)
+      VALUES ($1, 'TEST_CHAIN', 'test', 'ü', '{"i": 1, "s": "ß"}'::jsonb, '192.0.2.1')
+      RETURNING chain_seq, prev_hash, hash, ts, actor_user_id, action, entity_type, entity_id, metadata::text, ip
+    `, &actor).Scan(&seq, &prev, &hash, &ts, &actorID, &action, &entityType, &entityID, &metadata, &ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := auditChainHash(prev, seq, ts, actorID, action, entityType, entityID, metadata, ip); got != hash {
+			t.Fatalf("row %d: Go %s, trigger %s", i, got, hash)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"cementops/api/internal/config"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	a := &App{cfg: config.Config{
+		SessionSecret:  "test-secret",
+		AllowedOrigins: []string{"http://localhost:3000"},
+	}}
+	sid := uuid.New()
+	token := a.csrfToken(sid)
+	h := a.csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		session string
+		want    int
+	}{
+		{name: "safe method", method: http.MethodGet, path: "/api/ops/shipments", headers: map[string]string{"Origin": "https://evil.example"}, session: sid.String(), want: http.StatusNoContent},
+		{name: "valid token", method: http.MethodPost, path: "/api/ops/issues", headers: map[string]string{"Origin": "http://localhost:3000", csrfHeaderName: token}, session: sid.String(), want: http.StatusNoContent},
+		{name: "origin case and trailing slash", method: http.MethodPost, path: "/api/ops/issues", headers: map[string]string{"Origin": "HTTP://LOCALHOST:3000/", csrfHeaderName: token}, session: sid.String(), want: http.StatusNoContent},
+		{name: "missing token", method: http.MethodPost, path: "/api/ops/issues", headers: map[string]string{"Origin": "http://localhost:3000"}, session: sid.String(), want: http.StatusForbidden},
+		{name: "token for another session", method: http.MethodDelete, path: "/api/ops/issues/1", headers: map[string]string{csrfHeaderName: a.csrfToken(uuid.New())}, session: sid.String(), want: http.StatusForbidden},
+		{name: "malformed session cookie", method: http.MethodPut, path: "/api/admin/users/1", headers: map[string]string{csrfHeaderName: token}, session: "not-a-uuid", want: http.StatusForbidden},
+		{name: "foreign origin", method: http.MethodPost, path: "/api/ops/issues", headers: map[string]string{"Origin": "https://evil.example", csrfHeaderName: token}, session: sid.String(), want: http.StatusForbidden},
+		{name: "foreign referer", method: http.MethodPatch, path: "/api/ops/shipments/1", headers: map[string]string{"Referer": "https://evil.example/page", csrfHeaderName: token}, session: sid.String(), want: http.StatusForbidden},
+		{name: "allowed referer", method: http.MethodPatch, path: "/api/ops/shipments/1", headers: map[string]string{"Referer": "http://localhost:3000/ops", csrfHeaderName: token}, session: sid.String(), want: http.StatusNoContent},
+		{name: "unparseable referer", method: http.MethodPost, path: "/api/ops/issues", headers: map[string]string{"Referer": "::", csrfHeaderName: token}, session: sid.String(), want: http.StatusForbidden},
+		{name: "no session cookie", method: http.MethodPost, path: "/api/auth/logout", want: http.StatusNoContent},
+		{name: "login with stale session", method: http.MethodPost, path: "/api/auth/login", headers: map[string]string{"Origin": "http://localhost:3000"}, session: sid.String(), want: http.StatusNoContent},
+		{name: "login from foreign origin", method: http.MethodPost, path: "/api/auth/login", headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusForbidden},
+		{name: "bearer token", method: http.MethodPost, path: "/api/ops/issues", headers: map[string]string{"Authorization": "Bearer co_abc", "Origin": "https://evil.example"}, want: http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			if tc.session != "" {
+				req.AddCookie(&http.Cookie{Name: "cementops_session", Value: tc.session})
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCSRFTokenIsBoundToSecret(t *testing.T) {
+	sid := uuid.New()
+	a := &App{cfg: config.Config{SessionSecret: "one"}}
+	b := &App{cfg: config.Config{SessionSecret: "two"}}
+	if a.csrfToken(sid) != a.csrfToken(sid) {
+		t.Fatal("token is not deterministic")
+	}
+	if a.csrfToken(sid) == b.csrfToken(sid) || a.csrfToken(sid) == a.csrfToken(uuid.New()) {
+		t.Fatal("token does not depend on the secret and session")
+	}
+}
@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"cementops/api/internal/config"
+	"cementops/api/internal/db"
+)
+
+var (
+	migrateOnce sync.Once
+	migrateErr  error
+)
+
+// testDB connects to TEST_DATABASE_URL, migrated and seeded, and skips the
+// test when it is not set. Tests share the database, so they must leave the
+// seeded rows as they found them.
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	pool, err := db.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	migrateOnce.Do(func() {
+		if migrateErr = db.Migrate(url, filepath.Join("..", "..", "..", "..", "db", "migrations")); migrateErr == nil {
+			migrateErr = db.Seed(ctx, pool)
+		}
+	})
+	if migrateErr != nil {
+		t.Fatalf("setup: %v", migrateErr)
+	}
+	return pool
+}
+
+// testClient sends requests to h as userID, through a session row inserted
+// directly so no password or 2FA round trip is needed.
+func testClient(t *testing.T, pool *pgxpool.Pool, cfg config.Config, h http.Handler, userID int64) func(method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	sid := uuid.New()
+	if _, err := pool.Exec(context.Background(), `INSERT INTO sessions (id, user_id, expires_at) VALUES ($1,$2,$3)`, sid, userID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = pool.Exec(context.Background(), `DELETE FROM sessions WHERE id=$1`, sid) })
+	token := (&App{cfg: cfg}).csrfToken(sid)
+	return func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "cementops_session", Value: sid.String()})
+		req.Header.Set(csrfHeaderName, token)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+}
@@ -0,0 +1,189 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"cementops/api/internal/config"
+	"cementops/api/internal/oidc"
+	"cementops/api/internal/oidc/oidctest"
+)
+
+const testRedirectURL = "http://localhost:3000/api/auth/oidc/callback"
+
+func TestSafeRedirectPath(t *testing.T) {
+	cases := map[string]string{
+		"/ops/shipments":       "/ops/shipments",
+		"":                     "/dashboard",
+		"https://evil.example": "/dashboard",
+		"//evil.example":       "/dashboard",
+		"/\\evil.example":      "/dashboard",
+	}
+	for in, want := range cases {
+		if got := safeRedirectPath(in); got != want {
+			t.Errorf("safeRedirectPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	idp := oidctest.NewServer(t, "cementops", "secret")
+	a := &App{oidc: oidc.New(oidc.Config{IssuerURL: idp.URL, ClientID: "cementops", ClientSecret: "secret", RedirectURL: testRedirectURL})}
+
+	for name, cookie := range map[string]string{"no cookie": "", "other browser": "state-b"} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?code=c&state=state-a", nil)
+			if cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+			}
+			rec := httptest.NewRecorder()
+			a.handleOIDCCallback(rec, req)
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/login?error=sso_state" {
+				t.Fatalf("got %d %q", rec.Code, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	a := &App{}
+	for _, h := range []http.HandlerFunc{a.handleOIDCLogin, a.handleOIDCCallback} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want 404", rec.Code)
+		}
+	}
+}
+
+// oidcRouter returns the API wired to a test provider and the seeded database.
+func oidcRouter(t *testing.T) (*oidctest.Server, http.Handler) {
+	t.Helper()
+	pool := testDB(t)
+	idp := oidctest.NewServer(t, "cementops", "secret")
+	t.Setenv("OIDC_ISSUER_URL", idp.URL)
+	t.Setenv("OIDC_CLIENT_ID", "cementops")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_REDIRECT_URL", testRedirectURL)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = pool.Exec(ctx, `DELETE FROM sessions WHERE user_id IN (SELECT user_id FROM user_identities WHERE issuer=$1)`, idp.URL)
+		_, _ = pool.Exec(ctx, `DELETE FROM user_identities WHERE issuer=$1`, idp.URL)
+	})
+	return idp, NewRouter(Deps{DB: pool, Config: config.Load()})
+}
+
+// signInWithOIDC runs login, the provider round trip and the callback, and
+// returns the callback response.
+func signInWithOIDC(t *testing.T, idp *oidctest.Server, h http.Handler, next string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login?next="+url.QueryEscape(next), nil))
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), idp.URL+"/authorize?") {
+		t.Fatalf("login: %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	var stateCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly {
+		t.Fatalf("state cookie = %+v", stateCookie)
+	}
+
+	cb, err := idp.Authorize(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.Query().Get("state") != stateCookie.Value {
+		t.Fatalf("callback state %q does not match cookie %q", cb.Query().Get("state"), stateCookie.Value)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+cb.RawQuery, nil)
+	req.AddCookie(stateCookie)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	idp, h := oidcRouter(t)
+	idp.SetClaims(map[string]any{"email": "operator@cementops.local", "email_verified": true, "roles": []string{"OPERATOR"}})
+
+	rec := signInWithOIDC(t, idp, h, "/ops/shipments")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/ops/shipments" {
+		t.Fatalf("callback: %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "cementops_session" && c.Value != "" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+	req.AddCookie(session)
+	me := httptest.NewRecorder()
+	h.ServeHTTP(me, req)
+	if me.Code != http.StatusOK || !strings.Contains(me.Body.String(), "operator@cementops.local") {
+		t.Fatalf("me: %d %s", me.Code, me.Body.String())
+	}
+}
+
+func TestOIDCSignInRejectsBadToken(t *testing.T) {
+	cases := map[string]map[string]any{
+		"wrong audience": {"aud": "another-client"},
+		"wrong issuer":   {"iss": "https://evil.example"},
+		"expired":        {"exp": 1},
+	}
+	for name, claims := range cases {
+		t.Run(name, func(t *testing.T) {
+			idp, h := oidcRouter(t)
+			claims["email"], claims["email_verified"] = "operator@cementops.local", true
+			idp.SetClaims(claims)
+			rec := signInWithOIDC(t, idp, h, "/dashboard")
+			if rec.Header().Get("Location") != "/login?error=sso_failed" {
+				t.Fatalf("callback: %d %q", rec.Code, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestOIDCSignInRequiresMFA(t *testing.T) {
+	idp, h := oidcRouter(t)
+	pool := testDB(t)
+	ctx := context.Background()
+	var prev bool
+	if err := pool.QueryRow(ctx, `SELECT mfa_required FROM rbac_config WHERE role='OPERATOR'`).Scan(&prev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec(ctx, `UPDATE rbac_config SET mfa_required=true WHERE role='OPERATOR'`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), `UPDATE rbac_config SET mfa_required=$1 WHERE role='OPERATOR'`, prev)
+	})
+	idp.SetClaims(map[string]any{"email": "operator@cementops.local", "email_verified": true, "roles": []string{"OPERATOR"}})
+
+	rec := signInWithOIDC(t, idp, h, "/dashboard")
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || err != nil || loc.Path != "/login" || loc.Query().Get("challenge") == "" {
+		t.Fatalf("callback: %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "cementops_session" && c.Value != "" {
+			t.Fatal("session issued before the MFA challenge was passed")
+		}
+	}
+	var method string
+	if err := pool.QueryRow(ctx, `SELECT method FROM login_challenges WHERE id=$1`, loc.Query().Get("challenge")).Scan(&method); err != nil || method != loginMethodOIDC {
+		t.Fatalf("challenge method = %q, %v", method, err)
+	}
+}
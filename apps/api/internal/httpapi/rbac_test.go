@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"cementops/api/internal/config"
+)
+
+func TestRBACCacheDropsStaleLoad(t *testing.T) {
+	c := &rbacCache{}
+	stale := map[string]rbacConfig{"OPERATOR": {}}
+
+	// A load that began before an edit was invalidated must not be cached.
+	gen := c.generation()
+	c.invalidate()
+	c.store(gen, stale)
+	if c.perms != nil {
+		t.Fatal("load started before invalidate was cached")
+	}
+
+	gen = c.generation()
+	c.store(gen, stale)
+	if c.perms == nil || c.loadedAt.IsZero() {
+		t.Fatal("current load was not cached")
+	}
+	c.invalidate()
+	if c.perms != nil {
+		t.Fatal("invalidate kept the cached matrix")
+	}
+}
+
+// TestRBACRevokeTakesEffect turns off OPERATOR Operations.edit through the API
+// and checks the next approval is refused, with the matrix already cached.
+func TestRBACRevokeTakesEffect(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	cfg := config.Load()
+	h := NewRouter(Deps{DB: pool, Config: cfg})
+
+	var raw json.RawMessage
+	var version int
+	if err := pool.QueryRow(ctx, `SELECT config, version FROM rbac_config WHERE role='OPERATOR'`).Scan(&raw, &version); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = pool.Exec(ctx, `UPDATE rbac_config SET config=$1, version=$2 WHERE role='OPERATOR'`, raw, version)
+		_, _ = pool.Exec(ctx, `DELETE FROM rbac_config_versions WHERE role='OPERATOR' AND version > $1`, version)
+	})
+	var doc rbacConfig
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !doc.Permissions["Operations"].Edit {
+		t.Skip("seeded OPERATOR role cannot edit Operations")
+	}
+
+	admin := testClient(t, pool, cfg, h, 1)
+	operator := testClient(t, pool, cfg, h, 3)
+	const approve = "/api/ops/orders/999999999/approve"
+
+	if rec := operator(http.MethodPost, approve, `{}`); rec.Code == http.StatusForbidden {
+		t.Fatalf("approve before revoking: %d %s", rec.Code, rec.Body.String())
+	}
+
+	ops := doc.Permissions["Operations"]
+	ops.Edit = false
+	doc.Permissions["Operations"] = ops
+	body, _ := json.Marshal(doc)
+	if rec := admin(http.MethodPut, "/api/admin/rbac/OPERATOR", string(body)); rec.Code != http.StatusOK {
+		t.Fatalf("put rbac: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := operator(http.MethodPost, approve, `{}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("approve after revoking: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	cases := []struct {
+		name    string
+		trusted []netip.Prefix
+		remote  string
+		xff     []string
+		xrip    string
+		want    string
+	}{
+		{name: "no proxies configured", remote: "203.0.113.9:5000", xff: []string{"198.51.100.1"}, want: "203.0.113.9"},
+		{name: "untrusted peer", trusted: trusted, remote: "203.0.113.9:5000", xff: []string{"198.51.100.1"}, xrip: "198.51.100.2", want: "203.0.113.9"},
+		{name: "trusted peer", trusted: trusted, remote: "10.0.0.2:5000", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed leftmost entry", trusted: trusted, remote: "10.0.0.2:5000", xff: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted hops skipped", trusted: trusted, remote: "10.0.0.2:5000", xff: []string{"198.51.100.1, 10.0.0.7", "10.0.0.8"}, want: "198.51.100.1"},
+		{name: "garbage hop stops walk", trusted: trusted, remote: "10.0.0.2:5000", xff: []string{"198.51.100.1, junk, 10.0.0.7"}, want: "10.0.0.7"},
+		{name: "x-real-ip from trusted peer", trusted: trusted, remote: "[::1]:5000", xrip: "198.51.100.3", want: "198.51.100.3"},
+		{name: "trusted peer without headers", trusted: trusted, remote: "10.0.0.2:5000", want: "10.0.0.2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			h := realIP(tc.trusted)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+			req.RemoteAddr = tc.remote
+			for _, v := range tc.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tc.xrip != "" {
+				req.Header.Set("X-Real-IP", tc.xrip)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tc.want {
+				t.Fatalf("clientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
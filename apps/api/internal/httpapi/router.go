@@ -2,8 +2,12 @@ package httpapi
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,19 +15,40 @@ import (
 	"io"
 	"log"
 	"math"
+	"mime"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"cementops/api/internal/alerts"
 	"cementops/api/internal/config"
+	"cementops/api/internal/mailer"
+	"cementops/api/internal/media"
+	"cementops/api/internal/notify"
+	"cementops/api/internal/oidc"
+	"cementops/api/internal/password"
+	"cementops/api/internal/retention"
+	"cementops/api/internal/secretbox"
+	"cementops/api/internal/sheet"
+	"cementops/api/internal/storage"
+	"cementops/api/internal/totp"
+	"cementops/api/internal/uploads"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
@@ -32,16 +57,37 @@ import (
 type Deps struct {
 	DB     *pgxpool.Pool
 	Config config.Config
+	// PasswordResets delivers forgot-password links; nil queues them as email.
+	PasswordResets PasswordResetSender
+	// Archiver serves the retention endpoints; nil means no retention policy.
+	Archiver *retention.Archiver
+	// Storage holds attachment files; nil keeps them on local disk under
+	// Config.StorageDir.
+	Storage storage.Store
+	// Collector serves the storage garbage collection endpoints; nil means
+	// a collector that only runs on request.
+	Collector *uploads.Collector
 }
 
 type App struct {
-	db  *pgxpool.Pool
-	cfg config.Config
+	db   *pgxpool.Pool
+	cfg  config.Config
+	rbac *rbacCache
+	box  *secretbox.Box
+	// oidc is nil unless single sign-on is configured.
+	oidc      *oidc.Provider
+	passwords password.Policy
+	resets    PasswordResetSender
+	archiver  *retention.Archiver
+	store     storage.Store
+	collector *uploads.Collector
 }
 
 const maxUploadBytes int64 = 6 << 20
 
-func uploadsDir() string {
+// LegacyUploadsDir is where evidence photos were written before attachments
+// existed; they are still served from /uploads/{key}.
+func LegacyUploadsDir() string {
 	if wd, err := os.Getwd(); err == nil {
 		return filepath.Join(wd, "uploads")
 	}
@@ -51,7 +97,7 @@ func uploadsDir() string {
 func NewRouter(deps Deps) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(deps.Config.TrustedProxies))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -60,31 +106,97 @@ func NewRouter(deps Deps) http.Handler {
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	app := &App{db: deps.DB, cfg: deps.Config}
+	box, err := secretbox.New(deps.Config.MFAEncryptionKey)
+	if err != nil {
+		log.Fatalf("mfa: %v", err)
+	}
+	app := &App{db: deps.DB, cfg: deps.Config, rbac: &rbacCache{}, box: box, resets: deps.PasswordResets}
+	if app.resets == nil {
+		app.resets = outboxResetSender{}
+	}
+	app.archiver = deps.Archiver
+	if app.archiver == nil {
+		app.archiver = retention.NewArchiver(deps.DB, retention.Policy{}, 0)
+	}
+	app.store = deps.Storage
+	if app.store == nil {
+		app.store = &storage.Local{Dir: deps.Config.StorageDir}
+	}
+	app.collector = deps.Collector
+	if app.collector == nil {
+		app.collector = uploads.NewCollector(deps.DB, app.store, LegacyUploadsDir(), deps.Config.UploadGCGrace, 0)
+	}
+	app.passwords = password.Policy{
+		MinLength:     deps.Config.PasswordMinLength,
+		CheckBreached: deps.Config.PasswordCheckBreached,
+	}
+	if deps.Config.OIDCIssuerURL != "" {
+		app.oidc = oidc.New(oidc.Config{
+			IssuerURL:    deps.Config.OIDCIssuerURL,
+			ClientID:     deps.Config.OIDCClientID,
+			ClientSecret: deps.Config.OIDCClientSecret,
+			RedirectURL:  deps.Config.OIDCRedirectURL,
+			Scopes:       deps.Config.OIDCScopes,
+		})
+	}
 
-	r.Get("/uploads/{key}", app.handleServeUpload)
+	// Evidence uploaded before attachments existed; new files are served from
+	// /api/attachments/{id}.
+	r.With(app.authMiddleware, app.withAttachmentAccess).Get("/uploads/{key}", app.handleServeLegacyUpload)
 
 	r.Route("/api", func(api chi.Router) {
+		api.Use(app.csrfProtect)
+
 		api.Post("/auth/login", app.handleLogin)
+		api.Post("/auth/login/mfa", app.handleLoginMFA)
+		api.Post("/auth/login/mfa/enroll", app.handleLoginMFAEnroll)
 		api.Post("/auth/logout", app.handleLogout)
+		api.Get("/auth/providers", app.handleAuthProviders)
+		api.Post("/auth/forgot-password", app.handleForgotPassword)
+		api.Post("/auth/reset-password", app.handleResetPassword)
+		api.Get("/auth/oidc/login", app.handleOIDCLogin)
+		api.Get("/auth/oidc/callback", app.handleOIDCCallback)
 
 		api.Group(func(pr chi.Router) {
 			pr.Use(app.authMiddleware)
 			pr.Get("/auth/me", app.handleMe)
 			pr.Get("/rbac/me", app.handleRBACMe)
 
+			// Account management and the user's inbox need a browser session; API
+			// tokens only reach routes covered by their scopes.
+			pr.Group(func(se chi.Router) {
+				se.Use(requireSession)
+				se.Get("/auth/csrf", app.handleCSRFToken)
+				se.Get("/auth/sessions", app.handleListSessions)
+				se.Post("/auth/sessions/revoke-others", app.handleRevokeOtherSessions)
+				se.Delete("/auth/sessions/{id}", app.handleRevokeSession)
+				se.Get("/auth/mfa", app.handleMFAStatus)
+				se.Post("/auth/mfa/enroll", app.handleMFAEnroll)
+				se.Post("/auth/mfa/verify", app.handleMFAVerify)
+				se.Post("/auth/mfa/recovery-codes", app.handleMFARecoveryCodes)
+				se.Post("/auth/mfa/disable", app.handleMFADisable)
+				se.Post("/auth/change-password", app.handleChangePassword)
+				se.Get("/auth/tokens", app.handleListMyAPITokens)
+				se.Post("/auth/tokens", app.handleCreateMyAPIToken)
+				se.Delete("/auth/tokens/{id}", app.handleRevokeMyAPIToken)
+
+				se.Get("/notifications", app.handleListNotifications)
+				se.Get("/notifications/unread-count", app.handleNotificationsUnreadCount)
+				se.Post("/notifications/read-all", app.handleMarkAllNotificationsRead)
+				se.Post("/notifications/{id}/read", app.handleMarkNotificationRead)
+			})
+
 			// Planning is read-only analytics; access is controlled by DB RBAC on the frontend.
 			// Keep API accessible to any authenticated user to avoid role mismatch / 403 loops.
-			pr.Route("/planning", func(pl chi.Router) {
+			// API tokens still need the Planning.view scope.
+			pr.With(app.requireTokenScope("Planning", "view")).Route("/planning", func(pl chi.Router) {
 				pl.Get("/heatmap", app.handlePlanningHeatmap)
 				pl.Get("/site-profile", app.handlePlanningSiteProfile)
 				pl.Get("/whitespace", app.handlePlanningWhitespace)
 				pl.Get("/catchment", app.handlePlanningCatchment)
 			})
 
-			// Operations: OPERATOR runs day-to-day ops; MANAGEMENT monitors (read-only).
-			pr.With(app.requireRole("SUPER_ADMIN", "OPERATOR", "MANAGEMENT")).Route("/ops", func(op chi.Router) {
-				// Read-only monitoring endpoints (allowed for MANAGEMENT).
+			pr.With(app.requirePermission("Operations", "view"), app.withUserScope).Route("/ops", func(op chi.Router) {
 				op.Get("/overview", app.handleOpsOverview)
 				op.Get("/logistics/map", app.handleOpsLogisticsMap)
 				op.Get("/trucks", app.handleOpsTrucks)
@@ -97,51 +209,105 @@ func NewRouter(deps Deps) http.Handler {
 				op.Get("/issues", app.handleOpsIssues)
 				op.Get("/shipments", app.handleOpsShipments)
 				op.Get("/shipments/{id}", app.handleOpsShipmentDetail)
-
-				// Mutating endpoints.
-				// - OPERATOR: allowed (day-to-day operations)
-				// - SUPER_ADMIN: emergency override for shipment updates only
-				// - MANAGEMENT: never allowed to mutate
-				op.Group(func(mut chi.Router) {
-					mut.With(app.requireRoleStrict("OPERATOR")).Group(func(opOnly chi.Router) {
-						opOnly.Post("/inventory/adjust", app.handleOpsInventoryAdjust)
-						opOnly.Post("/orders/{id}/approve", app.handleOpsApproveOrder)
-						opOnly.Post("/orders/{id}/reject", app.handleOpsRejectOrder)
-						opOnly.Post("/issues", app.handleOpsCreateIssue)
-						opOnly.Patch("/issues/{id}/resolve", app.handleOpsResolveIssue)
-					})
-					mut.With(app.requireRoleStrict("OPERATOR", "SUPER_ADMIN")).Group(func(sh chi.Router) {
-						sh.Patch("/shipments/{id}", app.handleOpsUpdateShipment)
-						sh.Patch("/shipments/{id}/status", app.handleOpsUpdateShipmentStatus)
+				op.Get("/transfers", app.handleOpsTransfers)
+				op.Get("/transfers/{id}", app.handleOpsTransferDetail)
+				op.Get("/plants", app.handleOpsPlants)
+				op.Get("/plants/{id}/production", app.handleOpsPlantProduction)
+				op.Get("/dispatches", app.handleOpsDispatches)
+
+				// Mutations map to actions by what they do to existing records rather than
+				// by HTTP verb: approving/rejecting an order or adjusting stock is an edit.
+				op.With(app.requirePermission("Operations", "create")).Post("/attachments", app.handleOpsUploadAttachment)
+
+				// Stock, orders and issues are run by staff roles only. Administrators
+				// pass the permission matrix but, as before it existed, may only step in
+				// on shipments.
+				op.Group(func(st chi.Router) {
+					st.Use(app.requireRoleKind(roleKindStaff))
+					st.With(app.requirePermission("Operations", "create")).Post("/issues", app.handleOpsCreateIssue)
+					st.With(app.requirePermission("Operations", "create")).Post("/transfers", app.handleOpsCreateTransfer)
+					st.With(app.requirePermission("Operations", "create")).Post("/plants/{id}/production", app.handleOpsRecordProduction)
+					st.With(app.requirePermission("Operations", "create")).Post("/dispatches", app.handleOpsCreateDispatch)
+					st.Group(func(ed chi.Router) {
+						ed.Use(app.requirePermission("Operations", "edit"))
+						ed.Post("/inventory/adjust", app.handleOpsInventoryAdjust)
+						ed.Post("/orders/{id}/approve", app.handleOpsApproveOrder)
+						ed.Post("/orders/{id}/reject", app.handleOpsRejectOrder)
+						ed.Patch("/issues/{id}/resolve", app.handleOpsResolveIssue)
+						ed.Patch("/transfers/{id}/status", app.handleOpsUpdateTransferStatus)
+						ed.Patch("/dispatches/{id}/status", app.handleOpsUpdateDispatchStatus)
 					})
 				})
+				op.Group(func(sh chi.Router) {
+					sh.Use(app.requirePermission("Operations", "edit"))
+					sh.Patch("/shipments/{id}", app.handleOpsUpdateShipment)
+					sh.Patch("/shipments/{id}/status", app.handleOpsUpdateShipmentStatus)
+				})
+			})
+
+			// Attachments are read from both portals; uploads go through /ops or
+			// /distributor so the link target is checked against the right scope.
+			pr.With(app.withAttachmentAccess).Route("/attachments", func(at chi.Router) {
+				at.Get("/", app.handleListAttachments)
+				at.Get("/{id}", app.handleDownloadAttachment)
+				at.Get("/{id}/thumbnail", app.handleAttachmentThumbnail)
 			})
 
 			// Distributor portal: scoped to the authenticated distributor user.
-			pr.With(app.requireRoleStrict("DISTRIBUTOR")).Route("/distributor", func(di chi.Router) {
+			pr.With(app.requireRoleKind(roleKindDistributor)).Route("/distributor", func(di chi.Router) {
 				di.Get("/inventory", app.handleDistributorInventory)
 				di.Get("/orders", app.handleDistributorOrders)
 				di.Post("/orders", app.handleDistributorCreateOrder)
 				di.Get("/issues", app.handleDistributorIssues)
 				di.Post("/issues", app.handleDistributorCreateIssue)
-				di.Post("/issues/upload", app.handleDistributorIssueUpload)
+				di.Post("/issues/upload", app.handleDistributorUploadAttachment)
+				di.Post("/attachments", app.handleDistributorUploadAttachment)
 				di.Get("/shipments", app.handleDistributorShipments)
 				di.Patch("/shipments/{id}/status", app.handleDistributorUpdateShipmentStatus)
 				di.Get("/transactions", app.handleDistributorTransactions)
 			})
 
-			pr.With(app.requireRole("SUPER_ADMIN")).Route("/admin", func(ad chi.Router) {
+			// Administration routes are plain CRUD, so the action follows the HTTP verb.
+			pr.With(app.requirePermission("Administration", "")).Route("/admin", func(ad chi.Router) {
 				// Users
 				ad.Get("/users", app.handleAdminListUsers)
-				ad.Post("/users", app.handleAdminCreateUser)
-				ad.Put("/users/{id}", app.handleAdminUpdateUser)
 				ad.Delete("/users/{id}", app.handleAdminDeleteUser)
-				ad.Patch("/users/{id}/status", app.handleAdminUpdateUserStatus)
-				ad.Post("/users/{id}/reset-password", app.handleAdminResetUserPassword)
+				ad.Get("/users/{id}/scope", app.handleAdminGetUserScope)
+
+				// Roles
+				ad.Get("/roles", app.handleAdminListRoles)
+				ad.Delete("/roles/{key}", app.handleAdminDeleteRole)
 
 				// RBAC
 				ad.Get("/rbac", app.handleAdminGetRBAC)
-				ad.Put("/rbac/{role}", app.handleAdminPutRBAC)
+				ad.Get("/rbac/{role}/versions", app.handleAdminListRBACVersions)
+
+				// Anything that hands out credentials or permissions needs a browser
+				// session. Otherwise a token with Administration.create or .edit could
+				// mint a broader token, grant its user a bigger role or reset another
+				// account's password.
+				ad.Group(func(cr chi.Router) {
+					cr.Use(requireSession)
+					cr.Post("/users", app.handleAdminCreateUser)
+					cr.Put("/users/{id}", app.handleAdminUpdateUser)
+					cr.Patch("/users/{id}/status", app.handleAdminUpdateUserStatus)
+					cr.Post("/users/{id}/reset-password", app.handleAdminResetUserPassword) // needs Administration.create
+					cr.Delete("/users/{id}/sessions", app.handleAdminRevokeUserSessions)
+					cr.Delete("/users/{id}/mfa", app.handleAdminResetUserMFA)
+					cr.Put("/users/{id}/scope", app.handleAdminPutUserScope)
+
+					cr.Post("/roles", app.handleAdminCreateRole)
+					cr.Put("/roles/{key}", app.handleAdminUpdateRole)
+
+					cr.Put("/rbac/{role}", app.handleAdminPutRBAC)
+					cr.Put("/rbac/{role}/mfa", app.handleAdminPutRBACMFA)
+					cr.Post("/rbac/{role}/versions/{version}/rollback", app.handleAdminRollbackRBAC)
+
+					cr.Get("/api-tokens", app.handleAdminListAPITokens)
+					cr.Post("/api-tokens", app.handleAdminCreateAPIToken)
+					cr.Put("/api-tokens/{id}", app.handleAdminUpdateAPIToken)
+					cr.Delete("/api-tokens/{id}", app.handleAdminRevokeAPIToken)
+				})
 
 				// Thresholds
 				ad.Get("/thresholds", app.handleAdminListThresholds)
@@ -150,15 +316,37 @@ func NewRouter(deps Deps) http.Handler {
 				// Alerts
 				ad.Get("/alerts", app.handleAdminListAlerts)
 				ad.Put("/alerts", app.handleAdminPutAlerts)
+				ad.Get("/alerts/events", app.handleAdminListAlertEvents)
 
 				// Logs
 				ad.Get("/logs", app.handleAdminListAuditLogs)
+				ad.Get("/logs/export", app.handleAdminExportAuditLogs)
+				ad.Get("/logs/verify", app.handleAdminVerifyAuditLogs)
+
+				// Retention
+				ad.Get("/retention", app.handleAdminRetention)
+				ad.Get("/retention/report", app.handleAdminRetentionReport)
+				ad.Post("/retention/run", app.handleAdminRetentionRun)
+				ad.Get("/retention/audit-logs", app.handleAdminArchivedAuditLogs)
+				ad.Get("/retention/inventory-movements", app.handleAdminArchivedMovements)
+
+				// Attachment storage
+				ad.Get("/storage/usage", app.handleAdminStorageUsage)
+				ad.Get("/storage/gc/report", app.handleAdminStorageGCReport)
+				ad.Post("/storage/gc/run", app.handleAdminStorageGCRun)
+
+				// Login security
+				ad.Get("/login-attempts", app.handleAdminListLoginAttempts)
+				ad.Get("/lockouts", app.handleAdminListLockouts)
+				ad.Delete("/lockouts", app.handleAdminClearLockout)
 
 				// Plants CRUD
 				ad.Get("/plants", app.handleAdminListPlants)
 				ad.Post("/plants", app.handleAdminCreatePlant)
 				ad.Put("/plants/{id}", app.handleAdminUpdatePlant)
 				ad.Delete("/plants/{id}", app.handleAdminDeletePlant)
+				ad.Get("/plants/{id}/capacity", app.handleAdminGetPlantCapacity)
+				ad.Put("/plants/{id}/capacity", app.handleAdminPutPlantCapacity)
 
 				// Warehouses CRUD
 				ad.Get("/warehouses", app.handleAdminListWarehouses)
@@ -181,9 +369,14 @@ func NewRouter(deps Deps) http.Handler {
 				ad.Post("/projects", app.handleAdminCreateProject)
 				ad.Put("/projects/{id}", app.handleAdminUpdateProject)
 				ad.Delete("/projects/{id}", app.handleAdminDeleteProject)
+
+				// Master data history and restore
+				ad.Get("/"+masterEntityParam+"/{id}/history", app.handleAdminEntityHistory)
+				ad.Post("/"+masterEntityParam+"/{id}/restore", app.handleAdminRestoreEntity)
+				ad.Post("/"+masterEntityParam+"/import", app.handleAdminImportEntity)
 			})
 
-			pr.With(app.requireRole("SUPER_ADMIN", "MANAGEMENT")).Route("/exec", func(ex chi.Router) {
+			pr.With(app.requirePermission("Executive", "view"), app.withUserScope).Route("/exec", func(ex chi.Router) {
 				ex.Get("/target-vs-actual", app.handleExecTargetVsActual)
 				ex.Get("/competitor/map", app.handleExecCompetitorMap)
 				ex.Get("/partners/performance", app.handleExecPartnersPerformance)
@@ -247,18 +440,42 @@ func writeDBError(w http.ResponseWriter, err error) {
 
 type ctxKey string
 
-const ctxUserKey ctxKey = "cementops_user"
+const (
+	ctxUserKey    ctxKey = "cementops_user"
+	ctxSessionKey ctxKey = "cementops_session"
+	ctxScopeKey   ctxKey = "cementops_scope"
+	ctxTokenKey   ctxKey = "cementops_api_token"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written, so that busy
+// clients do not turn every request into an UPDATE.
+const sessionTouchInterval = time.Minute
 
 type User struct {
 	ID            int64  `json:"id"`
 	Name          string `json:"name"`
 	Email         string `json:"email"`
 	Role          string `json:"role"`
+	RoleKind      string `json:"roleKind"`
 	DistributorID *int64 `json:"distributorId,omitempty"`
 }
 
+// Role kinds stored in roles.kind.
+const (
+	roleKindAdmin       = "ADMIN"
+	roleKindStaff       = "STAFF"
+	roleKindDistributor = "DISTRIBUTOR"
+)
+
+// authMiddleware accepts either the session cookie or an API token sent as
+// "Authorization: Bearer <token>". A request carrying a bearer token is never
+// checked against the cookie.
 func (a *App) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if raw, ok := bearerToken(r); ok {
+			a.authenticateAPIToken(w, r, raw, next)
+			return
+		}
 		c, err := r.Cookie("cementops_session")
 		if err != nil || strings.TrimSpace(c.Value) == "" {
 			writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
@@ -272,14 +489,16 @@ func (a *App) authMiddleware(next http.Handler) http.Handler {
 
 		var u User
 		var distributorID sql.NullInt64
-		var expiresAt time.Time
+		var expiresAt, lastSeenAt time.Time
+		var disabledAt *time.Time
 		row := a.db.QueryRow(r.Context(), `
-      SELECT u.id, u.name, u.email, u.role, u.distributor_id, s.expires_at
+      SELECT u.id, u.name, u.email, u.role, ro.kind, u.distributor_id, u.disabled_at, s.expires_at, s.last_seen_at
       FROM sessions s
       JOIN users u ON u.id = s.user_id
+      JOIN roles ro ON ro.key = u.role
       WHERE s.id = $1
     `, sid)
-		if err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &distributorID, &expiresAt); err != nil {
+		if err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.RoleKind, &distributorID, &disabledAt, &expiresAt, &lastSeenAt); err != nil {
 			writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "session not found")
 			return
 		}
@@ -287,21 +506,51 @@ func (a *App) authMiddleware(next http.Handler) http.Handler {
 			v := distributorID.Int64
 			u.DistributorID = &v
 		}
-		if time.Now().After(expiresAt) {
+		now := time.Now()
+		idle := a.cfg.SessionIdleTimeout
+		if now.After(expiresAt) || (idle > 0 && now.Sub(lastSeenAt) > idle) {
 			_, _ = a.db.Exec(r.Context(), `DELETE FROM sessions WHERE id = $1`, sid)
 			writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "session expired")
 			return
 		}
+		if disabledAt != nil {
+			_, _ = a.db.Exec(r.Context(), `DELETE FROM sessions WHERE user_id = $1`, u.ID)
+			writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "account disabled")
+			return
+		}
+		if now.Sub(lastSeenAt) > sessionTouchInterval {
+			_, _ = a.db.Exec(r.Context(), `UPDATE sessions SET last_seen_at = now() WHERE id = $1`, sid)
+		}
+		// Sessions created before CSRF protection, or browsers that dropped the
+		// cookie, get it back on their next request.
+		if c, err := r.Cookie(csrfCookieName); err != nil || c.Value != a.csrfToken(sid) {
+			a.setCSRFCookie(w, r, sid, expiresAt)
+		}
 
 		ctx := context.WithValue(r.Context(), ctxUserKey, u)
+		ctx = context.WithValue(ctx, ctxSessionKey, sid)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (a *App) requireRole(roles ...string) func(http.Handler) http.Handler {
+// requireSession rejects API-token requests. It guards endpoints that manage the
+// account itself (sessions, 2FA, tokens) and the admin routes that grant
+// credentials or permissions, which a leaked token must not reach.
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ctxTokenKey).(apiToken); ok {
+			writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "not available to API tokens")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRoleKind admits users whose role record has one of the given kinds.
+func (a *App) requireRoleKind(kinds ...string) func(http.Handler) http.Handler {
 	allowed := map[string]bool{}
-	for _, r := range roles {
-		allowed[r] = true
+	for _, k := range kinds {
+		allowed[k] = true
 	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -310,11 +559,7 @@ func (a *App) requireRole(roles ...string) func(http.Handler) http.Handler {
 				writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
 				return
 			}
-			if u.Role == "SUPER_ADMIN" {
-				next.ServeHTTP(w, r)
-				return
-			}
-			if !allowed[u.Role] {
+			if !allowed[u.RoleKind] {
 				writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "insufficient role")
 				return
 			}
@@ -323,11 +568,224 @@ func (a *App) requireRole(roles ...string) func(http.Handler) http.Handler {
 	}
 }
 
-func (a *App) requireRoleStrict(roles ...string) func(http.Handler) http.Handler {
-	allowed := map[string]bool{}
-	for _, r := range roles {
-		allowed[r] = true
+// ---------- csrf ----------
+
+// Browsers attach the session cookie to cross-site requests, so every
+// state-changing request has to prove it came from our own pages:
+//
+//  1. Origin (or, failing that, Referer) must be in cfg.AllowedOrigins.
+//     Requests carrying neither are not from a browser form or script and
+//     pass this step.
+//  2. When a session cookie is present, X-CSRF-Token must match the
+//     cementops_csrf cookie's value, an HMAC of the session id. Pages read
+//     the cookie and echo it in the header (signed double submit), which a
+//     cross-site page cannot do.
+//
+// Bearer-token requests carry no ambient credentials and are exempt.
+const (
+	csrfCookieName = "cementops_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfPreSessionPaths are public endpoints called before a session exists.
+// They get the Origin check only; a stale session cookie must not block login.
+var csrfPreSessionPaths = map[string]bool{
+	"/api/auth/login":            true,
+	"/api/auth/login/mfa":        true,
+	"/api/auth/login/mfa/enroll": true,
+	"/api/auth/forgot-password":  true,
+	"/api/auth/reset-password":   true,
+}
+
+func (a *App) csrfToken(sid uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(a.cfg.SessionSecret))
+	mac.Write([]byte("csrf:" + sid.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setCSRFCookie issues the token for a session. It is readable by scripts on
+// purpose; see csrfProtect.
+func (a *App) setCSRFCookie(w http.ResponseWriter, r *http.Request, sid uuid.UUID, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    a.csrfToken(sid),
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.secureCookies(r),
+		Expires:  expires,
+	})
+}
+
+func (a *App) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		ref, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil || ref.Host == "" {
+			return r.Header.Get("Referer") == ""
+		}
+		origin = ref.Scheme + "://" + ref.Host
+	}
+	return slices.Contains(a.cfg.AllowedOrigins, strings.ToLower(strings.TrimRight(origin, "/")))
+}
+
+func (a *App) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !a.originAllowed(r) {
+			writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "request origin not allowed")
+			return
+		}
+		if !csrfPreSessionPaths[r.URL.Path] {
+			if c, err := r.Cookie("cementops_session"); err == nil && c.Value != "" {
+				sid, err := uuid.Parse(c.Value)
+				got := r.Header.Get(csrfHeaderName)
+				if err != nil || got == "" || !hmac.Equal([]byte(got), []byte(a.csrfToken(sid))) {
+					writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "missing or invalid CSRF token")
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleCSRFToken returns the current session's CSRF token and re-issues the
+// cookie, for clients that lost it.
+func (a *App) handleCSRFToken(w http.ResponseWriter, r *http.Request) {
+	sid, _ := r.Context().Value(ctxSessionKey).(uuid.UUID)
+	var expiresAt time.Time
+	if err := a.db.QueryRow(r.Context(), `SELECT expires_at FROM sessions WHERE id=$1`, sid).Scan(&expiresAt); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	a.setCSRFCookie(w, r, sid, expiresAt)
+	writeJSON(w, http.StatusOK, map[string]any{"token": a.csrfToken(sid), "header": csrfHeaderName})
+}
+
+// ---------- rbac enforcement ----------
+
+// rbacCacheTTL bounds how stale another instance's rbac_config edits can be;
+// edits made through this instance invalidate the cache immediately.
+const rbacCacheTTL = 30 * time.Second
+
+// rbacCache holds the rbac_config document per role. gen counts
+// invalidations, so a load that read the table before an edit committed does
+// not store its stale result afterwards.
+type rbacCache struct {
+	mu       sync.RWMutex
+	perms    map[string]rbacConfig
+	loadedAt time.Time
+	gen      uint64
+}
+
+func (c *rbacCache) invalidate() {
+	c.mu.Lock()
+	c.perms = nil
+	c.gen++
+	c.mu.Unlock()
+}
+
+// generation returns the current invalidation count.
+func (c *rbacCache) generation() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gen
+}
+
+// store caches perms unless the cache was invalidated after gen was read.
+func (c *rbacCache) store(gen uint64, perms map[string]rbacConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	c.perms = perms
+	c.loadedAt = time.Now()
+}
+
+func (a *App) rbacAllows(ctx context.Context, role, module, action string) (bool, error) {
+	a.rbac.mu.RLock()
+	perms, fresh := a.rbac.perms, time.Since(a.rbac.loadedAt) < rbacCacheTTL
+	a.rbac.mu.RUnlock()
+	if perms == nil || !fresh {
+		var err error
+		if perms, err = a.loadRBAC(ctx); err != nil {
+			return false, err
+		}
+	}
+	return perms[role].Permissions[module].allows(action), nil
+}
+
+func (a *App) loadRBAC(ctx context.Context) (map[string]rbacConfig, error) {
+	gen := a.rbac.generation()
+	rows, err := a.db.Query(ctx, `SELECT role, config FROM rbac_config`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	perms := map[string]rbacConfig{}
+	for rows.Next() {
+		var role string
+		var raw json.RawMessage
+		if err := rows.Scan(&role, &raw); err != nil {
+			return nil, err
+		}
+		var cfg rbacConfig
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			// A malformed row denies everything for that role rather than failing every request.
+			log.Printf("rbac: invalid config for role %s: %v", role, err)
+			continue
+		}
+		perms[role] = cfg
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	a.rbac.store(gen, perms)
+	return perms, nil
+}
+
+func actionForMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return "view"
+	case http.MethodPost:
+		return "create"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "edit"
+	}
+}
+
+// requireTokenScope checks only the API token scope, for routes open to every
+// signed-in user.
+func (a *App) requireTokenScope(module, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tok, ok := r.Context().Value(ctxTokenKey).(apiToken); ok && !tok.allows(module, action) {
+				writeAPIError(w, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("token lacks %s.%s scope", module, action))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requirePermission checks rbac_config.permissions[module][action] for the session
+// role. An empty action is derived from the HTTP verb. ADMIN-kind roles always pass,
+// matching the frontend, so the matrix can never lock administrators out; routes
+// reserved for staff add requireRoleKind. API tokens must also carry the
+// "Module.action" scope, even for administrators.
+func (a *App) requirePermission(module, action string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			u, ok := r.Context().Value(ctxUserKey).(User)
@@ -335,8 +793,25 @@ func (a *App) requireRoleStrict(roles ...string) func(http.Handler) http.Handler
 				writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
 				return
 			}
-			if !allowed[u.Role] {
-				writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "insufficient role")
+			act := action
+			if act == "" {
+				act = actionForMethod(r.Method)
+			}
+			if tok, ok := r.Context().Value(ctxTokenKey).(apiToken); ok && !tok.allows(module, act) {
+				writeAPIError(w, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("token lacks %s.%s scope", module, act))
+				return
+			}
+			if u.RoleKind == roleKindAdmin {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed, err := a.rbacAllows(r.Context(), u.Role, module, act)
+			if err != nil {
+				writeDBError(w, err)
+				return
+			}
+			if !allowed {
+				writeAPIError(w, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("missing %s.%s permission", module, act))
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -344,6 +819,172 @@ func (a *App) requireRoleStrict(roles ...string) func(http.Handler) http.Handler
 	}
 }
 
+// ---------- user scope ----------
+
+// userScope limits a staff user to the warehouses and distributors assigned in
+// user_warehouse_scopes / user_distributor_scopes. A nil slice means no
+// restriction on that axis; it is passed straight into queries as a NULL array
+// so filters read ($n::bigint[] IS NULL OR col = ANY($n)).
+type userScope struct {
+	WarehouseIDs   []int64 `json:"warehouseIds"`
+	DistributorIDs []int64 `json:"distributorIds"`
+}
+
+func (s userScope) allowsWarehouse(id int64) bool {
+	return s.WarehouseIDs == nil || slices.Contains(s.WarehouseIDs, id)
+}
+
+func (s userScope) allowsDistributor(id int64) bool {
+	return s.DistributorIDs == nil || slices.Contains(s.DistributorIDs, id)
+}
+
+func (a *App) loadUserScope(ctx context.Context, userID int64) (userScope, error) {
+	var sc userScope
+	var err error
+	if sc.WarehouseIDs, err = a.scopeIDs(ctx, `SELECT warehouse_id FROM user_warehouse_scopes WHERE user_id=$1 ORDER BY warehouse_id`, userID); err != nil {
+		return sc, err
+	}
+	if sc.DistributorIDs, err = a.scopeIDs(ctx, `SELECT distributor_id FROM user_distributor_scopes WHERE user_id=$1 ORDER BY distributor_id`, userID); err != nil {
+		return sc, err
+	}
+	return sc, nil
+}
+
+func (a *App) scopeIDs(ctx context.Context, q string, userID int64) ([]int64, error) {
+	rows, err := a.db.Query(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// withUserScope loads the session user's scope into the request context for the
+// ops and executive handlers.
+func (a *App) withUserScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := r.Context().Value(ctxUserKey).(User)
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
+			return
+		}
+		sc, err := a.loadUserScope(r.Context(), u.ID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxScopeKey, sc)))
+	})
+}
+
+func scopeFrom(r *http.Request) userScope {
+	sc, _ := r.Context().Value(ctxScopeKey).(userScope)
+	return sc
+}
+
+// ---------- login throttling ----------
+
+// Lockout scopes stored in login_lockouts.scope.
+const (
+	lockoutScopeEmail = "EMAIL"
+	lockoutScopeIP    = "IP"
+)
+
+// loginLockedUntil reports the active lock, if any, on the email or client address.
+func (a *App) loginLockedUntil(ctx context.Context, email, ip string) (string, *time.Time, error) {
+	var scope string
+	var until time.Time
+	err := a.db.QueryRow(ctx, `
+    SELECT scope, locked_until
+    FROM login_lockouts
+    WHERE locked_until > now()
+      AND ((scope=$1 AND subject=$2) OR (scope=$3 AND subject=$4))
+    ORDER BY locked_until DESC
+    LIMIT 1
+  `, lockoutScopeEmail, email, lockoutScopeIP, ip).Scan(&scope, &until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return scope, &until, nil
+}
+
+// recordLoginFailure counts a failed attempt against the email and the client
+// address, locking whichever reaches its limit. It returns the scope and expiry
+// of a lock this failure triggered.
+func (a *App) recordLoginFailure(ctx context.Context, email, ip string) (string, *time.Time, error) {
+	windowSecs := int64(a.cfg.LoginFailureWindow / time.Second)
+	lockSecs := int64(a.cfg.LoginLockoutDuration / time.Second)
+	limits := []struct {
+		scope, subject string
+		max            int
+	}{
+		{lockoutScopeEmail, email, a.cfg.LoginMaxFailures},
+		{lockoutScopeIP, ip, a.cfg.LoginIPMaxFailures},
+	}
+	for _, l := range limits {
+		if l.max <= 0 || l.subject == "" {
+			continue
+		}
+		var failures int
+		if err := a.db.QueryRow(ctx, `
+      INSERT INTO login_lockouts (scope, subject, failures, window_start, updated_at)
+      VALUES ($1,$2,1,now(),now())
+      ON CONFLICT (scope, subject) DO UPDATE SET
+        failures = CASE WHEN login_lockouts.window_start < now() - ($3::bigint * INTERVAL '1 second')
+                        THEN 1 ELSE login_lockouts.failures + 1 END,
+        window_start = CASE WHEN login_lockouts.window_start < now() - ($3::bigint * INTERVAL '1 second')
+                            THEN now() ELSE login_lockouts.window_start END,
+        updated_at = now()
+      RETURNING failures
+    `, l.scope, l.subject, windowSecs).Scan(&failures); err != nil {
+			return "", nil, err
+		}
+		if failures < l.max || lockSecs <= 0 {
+			continue
+		}
+		var until time.Time
+		if err := a.db.QueryRow(ctx, `
+      UPDATE login_lockouts
+      SET locked_until = now() + ($3::bigint * INTERVAL '1 second'), failures = 0, window_start = now(), updated_at = now()
+      WHERE scope=$1 AND subject=$2
+      RETURNING locked_until
+    `, l.scope, l.subject, lockSecs).Scan(&until); err != nil {
+			return "", nil, err
+		}
+		return l.scope, &until, nil
+	}
+	return "", nil, nil
+}
+
+func (a *App) recordLoginAttempt(r *http.Request, email string, userID *int64, success bool, reason string) {
+	if _, err := a.db.Exec(r.Context(), `
+    INSERT INTO login_attempts (email, user_id, ip, user_agent, success, reason)
+    VALUES ($1,$2,$3,$4,$5,$6)
+  `, email, userID, clientIP(r), r.UserAgent(), success, reason); err != nil {
+		log.Printf("login: record attempt: %v", err)
+	}
+}
+
+func writeLoginLocked(w http.ResponseWriter, until time.Time) {
+	secs := int(math.Ceil(time.Until(until).Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeAPIError(w, http.StatusTooManyRequests, "LOCKED", "too many failed login attempts, try again later")
+}
+
 func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Email    string `json:"email"`
@@ -359,44 +1000,126 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse locked accounts and addresses before doing any bcrypt work.
+	ip := clientIP(r)
+	scope, until, err := a.loginLockedUntil(r.Context(), body.Email, ip)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if until != nil {
+		a.recordLoginAttempt(r, body.Email, nil, false, "LOCKED")
+		a.insertAuditLog(r, nil, "LOGIN_LOCKED", "login", body.Email, map[string]any{"email": body.Email, "scope": scope, "lockedUntil": until})
+		writeLoginLocked(w, *until)
+		return
+	}
+
 	var u User
 	var passwordHash string
-	row := a.db.QueryRow(r.Context(), `SELECT id, name, email, role, password_hash FROM users WHERE email = $1`, body.Email)
-	if err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &passwordHash); err != nil {
+	var disabledAt *time.Time
+	row := a.db.QueryRow(r.Context(), `
+    SELECT u.id, u.name, u.email, u.role, ro.kind, u.password_hash, u.disabled_at
+    FROM users u
+    JOIN roles ro ON ro.key = u.role
+    WHERE u.email = $1
+  `, body.Email)
+	var userID *int64
+	err = row.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.RoleKind, &passwordHash, &disabledAt)
+	if err == nil {
+		userID = &u.ID
+		err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(body.Password))
+	}
+	if err != nil {
+		a.recordLoginAttempt(r, body.Email, userID, false, "INVALID_CREDENTIALS")
+		a.insertAuditLog(r, nil, "LOGIN_FAILED", "login", body.Email, map[string]any{"email": body.Email, "userId": userID})
+		scope, until, lerr := a.recordLoginFailure(r.Context(), body.Email, ip)
+		if lerr != nil {
+			log.Printf("login: record failure: %v", lerr)
+		}
+		if until != nil {
+			a.insertAuditLog(r, nil, "LOGIN_LOCKED", "login", body.Email, map[string]any{"email": body.Email, "scope": scope, "lockedUntil": until})
+		}
 		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
 		return
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(body.Password)); err != nil {
-		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+	if disabledAt != nil {
+		a.recordLoginAttempt(r, body.Email, userID, false, "DISABLED")
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "account disabled")
 		return
 	}
 
-	sid := uuid.New()
-	expires := time.Now().Add(7 * 24 * time.Hour)
-	if _, err := a.db.Exec(r.Context(), `INSERT INTO sessions (id, user_id, expires_at) VALUES ($1,$2,$3)`, sid, u.ID, expires); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "could not create session")
+	// Accounts with 2FA enabled, or whose role requires it, get a short-lived
+	// challenge instead of a session; the login completes at /auth/login/mfa.
+	enabled, required, err := a.mfaStatus(r.Context(), u)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-
-	secure := a.cfg.CookieSecure
-	if !secure {
-		if strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
-			secure = true
+	if enabled || required {
+		challengeID, err := a.createLoginChallenge(r.Context(), u.ID, loginMethodPassword)
+		if err != nil {
+			writeDBError(w, err)
+			return
 		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"mfaRequired":        true,
+			"enrollmentRequired": !enabled,
+			"challengeId":        challengeID.String(),
+		})
+		return
+	}
+
+	if _, ok := a.startSession(w, r, u, loginMethodPassword, false); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": u})
+}
+
+// Login methods, recorded on login challenges and in the LOGIN audit entry.
+const (
+	loginMethodPassword = "password"
+	loginMethodOIDC     = "oidc"
+)
+
+// startSession records the successful login, clears the account's failure
+// counter, creates the session row and sets the cookie. It writes the error
+// response itself and reports false on failure.
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, u User, method string, viaMFA bool) (uuid.UUID, bool) {
+	uid := u.ID
+	a.recordLoginAttempt(r, u.Email, &uid, true, "")
+	if _, err := a.db.Exec(r.Context(), `DELETE FROM login_lockouts WHERE scope=$1 AND subject=$2`, lockoutScopeEmail, u.Email); err != nil {
+		log.Printf("login: reset failures: %v", err)
+	}
+
+	sid := uuid.New()
+	expires := time.Now().Add(a.cfg.SessionAbsoluteTimeout)
+	if _, err := a.db.Exec(r.Context(), `
+    INSERT INTO sessions (id, user_id, expires_at, ip, user_agent)
+    VALUES ($1,$2,$3,$4,$5)
+  `, sid, u.ID, expires, clientIP(r), r.UserAgent()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "could not create session")
+		return sid, false
 	}
+
 	http.SetCookie(w, &http.Cookie{
 		Name:     "cementops_session",
 		Value:    sid.String(),
 		Path:     "/",
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-		Secure:   secure,
+		Secure:   a.secureCookies(r),
 		Expires:  expires,
 	})
+	a.setCSRFCookie(w, r, sid, expires)
 
-	a.insertAuditLog(r, &u, "LOGIN", "session", sid.String(), map[string]any{"email": u.Email})
+	a.insertAuditLog(r, &u, "LOGIN", "session", sid.String(), map[string]any{"email": u.Email, "method": method, "mfa": viaMFA})
+	return sid, true
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{"user": u})
+// secureCookies reports whether cookies should be marked Secure: always with
+// COOKIE_SECURE, otherwise when a proxy says the client used HTTPS.
+func (a *App) secureCookies(r *http.Request) bool {
+	return a.cfg.CookieSecure || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
 }
 
 func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
@@ -414,888 +1137,1170 @@ func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1,
 		Expires:  time.Unix(0, 0),
 	})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookieName, Value: "", Path: "/", MaxAge: -1, Expires: time.Unix(0, 0)})
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (a *App) handleMe(w http.ResponseWriter, r *http.Request) {
-	u, ok := r.Context().Value(ctxUserKey).(User)
-	if !ok {
-		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
-		return
-	}
-	writeJSON(w, http.StatusOK, map[string]any{"user": u})
-}
+// ---------- oidc single sign-on ----------
 
-func (a *App) handleRBACMe(w http.ResponseWriter, r *http.Request) {
-	u, ok := r.Context().Value(ctxUserKey).(User)
-	if !ok {
-		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
-		return
-	}
+const (
+	oidcStateTTL    = 10 * time.Minute
+	oidcStateCookie = "cementops_oidc_state"
+	oidcCookiePath  = "/api/auth/oidc"
+)
 
-	var config json.RawMessage
-	if err := a.db.QueryRow(r.Context(), `SELECT config FROM rbac_config WHERE role=$1`, u.Role).Scan(&config); err != nil {
-		if err == sql.ErrNoRows {
-			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "rbac config not found")
-			return
-		}
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
-	}
+// oidcLoginError is sent to the login page as ?error= when SSO fails. The
+// details go to the server log and login_attempts, not to the browser.
+func oidcLoginError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, "/login?error="+url.QueryEscape(code), http.StatusFound)
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{"role": u.Role, "config": config})
+// safeRedirectPath only allows same-origin absolute paths, so ?next= cannot be
+// used as an open redirect.
+func safeRedirectPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/dashboard"
+	}
+	return p
 }
 
-// ---------- planning ----------
+func (a *App) handleAuthProviders(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"oidc": map[string]any{
+			"enabled":     a.oidc != nil,
+			"displayName": a.cfg.OIDCDisplayName,
+		},
+	})
+}
 
-func parseBBox(s string) (minLat, minLng, maxLat, maxLng float64, ok bool) {
-	parts := strings.Split(strings.TrimSpace(s), ",")
-	if len(parts) != 4 {
-		return 0, 0, 0, 0, false
+// handleOIDCLogin starts the authorization-code flow: it stores state, nonce
+// and the PKCE verifier, binds the state to this browser with a cookie and
+// redirects to the identity provider.
+func (a *App) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "single sign-on is not configured")
+		return
 	}
-	f := make([]float64, 4)
-	for i := 0; i < 4; i++ {
-		v, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
-		if err != nil {
-			return 0, 0, 0, 0, false
-		}
-		f[i] = v
+	state, err1 := oidc.RandomString(32)
+	nonce, err2 := oidc.RandomString(32)
+	verifier, err3 := oidc.RandomString(48)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "could not start sign-in")
+		return
 	}
-	return f[0], f[1], f[2], f[3], true
+	authURL, err := a.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		oidcLoginError(w, r, "sso_unavailable")
+		return
+	}
+	redirectTo := safeRedirectPath(r.URL.Query().Get("next"))
+	if _, err := a.db.Exec(r.Context(), `
+    INSERT INTO oidc_login_states (state, nonce, code_verifier, redirect_to, expires_at)
+    VALUES ($1,$2,$3,$4,$5)
+  `, state, nonce, verifier, redirectTo, time.Now().Add(oidcStateTTL)); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.secureCookies(r),
+		MaxAge:   int(oidcStateTTL / time.Second),
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
-func (a *App) handlePlanningHeatmap(w http.ResponseWriter, r *http.Request) {
-	bboxStr := r.URL.Query().Get("bbox")
-	minLat, minLng, maxLat, maxLng, ok := parseBBox(bboxStr)
-	if !ok {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "bbox must be minLat,minLng,maxLat,maxLng")
+// handleOIDCCallback completes the flow. The user is found by their linked
+// identity, then by verified email, and is otherwise created when
+// OIDC_AUTO_PROVISION is on. Local 2FA still applies: users who have it (or
+// whose role requires it) continue on the login page with a challenge.
+func (a *App) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "single sign-on is not configured")
+		return
+	}
+	q := r.URL.Query()
+	state := q.Get("state")
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: oidcCookiePath, HttpOnly: true, MaxAge: -1})
+	c, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || c.Value != state {
+		oidcLoginError(w, r, "sso_state")
+		return
+	}
+	var nonce, verifier, redirectTo string
+	err = a.db.QueryRow(r.Context(), `
+    DELETE FROM oidc_login_states
+    WHERE state=$1 AND expires_at > now()
+    RETURNING nonce, code_verifier, redirect_to
+  `, state).Scan(&nonce, &verifier, &redirectTo)
+	if errors.Is(err, sql.ErrNoRows) {
+		oidcLoginError(w, r, "sso_state")
 		return
 	}
-
-	rows, err := a.db.Query(r.Context(), `
-    SELECT lat, lng, demand_tons_month
-    FROM projects
-    WHERE lat BETWEEN $1 AND $2 AND lng BETWEEN $3 AND $4
-  `, minLat, maxLat, minLng, maxLng)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
-	defer rows.Close()
-
-	cell := 0.02
-	type cellKey struct{ a, b int }
-	scores := map[cellKey]float64{}
-	for rows.Next() {
-		var lat, lng, demand float64
-		if err := rows.Scan(&lat, &lng, &demand); err != nil {
-			continue
-		}
-		la := int(math.Floor(lat / cell))
-		lb := int(math.Floor(lng / cell))
-		scores[cellKey{la, lb}] += demand
+	if e := q.Get("error"); e != "" {
+		log.Printf("oidc: provider returned error %q: %s", e, q.Get("error_description"))
+		oidcLoginError(w, r, "sso_denied")
+		return
 	}
 
-	out := make([]map[string]any, 0, len(scores))
-	for k, sc := range scores {
-		clat := float64(k.a) * cell
-		clng := float64(k.b) * cell
-		out = append(out, map[string]any{
-			"cellLat":   clat,
-			"cellLng":   clng,
-			"centerLat": clat + cell/2,
-			"centerLng": clng + cell/2,
-			"size":      cell,
-			"score":     sc,
-		})
+	claims, err := a.oidc.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		oidcLoginError(w, r, "sso_failed")
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"cells": out, "cellSize": cell})
-}
+	email := strings.ToLower(strings.TrimSpace(claims.String("email")))
 
-func (a *App) handlePlanningSiteProfile(w http.ResponseWriter, r *http.Request) {
-	lat, err1 := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
-	lng, err2 := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
-	if err1 != nil || err2 != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "lat and lng required")
+	u, reason, err := a.resolveOIDCUser(r, claims)
+	if err != nil {
+		log.Printf("oidc: resolve user: %v", err)
+		oidcLoginError(w, r, "sso_failed")
+		return
+	}
+	if reason != "" {
+		var userID *int64
+		if u.ID != 0 {
+			userID = &u.ID
+		}
+		a.recordLoginAttempt(r, email, userID, false, reason)
+		a.insertAuditLog(r, nil, "LOGIN_FAILED", "login", email, map[string]any{
+			"email":   email,
+			"userId":  userID,
+			"reason":  reason,
+			"method":  loginMethodOIDC,
+			"subject": claims.String("sub"),
+		})
+		oidcLoginError(w, r, strings.ToLower(reason))
 		return
 	}
 
-	// Road width: nearest segments within 0.5km, take max width.
-	rows, err := a.db.Query(r.Context(), `SELECT width_m, lat, lng, name FROM road_segments`)
+	enabled, required, err := a.mfaStatus(r.Context(), u)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
-	defer rows.Close()
-
-	bestWidth := 0.0
-	bestRoad := ""
-	for rows.Next() {
-		var width, rlat, rlng float64
-		var name string
-		if err := rows.Scan(&width, &rlat, &rlng, &name); err != nil {
-			continue
+	if enabled || required {
+		challengeID, err := a.createLoginChallenge(r.Context(), u.ID, loginMethodOIDC)
+		if err != nil {
+			writeDBError(w, err)
+			return
 		}
-		dkm := haversineKM(lat, lng, rlat, rlng)
-		if dkm <= 0.5 && width > bestWidth {
-			bestWidth = width
-			bestRoad = name
+		v := url.Values{"challenge": {challengeID.String()}}
+		if !enabled {
+			v.Set("enroll", "1")
 		}
+		http.Redirect(w, r, "/login?"+v.Encode(), http.StatusFound)
+		return
 	}
 
-	// Demand around point.
-	prow, err := a.db.Query(r.Context(), `SELECT lat, lng, demand_tons_month FROM projects`)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	if _, ok := a.startSession(w, r, u, loginMethodOIDC, false); !ok {
 		return
 	}
-	defer prow.Close()
-	demand3km := 0.0
-	for prow.Next() {
-		var plat, plng, dem float64
-		if err := prow.Scan(&plat, &plng, &dem); err != nil {
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
+// oidcRole maps the configured role claim to a role key. The first claim value
+// that maps to a defined role wins. Distributor roles also need a distributor
+// id from OIDC_DISTRIBUTOR_CLAIM; without one the role is not assignable.
+func (a *App) oidcRole(ctx context.Context, claims oidc.Claims) (role string, distributorID *int64, err error) {
+	for _, v := range claims.Strings(a.cfg.OIDCRoleClaim) {
+		key := v
+		if len(a.cfg.OIDCRoleMap) > 0 {
+			if key = a.cfg.OIDCRoleMap[v]; key == "" {
+				continue
+			}
+		}
+		kind, err := a.roleKind(ctx, key)
+		if err != nil {
+			return "", nil, err
+		}
+		if kind == "" {
 			continue
 		}
-		if haversineKM(lat, lng, plat, plng) <= 3 {
-			demand3km += dem
+		if kind != roleKindDistributor {
+			return key, nil, nil
+		}
+		if id, ok := claimInt64(claims, a.cfg.OIDCDistributorClaim); ok {
+			return key, &id, nil
 		}
 	}
+	return "", nil, nil
+}
 
-	// Distance to nearest warehouse.
-	wrows, err := a.db.Query(r.Context(), `SELECT lat, lng FROM warehouses`)
+// claimInt64 reads a numeric claim sent either as a JSON number or a string.
+func claimInt64(claims oidc.Claims, name string) (int64, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		if v == math.Trunc(v) && v > 0 {
+			return int64(v), true
+		}
+	case string:
+		if id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && id > 0 {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// resolveOIDCUser finds or provisions the local user for verified ID token
+// claims and brings their role and distributor in line with the IdP. A
+// non-empty reason means the sign-in is refused (u may still be set, for the
+// attempt log); err is reserved for database failures.
+func (a *App) resolveOIDCUser(r *http.Request, claims oidc.Claims) (u User, reason string, err error) {
+	ctx := r.Context()
+	issuer, subject := claims.String("iss"), claims.String("sub")
+	email := strings.ToLower(strings.TrimSpace(claims.String("email")))
+	name := strings.TrimSpace(claims.String("name"))
+	if name == "" {
+		name = email
+	}
+	role, distributorID, err := a.oidcRole(ctx, claims)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+		return u, "", err
 	}
-	defer wrows.Close()
-	nearestWH := 1e9
-	for wrows.Next() {
-		var wlat, wlng float64
-		if err := wrows.Scan(&wlat, &wlng); err != nil {
-			continue
-		}
-		d := haversineKM(lat, lng, wlat, wlng)
-		if d < nearestWH {
-			nearestWH = d
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return u, "", err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	linked := true
+	err = tx.QueryRow(ctx, `SELECT user_id FROM user_identities WHERE issuer=$1 AND subject=$2`, issuer, subject).Scan(&u.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		linked = false
+		// Only a verified email may claim an existing local account.
+		err = nil
+		if email != "" && claims.Bool("email_verified") {
+			err = tx.QueryRow(ctx, `SELECT id FROM users WHERE email=$1`, email).Scan(&u.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = nil
+			}
 		}
 	}
+	if err != nil {
+		return u, "", err
+	}
 
-	score := 50.0
-	reasons := []string{}
+	provisioned := false
+	if u.ID == 0 {
+		switch {
+		case !a.cfg.OIDCAutoProvision || email == "":
+			return u, "OIDC_NO_ACCOUNT", nil
+		case role == "":
+			return u, "OIDC_NO_ROLE", nil
+		}
+		// An empty password hash never matches bcrypt, so provisioned users can
+		// only sign in through the IdP until an administrator sets a password.
+		err = tx.QueryRow(ctx, `
+      INSERT INTO users (name, email, password_hash, role, distributor_id)
+      VALUES ($1,$2,'',$3,$4)
+      ON CONFLICT (email) DO NOTHING
+      RETURNING id
+    `, name, email, role, distributorID).Scan(&u.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The email belongs to an account the unverified IdP email cannot claim.
+			return u, "OIDC_EMAIL_IN_USE", nil
+		}
+		if err != nil {
+			return u, "", err
+		}
+		provisioned = true
+	}
 
-	if bestWidth > 0 {
-		if bestWidth >= 7 {
-			score += 20
-			reasons = append(reasons, "Road width "+fmtFloat(bestWidth)+"m OK for truck ("+bestRoad+")")
-		} else if bestWidth >= 5 {
-			score += 10
-			reasons = append(reasons, "Road width "+fmtFloat(bestWidth)+"m acceptable ("+bestRoad+")")
-		} else {
-			score -= 10
-			reasons = append(reasons, "Road width "+fmtFloat(bestWidth)+"m may be tight for heavy truck")
+	if !linked {
+		if _, err := tx.Exec(ctx, `
+      INSERT INTO user_identities (issuer, subject, user_id, email) VALUES ($1,$2,$3,$4)
+    `, issuer, subject, u.ID, email); err != nil {
+			return u, "", err
 		}
-	} else {
-		score -= 5
-		reasons = append(reasons, "No nearby road segment within 500m")
+	} else if _, err := tx.Exec(ctx, `
+    UPDATE user_identities SET last_login_at=now(), email=$3 WHERE issuer=$1 AND subject=$2
+  `, issuer, subject, email); err != nil {
+		return u, "", err
 	}
 
-	if demand3km > 600 {
-		score += 20
-		reasons = append(reasons, "High demand within 3km")
-	} else if demand3km > 250 {
-		score += 10
-		reasons = append(reasons, "Moderate demand within 3km")
-	} else {
-		reasons = append(reasons, "Demand within 3km is low")
+	var prevRole string
+	var prevDistributorID *int64
+	if err := tx.QueryRow(ctx, `SELECT role, distributor_id FROM users WHERE id=$1`, u.ID).Scan(&prevRole, &prevDistributorID); err != nil {
+		return u, "", err
+	}
+	synced := false
+	if role != "" && (role != prevRole || !equalInt64Ptr(distributorID, prevDistributorID)) {
+		if _, err := tx.Exec(ctx, `UPDATE users SET role=$2, distributor_id=$3 WHERE id=$1`, u.ID, role, distributorID); err != nil {
+			return u, "", err
+		}
+		synced = !provisioned
 	}
 
-	if nearestWH < 20 {
-		score += 10
-		reasons = append(reasons, "Warehouse within 20km")
-	} else if nearestWH < 50 {
-		score += 5
-		reasons = append(reasons, "Warehouse within 50km")
-	} else {
-		score -= 5
-		reasons = append(reasons, "Nearest warehouse is far")
+	var distributor sql.NullInt64
+	var disabledAt *time.Time
+	if err := tx.QueryRow(ctx, `
+    SELECT u.name, u.email, u.role, ro.kind, u.distributor_id, u.disabled_at
+    FROM users u
+    JOIN roles ro ON ro.key = u.role
+    WHERE u.id=$1
+  `, u.ID).Scan(&u.Name, &u.Email, &u.Role, &u.RoleKind, &distributor, &disabledAt); err != nil {
+		return u, "", err
+	}
+	if distributor.Valid {
+		v := distributor.Int64
+		u.DistributorID = &v
 	}
 
-	if score < 0 {
-		score = 0
+	if provisioned {
+		err = a.insertAuditLogTx(tx, r, nil, "USER_PROVISIONED", "user", fmt.Sprintf("%d", u.ID), map[string]any{
+			"email": email, "role": role, "distributorId": distributorID, "issuer": issuer, "subject": subject,
+		})
+	} else if !linked {
+		err = a.insertAuditLogTx(tx, r, nil, "USER_IDENTITY_LINKED", "user", fmt.Sprintf("%d", u.ID), map[string]any{"issuer": issuer, "subject": subject})
 	}
-	if score > 100 {
-		score = 100
+	if err != nil {
+		return u, "", err
+	}
+	if synced {
+		if err := a.insertAuditLogTx(tx, r, nil, "USER_ROLE_SYNCED", "user", fmt.Sprintf("%d", u.ID), map[string]any{
+			"from": map[string]any{"role": prevRole, "distributorId": prevDistributorID},
+			"to":   map[string]any{"role": role, "distributorId": distributorID},
+		}); err != nil {
+			return u, "", err
+		}
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return u, "", err
+	}
+	if disabledAt != nil {
+		return u, "DISABLED", nil
+	}
+	return u, "", nil
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"score":                 score,
-		"reasons":               reasons,
-		"roadWidthM":            bestWidth,
-		"demandWithin3km":       demand3km,
-		"distanceToWarehouseKm": nearestWH,
-	})
+func equalInt64Ptr(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
-func (a *App) handlePlanningWhitespace(w http.ResponseWriter, r *http.Request) {
-	bboxStr := r.URL.Query().Get("bbox")
-	minLat, minLng, maxLat, maxLng, ok := parseBBox(bboxStr)
-	if !ok {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "bbox must be minLat,minLng,maxLat,maxLng")
-		return
+// ---------- two-factor authentication ----------
+
+const (
+	loginChallengeTTL         = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+	recoveryCodeCount         = 10
+)
+
+// mfaStatus reports whether the user has confirmed TOTP and whether their
+// role's policy in rbac_config requires it.
+func (a *App) mfaStatus(ctx context.Context, u User) (enabled, required bool, err error) {
+	err = a.db.QueryRow(ctx, `
+    SELECT
+      EXISTS(SELECT 1 FROM user_mfa WHERE user_id=$1 AND enabled_at IS NOT NULL),
+      COALESCE((SELECT mfa_required FROM rbac_config WHERE role=$2), false)
+  `, u.ID, u.Role).Scan(&enabled, &required)
+	return enabled, required, err
+}
+
+func (a *App) createLoginChallenge(ctx context.Context, userID int64, method string) (uuid.UUID, error) {
+	id := uuid.New()
+	_, err := a.db.Exec(ctx, `
+    INSERT INTO login_challenges (id, user_id, expires_at, method)
+    VALUES ($1,$2,$3,$4)
+  `, id, userID, time.Now().Add(loginChallengeTTL), method)
+	return id, err
+}
+
+// beginMFAEnrollment stores a fresh, unconfirmed TOTP secret for the user and
+// returns it with its otpauth URI. Confirmed secrets are never replaced here.
+func (a *App) beginMFAEnrollment(ctx context.Context, u User) (string, string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	sealed, err := a.box.Seal(secret)
+	if err != nil {
+		return "", "", err
+	}
+	tag, err := a.db.Exec(ctx, `
+    INSERT INTO user_mfa (user_id, secret)
+    VALUES ($1,$2)
+    ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret, last_step=0, created_at=now()
+    WHERE user_mfa.enabled_at IS NULL
+  `, u.ID, sealed)
+	if err != nil {
+		return "", "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", "", errMFAAlreadyEnabled
 	}
+	return secret, totp.URI(a.cfg.MFAIssuer, u.Email, secret), nil
+}
 
-	// Preload distributors + stores (small dataset)
-	type pt struct{ lat, lng float64 }
-	drows, _ := a.db.Query(r.Context(), `SELECT lat, lng FROM distributors`)
-	distributors := []pt{}
-	for drows.Next() {
-		var la, ln float64
-		_ = drows.Scan(&la, &ln)
-		distributors = append(distributors, pt{la, ln})
+var errMFAAlreadyEnabled = errors.New("mfa already enabled")
+
+// verifyTOTP checks code against the user's stored secret (confirmed or
+// pending) and advances last_step so the same code cannot be replayed.
+func (a *App) verifyTOTP(ctx context.Context, tx pgx.Tx, userID int64, code string) (bool, error) {
+	var sealed string
+	var lastStep int64
+	if err := tx.QueryRow(ctx, `SELECT secret, last_step FROM user_mfa WHERE user_id=$1 FOR UPDATE`, userID).Scan(&sealed, &lastStep); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
 	}
-	drows.Close()
-	srows, _ := a.db.Query(r.Context(), `SELECT lat, lng FROM stores`)
-	stores := []pt{}
-	for srows.Next() {
-		var la, ln float64
-		_ = srows.Scan(&la, &ln)
-		stores = append(stores, pt{la, ln})
+	secret, err := a.box.Open(sealed)
+	if err != nil {
+		return false, err
 	}
-	srows.Close()
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+	_, err = tx.Exec(ctx, `UPDATE user_mfa SET last_step=$2 WHERE user_id=$1`, userID, step)
+	return err == nil, err
+}
 
-	// Heatmap cells in bbox
-	rows, err := a.db.Query(r.Context(), `
-    SELECT lat, lng, demand_tons_month
-    FROM projects
-    WHERE lat BETWEEN $1 AND $2 AND lng BETWEEN $3 AND $4
-  `, minLat, maxLat, minLng, maxLng)
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecoveryCode consumes a matching unused recovery code.
+func useRecoveryCode(ctx context.Context, tx pgx.Tx, userID int64, code string) (bool, error) {
+	if normalizeRecoveryCode(code) == "" {
+		return false, nil
+	}
+	tag, err := tx.Exec(ctx, `
+    UPDATE user_recovery_codes SET used_at=now()
+    WHERE id = (
+      SELECT id FROM user_recovery_codes
+      WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL
+      LIMIT 1
+    )
+  `, userID, hashRecoveryCode(code))
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+		return false, err
 	}
-	defer rows.Close()
+	return tag.RowsAffected() == 1, nil
+}
 
-	cell := 0.02
-	type cellKey struct{ a, b int }
-	scores := map[cellKey]float64{}
-	for rows.Next() {
-		var lat, lng, demand float64
-		if err := rows.Scan(&lat, &lng, &demand); err != nil {
-			continue
+// replaceRecoveryCodes discards the user's recovery codes and returns a new set.
+// Only hashes are stored, so this is the only time the codes are visible.
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64) ([]string, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
 		}
-		la := int(math.Floor(lat / cell))
-		lb := int(math.Floor(lng / cell))
-		scores[cellKey{la, lb}] += demand
+		h := hex.EncodeToString(b)
+		code := h[:5] + "-" + h[5:]
+		if _, err := tx.Exec(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1,$2)`, userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
 	}
+	return codes, nil
+}
 
-	whitespace := []map[string]any{}
-	for k, sc := range scores {
-		if sc < 450 {
-			continue
-		}
-		clat := float64(k.a) * cell
-		clng := float64(k.b) * cell
-		centerLat := clat + cell/2
-		centerLng := clng + cell/2
+// loginChallenge is a pending second login step.
+type loginChallenge struct {
+	ID     uuid.UUID
+	Method string
+}
 
-		nearestStore := 1e9
-		for _, s := range stores {
-			d := haversineKM(centerLat, centerLng, s.lat, s.lng)
-			if d < nearestStore {
-				nearestStore = d
-			}
-		}
-		nearestDist := 1e9
-		for _, d := range distributors {
-			dd := haversineKM(centerLat, centerLng, d.lat, d.lng)
-			if dd < nearestDist {
-				nearestDist = dd
-			}
-		}
-		if nearestStore > 5 && nearestDist > 6 {
-			whitespace = append(whitespace, map[string]any{
-				"cellLat":              clat,
-				"cellLng":              clng,
-				"centerLat":            centerLat,
-				"centerLng":            centerLng,
-				"size":                 cell,
-				"score":                sc,
-				"nearestStoreKm":       nearestStore,
-				"nearestDistributorKm": nearestDist,
-			})
+// loginChallengeUser loads the user behind a pending login challenge. It
+// writes the error response itself and reports false when the challenge is
+// missing, expired or used up.
+func (a *App) loginChallengeUser(w http.ResponseWriter, r *http.Request, rawID string) (loginChallenge, User, bool) {
+	var u User
+	var c loginChallenge
+	id, err := uuid.Parse(strings.TrimSpace(rawID))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid challengeId")
+		return c, u, false
+	}
+	c.ID = id
+	var expiresAt time.Time
+	var attempts int
+	var disabledAt *time.Time
+	if err := a.db.QueryRow(r.Context(), `
+    SELECT u.id, u.name, u.email, u.role, ro.kind, u.disabled_at, c.expires_at, c.attempts, c.method
+    FROM login_challenges c
+    JOIN users u ON u.id = c.user_id
+    JOIN roles ro ON ro.key = u.role
+    WHERE c.id=$1
+  `, id).Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.RoleKind, &disabledAt, &expiresAt, &attempts, &c.Method); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "login challenge not found")
+			return c, u, false
 		}
+		writeDBError(w, err)
+		return c, u, false
+	}
+	if time.Now().After(expiresAt) || attempts >= loginChallengeMaxAttempts || disabledAt != nil {
+		_, _ = a.db.Exec(r.Context(), `DELETE FROM login_challenges WHERE id=$1`, id)
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "login challenge expired")
+		return c, u, false
 	}
+	if _, until, err := a.loginLockedUntil(r.Context(), u.Email, clientIP(r)); err != nil {
+		writeDBError(w, err)
+		return c, u, false
+	} else if until != nil {
+		writeLoginLocked(w, *until)
+		return c, u, false
+	}
+	return c, u, true
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{"areas": whitespace, "cellSize": cell})
+// handleLoginMFAEnroll starts enrollment for a user whose role requires 2FA but
+// who has not set it up yet, so they can finish logging in.
+func (a *App) handleLoginMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ChallengeID string `json:"challengeId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	_, u, ok := a.loginChallengeUser(w, r, body.ChallengeID)
+	if !ok {
+		return
+	}
+	secret, uri, err := a.beginMFAEnrollment(r.Context(), u)
+	if errors.Is(err, errMFAAlreadyEnabled) {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "two-factor authentication already enabled")
+		return
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"secret": secret, "otpauthUri": uri})
 }
 
-func (a *App) handlePlanningCatchment(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	warehouseID := strings.TrimSpace(q.Get("warehouseId"))
-	distributorID := strings.TrimSpace(q.Get("distributorId"))
-	if warehouseID == "" && distributorID == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "warehouseId or distributorId required")
+// handleLoginMFA completes a login with a TOTP code or a recovery code. When the
+// user was enrolling, the first valid code also confirms 2FA and the response
+// carries their recovery codes.
+func (a *App) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ChallengeID  string `json:"challengeId"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if strings.TrimSpace(body.Code) == "" && strings.TrimSpace(body.RecoveryCode) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "code or recoveryCode required")
+		return
+	}
+	challenge, u, ok := a.loginChallengeUser(w, r, body.ChallengeID)
+	if !ok {
 		return
 	}
+	challengeID := challenge.ID
 
-	type entity struct {
-		id     int64
-		name   string
-		lat    float64
-		lng    float64
-		radius float64
-		kind   string
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
 	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
 
-	var sel entity
-	if distributorID != "" {
-		id, err := strconv.ParseInt(distributorID, 10, 64)
-		if err != nil {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid distributorId")
+	var enabledAt *time.Time
+	if err := tx.QueryRow(r.Context(), `SELECT enabled_at FROM user_mfa WHERE user_id=$1`, u.ID).Scan(&enabledAt); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeDBError(w, err)
+		return
+	}
+	enrolling := enabledAt == nil
+
+	valid := false
+	usedRecovery := false
+	if strings.TrimSpace(body.Code) != "" {
+		valid, err = a.verifyTOTP(r.Context(), tx, u.ID, body.Code)
+	} else if !enrolling {
+		valid, err = useRecoveryCode(r.Context(), tx, u.ID, body.RecoveryCode)
+		usedRecovery = valid
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if !valid {
+		_ = tx.Rollback(r.Context())
+		_, _ = a.db.Exec(r.Context(), `UPDATE login_challenges SET attempts = attempts + 1 WHERE id=$1`, challengeID)
+		uid := u.ID
+		a.recordLoginAttempt(r, u.Email, &uid, false, "MFA_FAILED")
+		a.insertAuditLog(r, nil, "LOGIN_FAILED", "login", u.Email, map[string]any{"email": u.Email, "userId": u.ID, "reason": "MFA_FAILED"})
+		if scope, until, err := a.recordLoginFailure(r.Context(), u.Email, clientIP(r)); err != nil {
+			log.Printf("login: record failure: %v", err)
+		} else if until != nil {
+			a.insertAuditLog(r, nil, "LOGIN_LOCKED", "login", u.Email, map[string]any{"email": u.Email, "scope": scope, "lockedUntil": until})
+		}
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid code")
+		return
+	}
+
+	var recoveryCodes []string
+	if enrolling {
+		if _, err := tx.Exec(r.Context(), `UPDATE user_mfa SET enabled_at=now() WHERE user_id=$1`, u.ID); err != nil {
+			writeDBError(w, err)
 			return
 		}
-		row := a.db.QueryRow(r.Context(), `SELECT id, name, lat, lng, service_radius_km FROM distributors WHERE id = $1`, id)
-		if err := row.Scan(&sel.id, &sel.name, &sel.lat, &sel.lng, &sel.radius); err != nil {
-			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
+		if recoveryCodes, err = replaceRecoveryCodes(r.Context(), tx, u.ID); err != nil {
+			writeDBError(w, err)
 			return
 		}
-		sel.kind = "distributor"
-	} else {
-		id, err := strconv.ParseInt(warehouseID, 10, 64)
-		if err != nil {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid warehouseId")
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM login_challenges WHERE id=$1`, challengeID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if enrolling {
+		if err := a.insertAuditLogTx(tx, r, &u, "MFA_ENABLED", "user", fmt.Sprintf("%d", u.ID), map[string]any{}); err != nil {
+			writeDBError(w, err)
 			return
 		}
-		row := a.db.QueryRow(r.Context(), `SELECT id, name, lat, lng FROM warehouses WHERE id = $1`, id)
-		if err := row.Scan(&sel.id, &sel.name, &sel.lat, &sel.lng); err != nil {
-			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "warehouse not found")
+	}
+	if usedRecovery {
+		if err := a.insertAuditLogTx(tx, r, &u, "MFA_RECOVERY_CODE_USED", "user", fmt.Sprintf("%d", u.ID), map[string]any{}); err != nil {
+			writeDBError(w, err)
 			return
 		}
-		sel.kind = "warehouse"
-		sel.radius = 45
 	}
-
-	// Compute cannibalization overlaps between distributors.
-	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, service_radius_km FROM distributors`)
-	if err != nil {
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
 
-	type dist struct {
-		id     int64
-		name   string
-		lat    float64
-		lng    float64
-		radius float64
+	if _, ok := a.startSession(w, r, u, challenge.Method, true); !ok {
+		return
 	}
-	dists := []dist{}
-	for rows.Next() {
-		var d dist
-		if err := rows.Scan(&d.id, &d.name, &d.lat, &d.lng, &d.radius); err != nil {
-			continue
-		}
-		dists = append(dists, d)
+	out := map[string]any{"user": u}
+	if recoveryCodes != nil {
+		out["recoveryCodes"] = recoveryCodes
 	}
+	writeJSON(w, http.StatusOK, out)
+}
 
-	conflicts := []map[string]any{}
-	if sel.kind == "distributor" {
-		for _, d := range dists {
-			if d.id == sel.id {
-				continue
-			}
-			centerDist := haversineKM(sel.lat, sel.lng, d.lat, d.lng)
-			if centerDist < (sel.radius + d.radius) {
-				conflicts = append(conflicts, map[string]any{
-					"otherDistributorId":   d.id,
-					"otherDistributorName": d.name,
-					"distanceKm":           centerDist,
-					"overlapKm":            (sel.radius + d.radius) - centerDist,
-				})
-			}
-		}
-	} else {
-		// For warehouses, list all pairs of distributors inside warehouse service radius that overlap.
-		inside := []dist{}
-		for _, d := range dists {
-			if haversineKM(sel.lat, sel.lng, d.lat, d.lng) <= sel.radius {
-				inside = append(inside, d)
-			}
-		}
-		for i := 0; i < len(inside); i++ {
-			for j := i + 1; j < len(inside); j++ {
-				a1 := inside[i]
-				a2 := inside[j]
-				centerDist := haversineKM(a1.lat, a1.lng, a2.lat, a2.lng)
-				if centerDist < (a1.radius + a2.radius) {
-					conflicts = append(conflicts, map[string]any{
-						"aDistributorId":   a1.id,
-						"aDistributorName": a1.name,
-						"bDistributorId":   a2.id,
-						"bDistributorName": a2.name,
-						"distanceKm":       centerDist,
-					})
-				}
-			}
-		}
+func (a *App) handleMFAStatus(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	enabled, required, err := a.mfaStatus(r.Context(), u)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	var remaining int
+	if err := a.db.QueryRow(r.Context(), `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id=$1 AND used_at IS NULL`, u.ID).Scan(&remaining); err != nil {
+		writeDBError(w, err)
+		return
 	}
-
 	writeJSON(w, http.StatusOK, map[string]any{
-		"entity": map[string]any{
-			"kind":     sel.kind,
-			"id":       sel.id,
-			"name":     sel.name,
-			"lat":      sel.lat,
-			"lng":      sel.lng,
-			"radiusKm": sel.radius,
-		},
-		"conflicts": conflicts,
+		"enabled":                enabled,
+		"required":               required,
+		"recoveryCodesRemaining": remaining,
 	})
 }
 
-// ---------- operations ----------
-
-func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
-	const r = 6371.0
-	toRad := func(d float64) float64 { return d * math.Pi / 180 }
-	phi1 := toRad(lat1)
-	phi2 := toRad(lat2)
-	dPhi := toRad(lat2 - lat1)
-	dLam := toRad(lng2 - lng1)
-	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLam/2)*math.Sin(dLam/2)
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-	return r * c
-}
-
-func estimateTravelMinutes(lat1, lng1, lat2, lng2 float64) int {
-	km := haversineKm(lat1, lng1, lat2, lng2)
-	// Dummy speed model: 45–60 km/h. Clamp to keep UX stable.
-	speed := 52.0
-	mins := int(math.Ceil((km / speed) * 60))
-	if mins < 60 {
-		mins = 60
+func (a *App) handleMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	secret, uri, err := a.beginMFAEnrollment(r.Context(), u)
+	if errors.Is(err, errMFAAlreadyEnabled) {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "two-factor authentication already enabled")
+		return
 	}
-	if mins > 720 {
-		mins = 720
+	if err != nil {
+		writeDBError(w, err)
+		return
 	}
-	return mins
+	writeJSON(w, http.StatusOK, map[string]any{"secret": secret, "otpauthUri": uri})
 }
 
-func (a *App) insertAuditLog(r *http.Request, actor *User, action, entityType, entityID string, metadata map[string]any) {
-	var actorID any = nil
-	if actor != nil {
-		actorID = actor.ID
+// handleMFAVerify confirms a pending enrollment with the first code from the
+// authenticator app and returns the initial recovery codes.
+func (a *App) handleMFAVerify(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Code string `json:"code"`
 	}
-	b, _ := json.Marshal(metadata)
-	ctx := context.Background()
-	ip := clientIP(r)
-	if r != nil {
-		ctx = r.Context()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
 	}
-	_, _ = a.db.Exec(ctx, `
-    INSERT INTO audit_logs (actor_user_id, action, entity_type, entity_id, metadata, ip)
-	  VALUES ($1,$2,$3,$4,$5::jsonb,$6)
-  `, actorID, action, entityType, entityID, string(b), ip)
-}
-
-func clientIP(r *http.Request) string {
-	if r == nil {
-		return ""
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
 	}
-	// In most deployments chi's RealIP middleware already rewrites RemoteAddr,
-	// but keep header parsing here to be robust.
-	if xff := strings.TrimSpace(r.Header.Get("X-Forwarded-For")); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			ip := strings.TrimSpace(parts[0])
-			if ip != "" {
-				return ip
-			}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var enabledAt *time.Time
+	if err := tx.QueryRow(r.Context(), `SELECT enabled_at FROM user_mfa WHERE user_id=$1`, u.ID).Scan(&enabledAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusConflict, "INVALID_STATE", "no pending enrollment")
+			return
 		}
+		writeDBError(w, err)
+		return
 	}
-	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
-		return xrip
+	if enabledAt != nil {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "two-factor authentication already enabled")
+		return
 	}
-	addr := strings.TrimSpace(r.RemoteAddr)
-	if addr == "" {
-		return ""
+	ok, err := a.verifyTOTP(r.Context(), tx, u.ID, body.Code)
+	if err != nil {
+		writeDBError(w, err)
+		return
 	}
-	host, _, err := net.SplitHostPort(addr)
-	if err == nil {
-		return host
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid code")
+		return
 	}
-	return addr
+	if _, err := tx.Exec(r.Context(), `UPDATE user_mfa SET enabled_at=now() WHERE user_id=$1`, u.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	codes, err := replaceRecoveryCodes(r.Context(), tx, u.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "MFA_ENABLED", "user", fmt.Sprintf("%d", u.ID), map[string]any{}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "recoveryCodes": codes})
 }
 
-func (a *App) handleOpsOverview(w http.ResponseWriter, r *http.Request) {
-	// Aggregations are intentionally simple and rule-based (no ML/AI).
-	var nationalStock float64
-	_ = a.db.QueryRow(r.Context(), `SELECT COALESCE(SUM(quantity_tons),0) FROM stock_levels`).Scan(&nationalStock)
+// handleMFARecoveryCodes issues a new set of recovery codes; a current TOTP code
+// is required so a hijacked session alone cannot mint them.
+func (a *App) handleMFARecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
 
-	regional := []map[string]any{}
+	var enabled bool
+	if err := tx.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM user_mfa WHERE user_id=$1 AND enabled_at IS NOT NULL)`, u.ID).Scan(&enabled); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if !enabled {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "two-factor authentication is not enabled")
+		return
+	}
+	ok, err := a.verifyTOTP(r.Context(), tx, u.ID, body.Code)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid code")
+		return
+	}
+	codes, err := replaceRecoveryCodes(r.Context(), tx, u.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "MFA_RECOVERY_CODES_REGENERATED", "user", fmt.Sprintf("%d", u.ID), map[string]any{}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "recoveryCodes": codes})
+}
+
+func (a *App) handleMFADisable(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	enabled, required, err := a.mfaStatus(r.Context(), u)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if !enabled {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "two-factor authentication is not enabled")
+		return
+	}
+	if required {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "two-factor authentication is required for your role")
+		return
+	}
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	ok, err := a.verifyTOTP(r.Context(), tx, u.ID, body.Code)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid code")
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM user_mfa WHERE user_id=$1`, u.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM user_recovery_codes WHERE user_id=$1`, u.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "MFA_DISABLED", "user", fmt.Sprintf("%d", u.ID), map[string]any{}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ---------- sessions ----------
+
+func (a *App) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	current, _ := r.Context().Value(ctxSessionKey).(uuid.UUID)
 	rows, err := a.db.Query(r.Context(), `
-    SELECT w.id, w.name, COALESCE(SUM(s.quantity_tons),0) AS stock
-    FROM warehouses w
-    LEFT JOIN stock_levels s ON s.warehouse_id = w.id
-    GROUP BY w.id, w.name
-    ORDER BY w.id
-  `)
-	if err == nil {
-		for rows.Next() {
-			var id int64
-			var name string
-			var stock float64
-			_ = rows.Scan(&id, &name, &stock)
-			regional = append(regional, map[string]any{"warehouseId": id, "warehouseName": name, "stockTons": stock})
+    SELECT id, created_at, last_seen_at, expires_at, ip, user_agent
+    FROM sessions
+    WHERE user_id=$1 AND expires_at > now()
+    ORDER BY last_seen_at DESC
+  `, u.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id uuid.UUID
+		var createdAt, lastSeenAt, expiresAt time.Time
+		var ip, ua string
+		if err := rows.Scan(&id, &createdAt, &lastSeenAt, &expiresAt, &ip, &ua); err != nil {
+			writeDBError(w, err)
+			return
 		}
-		rows.Close()
+		items = append(items, map[string]any{
+			"id":         id.String(),
+			"current":    id == current,
+			"createdAt":  createdAt.Format(time.RFC3339),
+			"lastSeenAt": lastSeenAt.Format(time.RFC3339),
+			"expiresAt":  expiresAt.Format(time.RFC3339),
+			"ip":         ip,
+			"userAgent":  ua,
+		})
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
 
-	var warehousesCritical int
-	_ = a.db.QueryRow(r.Context(), `
-    SELECT COUNT(DISTINCT s.warehouse_id)
-    FROM stock_levels s
-    JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
-    WHERE s.quantity_tons <= t.critical_level
-  `).Scan(&warehousesCritical)
+func (a *App) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	sid, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	tag, err := a.db.Exec(r.Context(), `DELETE FROM sessions WHERE id=$1 AND user_id=$2`, sid, u.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "session not found")
+		return
+	}
+	a.insertAuditLog(r, &u, "SESSION_REVOKED", "session", sid.String(), map[string]any{})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
 
-	var minStockAlerts int
-	_ = a.db.QueryRow(r.Context(), `
-    SELECT COUNT(*)
-    FROM stock_levels s
-    JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
-    WHERE s.quantity_tons <= t.min_stock
-  `).Scan(&minStockAlerts)
+func (a *App) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	current, _ := r.Context().Value(ctxSessionKey).(uuid.UUID)
+	tag, err := a.db.Exec(r.Context(), `DELETE FROM sessions WHERE user_id=$1 AND id<>$2`, u.ID, current)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	a.insertAuditLog(r, &u, "SESSIONS_REVOKED", "user", fmt.Sprintf("%d", u.ID), map[string]any{"revoked": tag.RowsAffected(), "keptCurrent": true})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": tag.RowsAffected()})
+}
 
-	var pendingOrdersToday int
-	_ = a.db.QueryRow(r.Context(), `
-    SELECT COUNT(*)
-    FROM order_requests
-    WHERE status='PENDING' AND requested_at::date = CURRENT_DATE
-  `).Scan(&pendingOrdersToday)
+// ---------- passwords ----------
 
-	var activeShipments int
-	_ = a.db.QueryRow(r.Context(), `
-    SELECT COUNT(*)
-    FROM shipments
-    WHERE status IN ('SCHEDULED','ON_DELIVERY','DELAYED')
-  `).Scan(&activeShipments)
+const (
+	passwordResetTokenBytes = 32
+	// passwordResetCooldown stops forgot-password from being used to flood an
+	// inbox: at most one link per account per cooldown.
+	passwordResetCooldown = time.Minute
+)
 
-	var delayedShipments int
-	_ = a.db.QueryRow(r.Context(), `
-    SELECT COUNT(*)
-    FROM shipments
-    WHERE status='DELAYED' OR (status='ON_DELIVERY' AND arrive_eta IS NOT NULL AND arrive_eta < now())
-  `).Scan(&delayedShipments)
+// PasswordResetMessage is what a PasswordResetSender delivers.
+type PasswordResetMessage struct {
+	UserID    int64
+	Name      string
+	Email     string
+	Link      string
+	ExpiresAt time.Time
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"nationalStockTons":       nationalStock,
-		"regionalStock":           regional,
-		"warehousesCriticalCount": warehousesCritical,
-		"pendingOrdersToday":      pendingOrdersToday,
-		"activeShipments":         activeShipments,
-		"delayedShipments":        delayedShipments,
-		"minStockAlerts":          minStockAlerts,
-		"note":                    "Regional menggunakan warehouse sebagai proxy (region tidak dimodelkan).",
+// PasswordResetSender delivers forgot-password links. It runs inside the
+// transaction that stores the token, so a failed send leaves no usable token.
+type PasswordResetSender interface {
+	SendPasswordReset(ctx context.Context, tx pgx.Tx, msg PasswordResetMessage) error
+}
+
+// outboxResetSender is the default sender: it queues an email in email_outbox.
+type outboxResetSender struct{}
+
+func (outboxResetSender) SendPasswordReset(ctx context.Context, tx pgx.Tx, msg PasswordResetMessage) error {
+	return mailer.Enqueue(ctx, tx, msg.Email, mailer.TemplatePasswordResetLink, map[string]any{
+		"Name":      msg.Name,
+		"Email":     msg.Email,
+		"Link":      msg.Link,
+		"ExpiresAt": msg.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"),
 	})
 }
 
-func (a *App) handleOpsLogisticsMap(w http.ResponseWriter, r *http.Request) {
-	// Plant
-	prow := a.db.QueryRow(r.Context(), `SELECT id, name, lat, lng FROM plants ORDER BY id LIMIT 1`)
-	plant := map[string]any{}
-	{
-		var id int64
-		var name string
-		var lat, lng float64
-		_ = prow.Scan(&id, &name, &lat, &lng)
-		plant = map[string]any{"id": id, "name": name, "lat": lat, "lng": lng}
-	}
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	// Warehouses
-	wrows, _ := a.db.Query(r.Context(), `SELECT id, name, lat, lng, capacity_tons FROM warehouses ORDER BY id`)
-	warehouses := []map[string]any{}
-	for wrows.Next() {
-		var id int64
-		var name string
-		var lat, lng, cap float64
-		_ = wrows.Scan(&id, &name, &lat, &lng, &cap)
-		warehouses = append(warehouses, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "capacityTons": cap})
+// hashPassword applies the password policy and hashes pw. userInputs are
+// values the password must not contain (email, name). It writes the error
+// response itself and reports false on failure.
+func (a *App) hashPassword(w http.ResponseWriter, r *http.Request, pw string, userInputs ...string) (string, bool) {
+	hash, err := a.passwords.Hash(r.Context(), pw, userInputs...)
+	var perr *password.PolicyError
+	if errors.As(err, &perr) {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", perr.Error())
+		return "", false
 	}
-	wrows.Close()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "could not hash password")
+		return "", false
+	}
+	return hash, true
+}
 
-	// Distributors
-	drows, _ := a.db.Query(r.Context(), `SELECT id, name, lat, lng, service_radius_km FROM distributors ORDER BY id`)
-	distributors := []map[string]any{}
-	for drows.Next() {
-		var id int64
-		var name string
-		var lat, lng, rad float64
-		_ = drows.Scan(&id, &name, &lat, &lng, &rad)
-		distributors = append(distributors, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "serviceRadiusKm": rad})
+// handleChangePassword lets a signed-in user change their own password. A wrong
+// current password counts towards the login lockout, so a hijacked session
+// cannot be used to guess it. Other sessions are ended; this one stays.
+func (a *App) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	current, _ := r.Context().Value(ctxSessionKey).(uuid.UUID)
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if body.CurrentPassword == "" || body.NewPassword == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "currentPassword and newPassword required")
+		return
 	}
-	drows.Close()
 
-	// Sample routes: connect each warehouse to a couple of distributors.
-	routes := []map[string]any{}
-	for i := 0; i < len(warehouses); i++ {
-		w := warehouses[i]
-		for j := 0; j < 2; j++ {
-			idx := (i*2 + j) % len(distributors)
-			d := distributors[idx]
-			routes = append(routes, map[string]any{
-				"fromWarehouseId": w["id"],
-				"toDistributorId": d["id"],
-				"polyline": []map[string]any{
-					{"lat": w["lat"], "lng": w["lng"]},
-					{"lat": d["lat"], "lng": d["lng"]},
-				},
-			})
-		}
+	ip := clientIP(r)
+	if _, until, err := a.loginLockedUntil(r.Context(), u.Email, ip); err != nil {
+		writeDBError(w, err)
+		return
+	} else if until != nil {
+		writeLoginLocked(w, *until)
+		return
 	}
 
-	// Active shipments: include simple polyline + simulated truck position.
-	srows, err := a.db.Query(r.Context(), `
-    SELECT s.id, s.status, s.depart_at, s.arrive_eta, s.eta_minutes, s.last_lat, s.last_lng, s.last_update,
-           w.id, w.name, w.lat, w.lng,
-           d.id, d.name, d.lat, d.lng
-    FROM shipments s
-    JOIN warehouses w ON w.id = s.from_warehouse_id
-    JOIN distributors d ON d.id = s.to_distributor_id
-    WHERE s.status IN ('SCHEDULED','ON_DELIVERY','DELAYED')
-    ORDER BY s.id DESC
-    LIMIT 50
-  `)
-	activeShipments := []map[string]any{}
-	if err == nil {
-		now := time.Now().UTC()
-		for srows.Next() {
-			var id int64
-			var status string
-			var depart, eta *time.Time
-			var etaMinutes int
-			var lastLat, lastLng *float64
-			var lastUpdate *time.Time
-			var wid, did int64
-			var wname, dname string
-			var wlat, wlng, dlat, dlng float64
-			_ = srows.Scan(&id, &status, &depart, &eta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname, &wlat, &wlng, &did, &dname, &dlat, &dlng)
-
-			// Simulate position if ON_DELIVERY.
-			if status == "ON_DELIVERY" && depart != nil && eta != nil {
-				frac := float64(now.Sub(depart.UTC())) / float64(eta.UTC().Sub(depart.UTC()))
-				if frac < 0 {
-					frac = 0
-				}
-				if frac > 1 {
-					frac = 1
-				}
-				ll := wlat + (dlat-wlat)*frac
-				lg := wlng + (dlng-wlng)*frac
-				lastLat, lastLng = &ll, &lg
-				u := now
-				lastUpdate = &u
-				etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
-			}
-
-			activeShipments = append(activeShipments, map[string]any{
-				"id":            id,
-				"status":        status,
-				"etaMinutes":    etaMinutes,
-				"truck":         map[string]any{"lastLat": lastLat, "lastLng": lastLng, "lastUpdate": lastUpdate},
-				"fromWarehouse": map[string]any{"id": wid, "name": wname, "lat": wlat, "lng": wlng},
-				"toDistributor": map[string]any{"id": did, "name": dname, "lat": dlat, "lng": dlng},
-				"polyline":      []map[string]any{{"lat": wlat, "lng": wlng}, {"lat": dlat, "lng": dlng}},
-			})
+	var passwordHash string
+	if err := a.db.QueryRow(r.Context(), `SELECT password_hash FROM users WHERE id=$1`, u.ID).Scan(&passwordHash); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(body.CurrentPassword)) != nil {
+		a.insertAuditLog(r, &u, "PASSWORD_CHANGE_FAILED", "user", fmt.Sprintf("%d", u.ID), map[string]any{})
+		if scope, until, err := a.recordLoginFailure(r.Context(), u.Email, ip); err != nil {
+			log.Printf("password: record failure: %v", err)
+		} else if until != nil {
+			a.insertAuditLog(r, nil, "LOGIN_LOCKED", "login", u.Email, map[string]any{"email": u.Email, "scope": scope, "lockedUntil": until})
 		}
-		srows.Close()
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "current password is incorrect")
+		return
 	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"plant":           plant,
-		"warehouses":      warehouses,
-		"distributors":    distributors,
-		"routes":          routes,
-		"activeShipments": activeShipments,
-	})
-}
-
-func (a *App) handleOpsStock(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `
-    SELECT w.id, w.name, s.cement_type, s.quantity_tons, s.updated_at
-    FROM stock_levels s
-    JOIN warehouses w ON w.id = s.warehouse_id
-    ORDER BY w.id, s.cement_type
-  `)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	if body.NewPassword == body.CurrentPassword {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "new password must differ from the current one")
 		return
 	}
-	defer rows.Close()
-	out := []map[string]any{}
-	for rows.Next() {
-		var wid int64
-		var wname, ct string
-		var qty float64
-		var updated time.Time
-		_ = rows.Scan(&wid, &wname, &ct, &qty, &updated)
-		out = append(out, map[string]any{
-			"warehouseId":   wid,
-			"warehouseName": wname,
-			"cementType":    ct,
-			"quantityTons":  qty,
-			"updatedAt":     updated,
-		})
+	hash, ok := a.hashPassword(w, r, body.NewPassword, u.Email, u.Name)
+	if !ok {
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": out})
-}
 
-func (a *App) handleOpsTrucks(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `
-    SELECT id, code, name, capacity_tons, active
-    FROM trucks
-    ORDER BY id
-  `)
+	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
-	items := []map[string]any{}
-	for rows.Next() {
-		var id int64
-		var code, name string
-		var cap float64
-		var active bool
-		_ = rows.Scan(&id, &code, &name, &cap, &active)
-		items = append(items, map[string]any{"id": id, "code": code, "name": name, "capacityTons": cap, "active": active})
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	if _, err := tx.Exec(r.Context(), `UPDATE users SET password_hash=$2, password_changed_at=now() WHERE id=$1`, u.ID, hash); err != nil {
+		writeDBError(w, err)
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
-}
-
-func (a *App) handleOpsInventory(w http.ResponseWriter, r *http.Request) {
-	// Join stock with thresholds to compute a simple status.
-	rows, err := a.db.Query(r.Context(), `
-    SELECT w.id, w.name, w.capacity_tons,
-	    s.cement_type, s.quantity_tons, s.updated_at,
-           t.min_stock, t.safety_stock, t.warning_level, t.critical_level, t.lead_time_days
-    FROM stock_levels s
-    JOIN warehouses w ON w.id = s.warehouse_id
-    LEFT JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
-    ORDER BY w.id, s.cement_type
-  `)
+	if _, err := tx.Exec(r.Context(), `UPDATE password_reset_tokens SET used_at=now() WHERE user_id=$1 AND used_at IS NULL`, u.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	tag, err := tx.Exec(r.Context(), `DELETE FROM sessions WHERE user_id=$1 AND id<>$2`, u.ID, current)
 	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "PASSWORD_CHANGED", "user", fmt.Sprintf("%d", u.ID), map[string]any{"sessionsRevoked": tag.RowsAffected()}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
 
-	type key struct {
-		wid int64
-		ct  string
+// handleForgotPassword sends a single-use reset link. The response is the same
+// whether or not the email belongs to an account, so it cannot be used to
+// discover accounts.
+func (a *App) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
 	}
-	items := []map[string]any{}
-	keys := []key{}
-	for rows.Next() {
-		var wid int64
-		var wname, ct string
-		var cap float64
-		var qty float64
-		var updated time.Time
-		var min, safety, warn, critical *float64
-		var lead *int
-		_ = rows.Scan(&wid, &wname, &cap, &ct, &qty, &updated, &min, &safety, &warn, &critical, &lead)
-
-		status := "OK"
-		if critical != nil && qty <= *critical {
-			status = "CRITICAL"
-		} else if warn != nil && qty <= *warn {
-			status = "WARNING"
-		}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	email := strings.TrimSpace(strings.ToLower(body.Email))
+	if email == "" || !strings.Contains(email, "@") {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "valid email required")
+		return
+	}
+	if err := a.sendPasswordReset(r, email); err != nil {
+		log.Printf("password: reset link for %s: %v", email, err)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
 
-		it := map[string]any{
-			"warehouseId":   wid,
-			"warehouseName": wname,
-			"capacityTons":  cap,
-			"cementType":    ct,
-			"quantityTons":  qty,
-			"updatedAt":     updated,
-			"status":        status,
-			"thresholds": map[string]any{
-				"minStock":      min,
-				"safetyStock":   safety,
-				"warningLevel":  warn,
-				"criticalLevel": critical,
-				"leadTimeDays":  lead,
-			},
-		}
-		items = append(items, it)
-		keys = append(keys, key{wid: wid, ct: ct})
+func (a *App) sendPasswordReset(r *http.Request, email string) error {
+	ctx := r.Context()
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	// Attach recent movements (3 per warehouse+cement type)
-	mrows, err := a.db.Query(r.Context(), `
-    SELECT id, ts, actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason
-    FROM (
-      SELECT m.*, ROW_NUMBER() OVER (PARTITION BY warehouse_id, cement_type ORDER BY ts DESC) AS rn
-      FROM inventory_movements m
-    ) x
-    WHERE rn <= 3
-    ORDER BY ts DESC
-  `)
-	recent := map[key][]map[string]any{}
-	if err == nil {
-		for mrows.Next() {
-			var id int64
-			var ts time.Time
-			var actorID *int64
-			var wid int64
-			var ct, mt, reason string
-			var qty float64
-			_ = mrows.Scan(&id, &ts, &actorID, &wid, &ct, &mt, &qty, &reason)
-			recent[key{wid: wid, ct: ct}] = append(recent[key{wid: wid, ct: ct}], map[string]any{
-				"id":           id,
-				"ts":           ts,
-				"movementType": mt,
-				"quantityTons": qty,
-				"reason":       reason,
-				"actorUserId":  actorID,
-			})
-		}
-		mrows.Close()
+	var msg PasswordResetMessage
+	var recent bool
+	err = tx.QueryRow(ctx, `
+    SELECT u.id, u.name, u.email,
+           EXISTS(SELECT 1 FROM password_reset_tokens t WHERE t.user_id = u.id AND t.created_at > now() - make_interval(secs => $2))
+    FROM users u
+    WHERE u.email=$1 AND u.disabled_at IS NULL
+    FOR UPDATE OF u
+  `, email, passwordResetCooldown.Seconds()).Scan(&msg.UserID, &msg.Name, &msg.Email, &recent)
+	if errors.Is(err, sql.ErrNoRows) {
+		a.insertAuditLog(r, nil, "PASSWORD_RESET_REQUESTED", "login", email, map[string]any{"email": email, "sent": false})
+		return nil
 	}
-	for i := range items {
-		wid := items[i]["warehouseId"].(int64)
-		ct := items[i]["cementType"].(string)
-		items[i]["recentMovements"] = recent[key{wid: wid, ct: ct}]
+	if err != nil {
+		return err
+	}
+	if recent {
+		return nil
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	b := make([]byte, passwordResetTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+	msg.ExpiresAt = time.Now().Add(a.cfg.PasswordResetTTL)
+	msg.Link = a.cfg.AppBaseURL + "/reset-password?token=" + token
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, ip)
+    VALUES ($1,$2,$3,$4)
+  `, msg.UserID, hashResetToken(token), msg.ExpiresAt, clientIP(r)); err != nil {
+		return err
+	}
+	if err := a.resets.SendPasswordReset(ctx, tx, msg); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	a.insertAuditLog(r, nil, "PASSWORD_RESET_REQUESTED", "user", fmt.Sprintf("%d", msg.UserID), map[string]any{"email": email, "sent": true})
+	return nil
 }
 
-func (a *App) handleOpsInventoryAdjust(w http.ResponseWriter, r *http.Request) {
-	u, _ := r.Context().Value(ctxUserKey).(User)
+// handleResetPassword sets a new password with a reset token. The token is
+// consumed only when the new password is accepted, so a rejected password can
+// be retried with the same link. All of the user's sessions end.
+func (a *App) handleResetPassword(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		WarehouseID int64   `json:"warehouseId"`
-		CementType  string  `json:"cementType"`
-		DeltaTons   float64 `json:"deltaTons"`
-		Reason      string  `json:"reason"`
+		Token       string `json:"token"`
+		NewPassword string `json:"newPassword"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	if body.WarehouseID <= 0 || strings.TrimSpace(body.CementType) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "warehouseId and cementType required")
-		return
-	}
-	if body.DeltaTons == 0 {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "deltaTons must be non-zero")
-		return
-	}
-	if math.Abs(body.DeltaTons) > 500 {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "deltaTons too large")
+	body.Token = strings.TrimSpace(body.Token)
+	if body.Token == "" || body.NewPassword == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "token and newPassword required")
 		return
 	}
 
@@ -1306,2137 +2311,8273 @@ func (a *App) handleOpsInventoryAdjust(w http.ResponseWriter, r *http.Request) {
 	}
 	defer func() { _ = tx.Rollback(r.Context()) }()
 
-	// Upsert stock row.
-	_, _ = tx.Exec(r.Context(), `
-    INSERT INTO stock_levels (warehouse_id, cement_type, quantity_tons)
-    VALUES ($1,$2,0)
-    ON CONFLICT (warehouse_id, cement_type) DO NOTHING
-  `, body.WarehouseID, body.CementType)
-
-	var current float64
-	if err := tx.QueryRow(r.Context(), `
-    SELECT quantity_tons FROM stock_levels
-    WHERE warehouse_id=$1 AND cement_type=$2
-    FOR UPDATE
-  `, body.WarehouseID, body.CementType).Scan(&current); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	var u User
+	var tokenID int64
+	var disabledAt *time.Time
+	err = tx.QueryRow(r.Context(), `
+    SELECT t.id, u.id, u.name, u.email, u.role, ro.kind, u.disabled_at
+    FROM password_reset_tokens t
+    JOIN users u ON u.id = t.user_id
+    JOIN roles ro ON ro.key = u.role
+    WHERE t.token_hash=$1 AND t.used_at IS NULL AND t.expires_at > now()
+    FOR UPDATE OF t
+  `, hashResetToken(body.Token)).Scan(&tokenID, &u.ID, &u.Name, &u.Email, &u.Role, &u.RoleKind, &disabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "reset link is invalid or has expired")
 		return
 	}
-	newQty := current + body.DeltaTons
-	if newQty < 0 {
-		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "resulting stock would be negative")
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if _, err := tx.Exec(r.Context(), `
-    UPDATE stock_levels SET quantity_tons=$1, updated_at=now()
-    WHERE warehouse_id=$2 AND cement_type=$3
-  `, newQty, body.WarehouseID, body.CementType); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	if disabledAt != nil {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "account disabled")
 		return
 	}
-	// Movement + audit.
-	if _, err := tx.Exec(r.Context(), `
-    INSERT INTO inventory_movements (actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata)
-    VALUES ($1,$2,$3,'ADJUST',$4,$5,'stock_levels','', '{}'::jsonb)
-  `, u.ID, body.WarehouseID, body.CementType, body.DeltaTons, body.Reason); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	hash, ok := a.hashPassword(w, r, body.NewPassword, u.Email, u.Name)
+	if !ok {
 		return
 	}
 
+	if _, err := tx.Exec(r.Context(), `UPDATE users SET password_hash=$2, password_changed_at=now() WHERE id=$1`, u.ID, hash); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `UPDATE password_reset_tokens SET used_at=now() WHERE user_id=$1 AND used_at IS NULL`, u.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM sessions WHERE user_id=$1`, u.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM login_lockouts WHERE scope=$1 AND subject=$2`, lockoutScopeEmail, u.Email); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "PASSWORD_RESET", "user", fmt.Sprintf("%d", u.ID), map[string]any{"tokenId": fmt.Sprintf("%d", tokenID)}); err != nil {
+		writeDBError(w, err)
+		return
+	}
 	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	a.insertAuditLog(r, &u, "STOCK_ADJUSTMENT", "stock_levels", fmt.Sprintf("%d:%s", body.WarehouseID, body.CementType), map[string]any{"deltaTons": body.DeltaTons, "reason": body.Reason})
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "newQuantityTons": newQty})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (a *App) handleOpsOrders(w http.ResponseWriter, r *http.Request) {
-	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
-	where := ""
-	args := []any{}
-	if status != "" {
-		where = "WHERE o.status=$1"
-		args = append(args, status)
+// ---------- api tokens ----------
+
+// API tokens authenticate integrations (ERP sync, weighbridge) without a login.
+// A token acts as its user, narrowed to its scopes: requirePermission demands
+// both the role permission and a matching "Module.action" scope. Only the
+// SHA-256 of the token is stored; the plaintext is returned once at creation.
+const apiTokenPrefix = "cop_"
+
+type apiToken struct {
+	ID     int64
+	Scopes []string
+}
+
+func (t apiToken) allows(module, action string) bool {
+	return slices.Contains(t.Scopes, module+"."+action)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
 	}
-	q := fmt.Sprintf(`
-    SELECT o.id, o.distributor_id, d.name, o.cement_type, o.quantity_tons, o.status, o.requested_at,
-           o.decided_at, o.decided_by_user_id, o.decision_reason, o.approved_shipment_id
-    FROM order_requests o
-    JOIN distributors d ON d.id = o.distributor_id
-    %s
-    ORDER BY o.requested_at DESC, o.id DESC
-    LIMIT 200
-  `, where)
-	rows, err := a.db.Query(r.Context(), q, args...)
+	return strings.TrimSpace(h[7:]), true
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// normalizeAPITokenScopes validates "Module.action" scopes against the RBAC
+// matrix and returns them sorted and de-duplicated. Modules are matched
+// case-insensitively but stored in their canonical spelling.
+func normalizeAPITokenScopes(scopes []string) ([]string, error) {
+	out := []string{}
+	for _, s := range scopes {
+		module, action, ok := strings.Cut(strings.TrimSpace(s), ".")
+		if !ok {
+			return nil, fmt.Errorf("invalid scope %q, expected Module.action", s)
+		}
+		action = strings.ToLower(action)
+		canonical := ""
+		for _, m := range rbacModules {
+			if strings.EqualFold(m, module) {
+				canonical = m
+			}
+		}
+		if canonical == "" {
+			return nil, fmt.Errorf("unknown module in scope %q", s)
+		}
+		if !containsString([]string{"view", "create", "edit", "delete"}, action) {
+			return nil, fmt.Errorf("unknown action in scope %q", s)
+		}
+		out = append(out, canonical+"."+action)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// parseAPITokenExpiry accepts an RFC3339 timestamp, or empty for no expiry.
+func parseAPITokenExpiry(raw *string) (*time.Time, error) {
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(*raw))
 	if err != nil {
-		writeDBError(w, err)
+		return nil, errors.New("expiresAt must be an RFC3339 timestamp")
+	}
+	if !t.After(time.Now()) {
+		return nil, errors.New("expiresAt must be in the future")
+	}
+	return &t, nil
+}
+
+// authenticateAPIToken is the bearer half of authMiddleware. last_used_at is
+// written at most once per sessionTouchInterval, and each such write is
+// recorded as API_TOKEN_USED, so audit_logs shows when and from where every
+// token is in use without logging every request.
+func (a *App) authenticateAPIToken(w http.ResponseWriter, r *http.Request, raw string, next http.Handler) {
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid token")
 		return
 	}
-	defer rows.Close()
-	items := []map[string]any{}
-	for rows.Next() {
-		var id, did int64
-		var dname, ct, st, reason string
-		var qty float64
-		var requested time.Time
-		var decided *time.Time
-		var decidedBy *int64
-		var approvedShipment *int64
-		_ = rows.Scan(&id, &did, &dname, &ct, &qty, &st, &requested, &decided, &decidedBy, &reason, &approvedShipment)
-		items = append(items, map[string]any{
-			"id":                 id,
-			"status":             st,
-			"requestedAt":        requested,
-			"decidedAt":          decided,
-			"decidedBy":          decidedBy,
-			"decisionReason":     reason,
-			"approvedShipmentId": approvedShipment,
-			"cementType":         ct,
-			"quantityTons":       qty,
-			"distributor":        map[string]any{"id": did, "name": dname},
-		})
+	var u User
+	var tok apiToken
+	var distributorID sql.NullInt64
+	var expiresAt, revokedAt, disabledAt *time.Time
+	row := a.db.QueryRow(r.Context(), `
+    SELECT t.id, t.scopes, t.expires_at, t.revoked_at,
+           u.id, u.name, u.email, u.role, ro.kind, u.distributor_id, u.disabled_at
+    FROM api_tokens t
+    JOIN users u ON u.id = t.user_id
+    JOIN roles ro ON ro.key = u.role
+    WHERE t.token_hash = $1
+  `, hashAPIToken(raw))
+	if err := row.Scan(&tok.ID, &tok.Scopes, &expiresAt, &revokedAt,
+		&u.ID, &u.Name, &u.Email, &u.Role, &u.RoleKind, &distributorID, &disabledAt); err != nil {
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid token")
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	if distributorID.Valid {
+		v := distributorID.Int64
+		u.DistributorID = &v
+	}
+	switch {
+	case revokedAt != nil:
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "token revoked")
+		return
+	case expiresAt != nil && time.Now().After(*expiresAt):
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "token expired")
+		return
+	case disabledAt != nil:
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "account disabled")
+		return
+	case u.RoleKind == roleKindDistributor:
+		// The user's role changed after the token was issued; see createAPIToken.
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "token not valid for this account")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), ctxUserKey, u)
+	ctx = context.WithValue(ctx, ctxTokenKey, tok)
+	r = r.WithContext(ctx)
+
+	tag, err := a.db.Exec(ctx, `
+    UPDATE api_tokens SET last_used_at = now(), last_used_ip = $2
+    WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - make_interval(secs => $3))
+  `, tok.ID, clientIP(r), sessionTouchInterval.Seconds())
+	if err == nil && tag.RowsAffected() > 0 {
+		a.insertAuditLog(r, &u, "API_TOKEN_USED", "api_token", fmt.Sprintf("%d", tok.ID), map[string]any{"method": r.Method, "path": r.URL.Path})
+	}
+	next.ServeHTTP(w, r)
 }
 
-func (a *App) handleOpsApproveOrder(w http.ResponseWriter, r *http.Request) {
-	u, _ := r.Context().Value(ctxUserKey).(User)
-	idStr := chi.URLParam(r, "id")
-	orderID, err := strconv.ParseInt(idStr, 10, 64)
+type apiTokenRequest struct {
+	UserID    *int64   `json:"userId"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt *string  `json:"expiresAt"`
+}
+
+// createAPIToken validates the request and stores a new token for userID,
+// returning the token's JSON view with the plaintext included. It writes the
+// error response itself and reports false on failure.
+func (a *App) createAPIToken(w http.ResponseWriter, r *http.Request, actor User, userID int64, body apiTokenRequest) (map[string]any, bool) {
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return nil, false
+	}
+	scopes, err := normalizeAPITokenScopes(body.Scopes)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
-		return
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return nil, false
 	}
-	var body struct {
-		FromWarehouseID *int64     `json:"fromWarehouseId"`
-		TruckID         *int64     `json:"truckId"`
-		DepartAt        *time.Time `json:"departAt"`
-		Reason          string     `json:"reason"`
+	expiresAt, err := parseAPITokenExpiry(body.ExpiresAt)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return nil, false
+	}
+
+	var kind string
+	if err := a.db.QueryRow(r.Context(), `
+    SELECT ro.kind FROM users u JOIN roles ro ON ro.key = u.role WHERE u.id=$1
+  `, userID).Scan(&kind); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+			return nil, false
+		}
+		writeDBError(w, err)
+		return nil, false
+	}
+	// Distributor endpoints are gated by role kind rather than the permission
+	// matrix, so scopes could not narrow what such a token can reach.
+	if kind == roleKindDistributor {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "API tokens are not available to distributor users")
+		return nil, false
 	}
-	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	token, err := generateAPIToken()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "token generation failed")
+		return nil, false
+	}
+	prefix := token[:len(apiTokenPrefix)+8]
 
 	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+		return nil, false
 	}
 	defer func() { _ = tx.Rollback(r.Context()) }()
 
-	// Lock order request.
-	var distributorID int64
-	var cementType string
-	var qty float64
-	var status string
+	var id int64
+	var createdAt time.Time
 	if err := tx.QueryRow(r.Context(), `
-    SELECT distributor_id, cement_type, quantity_tons, status
-    FROM order_requests
-    WHERE id=$1
-    FOR UPDATE
-  `, orderID).Scan(&distributorID, &cementType, &qty, &status); err != nil {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "order not found")
-		return
-	}
-	if status != "PENDING" {
-		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "order is not pending")
-		return
+    INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at, created_by_user_id)
+    VALUES ($1,$2,$3,$4,$5,$6,$7)
+    RETURNING id, created_at
+  `, userID, name, prefix, hashAPIToken(token), scopes, expiresAt, actor.ID).Scan(&id, &createdAt); err != nil {
+		writeDBError(w, err)
+		return nil, false
+	}
+	if err := a.insertAuditLogTx(tx, r, &actor, "API_TOKEN_CREATED", "api_token", fmt.Sprintf("%d", id), map[string]any{
+		"userId":    fmt.Sprintf("%d", userID),
+		"name":      name,
+		"scopes":    scopes,
+		"expiresAt": expiresAt,
+	}); err != nil {
+		writeDBError(w, err)
+		return nil, false
 	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return nil, false
+	}
+	var exp any
+	if expiresAt != nil {
+		exp = expiresAt.Format(time.RFC3339)
+	}
+	return map[string]any{
+		"id":        fmt.Sprintf("%d", id),
+		"userId":    fmt.Sprintf("%d", userID),
+		"name":      name,
+		"prefix":    prefix,
+		"scopes":    scopes,
+		"expiresAt": exp,
+		"createdAt": createdAt.Format(time.RFC3339),
+		"token":     token,
+	}, true
+}
 
-	fromWarehouseID := int64(0)
-	if body.FromWarehouseID != nil {
-		fromWarehouseID = *body.FromWarehouseID
+// listAPITokens returns tokens for one user, or all users when userID is nil.
+func (a *App) listAPITokens(ctx context.Context, userID *int64, includeRevoked bool) ([]map[string]any, error) {
+	rows, err := a.db.Query(ctx, `
+    SELECT t.id, t.user_id, u.email, t.name, t.token_prefix, t.scopes, t.expires_at,
+           t.last_used_at, t.last_used_ip, t.revoked_at, t.created_by_user_id, t.created_at
+    FROM api_tokens t
+    JOIN users u ON u.id = t.user_id
+    WHERE ($1::bigint IS NULL OR t.user_id = $1)
+      AND ($2::bool OR t.revoked_at IS NULL)
+    ORDER BY t.id DESC
+  `, userID, includeRevoked)
+	if err != nil {
+		return nil, err
 	}
-	if fromWarehouseID == 0 {
-		// Pick warehouse with highest stock.
-		_ = tx.QueryRow(r.Context(), `
-      SELECT warehouse_id
-      FROM stock_levels
-      WHERE cement_type=$1
-      ORDER BY quantity_tons DESC
-      LIMIT 1
-    `, cementType).Scan(&fromWarehouseID)
-		if fromWarehouseID == 0 {
-			writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "no warehouse stock for cement type")
-			return
+	defer rows.Close()
+	fmtTime := func(t *time.Time) any {
+		if t == nil {
+			return nil
+		}
+		return t.Format(time.RFC3339)
+	}
+	items := []map[string]any{}
+	for rows.Next() {
+		var id, ownerID int64
+		var email, name, prefix, lastUsedIP string
+		var scopes []string
+		var expiresAt, lastUsedAt, revokedAt *time.Time
+		var createdBy *int64
+		var createdAt time.Time
+		if err := rows.Scan(&id, &ownerID, &email, &name, &prefix, &scopes, &expiresAt,
+			&lastUsedAt, &lastUsedIP, &revokedAt, &createdBy, &createdAt); err != nil {
+			return nil, err
 		}
+		items = append(items, map[string]any{
+			"id":              fmt.Sprintf("%d", id),
+			"userId":          fmt.Sprintf("%d", ownerID),
+			"userEmail":       email,
+			"name":            name,
+			"prefix":          prefix,
+			"scopes":          scopes,
+			"expiresAt":       fmtTime(expiresAt),
+			"lastUsedAt":      fmtTime(lastUsedAt),
+			"lastUsedIp":      lastUsedIP,
+			"revokedAt":       fmtTime(revokedAt),
+			"createdByUserId": createdBy,
+			"createdAt":       createdAt.Format(time.RFC3339),
+		})
 	}
+	return items, rows.Err()
+}
 
-	// Check stock availability.
-	var available float64
-	if err := tx.QueryRow(r.Context(), `
-    SELECT quantity_tons
-    FROM stock_levels
-    WHERE warehouse_id=$1 AND cement_type=$2
-    FOR UPDATE
-  `, fromWarehouseID, cementType).Scan(&available); err != nil {
-		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "stock row not found")
+// revokeAPIToken marks a token revoked. ownerID restricts it to one user's
+// tokens; nil lets administrators revoke any token.
+func (a *App) revokeAPIToken(w http.ResponseWriter, r *http.Request, actor User, ownerID *int64) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	if available < qty {
-		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "insufficient stock")
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
 
-	// Compute ETA based on dummy distance.
-	var wlat, wlng, dlat, dlng float64
-	if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM warehouses WHERE id=$1`, fromWarehouseID).Scan(&wlat, &wlng); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid warehouse")
+	var userID int64
+	err = tx.QueryRow(r.Context(), `
+    UPDATE api_tokens SET revoked_at = now()
+    WHERE id=$1 AND revoked_at IS NULL AND ($2::bigint IS NULL OR user_id = $2)
+    RETURNING user_id
+  `, id, ownerID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "token not found")
+			return
+		}
+		writeDBError(w, err)
 		return
 	}
-	if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM distributors WHERE id=$1`, distributorID).Scan(&dlat, &dlng); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid distributor")
+	if err := a.insertAuditLogTx(tx, r, &actor, "API_TOKEN_REVOKED", "api_token", fmt.Sprintf("%d", id), map[string]any{"userId": fmt.Sprintf("%d", userID)}); err != nil {
+		writeDBError(w, err)
 		return
 	}
-
-	departAt := time.Now().UTC().Add(45 * time.Minute)
-	if body.DepartAt != nil {
-		departAt = body.DepartAt.UTC()
-	}
-	travelMin := estimateTravelMinutes(wlat, wlng, dlat, dlng)
-	eta := departAt.Add(time.Duration(travelMin) * time.Minute)
-
-	var shipmentID int64
-	etaMinutes := int(math.Max(0, eta.Sub(time.Now().UTC()).Minutes()))
-	truckID := body.TruckID
-	if err := tx.QueryRow(r.Context(), `
-    INSERT INTO shipments (from_warehouse_id, to_distributor_id, status, cement_type, quantity_tons, truck_id, depart_at, arrive_eta, eta_minutes)
-    VALUES ($1,$2,'SCHEDULED',$3,$4,$5,$6,$7,$8)
-    RETURNING id
-  `, fromWarehouseID, distributorID, cementType, qty, truckID, departAt, eta, etaMinutes).Scan(&shipmentID); err != nil {
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
 
-	// Reserve stock (decrement now) + movement.
-	if _, err := tx.Exec(r.Context(), `
-    UPDATE stock_levels SET quantity_tons = quantity_tons - $1, updated_at=now()
-    WHERE warehouse_id=$2 AND cement_type=$3
-  `, qty, fromWarehouseID, cementType); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+func (a *App) handleListMyAPITokens(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	items, err := a.listAPITokens(r.Context(), &u.ID, false)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-	_, _ = tx.Exec(r.Context(), `
-    INSERT INTO inventory_movements (actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata)
-    VALUES ($1,$2,$3,'OUT',$4,'Order approved','shipment',$5, '{}'::jsonb)
-  `, u.ID, fromWarehouseID, cementType, qty, fmt.Sprintf("%d", shipmentID))
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
 
-	// Update order request.
-	if _, err := tx.Exec(r.Context(), `
-    UPDATE order_requests
-    SET status='APPROVED', decided_at=now(), decided_by_user_id=$1, decision_reason=$2, approved_shipment_id=$3, updated_at=now()
-    WHERE id=$4
-  `, u.ID, body.Reason, shipmentID, orderID); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+func (a *App) handleCreateMyAPIToken(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body apiTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	_, _ = tx.Exec(r.Context(), `UPDATE shipments SET order_request_id=$1 WHERE id=$2`, orderID, shipmentID)
-
-	if err := tx.Commit(r.Context()); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	item, ok := a.createAPIToken(w, r, u, u.ID, body)
+	if !ok {
 		return
 	}
-	a.insertAuditLog(r, &u, "ORDER_APPROVED", "order_request", fmt.Sprintf("%d", orderID), map[string]any{"shipmentId": shipmentID, "warehouseId": fromWarehouseID, "cementType": cementType, "quantityTons": qty})
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "shipmentId": shipmentID})
+	writeJSON(w, http.StatusCreated, item)
 }
 
-func (a *App) handleOpsRejectOrder(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleRevokeMyAPIToken(w http.ResponseWriter, r *http.Request) {
 	u, _ := r.Context().Value(ctxUserKey).(User)
-	idStr := chi.URLParam(r, "id")
-	orderID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+	a.revokeAPIToken(w, r, u, &u.ID)
+}
+
+func (a *App) handleMe(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(ctxUserKey).(User)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
 		return
 	}
-	var body struct {
-		Reason string `json:"reason"`
+	writeJSON(w, http.StatusOK, map[string]any{"user": u})
+}
+
+func (a *App) handleRBACMe(w http.ResponseWriter, r *http.Request) {
+	u, ok := r.Context().Value(ctxUserKey).(User)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
+		return
 	}
-	_ = json.NewDecoder(r.Body).Decode(&body)
 
-	tag, err := a.db.Exec(r.Context(), `
-    UPDATE order_requests
-    SET status='REJECTED', decided_at=now(), decided_by_user_id=$1, decision_reason=$2, updated_at=now()
-    WHERE id=$3 AND status='PENDING'
-  `, u.ID, body.Reason, orderID)
-	if err != nil {
+	var config json.RawMessage
+	if err := a.db.QueryRow(r.Context(), `SELECT config FROM rbac_config WHERE role=$1`, u.Role).Scan(&config); err != nil {
+		if err == sql.ErrNoRows {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "rbac config not found")
+			return
+		}
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "order is not pending")
-		return
+
+	writeJSON(w, http.StatusOK, map[string]any{"role": u.Role, "config": config})
+}
+
+// ---------- planning ----------
+
+func parseBBox(s string) (minLat, minLng, maxLat, maxLng float64, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, 0, false
 	}
-	a.insertAuditLog(r, &u, "ORDER_REJECTED", "order_request", fmt.Sprintf("%d", orderID), map[string]any{"reason": body.Reason})
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	f := make([]float64, 4)
+	for i := 0; i < 4; i++ {
+		v, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		if err != nil {
+			return 0, 0, 0, 0, false
+		}
+		f[i] = v
+	}
+	return f[0], f[1], f[2], f[3], true
 }
 
-func (a *App) handleOpsOrderAudit(w http.ResponseWriter, r *http.Request) {
+func (a *App) handlePlanningHeatmap(w http.ResponseWriter, r *http.Request) {
+	bboxStr := r.URL.Query().Get("bbox")
+	minLat, minLng, maxLat, maxLng, ok := parseBBox(bboxStr)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "bbox must be minLat,minLng,maxLat,maxLng")
+		return
+	}
+
 	rows, err := a.db.Query(r.Context(), `
-    SELECT l.id, l.ts, l.actor_user_id, u.name, l.action, l.entity_id, l.metadata
-    FROM audit_logs l
-    LEFT JOIN users u ON u.id = l.actor_user_id
-    WHERE l.entity_type='order_request'
-    ORDER BY l.ts DESC
-    LIMIT 200
-  `)
+    SELECT lat, lng, demand_tons_month
+    FROM projects
+    WHERE archived_at IS NULL AND lat BETWEEN $1 AND $2 AND lng BETWEEN $3 AND $4
+  `, minLat, maxLat, minLng, maxLng)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	defer rows.Close()
-	items := []map[string]any{}
+
+	cell := 0.02
+	type cellKey struct{ a, b int }
+	scores := map[cellKey]float64{}
 	for rows.Next() {
-		var id int64
-		var ts time.Time
-		var actorID *int64
-		var actorName *string
-		var action, entityID string
-		var meta json.RawMessage
-		_ = rows.Scan(&id, &ts, &actorID, &actorName, &action, &entityID, &meta)
-		items = append(items, map[string]any{
-			"id":             id,
-			"ts":             ts,
-			"actorUserId":    actorID,
-			"actorName":      actorName,
-			"action":         action,
-			"orderRequestId": entityID,
-			"metadata":       meta,
+		var lat, lng, demand float64
+		if err := rows.Scan(&lat, &lng, &demand); err != nil {
+			continue
+		}
+		la := int(math.Floor(lat / cell))
+		lb := int(math.Floor(lng / cell))
+		scores[cellKey{la, lb}] += demand
+	}
+
+	out := make([]map[string]any, 0, len(scores))
+	for k, sc := range scores {
+		clat := float64(k.a) * cell
+		clng := float64(k.b) * cell
+		out = append(out, map[string]any{
+			"cellLat":   clat,
+			"cellLng":   clng,
+			"centerLat": clat + cell/2,
+			"centerLng": clng + cell/2,
+			"size":      cell,
+			"score":     sc,
 		})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	writeJSON(w, http.StatusOK, map[string]any{"cells": out, "cellSize": cell})
 }
 
-func (a *App) handleOpsActivityLog(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `
-    SELECT l.id, l.ts, l.actor_user_id, u.name, l.action, l.entity_type, l.entity_id, l.metadata
-    FROM audit_logs l
-    LEFT JOIN users u ON u.id = l.actor_user_id
-    ORDER BY l.ts DESC
-    LIMIT 300
-  `)
+func (a *App) handlePlanningSiteProfile(w http.ResponseWriter, r *http.Request) {
+	lat, err1 := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lng, err2 := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+	if err1 != nil || err2 != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "lat and lng required")
+		return
+	}
+
+	// Road width: nearest segments within 0.5km, take max width.
+	rows, err := a.db.Query(r.Context(), `SELECT width_m, lat, lng, name FROM road_segments`)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	defer rows.Close()
-	items := []map[string]any{}
+
+	bestWidth := 0.0
+	bestRoad := ""
 	for rows.Next() {
-		var id int64
-		var ts time.Time
-		var actorID *int64
-		var actorName *string
-		var action, et, eid string
-		var meta json.RawMessage
-		_ = rows.Scan(&id, &ts, &actorID, &actorName, &action, &et, &eid, &meta)
-		items = append(items, map[string]any{
-			"id":          id,
-			"ts":          ts,
-			"actorUserId": actorID,
-			"actorName":   actorName,
-			"action":      action,
-			"entityType":  et,
-			"entityId":    eid,
-			"metadata":    meta,
-		})
+		var width, rlat, rlng float64
+		var name string
+		if err := rows.Scan(&width, &rlat, &rlng, &name); err != nil {
+			continue
+		}
+		dkm := haversineKM(lat, lng, rlat, rlng)
+		if dkm <= 0.5 && width > bestWidth {
+			bestWidth = width
+			bestRoad = name
+		}
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
-}
 
-func (a *App) handleOpsUpdateShipment(w http.ResponseWriter, r *http.Request) {
-	u, _ := r.Context().Value(ctxUserKey).(User)
-	idStr := chi.URLParam(r, "id")
-	shipmentID, err := strconv.ParseInt(idStr, 10, 64)
+	// Demand around point.
+	prow, err := a.db.Query(r.Context(), `SELECT lat, lng, demand_tons_month FROM projects WHERE archived_at IS NULL`)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	var body struct {
-		FromWarehouseID *int64     `json:"fromWarehouseId"`
-		ToDistributorID *int64     `json:"toDistributorId"`
-		TruckID         *int64     `json:"truckId"`
-		DepartAt        *time.Time `json:"departAt"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
-		return
+	defer prow.Close()
+	demand3km := 0.0
+	for prow.Next() {
+		var plat, plng, dem float64
+		if err := prow.Scan(&plat, &plng, &dem); err != nil {
+			continue
+		}
+		if haversineKM(lat, lng, plat, plng) <= 3 {
+			demand3km += dem
+		}
 	}
 
-	tx, err := a.db.Begin(r.Context())
+	// Distance to nearest warehouse.
+	wrows, err := a.db.Query(r.Context(), `SELECT lat, lng FROM warehouses WHERE archived_at IS NULL`)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer func() { _ = tx.Rollback(r.Context()) }()
-
-	// Read current shipment and coords.
-	var fromID, toID int64
-	var status string
-	var wlat, wlng, dlat, dlng float64
-	var depart *time.Time
-	var eta *time.Time
-	var truckID *int64
-	if err := tx.QueryRow(r.Context(), `
-    SELECT s.from_warehouse_id, s.to_distributor_id, s.status, s.truck_id, s.depart_at, s.arrive_eta,
-           w.lat, w.lng, d.lat, d.lng
-    FROM shipments s
-    JOIN warehouses w ON w.id = s.from_warehouse_id
-    JOIN distributors d ON d.id = s.to_distributor_id
-    WHERE s.id=$1
-    FOR UPDATE
-	`, shipmentID).Scan(&fromID, &toID, &status, &truckID, &depart, &eta, &wlat, &wlng, &dlat, &dlng); err != nil {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
-		return
-	}
-	if body.TruckID != nil {
-		truckID = body.TruckID
+	defer wrows.Close()
+	nearestWH := 1e9
+	for wrows.Next() {
+		var wlat, wlng float64
+		if err := wrows.Scan(&wlat, &wlng); err != nil {
+			continue
+		}
+		d := haversineKM(lat, lng, wlat, wlng)
+		if d < nearestWH {
+			nearestWH = d
+		}
 	}
-	if body.FromWarehouseID != nil {
-		fromID = *body.FromWarehouseID
-		_ = tx.QueryRow(r.Context(), `SELECT lat,lng FROM warehouses WHERE id=$1`, fromID).Scan(&wlat, &wlng)
-	}
-	if body.ToDistributorID != nil {
-		toID = *body.ToDistributorID
-		_ = tx.QueryRow(r.Context(), `SELECT lat,lng FROM distributors WHERE id=$1`, toID).Scan(&dlat, &dlng)
-	}
-	if body.DepartAt != nil {
-		d := body.DepartAt.UTC()
-		depart = &d
-	}
-	if depart != nil && (status == "SCHEDULED" || status == "ON_DELIVERY" || status == "DELAYED") {
-		travelMin := estimateTravelMinutes(wlat, wlng, dlat, dlng)
-		e := depart.UTC().Add(time.Duration(travelMin) * time.Minute)
-		eta = &e
+
+	score := 50.0
+	reasons := []string{}
+
+	if bestWidth > 0 {
+		if bestWidth >= 7 {
+			score += 20
+			reasons = append(reasons, "Road width "+fmtFloat(bestWidth)+"m OK for truck ("+bestRoad+")")
+		} else if bestWidth >= 5 {
+			score += 10
+			reasons = append(reasons, "Road width "+fmtFloat(bestWidth)+"m acceptable ("+bestRoad+")")
+		} else {
+			score -= 10
+			reasons = append(reasons, "Road width "+fmtFloat(bestWidth)+"m may be tight for heavy truck")
+		}
+	} else {
+		score -= 5
+		reasons = append(reasons, "No nearby road segment within 500m")
 	}
-	etaMinutes := 0
-	if eta != nil {
-		etaMinutes = int(math.Max(0, eta.Sub(time.Now().UTC()).Minutes()))
+
+	if demand3km > 600 {
+		score += 20
+		reasons = append(reasons, "High demand within 3km")
+	} else if demand3km > 250 {
+		score += 10
+		reasons = append(reasons, "Moderate demand within 3km")
+	} else {
+		reasons = append(reasons, "Demand within 3km is low")
 	}
 
-	if _, err := tx.Exec(r.Context(), `
-    UPDATE shipments
-    SET from_warehouse_id=$1, to_distributor_id=$2, truck_id=$3, depart_at=$4, arrive_eta=$5, eta_minutes=$6, updated_at=now()
-    WHERE id=$7
-	`, fromID, toID, truckID, depart, eta, etaMinutes, shipmentID); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+	if nearestWH < 20 {
+		score += 10
+		reasons = append(reasons, "Warehouse within 20km")
+	} else if nearestWH < 50 {
+		score += 5
+		reasons = append(reasons, "Warehouse within 50km")
+	} else {
+		score -= 5
+		reasons = append(reasons, "Nearest warehouse is far")
 	}
 
-	if err := tx.Commit(r.Context()); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+	if score < 0 {
+		score = 0
+	}
+	if score > 100 {
+		score = 100
 	}
-	a.insertAuditLog(r, &u, "SHIPMENT_UPDATED", "shipment", fmt.Sprintf("%d", shipmentID), map[string]any{"fromWarehouseId": fromID, "toDistributorId": toID, "truckId": truckID})
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
 
-func (a *App) handleOpsPredictionReorder(w http.ResponseWriter, r *http.Request) {
-	// Rule-based reorder recommendation:
-	// uses current stock, threshold settings, lead time, and nearby project demand intensity.
-	// Radius is a simple fixed value (50km) for demo purposes.
-	const radiusKm = 50.0
+	writeJSON(w, http.StatusOK, map[string]any{
+		"score":                 score,
+		"reasons":               reasons,
+		"roadWidthM":            bestWidth,
+		"demandWithin3km":       demand3km,
+		"distanceToWarehouseKm": nearestWH,
+	})
+}
 
-	// Load projects for intensity signal.
-	prows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, demand_tons_month FROM projects`)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+func (a *App) handlePlanningWhitespace(w http.ResponseWriter, r *http.Request) {
+	bboxStr := r.URL.Query().Get("bbox")
+	minLat, minLng, maxLat, maxLng, ok := parseBBox(bboxStr)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "bbox must be minLat,minLng,maxLat,maxLng")
 		return
 	}
-	type proj struct {
-		id     int64
-		lat    float64
-		lng    float64
-		demand float64
+
+	// Preload distributors + stores (small dataset)
+	type pt struct{ lat, lng float64 }
+	drows, _ := a.db.Query(r.Context(), `SELECT lat, lng FROM distributors WHERE archived_at IS NULL`)
+	distributors := []pt{}
+	for drows.Next() {
+		var la, ln float64
+		_ = drows.Scan(&la, &ln)
+		distributors = append(distributors, pt{la, ln})
 	}
-	projects := []proj{}
-	for prows.Next() {
-		var p proj
-		var name string
-		_ = prows.Scan(&p.id, &name, &p.lat, &p.lng, &p.demand)
-		projects = append(projects, p)
+	drows.Close()
+	srows, _ := a.db.Query(r.Context(), `SELECT lat, lng FROM stores WHERE archived_at IS NULL`)
+	stores := []pt{}
+	for srows.Next() {
+		var la, ln float64
+		_ = srows.Scan(&la, &ln)
+		stores = append(stores, pt{la, ln})
 	}
-	prows.Close()
+	srows.Close()
 
+	// Heatmap cells in bbox
 	rows, err := a.db.Query(r.Context(), `
-    SELECT w.id, w.name, w.lat, w.lng,
-           s.cement_type, s.quantity_tons,
-           t.min_stock, t.safety_stock, t.warning_level, t.critical_level, t.lead_time_days
-    FROM stock_levels s
-    JOIN warehouses w ON w.id = s.warehouse_id
-    JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
-    ORDER BY w.id, s.cement_type
-  `)
+    SELECT lat, lng, demand_tons_month
+    FROM projects
+    WHERE archived_at IS NULL AND lat BETWEEN $1 AND $2 AND lng BETWEEN $3 AND $4
+  `, minLat, maxLat, minLng, maxLng)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	defer rows.Close()
 
-	out := []map[string]any{}
+	cell := 0.02
+	type cellKey struct{ a, b int }
+	scores := map[cellKey]float64{}
 	for rows.Next() {
-		var wid int64
-		var wname string
-		var wlat, wlng float64
-		var ct string
-		var qty float64
-		var min, safety, warn, critical float64
-		var lead int
-		_ = rows.Scan(&wid, &wname, &wlat, &wlng, &ct, &qty, &min, &safety, &warn, &critical, &lead)
+		var lat, lng, demand float64
+		if err := rows.Scan(&lat, &lng, &demand); err != nil {
+			continue
+		}
+		la := int(math.Floor(lat / cell))
+		lb := int(math.Floor(lng / cell))
+		scores[cellKey{la, lb}] += demand
+	}
 
-		intensity := 0.0
-		for _, p := range projects {
-			if haversineKm(wlat, wlng, p.lat, p.lng) <= radiusKm {
-				intensity += p.demand
-			}
+	whitespace := []map[string]any{}
+	for k, sc := range scores {
+		if sc < 450 {
+			continue
 		}
-		// Rough demand during lead time window.
-		demandLead := intensity * (float64(lead) / 30.0)
-		target := safety + demandLead
-		recommended := math.Max(0, target-qty)
+		clat := float64(k.a) * cell
+		clng := float64(k.b) * cell
+		centerLat := clat + cell/2
+		centerLng := clng + cell/2
 
-		recoStatus := "OK"
-		urgency := "LOW"
-		if recommended > 0 {
-			recoStatus = "REORDER"
+		nearestStore := 1e9
+		for _, s := range stores {
+			d := haversineKM(centerLat, centerLng, s.lat, s.lng)
+			if d < nearestStore {
+				nearestStore = d
+			}
 		}
-		if qty <= critical || recommended >= 250 {
-			urgency = "HIGH"
-		} else if qty <= warn || recommended >= 120 {
-			urgency = "MED"
+		nearestDist := 1e9
+		for _, d := range distributors {
+			dd := haversineKM(centerLat, centerLng, d.lat, d.lng)
+			if dd < nearestDist {
+				nearestDist = dd
+			}
+		}
+		if nearestStore > 5 && nearestDist > 6 {
+			whitespace = append(whitespace, map[string]any{
+				"cellLat":              clat,
+				"cellLng":              clng,
+				"centerLat":            centerLat,
+				"centerLng":            centerLng,
+				"size":                 cell,
+				"score":                sc,
+				"nearestStoreKm":       nearestStore,
+				"nearestDistributorKm": nearestDist,
+			})
 		}
-
-		out = append(out, map[string]any{
-			"warehouseId":                        wid,
-			"warehouseName":                      wname,
-			"cementType":                         ct,
-			"quantityTons":                       qty,
-			"leadTimeDays":                       lead,
-			"nearbyProjectIntensityTonsPerMonth": intensity,
-			"targetStockTons":                    target,
-			"recommendedQuantityTons":            recommended,
-			"status":                             recoStatus,
-			"urgency":                            urgency,
-			"radiusKm":                           radiusKm,
-			"thresholds": map[string]any{
-				"minStock":      min,
-				"safetyStock":   safety,
-				"warningLevel":  warn,
-				"criticalLevel": critical,
-			},
-		})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": out})
-}
 
-func (a *App) handleOpsShipments(w http.ResponseWriter, r *http.Request) {
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
-	}
-	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
-	if pageSize < 1 {
-		pageSize = 10
-	}
-	if pageSize > 50 {
-		pageSize = 50
-	}
-	offset := (page - 1) * pageSize
+	writeJSON(w, http.StatusOK, map[string]any{"areas": whitespace, "cellSize": cell})
+}
 
-	rows, err := a.db.Query(r.Context(), `
-		SELECT s.id, s.status, s.cement_type, s.quantity_tons,
-					 s.depart_at, s.arrive_eta, s.eta_minutes, s.last_lat, s.last_lng, s.last_update,
-					 w.id, w.name,
-					 d.id, d.name,
-					 t.id, t.code, t.name
-		FROM shipments s
-		JOIN warehouses w ON w.id = s.from_warehouse_id
-		JOIN distributors d ON d.id = s.to_distributor_id
-		LEFT JOIN trucks t ON t.id = s.truck_id
-		ORDER BY s.id DESC
-		LIMIT $1 OFFSET $2
-	`, pageSize, offset)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+func (a *App) handlePlanningCatchment(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	warehouseID := strings.TrimSpace(q.Get("warehouseId"))
+	distributorID := strings.TrimSpace(q.Get("distributorId"))
+	if warehouseID == "" && distributorID == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "warehouseId or distributorId required")
 		return
 	}
-	defer rows.Close()
 
-	items := []map[string]any{}
-	for rows.Next() {
-		var id int64
-		var status string
-		var cementType string
-		var qtyTons float64
-		var depart, eta *time.Time
-		var etaMinutes int
-		var lastLat, lastLng *float64
-		var lastUpdate *time.Time
-		var wid, did int64
-		var wname, dname string
-		var truckID *int64
-		var truckCode, truckName *string
-		_ = rows.Scan(&id, &status, &cementType, &qtyTons, &depart, &eta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname, &did, &dname, &truckID, &truckCode, &truckName)
-		truck := map[string]any{"id": nil, "code": nil, "name": nil}
-		if truckID != nil {
-			truck["id"] = *truckID
-			if truckCode != nil {
-				truck["code"] = *truckCode
-			}
-			if truckName != nil {
-				truck["name"] = *truckName
-			}
+	type entity struct {
+		id     int64
+		name   string
+		lat    float64
+		lng    float64
+		radius float64
+		kind   string
+	}
+
+	var sel entity
+	if distributorID != "" {
+		id, err := strconv.ParseInt(distributorID, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid distributorId")
+			return
 		}
-		items = append(items, map[string]any{
-			"id":            id,
-			"status":        status,
-			"cementType":    cementType,
-			"quantityTons":  qtyTons,
-			"departAt":      depart,
-			"arriveEta":     eta,
-			"etaMinutes":    etaMinutes,
-			"lastLat":       lastLat,
-			"lastLng":       lastLng,
-			"lastUpdate":    lastUpdate,
-			"truck":         truck,
-			"fromWarehouse": map[string]any{"id": wid, "name": wname},
-			"toDistributor": map[string]any{"id": did, "name": dname},
-		})
+		row := a.db.QueryRow(r.Context(), `SELECT id, name, lat, lng, service_radius_km FROM distributors WHERE id = $1 AND archived_at IS NULL`, id)
+		if err := row.Scan(&sel.id, &sel.name, &sel.lat, &sel.lng, &sel.radius); err != nil {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
+			return
+		}
+		sel.kind = "distributor"
+	} else {
+		id, err := strconv.ParseInt(warehouseID, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid warehouseId")
+			return
+		}
+		row := a.db.QueryRow(r.Context(), `SELECT id, name, lat, lng FROM warehouses WHERE id = $1 AND archived_at IS NULL`, id)
+		if err := row.Scan(&sel.id, &sel.name, &sel.lat, &sel.lng); err != nil {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "warehouse not found")
+			return
+		}
+		sel.kind = "warehouse"
+		sel.radius = 45
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items, "page": page, "pageSize": pageSize})
-}
 
-func (a *App) handleOpsShipmentDetail(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	// Compute cannibalization overlaps between distributors.
+	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, service_radius_km FROM distributors WHERE archived_at IS NULL`)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
+	defer rows.Close()
 
-	// Load shipment + endpoints.
-	row := a.db.QueryRow(r.Context(), `
-    SELECT s.id, s.status, s.cement_type, s.quantity_tons,
-           s.depart_at, s.arrive_eta, s.eta_minutes, s.last_lat, s.last_lng, s.last_update,
-           w.id, w.name, w.lat, w.lng,
-           d.id, d.name, d.lat, d.lng,
-           t.id, t.code, t.name
-    FROM shipments s
-    JOIN warehouses w ON w.id = s.from_warehouse_id
-    JOIN distributors d ON d.id = s.to_distributor_id
-    LEFT JOIN trucks t ON t.id = s.truck_id
-    WHERE s.id = $1
-  `, id)
-	var status string
-	var cementType string
-	var qtyTons float64
-	var depart, eta *time.Time
-	var etaMinutes int
-	var lastLat, lastLng *float64
-	var lastUpdate *time.Time
-	var wid, did int64
-	var wname, dname string
-	var wlat, wlng, dlat, dlng float64
-	var truckID *int64
-	var truckCode, truckName *string
-	if err := row.Scan(&id, &status, &cementType, &qtyTons, &depart, &eta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname, &wlat, &wlng, &did, &dname, &dlat, &dlng, &truckID, &truckCode, &truckName); err != nil {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
-		return
+	type dist struct {
+		id     int64
+		name   string
+		lat    float64
+		lng    float64
+		radius float64
 	}
-	truck := map[string]any{"id": nil, "code": nil, "name": nil}
-	if truckID != nil {
-		truck["id"] = *truckID
-		if truckCode != nil {
-			truck["code"] = *truckCode
-		}
-		if truckName != nil {
-			truck["name"] = *truckName
+	dists := []dist{}
+	for rows.Next() {
+		var d dist
+		if err := rows.Scan(&d.id, &d.name, &d.lat, &d.lng, &d.radius); err != nil {
+			continue
 		}
+		dists = append(dists, d)
 	}
 
-	// Update truck position for in-transit shipments.
-	if status == "ON_DELIVERY" && depart != nil && eta != nil {
-		now := time.Now().UTC()
-		frac := float64(now.Sub(depart.UTC())) / float64(eta.UTC().Sub(depart.UTC()))
-		if frac < 0 {
-			frac = 0
+	conflicts := []map[string]any{}
+	if sel.kind == "distributor" {
+		for _, d := range dists {
+			if d.id == sel.id {
+				continue
+			}
+			centerDist := haversineKM(sel.lat, sel.lng, d.lat, d.lng)
+			if centerDist < (sel.radius + d.radius) {
+				conflicts = append(conflicts, map[string]any{
+					"otherDistributorId":   d.id,
+					"otherDistributorName": d.name,
+					"distanceKm":           centerDist,
+					"overlapKm":            (sel.radius + d.radius) - centerDist,
+				})
+			}
 		}
-		if frac > 1 {
-			frac = 1
+	} else {
+		// For warehouses, list all pairs of distributors inside warehouse service radius that overlap.
+		inside := []dist{}
+		for _, d := range dists {
+			if haversineKM(sel.lat, sel.lng, d.lat, d.lng) <= sel.radius {
+				inside = append(inside, d)
+			}
+		}
+		for i := 0; i < len(inside); i++ {
+			for j := i + 1; j < len(inside); j++ {
+				a1 := inside[i]
+				a2 := inside[j]
+				centerDist := haversineKM(a1.lat, a1.lng, a2.lat, a2.lng)
+				if centerDist < (a1.radius + a2.radius) {
+					conflicts = append(conflicts, map[string]any{
+						"aDistributorId":   a1.id,
+						"aDistributorName": a1.name,
+						"bDistributorId":   a2.id,
+						"bDistributorName": a2.name,
+						"distanceKm":       centerDist,
+					})
+				}
+			}
 		}
-		ll := wlat + (dlat-wlat)*frac
-		lg := wlng + (dlng-wlng)*frac
-		lastLat, lastLng = &ll, &lg
-		u := now
-		lastUpdate = &u
-		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
-		_, _ = a.db.Exec(r.Context(), `UPDATE shipments SET last_lat=$1, last_lng=$2, last_update=$3, eta_minutes=$4 WHERE id=$5`, ll, lg, u, etaMinutes, id)
 	}
 
 	writeJSON(w, http.StatusOK, map[string]any{
-		"id":            id,
-		"status":        status,
-		"cementType":    cementType,
-		"quantityTons":  qtyTons,
-		"departAt":      depart,
-		"arriveEta":     eta,
-		"etaMinutes":    etaMinutes,
-		"truck":         map[string]any{"id": truck["id"], "code": truck["code"], "name": truck["name"], "lastLat": lastLat, "lastLng": lastLng, "lastUpdate": lastUpdate},
-		"fromWarehouse": map[string]any{"id": wid, "name": wname, "lat": wlat, "lng": wlng},
-		"toDistributor": map[string]any{"id": did, "name": dname, "lat": dlat, "lng": dlng},
+		"entity": map[string]any{
+			"kind":     sel.kind,
+			"id":       sel.id,
+			"name":     sel.name,
+			"lat":      sel.lat,
+			"lng":      sel.lng,
+			"radiusKm": sel.radius,
+		},
+		"conflicts": conflicts,
 	})
 }
 
-// ---------- ops: issues ----------
+// ---------- operations ----------
 
-func (a *App) handleOpsIssues(w http.ResponseWriter, r *http.Request) {
-	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
-	issueType := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("type")))
-	severity := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("severity")))
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const r = 6371.0
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+	phi1 := toRad(lat1)
+	phi2 := toRad(lat2)
+	dPhi := toRad(lat2 - lat1)
+	dLam := toRad(lng2 - lng1)
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLam/2)*math.Sin(dLam/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return r * c
+}
 
-	whereParts := []string{}
-	args := []any{}
-	idx := 1
-	if status != "" && status != "ALL" {
-		whereParts = append(whereParts, fmt.Sprintf("i.status=$%d", idx))
-		args = append(args, status)
-		idx++
-	}
-	if issueType != "" && issueType != "ALL" {
-		whereParts = append(whereParts, fmt.Sprintf("i.issue_type=$%d", idx))
-		args = append(args, issueType)
-		idx++
+func estimateTravelMinutes(lat1, lng1, lat2, lng2 float64) int {
+	km := haversineKm(lat1, lng1, lat2, lng2)
+	// Dummy speed model: 45–60 km/h. Clamp to keep UX stable.
+	speed := 52.0
+	mins := int(math.Ceil((km / speed) * 60))
+	if mins < 60 {
+		mins = 60
 	}
-	if severity != "" && severity != "ALL" {
-		whereParts = append(whereParts, fmt.Sprintf("i.severity=$%d", idx))
-		args = append(args, severity)
-		idx++
+	if mins > 720 {
+		mins = 720
 	}
+	return mins
+}
 
-	where := ""
-	if len(whereParts) > 0 {
-		where = "WHERE " + strings.Join(whereParts, " AND ")
+// insertAuditLog appends an audit entry on its own. It is meant for events
+// with no business change to roll back (logins, token use, failed attempts);
+// a failure is logged rather than surfaced. Mutations use insertAuditLogTx.
+func (a *App) insertAuditLog(r *http.Request, actor *User, action, entityType, entityID string, metadata map[string]any) {
+	if err := a.execAuditLog(a.db, r, actor, action, entityType, entityID, metadata); err != nil {
+		log.Printf("audit: %s %s %s: %v", action, entityType, entityID, err)
 	}
+}
 
-	q := fmt.Sprintf(`
-    SELECT i.id, i.issue_type, i.severity, i.status,
-           i.title, i.description,
-           i.shipment_id,
-           i.warehouse_id, w.name,
-           i.distributor_id, d.name,
-           i.reported_by_user_id, ru.name,
-           i.reported_at,
-           i.resolved_by_user_id, su.name,
-	    i.resolved_at, i.resolution_notes,
-	    i.metadata,
-           i.created_at, i.updated_at
-    FROM ops_issues i
+// insertAuditLogTx appends an audit entry inside tx, so the change and its
+// audit entry commit together. Callers must abort the request on error; the
+// deferred rollback then undoes the business change as well.
+func (a *App) insertAuditLogTx(tx pgx.Tx, r *http.Request, actor *User, action, entityType, entityID string, metadata map[string]any) error {
+	return a.execAuditLog(tx, r, actor, action, entityType, entityID, metadata)
+}
+
+// dbExecer is satisfied by both the pool and a pgx.Tx.
+type dbExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// execAuditLog writes one audit_logs row. chain_seq, prev_hash and hash are
+// filled in by the audit_logs_chain trigger.
+func (a *App) execAuditLog(q dbExecer, r *http.Request, actor *User, action, entityType, entityID string, metadata map[string]any) error {
+	var actorID any = nil
+	if actor != nil {
+		actorID = actor.ID
+	}
+	ctx := context.Background()
+	ip := clientIP(r)
+	if r != nil {
+		ctx = r.Context()
+		// Record which token performed the action without mutating the caller's map.
+		if tok, ok := ctx.Value(ctxTokenKey).(apiToken); ok {
+			withToken := make(map[string]any, len(metadata)+1)
+			for k, v := range metadata {
+				withToken[k] = v
+			}
+			withToken["apiTokenId"] = fmt.Sprintf("%d", tok.ID)
+			metadata = withToken
+		}
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+    INSERT INTO audit_logs (actor_user_id, action, entity_type, entity_id, metadata, ip)
+	  VALUES ($1,$2,$3,$4,$5::jsonb,$6)
+  `, actorID, action, entityType, entityID, string(b), ip)
+	return err
+}
+
+// clientIP returns the caller's address. RemoteAddr has already been
+// rewritten by realIP when the request came through a trusted proxy, so the
+// forwarding headers are not consulted here.
+func clientIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	addr := strings.TrimSpace(r.RemoteAddr)
+	if addr == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil {
+		return host
+	}
+	return addr
+}
+
+// realIP replaces RemoteAddr with the client address from X-Forwarded-For or
+// X-Real-IP, but only when the direct peer is one of the trusted proxies.
+// X-Forwarded-For is read from the right and trusted hops are skipped, so a
+// client cannot choose its own address by prepending entries.
+func realIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(ip netip.Addr) bool {
+		ip = ip.Unmap()
+		for _, p := range trusted {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(trusted) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			peer, err := netip.ParseAddr(clientIP(r))
+			if err != nil || !isTrusted(peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			client := ""
+			if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+				hops := strings.Split(strings.Join(xff, ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+					if err != nil {
+						break
+					}
+					client = ip.Unmap().String()
+					if !isTrusted(ip) {
+						break
+					}
+				}
+			} else if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+				client = ip.Unmap().String()
+			}
+			if client != "" {
+				r.RemoteAddr = net.JoinHostPort(client, "0")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (a *App) handleOpsOverview(w http.ResponseWriter, r *http.Request) {
+	// Aggregations are intentionally simple and rule-based (no ML/AI).
+	sc := scopeFrom(r)
+	var nationalStock float64
+	_ = a.db.QueryRow(r.Context(), `
+    SELECT COALESCE(SUM(quantity_tons),0)
+    FROM stock_levels
+    WHERE ($1::bigint[] IS NULL OR warehouse_id = ANY($1))
+  `, sc.WarehouseIDs).Scan(&nationalStock)
+
+	regional := []map[string]any{}
+	rows, err := a.db.Query(r.Context(), `
+    SELECT w.id, w.name, COALESCE(SUM(s.quantity_tons),0) AS stock
+    FROM warehouses w
+    LEFT JOIN stock_levels s ON s.warehouse_id = w.id
+    WHERE w.archived_at IS NULL AND ($1::bigint[] IS NULL OR w.id = ANY($1))
+    GROUP BY w.id, w.name
+    ORDER BY w.id
+  `, sc.WarehouseIDs)
+	if err == nil {
+		for rows.Next() {
+			var id int64
+			var name string
+			var stock float64
+			_ = rows.Scan(&id, &name, &stock)
+			regional = append(regional, map[string]any{"warehouseId": id, "warehouseName": name, "stockTons": stock})
+		}
+		rows.Close()
+	}
+
+	var warehousesCritical int
+	_ = a.db.QueryRow(r.Context(), `
+    SELECT COUNT(DISTINCT s.warehouse_id)
+    FROM stock_levels s
+    JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
+    WHERE s.quantity_tons <= t.critical_level
+      AND ($1::bigint[] IS NULL OR s.warehouse_id = ANY($1))
+  `, sc.WarehouseIDs).Scan(&warehousesCritical)
+
+	var minStockAlerts int
+	_ = a.db.QueryRow(r.Context(), `
+    SELECT COUNT(*)
+    FROM stock_levels s
+    JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
+    WHERE s.quantity_tons <= t.min_stock
+      AND ($1::bigint[] IS NULL OR s.warehouse_id = ANY($1))
+  `, sc.WarehouseIDs).Scan(&minStockAlerts)
+
+	var pendingOrdersToday int
+	_ = a.db.QueryRow(r.Context(), `
+    SELECT COUNT(*)
+    FROM order_requests
+    WHERE status='PENDING' AND requested_at::date = CURRENT_DATE
+      AND ($1::bigint[] IS NULL OR distributor_id = ANY($1))
+  `, sc.DistributorIDs).Scan(&pendingOrdersToday)
+
+	var activeShipments int
+	_ = a.db.QueryRow(r.Context(), `
+    SELECT COUNT(*)
+    FROM shipments
+    WHERE status IN ('SCHEDULED','ON_DELIVERY','DELAYED')
+      AND ($1::bigint[] IS NULL OR from_warehouse_id = ANY($1))
+      AND ($2::bigint[] IS NULL OR to_distributor_id = ANY($2))
+  `, sc.WarehouseIDs, sc.DistributorIDs).Scan(&activeShipments)
+
+	var delayedShipments int
+	_ = a.db.QueryRow(r.Context(), `
+    SELECT COUNT(*)
+    FROM shipments
+    WHERE (status='DELAYED' OR (status='ON_DELIVERY' AND arrive_eta IS NOT NULL AND arrive_eta < now()))
+      AND ($1::bigint[] IS NULL OR from_warehouse_id = ANY($1))
+      AND ($2::bigint[] IS NULL OR to_distributor_id = ANY($2))
+  `, sc.WarehouseIDs, sc.DistributorIDs).Scan(&delayedShipments)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"nationalStockTons":       nationalStock,
+		"regionalStock":           regional,
+		"warehousesCriticalCount": warehousesCritical,
+		"pendingOrdersToday":      pendingOrdersToday,
+		"activeShipments":         activeShipments,
+		"delayedShipments":        delayedShipments,
+		"minStockAlerts":          minStockAlerts,
+		"note":                    "Regional menggunakan warehouse sebagai proxy (region tidak dimodelkan).",
+	})
+}
+
+func (a *App) handleOpsLogisticsMap(w http.ResponseWriter, r *http.Request) {
+	// Plant
+	prow := a.db.QueryRow(r.Context(), `SELECT id, name, lat, lng FROM plants WHERE archived_at IS NULL ORDER BY id LIMIT 1`)
+	plant := map[string]any{}
+	{
+		var id int64
+		var name string
+		var lat, lng float64
+		_ = prow.Scan(&id, &name, &lat, &lng)
+		plant = map[string]any{"id": id, "name": name, "lat": lat, "lng": lng}
+	}
+
+	sc := scopeFrom(r)
+
+	// Warehouses
+	wrows, _ := a.db.Query(r.Context(), `
+    SELECT id, name, lat, lng, capacity_tons
+    FROM warehouses
+    WHERE archived_at IS NULL AND ($1::bigint[] IS NULL OR id = ANY($1))
+    ORDER BY id
+  `, sc.WarehouseIDs)
+	warehouses := []map[string]any{}
+	for wrows.Next() {
+		var id int64
+		var name string
+		var lat, lng, cap float64
+		_ = wrows.Scan(&id, &name, &lat, &lng, &cap)
+		warehouses = append(warehouses, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "capacityTons": cap})
+	}
+	wrows.Close()
+
+	// Distributors
+	drows, _ := a.db.Query(r.Context(), `
+    SELECT id, name, lat, lng, service_radius_km
+    FROM distributors
+    WHERE archived_at IS NULL AND ($1::bigint[] IS NULL OR id = ANY($1))
+    ORDER BY id
+  `, sc.DistributorIDs)
+	distributors := []map[string]any{}
+	for drows.Next() {
+		var id int64
+		var name string
+		var lat, lng, rad float64
+		_ = drows.Scan(&id, &name, &lat, &lng, &rad)
+		distributors = append(distributors, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "serviceRadiusKm": rad})
+	}
+	drows.Close()
+
+	// Sample routes: connect each warehouse to a couple of distributors.
+	routes := []map[string]any{}
+	for i := 0; i < len(warehouses) && len(distributors) > 0; i++ {
+		w := warehouses[i]
+		for j := 0; j < 2; j++ {
+			idx := (i*2 + j) % len(distributors)
+			d := distributors[idx]
+			routes = append(routes, map[string]any{
+				"fromWarehouseId": w["id"],
+				"toDistributorId": d["id"],
+				"polyline": []map[string]any{
+					{"lat": w["lat"], "lng": w["lng"]},
+					{"lat": d["lat"], "lng": d["lng"]},
+				},
+			})
+		}
+	}
+
+	// Active shipments: include simple polyline + simulated truck position.
+	srows, err := a.db.Query(r.Context(), `
+    SELECT s.id, s.status, s.depart_at, s.arrive_eta, s.eta_minutes, s.last_lat, s.last_lng, s.last_update,
+           w.id, w.name, w.lat, w.lng,
+           d.id, d.name, d.lat, d.lng
+    FROM shipments s
+    JOIN warehouses w ON w.id = s.from_warehouse_id
+    JOIN distributors d ON d.id = s.to_distributor_id
+    WHERE s.status IN ('SCHEDULED','ON_DELIVERY','DELAYED')
+      AND ($1::bigint[] IS NULL OR s.from_warehouse_id = ANY($1))
+      AND ($2::bigint[] IS NULL OR s.to_distributor_id = ANY($2))
+    ORDER BY s.id DESC
+    LIMIT 50
+  `, sc.WarehouseIDs, sc.DistributorIDs)
+	activeShipments := []map[string]any{}
+	if err == nil {
+		now := time.Now().UTC()
+		for srows.Next() {
+			var id int64
+			var status string
+			var depart, eta *time.Time
+			var etaMinutes int
+			var lastLat, lastLng *float64
+			var lastUpdate *time.Time
+			var wid, did int64
+			var wname, dname string
+			var wlat, wlng, dlat, dlng float64
+			_ = srows.Scan(&id, &status, &depart, &eta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname, &wlat, &wlng, &did, &dname, &dlat, &dlng)
+
+			// Simulate position if ON_DELIVERY.
+			if status == "ON_DELIVERY" && depart != nil && eta != nil {
+				frac := float64(now.Sub(depart.UTC())) / float64(eta.UTC().Sub(depart.UTC()))
+				if frac < 0 {
+					frac = 0
+				}
+				if frac > 1 {
+					frac = 1
+				}
+				ll := wlat + (dlat-wlat)*frac
+				lg := wlng + (dlng-wlng)*frac
+				lastLat, lastLng = &ll, &lg
+				u := now
+				lastUpdate = &u
+				etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
+			}
+
+			activeShipments = append(activeShipments, map[string]any{
+				"id":            id,
+				"status":        status,
+				"etaMinutes":    etaMinutes,
+				"truck":         map[string]any{"lastLat": lastLat, "lastLng": lastLng, "lastUpdate": lastUpdate},
+				"fromWarehouse": map[string]any{"id": wid, "name": wname, "lat": wlat, "lng": wlng},
+				"toDistributor": map[string]any{"id": did, "name": dname, "lat": dlat, "lng": dlng},
+				"polyline":      []map[string]any{{"lat": wlat, "lng": wlng}, {"lat": dlat, "lng": dlng}},
+			})
+		}
+		srows.Close()
+	}
+
+	// Open warehouse-to-warehouse transfers, positioned the same way.
+	trows, err := a.db.Query(r.Context(), `
+    SELECT t.id, t.status, t.cement_type, t.quantity_tons, t.depart_at, t.arrive_eta,
+           fw.id, fw.name, fw.lat, fw.lng,
+           tw.id, tw.name, tw.lat, tw.lng
+    FROM stock_transfers t
+    JOIN warehouses fw ON fw.id = t.from_warehouse_id
+    JOIN warehouses tw ON tw.id = t.to_warehouse_id
+    WHERE t.status IN ('SCHEDULED','IN_TRANSIT') AND `+transferScope+`
+    ORDER BY t.id DESC
+    LIMIT 50
+  `, sc.WarehouseIDs)
+	activeTransfers := []map[string]any{}
+	if err == nil {
+		now := time.Now().UTC()
+		for trows.Next() {
+			var id, fid, tid int64
+			var status, ct, fname, tname string
+			var qty float64
+			var depart, eta *time.Time
+			var flat, flng, tlat, tlng float64
+			_ = trows.Scan(&id, &status, &ct, &qty, &depart, &eta, &fid, &fname, &flat, &flng, &tid, &tname, &tlat, &tlng)
+
+			var lat, lng *float64
+			etaMinutes := 0
+			if eta != nil {
+				etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
+			}
+			if status == "IN_TRANSIT" && depart != nil && eta != nil {
+				frac := 1.0
+				if total := eta.UTC().Sub(depart.UTC()); total > 0 {
+					frac = math.Min(1, math.Max(0, float64(now.Sub(depart.UTC()))/float64(total)))
+				}
+				ll := flat + (tlat-flat)*frac
+				lg := flng + (tlng-flng)*frac
+				lat, lng = &ll, &lg
+			}
+
+			activeTransfers = append(activeTransfers, map[string]any{
+				"id":            id,
+				"status":        status,
+				"cementType":    ct,
+				"quantityTons":  qty,
+				"etaMinutes":    etaMinutes,
+				"position":      map[string]any{"lat": lat, "lng": lng},
+				"fromWarehouse": map[string]any{"id": fid, "name": fname, "lat": flat, "lng": flng},
+				"toWarehouse":   map[string]any{"id": tid, "name": tname, "lat": tlat, "lng": tlng},
+				"polyline":      []map[string]any{{"lat": flat, "lng": flng}, {"lat": tlat, "lng": tlng}},
+			})
+		}
+		trows.Close()
+	}
+
+	// Open plant-to-warehouse dispatches.
+	dsrows, err := a.db.Query(r.Context(), `
+    SELECT d.id, d.status, d.cement_type, d.quantity_tons, d.depart_at, d.arrive_eta,
+           p.id, p.name, p.lat, p.lng,
+           w.id, w.name, w.lat, w.lng
+    FROM plant_dispatches d
+    JOIN plants p ON p.id = d.plant_id
+    JOIN warehouses w ON w.id = d.to_warehouse_id
+    WHERE d.status IN ('SCHEDULED','IN_TRANSIT') AND ($1::bigint[] IS NULL OR d.to_warehouse_id = ANY($1))
+    ORDER BY d.id DESC
+    LIMIT 50
+  `, sc.WarehouseIDs)
+	activeDispatches := []map[string]any{}
+	if err == nil {
+		now := time.Now().UTC()
+		for dsrows.Next() {
+			var id, pid, wid int64
+			var status, ct, pname, wname string
+			var qty float64
+			var depart, eta *time.Time
+			var plat, plng, wlat, wlng float64
+			_ = dsrows.Scan(&id, &status, &ct, &qty, &depart, &eta, &pid, &pname, &plat, &plng, &wid, &wname, &wlat, &wlng)
+
+			var lat, lng *float64
+			etaMinutes := 0
+			if eta != nil {
+				etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
+			}
+			if status == "IN_TRANSIT" && depart != nil && eta != nil {
+				frac := 1.0
+				if total := eta.UTC().Sub(depart.UTC()); total > 0 {
+					frac = math.Min(1, math.Max(0, float64(now.Sub(depart.UTC()))/float64(total)))
+				}
+				ll := plat + (wlat-plat)*frac
+				lg := plng + (wlng-plng)*frac
+				lat, lng = &ll, &lg
+			}
+
+			activeDispatches = append(activeDispatches, map[string]any{
+				"id":           id,
+				"status":       status,
+				"cementType":   ct,
+				"quantityTons": qty,
+				"etaMinutes":   etaMinutes,
+				"position":     map[string]any{"lat": lat, "lng": lng},
+				"plant":        map[string]any{"id": pid, "name": pname, "lat": plat, "lng": plng},
+				"toWarehouse":  map[string]any{"id": wid, "name": wname, "lat": wlat, "lng": wlng},
+				"polyline":     []map[string]any{{"lat": plat, "lng": plng}, {"lat": wlat, "lng": wlng}},
+			})
+		}
+		dsrows.Close()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"plant":            plant,
+		"warehouses":       warehouses,
+		"distributors":     distributors,
+		"routes":           routes,
+		"activeShipments":  activeShipments,
+		"activeTransfers":  activeTransfers,
+		"activeDispatches": activeDispatches,
+	})
+}
+
+func (a *App) handleOpsStock(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `
+    SELECT w.id, w.name, s.cement_type, s.quantity_tons, s.updated_at
+    FROM stock_levels s
+    JOIN warehouses w ON w.id = s.warehouse_id
+    WHERE w.archived_at IS NULL AND ($1::bigint[] IS NULL OR w.id = ANY($1))
+    ORDER BY w.id, s.cement_type
+  `, scopeFrom(r).WarehouseIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	out := []map[string]any{}
+	for rows.Next() {
+		var wid int64
+		var wname, ct string
+		var qty float64
+		var updated time.Time
+		_ = rows.Scan(&wid, &wname, &ct, &qty, &updated)
+		out = append(out, map[string]any{
+			"warehouseId":   wid,
+			"warehouseName": wname,
+			"cementType":    ct,
+			"quantityTons":  qty,
+			"updatedAt":     updated,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+// handleOpsTrucks lists trucks; scoped users only see trucks based at one of
+// their warehouses.
+func (a *App) handleOpsTrucks(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `
+    SELECT id, code, name, capacity_tons, active
+    FROM trucks
+    WHERE ($1::bigint[] IS NULL OR home_warehouse_id = ANY($1))
+    ORDER BY id
+  `, scopeFrom(r).WarehouseIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var code, name string
+		var cap float64
+		var active bool
+		_ = rows.Scan(&id, &code, &name, &cap, &active)
+		items = append(items, map[string]any{"id": id, "code": code, "name": name, "capacityTons": cap, "active": active})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleOpsInventory(w http.ResponseWriter, r *http.Request) {
+	sc := scopeFrom(r)
+	// Join stock with thresholds to compute a simple status.
+	rows, err := a.db.Query(r.Context(), `
+    SELECT w.id, w.name, w.capacity_tons,
+	    s.cement_type, s.quantity_tons, s.updated_at,
+           t.min_stock, t.safety_stock, t.warning_level, t.critical_level, t.lead_time_days
+    FROM stock_levels s
+    JOIN warehouses w ON w.id = s.warehouse_id
+    LEFT JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
+    WHERE w.archived_at IS NULL AND ($1::bigint[] IS NULL OR w.id = ANY($1))
+    ORDER BY w.id, s.cement_type
+  `, sc.WarehouseIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	type key struct {
+		wid int64
+		ct  string
+	}
+	items := []map[string]any{}
+	keys := []key{}
+	for rows.Next() {
+		var wid int64
+		var wname, ct string
+		var cap float64
+		var qty float64
+		var updated time.Time
+		var min, safety, warn, critical *float64
+		var lead *int
+		_ = rows.Scan(&wid, &wname, &cap, &ct, &qty, &updated, &min, &safety, &warn, &critical, &lead)
+
+		status := "OK"
+		if critical != nil && qty <= *critical {
+			status = "CRITICAL"
+		} else if warn != nil && qty <= *warn {
+			status = "WARNING"
+		}
+
+		it := map[string]any{
+			"warehouseId":   wid,
+			"warehouseName": wname,
+			"capacityTons":  cap,
+			"cementType":    ct,
+			"quantityTons":  qty,
+			"updatedAt":     updated,
+			"status":        status,
+			"thresholds": map[string]any{
+				"minStock":      min,
+				"safetyStock":   safety,
+				"warningLevel":  warn,
+				"criticalLevel": critical,
+				"leadTimeDays":  lead,
+			},
+		}
+		items = append(items, it)
+		keys = append(keys, key{wid: wid, ct: ct})
+	}
+
+	// Attach recent movements (3 per warehouse+cement type)
+	mrows, err := a.db.Query(r.Context(), `
+    SELECT id, ts, actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason
+    FROM (
+      SELECT m.*, ROW_NUMBER() OVER (PARTITION BY warehouse_id, cement_type ORDER BY ts DESC) AS rn
+      FROM inventory_movements m
+      WHERE ($1::bigint[] IS NULL OR m.warehouse_id = ANY($1))
+    ) x
+    WHERE rn <= 3
+    ORDER BY ts DESC
+  `, sc.WarehouseIDs)
+	recent := map[key][]map[string]any{}
+	if err == nil {
+		for mrows.Next() {
+			var id int64
+			var ts time.Time
+			var actorID *int64
+			var wid int64
+			var ct, mt, reason string
+			var qty float64
+			_ = mrows.Scan(&id, &ts, &actorID, &wid, &ct, &mt, &qty, &reason)
+			recent[key{wid: wid, ct: ct}] = append(recent[key{wid: wid, ct: ct}], map[string]any{
+				"id":           id,
+				"ts":           ts,
+				"movementType": mt,
+				"quantityTons": qty,
+				"reason":       reason,
+				"actorUserId":  actorID,
+			})
+		}
+		mrows.Close()
+	}
+	inbound, outbound, err := a.stockInTransit(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	for i := range items {
+		wid := items[i]["warehouseId"].(int64)
+		ct := items[i]["cementType"].(string)
+		items[i]["recentMovements"] = recent[key{wid: wid, ct: ct}]
+		items[i]["inTransitInTons"] = inbound[stockKey{wid: wid, ct: ct}]
+		items[i]["inTransitOutTons"] = outbound[stockKey{wid: wid, ct: ct}]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleOpsInventoryAdjust(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		WarehouseID int64   `json:"warehouseId"`
+		CementType  string  `json:"cementType"`
+		DeltaTons   float64 `json:"deltaTons"`
+		Reason      string  `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if body.WarehouseID <= 0 || strings.TrimSpace(body.CementType) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "warehouseId and cementType required")
+		return
+	}
+	if body.DeltaTons == 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "deltaTons must be non-zero")
+		return
+	}
+	if math.Abs(body.DeltaTons) > 500 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "deltaTons too large")
+		return
+	}
+	if !scopeFrom(r).allowsWarehouse(body.WarehouseID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "warehouse is outside your scope")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	// Upsert stock row.
+	_, _ = tx.Exec(r.Context(), `
+    INSERT INTO stock_levels (warehouse_id, cement_type, quantity_tons)
+    VALUES ($1,$2,0)
+    ON CONFLICT (warehouse_id, cement_type) DO NOTHING
+  `, body.WarehouseID, body.CementType)
+
+	var current float64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT quantity_tons FROM stock_levels
+    WHERE warehouse_id=$1 AND cement_type=$2
+    FOR UPDATE
+  `, body.WarehouseID, body.CementType).Scan(&current); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	newQty := current + body.DeltaTons
+	if newQty < 0 {
+		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "resulting stock would be negative")
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+    UPDATE stock_levels SET quantity_tons=$1, updated_at=now()
+    WHERE warehouse_id=$2 AND cement_type=$3
+  `, newQty, body.WarehouseID, body.CementType); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	// Movement + audit.
+	if _, err := tx.Exec(r.Context(), `
+    INSERT INTO inventory_movements (actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata)
+    VALUES ($1,$2,$3,'ADJUST',$4,$5,'stock_levels','', '{}'::jsonb)
+  `, u.ID, body.WarehouseID, body.CementType, body.DeltaTons, body.Reason); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "STOCK_ADJUSTMENT", "stock_levels", fmt.Sprintf("%d:%s", body.WarehouseID, body.CementType), map[string]any{"deltaTons": body.DeltaTons, "reason": body.Reason}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "newQuantityTons": newQty})
+}
+
+func (a *App) handleOpsOrders(w http.ResponseWriter, r *http.Request) {
+	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
+	where := "WHERE ($1::bigint[] IS NULL OR o.distributor_id = ANY($1))"
+	args := []any{scopeFrom(r).DistributorIDs}
+	if status != "" {
+		where += " AND o.status=$2"
+		args = append(args, status)
+	}
+	q := fmt.Sprintf(`
+    SELECT o.id, o.distributor_id, d.name, o.cement_type, o.quantity_tons, o.status, o.requested_at,
+           o.decided_at, o.decided_by_user_id, o.decision_reason, o.approved_shipment_id
+    FROM order_requests o
+    JOIN distributors d ON d.id = o.distributor_id
+    %s
+    ORDER BY o.requested_at DESC, o.id DESC
+    LIMIT 200
+  `, where)
+	rows, err := a.db.Query(r.Context(), q, args...)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id, did int64
+		var dname, ct, st, reason string
+		var qty float64
+		var requested time.Time
+		var decided *time.Time
+		var decidedBy *int64
+		var approvedShipment *int64
+		_ = rows.Scan(&id, &did, &dname, &ct, &qty, &st, &requested, &decided, &decidedBy, &reason, &approvedShipment)
+		items = append(items, map[string]any{
+			"id":                 id,
+			"status":             st,
+			"requestedAt":        requested,
+			"decidedAt":          decided,
+			"decidedBy":          decidedBy,
+			"decisionReason":     reason,
+			"approvedShipmentId": approvedShipment,
+			"cementType":         ct,
+			"quantityTons":       qty,
+			"distributor":        map[string]any{"id": did, "name": dname},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleOpsApproveOrder(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	idStr := chi.URLParam(r, "id")
+	orderID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		FromWarehouseID *int64     `json:"fromWarehouseId"`
+		TruckID         *int64     `json:"truckId"`
+		DepartAt        *time.Time `json:"departAt"`
+		Reason          string     `json:"reason"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	// Lock order request.
+	var distributorID int64
+	var cementType string
+	var qty float64
+	var status string
+	if err := tx.QueryRow(r.Context(), `
+    SELECT distributor_id, cement_type, quantity_tons, status
+    FROM order_requests
+    WHERE id=$1
+    FOR UPDATE
+  `, orderID).Scan(&distributorID, &cementType, &qty, &status); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "order not found")
+		return
+	}
+	sc := scopeFrom(r)
+	if !sc.allowsDistributor(distributorID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "distributor is outside your scope")
+		return
+	}
+	if status != "PENDING" {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "order is not pending")
+		return
+	}
+
+	fromWarehouseID := int64(0)
+	if body.FromWarehouseID != nil {
+		fromWarehouseID = *body.FromWarehouseID
+		if !sc.allowsWarehouse(fromWarehouseID) {
+			writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "warehouse is outside your scope")
+			return
+		}
+	}
+	if fromWarehouseID == 0 {
+		// Pick the in-scope warehouse with highest stock.
+		_ = tx.QueryRow(r.Context(), `
+      SELECT warehouse_id
+      FROM stock_levels s
+      JOIN warehouses w ON w.id = s.warehouse_id
+      WHERE s.cement_type=$1 AND w.archived_at IS NULL AND ($2::bigint[] IS NULL OR s.warehouse_id = ANY($2))
+      ORDER BY s.quantity_tons DESC
+      LIMIT 1
+    `, cementType, sc.WarehouseIDs).Scan(&fromWarehouseID)
+		if fromWarehouseID == 0 {
+			writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "no warehouse stock for cement type")
+			return
+		}
+	}
+
+	// Check stock availability.
+	var available float64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT quantity_tons
+    FROM stock_levels
+    WHERE warehouse_id=$1 AND cement_type=$2
+    FOR UPDATE
+  `, fromWarehouseID, cementType).Scan(&available); err != nil {
+		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "stock row not found")
+		return
+	}
+	if available < qty {
+		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "insufficient stock")
+		return
+	}
+
+	// Compute ETA based on dummy distance.
+	var wlat, wlng, dlat, dlng float64
+	if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM warehouses WHERE id=$1 AND archived_at IS NULL FOR SHARE`, fromWarehouseID).Scan(&wlat, &wlng); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid warehouse")
+		return
+	}
+	if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM distributors WHERE id=$1 AND archived_at IS NULL FOR SHARE`, distributorID).Scan(&dlat, &dlng); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid distributor")
+		return
+	}
+
+	departAt := time.Now().UTC().Add(45 * time.Minute)
+	if body.DepartAt != nil {
+		departAt = body.DepartAt.UTC()
+	}
+	travelMin := estimateTravelMinutes(wlat, wlng, dlat, dlng)
+	eta := departAt.Add(time.Duration(travelMin) * time.Minute)
+
+	var shipmentID int64
+	etaMinutes := int(math.Max(0, eta.Sub(time.Now().UTC()).Minutes()))
+	truckID := body.TruckID
+	if err := tx.QueryRow(r.Context(), `
+    INSERT INTO shipments (from_warehouse_id, to_distributor_id, status, cement_type, quantity_tons, truck_id, depart_at, arrive_eta, eta_minutes)
+    VALUES ($1,$2,'SCHEDULED',$3,$4,$5,$6,$7,$8)
+    RETURNING id
+  `, fromWarehouseID, distributorID, cementType, qty, truckID, departAt, eta, etaMinutes).Scan(&shipmentID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	// Reserve stock (decrement now) + movement.
+	if _, err := tx.Exec(r.Context(), `
+    UPDATE stock_levels SET quantity_tons = quantity_tons - $1, updated_at=now()
+    WHERE warehouse_id=$2 AND cement_type=$3
+  `, qty, fromWarehouseID, cementType); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	_, _ = tx.Exec(r.Context(), `
+    INSERT INTO inventory_movements (actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata)
+    VALUES ($1,$2,$3,'OUT',$4,'Order approved','shipment',$5, '{}'::jsonb)
+  `, u.ID, fromWarehouseID, cementType, qty, fmt.Sprintf("%d", shipmentID))
+
+	// Update order request.
+	if _, err := tx.Exec(r.Context(), `
+    UPDATE order_requests
+    SET status='APPROVED', decided_at=now(), decided_by_user_id=$1, decision_reason=$2, approved_shipment_id=$3, updated_at=now()
+    WHERE id=$4
+  `, u.ID, body.Reason, shipmentID, orderID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	_, _ = tx.Exec(r.Context(), `UPDATE shipments SET order_request_id=$1 WHERE id=$2`, orderID, shipmentID)
+
+	if err := notify.ToDistributor(r.Context(), tx, distributorID, notify.Notification{
+		Kind:       notify.KindOrderApproved,
+		Title:      fmt.Sprintf("Order #%d approved", orderID),
+		Body:       fmt.Sprintf("%.1f tons of %s scheduled for delivery, ETA %s.", qty, cementType, eta.Format(time.RFC3339)),
+		EntityType: "order_request",
+		EntityID:   fmt.Sprintf("%d", orderID),
+		Metadata:   map[string]any{"shipmentId": shipmentID, "reason": body.Reason},
+	}); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := mailer.EnqueueToDistributor(r.Context(), tx, distributorID, mailer.TemplateOrderDecision, map[string]any{
+		"OrderID":      orderID,
+		"Decision":     "APPROVED",
+		"CementType":   cementType,
+		"QuantityTons": fmt.Sprintf("%.1f", qty),
+		"ShipmentID":   shipmentID,
+		"ETA":          eta.Format(time.RFC1123),
+		"Reason":       body.Reason,
+	}); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "ORDER_APPROVED", "order_request", fmt.Sprintf("%d", orderID), map[string]any{"shipmentId": shipmentID, "warehouseId": fromWarehouseID, "cementType": cementType, "quantityTons": qty}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "shipmentId": shipmentID})
+}
+
+func (a *App) handleOpsRejectOrder(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	idStr := chi.URLParam(r, "id")
+	orderID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var distributorID int64
+	var cementType string
+	var qty float64
+	if err := tx.QueryRow(r.Context(), `
+    UPDATE order_requests
+    SET status='REJECTED', decided_at=now(), decided_by_user_id=$1, decision_reason=$2, updated_at=now()
+    WHERE id=$3 AND status='PENDING'
+    RETURNING distributor_id, cement_type, quantity_tons
+  `, u.ID, body.Reason, orderID).Scan(&distributorID, &cementType, &qty); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusConflict, "INVALID_STATE", "order is not pending")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if !scopeFrom(r).allowsDistributor(distributorID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "distributor is outside your scope")
+		return
+	}
+
+	msg := fmt.Sprintf("Your request for %.1f tons of %s was rejected.", qty, cementType)
+	if strings.TrimSpace(body.Reason) != "" {
+		msg += " Reason: " + strings.TrimSpace(body.Reason)
+	}
+	if err := notify.ToDistributor(r.Context(), tx, distributorID, notify.Notification{
+		Kind:       notify.KindOrderRejected,
+		Title:      fmt.Sprintf("Order #%d rejected", orderID),
+		Body:       msg,
+		EntityType: "order_request",
+		EntityID:   fmt.Sprintf("%d", orderID),
+		Metadata:   map[string]any{"reason": body.Reason},
+	}); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := mailer.EnqueueToDistributor(r.Context(), tx, distributorID, mailer.TemplateOrderDecision, map[string]any{
+		"OrderID":      orderID,
+		"Decision":     "REJECTED",
+		"CementType":   cementType,
+		"QuantityTons": fmt.Sprintf("%.1f", qty),
+		"Reason":       strings.TrimSpace(body.Reason),
+	}); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "ORDER_REJECTED", "order_request", fmt.Sprintf("%d", orderID), map[string]any{"reason": body.Reason}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// auditScopeFilter limits audit_logs rows (alias l) to entities inside the
+// scope bound to $1 (warehouse ids) and $2 (distributor ids). Unscoped users see
+// everything; scoped users only see entries for orders, shipments, issues and
+// stock they could load themselves.
+const auditScopeFilter = `(
+      ($1::bigint[] IS NULL AND $2::bigint[] IS NULL)
+      OR CASE l.entity_type
+        WHEN 'order_request' THEN EXISTS (
+          SELECT 1 FROM order_requests o
+          WHERE o.id::text = l.entity_id AND ($2::bigint[] IS NULL OR o.distributor_id = ANY($2)))
+        WHEN 'shipment' THEN EXISTS (
+          SELECT 1 FROM shipments s
+          WHERE s.id::text = l.entity_id
+            AND ($1::bigint[] IS NULL OR s.from_warehouse_id = ANY($1))
+            AND ($2::bigint[] IS NULL OR s.to_distributor_id = ANY($2)))
+        WHEN 'issue' THEN EXISTS (
+          SELECT 1 FROM ops_issues i
+          WHERE i.id::text = l.entity_id
+            AND (i.warehouse_id IS NULL OR $1::bigint[] IS NULL OR i.warehouse_id = ANY($1))
+            AND (i.distributor_id IS NULL OR $2::bigint[] IS NULL OR i.distributor_id = ANY($2)))
+        WHEN 'stock_levels' THEN
+          $1::bigint[] IS NULL OR split_part(l.entity_id, ':', 1) = ANY($1::bigint[]::text[])
+        ELSE false
+      END
+    )`
+
+func (a *App) handleOpsOrderAudit(w http.ResponseWriter, r *http.Request) {
+	sc := scopeFrom(r)
+	rows, err := a.db.Query(r.Context(), `
+    SELECT l.id, l.ts, l.actor_user_id, u.name, l.action, l.entity_id, l.metadata
+    FROM audit_logs l
+    LEFT JOIN users u ON u.id = l.actor_user_id
+    WHERE l.entity_type='order_request' AND `+auditScopeFilter+`
+    ORDER BY l.ts DESC
+    LIMIT 200
+  `, sc.WarehouseIDs, sc.DistributorIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var ts time.Time
+		var actorID *int64
+		var actorName *string
+		var action, entityID string
+		var meta json.RawMessage
+		_ = rows.Scan(&id, &ts, &actorID, &actorName, &action, &entityID, &meta)
+		items = append(items, map[string]any{
+			"id":             id,
+			"ts":             ts,
+			"actorUserId":    actorID,
+			"actorName":      actorName,
+			"action":         action,
+			"orderRequestId": entityID,
+			"metadata":       meta,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleOpsActivityLog(w http.ResponseWriter, r *http.Request) {
+	sc := scopeFrom(r)
+	rows, err := a.db.Query(r.Context(), `
+    SELECT l.id, l.ts, l.actor_user_id, u.name, l.action, l.entity_type, l.entity_id, l.metadata
+    FROM audit_logs l
+    LEFT JOIN users u ON u.id = l.actor_user_id
+    WHERE `+auditScopeFilter+`
+    ORDER BY l.ts DESC
+    LIMIT 300
+  `, sc.WarehouseIDs, sc.DistributorIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var ts time.Time
+		var actorID *int64
+		var actorName *string
+		var action, et, eid string
+		var meta json.RawMessage
+		_ = rows.Scan(&id, &ts, &actorID, &actorName, &action, &et, &eid, &meta)
+		items = append(items, map[string]any{
+			"id":          id,
+			"ts":          ts,
+			"actorUserId": actorID,
+			"actorName":   actorName,
+			"action":      action,
+			"entityType":  et,
+			"entityId":    eid,
+			"metadata":    meta,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleOpsUpdateShipment(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	idStr := chi.URLParam(r, "id")
+	shipmentID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		FromWarehouseID *int64     `json:"fromWarehouseId"`
+		ToDistributorID *int64     `json:"toDistributorId"`
+		TruckID         *int64     `json:"truckId"`
+		DepartAt        *time.Time `json:"departAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	// Read current shipment and coords.
+	var fromID, toID int64
+	var status string
+	var wlat, wlng, dlat, dlng float64
+	var depart *time.Time
+	var eta *time.Time
+	var truckID *int64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT s.from_warehouse_id, s.to_distributor_id, s.status, s.truck_id, s.depart_at, s.arrive_eta,
+           w.lat, w.lng, d.lat, d.lng
+    FROM shipments s
+    JOIN warehouses w ON w.id = s.from_warehouse_id
+    JOIN distributors d ON d.id = s.to_distributor_id
+    WHERE s.id=$1
+    FOR UPDATE
+	`, shipmentID).Scan(&fromID, &toID, &status, &truckID, &depart, &eta, &wlat, &wlng, &dlat, &dlng); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
+		return
+	}
+	sc := scopeFrom(r)
+	if !sc.allowsWarehouse(fromID) || !sc.allowsDistributor(toID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "shipment is outside your scope")
+		return
+	}
+	if (body.FromWarehouseID != nil && !sc.allowsWarehouse(*body.FromWarehouseID)) ||
+		(body.ToDistributorID != nil && !sc.allowsDistributor(*body.ToDistributorID)) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "target is outside your scope")
+		return
+	}
+	if body.TruckID != nil {
+		truckID = body.TruckID
+	}
+	if body.FromWarehouseID != nil {
+		fromID = *body.FromWarehouseID
+		if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM warehouses WHERE id=$1 AND archived_at IS NULL FOR SHARE`, fromID).Scan(&wlat, &wlng); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid warehouse")
+			return
+		}
+	}
+	if body.ToDistributorID != nil {
+		toID = *body.ToDistributorID
+		if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM distributors WHERE id=$1 AND archived_at IS NULL FOR SHARE`, toID).Scan(&dlat, &dlng); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid distributor")
+			return
+		}
+	}
+	if body.DepartAt != nil {
+		d := body.DepartAt.UTC()
+		depart = &d
+	}
+	if depart != nil && (status == "SCHEDULED" || status == "ON_DELIVERY" || status == "DELAYED") {
+		travelMin := estimateTravelMinutes(wlat, wlng, dlat, dlng)
+		e := depart.UTC().Add(time.Duration(travelMin) * time.Minute)
+		eta = &e
+	}
+	etaMinutes := 0
+	if eta != nil {
+		etaMinutes = int(math.Max(0, eta.Sub(time.Now().UTC()).Minutes()))
+	}
+
+	if _, err := tx.Exec(r.Context(), `
+    UPDATE shipments
+    SET from_warehouse_id=$1, to_distributor_id=$2, truck_id=$3, depart_at=$4, arrive_eta=$5, eta_minutes=$6, updated_at=now()
+    WHERE id=$7
+	`, fromID, toID, truckID, depart, eta, etaMinutes, shipmentID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "SHIPMENT_UPDATED", "shipment", fmt.Sprintf("%d", shipmentID), map[string]any{"fromWarehouseId": fromID, "toDistributorId": toID, "truckId": truckID}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) handleOpsPredictionReorder(w http.ResponseWriter, r *http.Request) {
+	// Rule-based reorder recommendation:
+	// uses current stock, threshold settings, lead time, and nearby project demand intensity.
+	// Radius is a simple fixed value (50km) for demo purposes.
+	const radiusKm = 50.0
+
+	// Load projects for intensity signal.
+	prows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, demand_tons_month FROM projects WHERE archived_at IS NULL`)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	type proj struct {
+		id     int64
+		lat    float64
+		lng    float64
+		demand float64
+	}
+	projects := []proj{}
+	for prows.Next() {
+		var p proj
+		var name string
+		_ = prows.Scan(&p.id, &name, &p.lat, &p.lng, &p.demand)
+		projects = append(projects, p)
+	}
+	prows.Close()
+
+	// Transfers and plant dispatches already on their way count toward the
+	// target; what the plants still hold bounds what can be sent.
+	inbound, _, err := a.stockInTransit(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	plantAvailable := map[string]float64{}
+	arows, err := a.db.Query(r.Context(), `
+    SELECT ps.cement_type, SUM(ps.available_tons)
+    FROM plant_stock ps
+    JOIN plants p ON p.id = ps.plant_id
+    WHERE p.archived_at IS NULL
+    GROUP BY ps.cement_type
+  `)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	for arows.Next() {
+		var ct string
+		var qty float64
+		_ = arows.Scan(&ct, &qty)
+		plantAvailable[ct] = qty
+	}
+	arows.Close()
+
+	rows, err := a.db.Query(r.Context(), `
+    SELECT w.id, w.name, w.lat, w.lng,
+           s.cement_type, s.quantity_tons,
+           t.min_stock, t.safety_stock, t.warning_level, t.critical_level, t.lead_time_days
+    FROM stock_levels s
+    JOIN warehouses w ON w.id = s.warehouse_id
+    JOIN threshold_settings t ON t.warehouse_id=s.warehouse_id AND t.cement_type=s.cement_type
+    WHERE w.archived_at IS NULL AND ($1::bigint[] IS NULL OR w.id = ANY($1))
+    ORDER BY w.id, s.cement_type
+  `, scopeFrom(r).WarehouseIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	out := []map[string]any{}
+	for rows.Next() {
+		var wid int64
+		var wname string
+		var wlat, wlng float64
+		var ct string
+		var qty float64
+		var min, safety, warn, critical float64
+		var lead int
+		_ = rows.Scan(&wid, &wname, &wlat, &wlng, &ct, &qty, &min, &safety, &warn, &critical, &lead)
+
+		intensity := 0.0
+		for _, p := range projects {
+			if haversineKm(wlat, wlng, p.lat, p.lng) <= radiusKm {
+				intensity += p.demand
+			}
+		}
+		// Rough demand during lead time window.
+		demandLead := intensity * (float64(lead) / 30.0)
+		target := safety + demandLead
+		inTransit := inbound[stockKey{wid: wid, ct: ct}]
+		recommended := math.Max(0, target-qty-inTransit)
+
+		recoStatus := "OK"
+		urgency := "LOW"
+		if recommended > 0 {
+			recoStatus = "REORDER"
+		}
+		if qty <= critical || recommended >= 250 {
+			urgency = "HIGH"
+		} else if qty <= warn || recommended >= 120 {
+			urgency = "MED"
+		}
+
+		out = append(out, map[string]any{
+			"warehouseId":                        wid,
+			"warehouseName":                      wname,
+			"cementType":                         ct,
+			"quantityTons":                       qty,
+			"inTransitTons":                      inTransit,
+			"plantAvailableTons":                 plantAvailable[ct],
+			"leadTimeDays":                       lead,
+			"nearbyProjectIntensityTonsPerMonth": intensity,
+			"targetStockTons":                    target,
+			"recommendedQuantityTons":            recommended,
+			"status":                             recoStatus,
+			"urgency":                            urgency,
+			"radiusKm":                           radiusKm,
+			"thresholds": map[string]any{
+				"minStock":      min,
+				"safetyStock":   safety,
+				"warningLevel":  warn,
+				"criticalLevel": critical,
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+func (a *App) handleOpsShipments(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if pageSize > 50 {
+		pageSize = 50
+	}
+	offset := (page - 1) * pageSize
+	sc := scopeFrom(r)
+
+	rows, err := a.db.Query(r.Context(), `
+		SELECT s.id, s.status, s.cement_type, s.quantity_tons,
+					 s.depart_at, s.arrive_eta, s.eta_minutes, s.last_lat, s.last_lng, s.last_update,
+					 w.id, w.name,
+					 d.id, d.name,
+					 t.id, t.code, t.name
+		FROM shipments s
+		JOIN warehouses w ON w.id = s.from_warehouse_id
+		JOIN distributors d ON d.id = s.to_distributor_id
+		LEFT JOIN trucks t ON t.id = s.truck_id
+		WHERE ($3::bigint[] IS NULL OR s.from_warehouse_id = ANY($3))
+		  AND ($4::bigint[] IS NULL OR s.to_distributor_id = ANY($4))
+		ORDER BY s.id DESC
+		LIMIT $1 OFFSET $2
+	`, pageSize, offset, sc.WarehouseIDs, sc.DistributorIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var status string
+		var cementType string
+		var qtyTons float64
+		var depart, eta *time.Time
+		var etaMinutes int
+		var lastLat, lastLng *float64
+		var lastUpdate *time.Time
+		var wid, did int64
+		var wname, dname string
+		var truckID *int64
+		var truckCode, truckName *string
+		_ = rows.Scan(&id, &status, &cementType, &qtyTons, &depart, &eta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname, &did, &dname, &truckID, &truckCode, &truckName)
+		truck := map[string]any{"id": nil, "code": nil, "name": nil}
+		if truckID != nil {
+			truck["id"] = *truckID
+			if truckCode != nil {
+				truck["code"] = *truckCode
+			}
+			if truckName != nil {
+				truck["name"] = *truckName
+			}
+		}
+		items = append(items, map[string]any{
+			"id":            id,
+			"status":        status,
+			"cementType":    cementType,
+			"quantityTons":  qtyTons,
+			"departAt":      depart,
+			"arriveEta":     eta,
+			"etaMinutes":    etaMinutes,
+			"lastLat":       lastLat,
+			"lastLng":       lastLng,
+			"lastUpdate":    lastUpdate,
+			"truck":         truck,
+			"fromWarehouse": map[string]any{"id": wid, "name": wname},
+			"toDistributor": map[string]any{"id": did, "name": dname},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "page": page, "pageSize": pageSize})
+}
+
+func (a *App) handleOpsShipmentDetail(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+
+	// Load shipment + endpoints.
+	row := a.db.QueryRow(r.Context(), `
+    SELECT s.id, s.status, s.cement_type, s.quantity_tons,
+           s.depart_at, s.arrive_eta, s.eta_minutes, s.last_lat, s.last_lng, s.last_update,
+           w.id, w.name, w.lat, w.lng,
+           d.id, d.name, d.lat, d.lng,
+           t.id, t.code, t.name
+    FROM shipments s
+    JOIN warehouses w ON w.id = s.from_warehouse_id
+    JOIN distributors d ON d.id = s.to_distributor_id
+    LEFT JOIN trucks t ON t.id = s.truck_id
+    WHERE s.id = $1
+  `, id)
+	var status string
+	var cementType string
+	var qtyTons float64
+	var depart, eta *time.Time
+	var etaMinutes int
+	var lastLat, lastLng *float64
+	var lastUpdate *time.Time
+	var wid, did int64
+	var wname, dname string
+	var wlat, wlng, dlat, dlng float64
+	var truckID *int64
+	var truckCode, truckName *string
+	if err := row.Scan(&id, &status, &cementType, &qtyTons, &depart, &eta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname, &wlat, &wlng, &did, &dname, &dlat, &dlng, &truckID, &truckCode, &truckName); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
+		return
+	}
+	if sc := scopeFrom(r); !sc.allowsWarehouse(wid) || !sc.allowsDistributor(did) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "shipment is outside your scope")
+		return
+	}
+	truck := map[string]any{"id": nil, "code": nil, "name": nil}
+	if truckID != nil {
+		truck["id"] = *truckID
+		if truckCode != nil {
+			truck["code"] = *truckCode
+		}
+		if truckName != nil {
+			truck["name"] = *truckName
+		}
+	}
+
+	// Update truck position for in-transit shipments.
+	if status == "ON_DELIVERY" && depart != nil && eta != nil {
+		now := time.Now().UTC()
+		frac := float64(now.Sub(depart.UTC())) / float64(eta.UTC().Sub(depart.UTC()))
+		if frac < 0 {
+			frac = 0
+		}
+		if frac > 1 {
+			frac = 1
+		}
+		ll := wlat + (dlat-wlat)*frac
+		lg := wlng + (dlng-wlng)*frac
+		lastLat, lastLng = &ll, &lg
+		u := now
+		lastUpdate = &u
+		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
+		_, _ = a.db.Exec(r.Context(), `UPDATE shipments SET last_lat=$1, last_lng=$2, last_update=$3, eta_minutes=$4 WHERE id=$5`, ll, lg, u, etaMinutes, id)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":            id,
+		"status":        status,
+		"cementType":    cementType,
+		"quantityTons":  qtyTons,
+		"departAt":      depart,
+		"arriveEta":     eta,
+		"etaMinutes":    etaMinutes,
+		"truck":         map[string]any{"id": truck["id"], "code": truck["code"], "name": truck["name"], "lastLat": lastLat, "lastLng": lastLng, "lastUpdate": lastUpdate},
+		"fromWarehouse": map[string]any{"id": wid, "name": wname, "lat": wlat, "lng": wlng},
+		"toDistributor": map[string]any{"id": did, "name": dname, "lat": dlat, "lng": dlng},
+	})
+}
+
+// ---------- ops: issues ----------
+
+func (a *App) handleOpsIssues(w http.ResponseWriter, r *http.Request) {
+	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
+	issueType := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("type")))
+	severity := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("severity")))
+
+	sc := scopeFrom(r)
+	whereParts := []string{
+		"(i.warehouse_id IS NULL OR $1::bigint[] IS NULL OR i.warehouse_id = ANY($1))",
+		"(i.distributor_id IS NULL OR $2::bigint[] IS NULL OR i.distributor_id = ANY($2))",
+	}
+	args := []any{sc.WarehouseIDs, sc.DistributorIDs}
+	idx := 3
+	if status != "" && status != "ALL" {
+		whereParts = append(whereParts, fmt.Sprintf("i.status=$%d", idx))
+		args = append(args, status)
+		idx++
+	}
+	if issueType != "" && issueType != "ALL" {
+		whereParts = append(whereParts, fmt.Sprintf("i.issue_type=$%d", idx))
+		args = append(args, issueType)
+		idx++
+	}
+	if severity != "" && severity != "ALL" {
+		whereParts = append(whereParts, fmt.Sprintf("i.severity=$%d", idx))
+		args = append(args, severity)
+		idx++
+	}
+
+	where := "WHERE " + strings.Join(whereParts, " AND ")
+
+	q := fmt.Sprintf(`
+    SELECT i.id, i.issue_type, i.severity, i.status,
+           i.title, i.description,
+           i.shipment_id,
+           i.warehouse_id, w.name,
+           i.distributor_id, d.name,
+           i.reported_by_user_id, ru.name,
+           i.reported_at,
+           i.resolved_by_user_id, su.name,
+	    i.resolved_at, i.resolution_notes,
+	    i.metadata,
+           i.created_at, i.updated_at
+    FROM ops_issues i
     LEFT JOIN warehouses w ON w.id = i.warehouse_id
     LEFT JOIN distributors d ON d.id = i.distributor_id
     LEFT JOIN users ru ON ru.id = i.reported_by_user_id
     LEFT JOIN users su ON su.id = i.resolved_by_user_id
     %s
-    ORDER BY i.created_at DESC, i.id DESC
-    LIMIT 200
-  `, where)
+    ORDER BY i.created_at DESC, i.id DESC
+    LIMIT 200
+  `, where)
+
+	rows, err := a.db.Query(r.Context(), q, args...)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var it, sev, st string
+		var title, desc string
+		var shipmentID *int64
+		var warehouseID *int64
+		var warehouseName *string
+		var distributorID *int64
+		var distributorName *string
+		var reportedByID *int64
+		var reportedByName *string
+		var reportedAt time.Time
+		var resolvedByID *int64
+		var resolvedByName *string
+		var resolvedAt *time.Time
+		var resolutionNotes string
+		var metadata json.RawMessage
+		var createdAt, updatedAt time.Time
+
+		_ = rows.Scan(
+			&id, &it, &sev, &st,
+			&title, &desc,
+			&shipmentID,
+			&warehouseID, &warehouseName,
+			&distributorID, &distributorName,
+			&reportedByID, &reportedByName,
+			&reportedAt,
+			&resolvedByID, &resolvedByName,
+			&resolvedAt, &resolutionNotes,
+			&metadata,
+			&createdAt, &updatedAt,
+		)
+
+		wh := map[string]any{"id": nil, "name": nil}
+		if warehouseID != nil {
+			wh["id"] = *warehouseID
+			if warehouseName != nil {
+				wh["name"] = *warehouseName
+			}
+		}
+		di := map[string]any{"id": nil, "name": nil}
+		if distributorID != nil {
+			di["id"] = *distributorID
+			if distributorName != nil {
+				di["name"] = *distributorName
+			}
+		}
+		reportedBy := map[string]any{"id": nil, "name": nil}
+		if reportedByID != nil {
+			reportedBy["id"] = *reportedByID
+			if reportedByName != nil {
+				reportedBy["name"] = *reportedByName
+			}
+		}
+		resolvedBy := map[string]any{"id": nil, "name": nil}
+		if resolvedByID != nil {
+			resolvedBy["id"] = *resolvedByID
+			if resolvedByName != nil {
+				resolvedBy["name"] = *resolvedByName
+			}
+		}
+
+		items = append(items, map[string]any{
+			"id":              id,
+			"issueType":       it,
+			"severity":        sev,
+			"status":          st,
+			"title":           title,
+			"description":     desc,
+			"shipmentId":      shipmentID,
+			"warehouse":       wh,
+			"distributor":     di,
+			"reportedBy":      reportedBy,
+			"reportedAt":      reportedAt,
+			"resolvedBy":      resolvedBy,
+			"resolvedAt":      resolvedAt,
+			"resolutionNotes": resolutionNotes,
+			"metadata":        metadata,
+			"createdAt":       createdAt,
+			"updatedAt":       updatedAt,
+		})
+	}
+	if err := a.attachIssueAttachments(r, items); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleOpsCreateIssue(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		IssueType     string         `json:"issueType"`
+		Severity      string         `json:"severity"`
+		Title         string         `json:"title"`
+		Description   string         `json:"description"`
+		ShipmentID    *int64         `json:"shipmentId"`
+		WarehouseID   *int64         `json:"warehouseId"`
+		DistributorID *int64         `json:"distributorId"`
+		Metadata      map[string]any `json:"metadata"`
+		AttachmentIDs []int64        `json:"attachmentIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	issueType := strings.TrimSpace(strings.ToUpper(body.IssueType))
+	severity := strings.TrimSpace(strings.ToUpper(body.Severity))
+	title := strings.TrimSpace(body.Title)
+	desc := strings.TrimSpace(body.Description)
+
+	allowedType := map[string]bool{"DELAY": true, "STOCK_SHORTAGE": true, "FLEET": true, "DAMAGED": true, "OTHER": true}
+	if !allowedType[issueType] {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "issueType must be DELAY|STOCK_SHORTAGE|FLEET|DAMAGED|OTHER")
+		return
+	}
+	if severity == "" {
+		severity = "MED"
+	}
+	allowedSev := map[string]bool{"LOW": true, "MED": true, "HIGH": true}
+	if !allowedSev[severity] {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "severity must be LOW|MED|HIGH")
+		return
+	}
+	if title == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "title required")
+		return
+	}
+	if body.ShipmentID != nil && *body.ShipmentID <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "shipmentId must be positive")
+		return
+	}
+	if body.WarehouseID != nil && *body.WarehouseID <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "warehouseId must be positive")
+		return
+	}
+	if body.DistributorID != nil && *body.DistributorID <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "distributorId must be positive")
+		return
+	}
+	sc := scopeFrom(r)
+	if (body.WarehouseID != nil && !sc.allowsWarehouse(*body.WarehouseID)) ||
+		(body.DistributorID != nil && !sc.allowsDistributor(*body.DistributorID)) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "issue target is outside your scope")
+		return
+	}
+	if body.ShipmentID != nil {
+		var fromID, toID int64
+		if err := a.db.QueryRow(r.Context(), `SELECT from_warehouse_id, to_distributor_id FROM shipments WHERE id=$1`, *body.ShipmentID).Scan(&fromID, &toID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "shipment not found")
+				return
+			}
+			writeDBError(w, err)
+			return
+		}
+		if !sc.allowsWarehouse(fromID) || !sc.allowsDistributor(toID) {
+			writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "shipment is outside your scope")
+			return
+		}
+	}
+	if body.Metadata == nil {
+		body.Metadata = map[string]any{}
+	}
+	metaBytes, _ := json.Marshal(body.Metadata)
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var id int64
+	if err := tx.QueryRow(r.Context(), `
+    INSERT INTO ops_issues (
+      issue_type, severity, status,
+      title, description,
+      shipment_id, warehouse_id, distributor_id,
+      reported_by_user_id, reported_at,
+      resolution_notes,
+      metadata,
+      created_at, updated_at
+    )
+    VALUES ($1,$2,'OPEN',$3,$4,$5,$6,$7,$8,now(),'',$9,now(),now())
+    RETURNING id
+  `, issueType, severity, title, desc, body.ShipmentID, body.WarehouseID, body.DistributorID, u.ID, metaBytes).Scan(&id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := a.linkAttachmentsTx(r.Context(), tx, u.ID, body.AttachmentIDs, id, body.WarehouseID, body.DistributorID); err != nil {
+		writeAttachmentLinkError(w, err)
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "ISSUE_CREATED", "issue", fmt.Sprintf("%d", id), map[string]any{
+		"issueType":     issueType,
+		"severity":      severity,
+		"shipmentId":    body.ShipmentID,
+		"warehouseId":   body.WarehouseID,
+		"distributorId": body.DistributorID,
+		"attachmentIds": body.AttachmentIDs,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "id": id})
+}
+
+func (a *App) handleOpsResolveIssue(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		ResolutionNotes string `json:"resolutionNotes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	notes := strings.TrimSpace(body.ResolutionNotes)
+	if notes == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "resolutionNotes required")
+		return
+	}
+
+	var warehouseID, distributorID *int64
+	if err := a.db.QueryRow(r.Context(), `SELECT warehouse_id, distributor_id FROM ops_issues WHERE id=$1`, id).Scan(&warehouseID, &distributorID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "issue not found")
+			return
+		}
+		writeDBError(w, err)
+		return
+	}
+	sc := scopeFrom(r)
+	if (warehouseID != nil && !sc.allowsWarehouse(*warehouseID)) || (distributorID != nil && !sc.allowsDistributor(*distributorID)) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "issue is outside your scope")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	ct, err := tx.Exec(r.Context(), `
+    UPDATE ops_issues
+    SET status='RESOLVED', resolved_by_user_id=$1, resolved_at=now(), resolution_notes=$2, updated_at=now()
+    WHERE id=$3 AND status='OPEN'
+  `, u.ID, notes, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if ct.RowsAffected() == 0 {
+		var exists bool
+		_ = a.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM ops_issues WHERE id=$1)`, id).Scan(&exists)
+		if !exists {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "issue not found")
+			return
+		}
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "issue already resolved")
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "ISSUE_RESOLVED", "issue", fmt.Sprintf("%d", id), map[string]any{"resolutionNotes": notes}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ---------- executive ----------
+
+func (a *App) handleExecTargetVsActual(w http.ResponseWriter, r *http.Request) {
+	monthStr := strings.TrimSpace(r.URL.Query().Get("month"))
+	if monthStr == "" {
+		monthStr = time.Now().Format("2006-01")
+	}
+	t, err := time.Parse("2006-01", monthStr)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "month must be YYYY-MM")
+		return
+	}
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	daysInMonth := int(end.Sub(start).Hours() / 24)
+
+	var target float64
+	_ = a.db.QueryRow(r.Context(), `SELECT target_tons FROM sales_targets WHERE month = $1`, start.Format("2006-01-02")).Scan(&target)
+	if target == 0 {
+		target = 15000
+	}
+
+	// Actual per day. The target is company-wide, so scoped users compare their
+	// territory's sales against it; the response flags this.
+	sc := scopeFrom(r)
+	rows, err := a.db.Query(r.Context(), `
+    SELECT order_date, SUM(quantity_tons)
+    FROM sales_orders
+    WHERE order_date >= $1 AND order_date < $2
+      AND ($3::bigint[] IS NULL OR distributor_id = ANY($3))
+    GROUP BY order_date
+    ORDER BY order_date
+  `, start.Format("2006-01-02"), end.Format("2006-01-02"), sc.DistributorIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	actualByDay := map[string]float64{}
+	for rows.Next() {
+		var d time.Time
+		var qty float64
+		_ = rows.Scan(&d, &qty)
+		actualByDay[d.Format("2006-01-02")] = qty
+	}
+
+	series := []map[string]any{}
+	cumulativeActual := 0.0
+	for i := 0; i < daysInMonth; i++ {
+		day := start.AddDate(0, 0, i)
+		key := day.Format("2006-01-02")
+		cumulativeActual += actualByDay[key]
+		cumulativeTarget := target * (float64(i+1) / float64(daysInMonth))
+		series = append(series, map[string]any{
+			"date":   key,
+			"target": cumulativeTarget,
+			"actual": cumulativeActual,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"month":         monthStr,
+		"targetMonthly": target,
+		"series":        series,
+		"scoped":        sc.DistributorIDs != nil,
+	})
+}
+
+func (a *App) handleExecCompetitorMap(w http.ResponseWriter, r *http.Request) {
+	bboxStr := r.URL.Query().Get("bbox")
+	minLat, minLng, maxLat, maxLng, ok := parseBBox(bboxStr)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "bbox must be minLat,minLng,maxLat,maxLng")
+		return
+	}
+	// Stores are not assigned to distributors, so a scoped user's territory is
+	// the service area of their distributors.
+	type area struct{ lat, lng, radiusKm float64 }
+	var territory []area
+	if sc := scopeFrom(r); sc.DistributorIDs != nil {
+		drows, err := a.db.Query(r.Context(), `
+      SELECT lat, lng, service_radius_km FROM distributors
+      WHERE archived_at IS NULL AND id = ANY($1)
+    `, sc.DistributorIDs)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+			return
+		}
+		territory = []area{}
+		for drows.Next() {
+			var d area
+			_ = drows.Scan(&d.lat, &d.lng, &d.radiusKm)
+			territory = append(territory, d)
+		}
+		drows.Close()
+	}
+	inTerritory := func(lat, lng float64) bool {
+		if territory == nil {
+			return true
+		}
+		for _, d := range territory {
+			if haversineKm(d.lat, d.lng, lat, lng) <= d.radiusKm {
+				return true
+			}
+		}
+		return false
+	}
+
+	rows, err := a.db.Query(r.Context(), `
+    SELECT s.id, s.name, s.lat, s.lng, c.our_share_pct, c.competitor_share_pct, c.updated_at
+    FROM stores s
+    JOIN competitor_presence c ON c.store_id = s.id
+    WHERE s.archived_at IS NULL AND s.lat BETWEEN $1 AND $2 AND s.lng BETWEEN $3 AND $4
+    ORDER BY s.id
+  `, minLat, maxLat, minLng, maxLng)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var lat, lng, our, comp float64
+		var updated time.Time
+		_ = rows.Scan(&id, &name, &lat, &lng, &our, &comp, &updated)
+		if !inTerritory(lat, lng) {
+			continue
+		}
+		items = append(items, map[string]any{
+			"id":                 id,
+			"name":               name,
+			"lat":                lat,
+			"lng":                lng,
+			"ourSharePct":        our,
+			"competitorSharePct": comp,
+			"updatedAt":          updated,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleExecPartnersPerformance(w http.ResponseWriter, r *http.Request) {
+	// Summary per distributor for last 90 days + trend vs previous 30 days.
+	rows, err := a.db.Query(r.Context(), `
+    WITH last30 AS (
+      SELECT distributor_id, COALESCE(SUM(quantity_tons),0) AS qty
+      FROM sales_orders
+      WHERE order_date >= CURRENT_DATE - INTERVAL '30 days'
+      GROUP BY distributor_id
+    ), prev30 AS (
+      SELECT distributor_id, COALESCE(SUM(quantity_tons),0) AS qty
+      FROM sales_orders
+      WHERE order_date >= CURRENT_DATE - INTERVAL '60 days'
+        AND order_date < CURRENT_DATE - INTERVAL '30 days'
+      GROUP BY distributor_id
+    ), total90 AS (
+      SELECT distributor_id,
+             COALESCE(SUM(quantity_tons),0) AS qty,
+             COALESCE(SUM(total_price),0) AS total_price,
+             MAX(order_date) AS last_order
+      FROM sales_orders
+      WHERE order_date >= CURRENT_DATE - INTERVAL '90 days'
+      GROUP BY distributor_id
+    )
+    SELECT d.id, d.name,
+           COALESCE(t.qty,0) AS qty90,
+           COALESCE(t.total_price,0) AS price90,
+           t.last_order,
+           COALESCE(l.qty,0) AS qty_last30,
+           COALESCE(p.qty,0) AS qty_prev30
+    FROM distributors d
+    LEFT JOIN total90 t ON t.distributor_id = d.id
+    LEFT JOIN last30 l ON l.distributor_id = d.id
+    LEFT JOIN prev30 p ON p.distributor_id = d.id
+    WHERE d.archived_at IS NULL AND ($1::bigint[] IS NULL OR d.id = ANY($1))
+    ORDER BY d.id
+  `, scopeFrom(r).DistributorIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var qty90, price90, qLast30, qPrev30 float64
+		var lastOrder *time.Time
+		_ = rows.Scan(&id, &name, &qty90, &price90, &lastOrder, &qLast30, &qPrev30)
+		trendPct := 0.0
+		if qPrev30 > 0 {
+			trendPct = ((qLast30 - qPrev30) / qPrev30) * 100
+		} else if qLast30 > 0 {
+			trendPct = 100
+		}
+		items = append(items, map[string]any{
+			"distributorId":   id,
+			"distributorName": name,
+			"totalQtyTons90d": qty90,
+			"totalPrice90d":   price90,
+			"lastOrderDate":   lastOrder,
+			"trendPct":        trendPct,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleExecShipmentsSummary(w http.ResponseWriter, r *http.Request) {
+	daysStr := strings.TrimSpace(r.URL.Query().Get("days"))
+	days := int64(0)
+	if daysStr != "" {
+		v, err := strconv.ParseInt(daysStr, 10, 64)
+		if err != nil || v < 0 || v > 3650 {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "days must be a number between 0 and 3650")
+			return
+		}
+		days = v
+	}
+
+	sc := scopeFrom(r)
+	var total, delivered, planned, inTransit, cancelled, overdue int64
+	err := a.db.QueryRow(r.Context(), `
+		SELECT
+			COUNT(*)::bigint AS total,
+			COUNT(*) FILTER (WHERE status = 'DELIVERED')::bigint AS delivered,
+			COUNT(*) FILTER (WHERE status = 'PLANNED')::bigint AS planned,
+			COUNT(*) FILTER (WHERE status = 'IN_TRANSIT')::bigint AS in_transit,
+			COUNT(*) FILTER (WHERE status = 'CANCELLED')::bigint AS cancelled,
+			COUNT(*) FILTER (
+				WHERE status IN ('PLANNED','IN_TRANSIT')
+				  AND arrive_eta IS NOT NULL
+				  AND arrive_eta < NOW()
+			)::bigint AS overdue
+		FROM shipments
+		WHERE (
+			($1::bigint = 0)
+		   OR (
+				(depart_at IS NOT NULL AND depart_at >= NOW() - ($1::bigint * INTERVAL '1 day'))
+			 OR (depart_at IS NULL AND arrive_eta IS NOT NULL AND arrive_eta >= NOW() - ($1::bigint * INTERVAL '1 day'))
+		   )
+		)
+		  AND ($2::bigint[] IS NULL OR from_warehouse_id = ANY($2))
+		  AND ($3::bigint[] IS NULL OR to_distributor_id = ANY($3))
+	`, days, sc.WarehouseIDs, sc.DistributorIDs).Scan(&total, &delivered, &planned, &inTransit, &cancelled, &overdue)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	overduePct := 0.0
+	if total > 0 {
+		overduePct = (float64(overdue) / float64(total)) * 100
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"days":       days,
+		"total":      total,
+		"delivered":  delivered,
+		"overdue":    overdue,
+		"overduePct": overduePct,
+		"byStatus": map[string]any{
+			"PLANNED":    planned,
+			"IN_TRANSIT": inTransit,
+			"DELIVERED":  delivered,
+			"CANCELLED":  cancelled,
+		},
+	})
+}
+
+func (a *App) handleExecSalesSummary(w http.ResponseWriter, r *http.Request) {
+	daysStr := strings.TrimSpace(r.URL.Query().Get("days"))
+	days := int64(90)
+	if daysStr != "" {
+		v, err := strconv.ParseInt(daysStr, 10, 64)
+		if err != nil || v <= 0 || v > 3650 {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "days must be a number between 1 and 3650")
+			return
+		}
+		days = v
+	}
+
+	sc := scopeFrom(r)
+	var orderCount int64
+	var totalQty, totalRevenue, avgOrder float64
+	err := a.db.QueryRow(r.Context(), `
+		SELECT
+			COUNT(*)::bigint AS orders,
+			COALESCE(SUM(quantity_tons),0) AS qty,
+			COALESCE(SUM(total_price),0) AS revenue,
+			COALESCE(AVG(total_price),0) AS avg_order
+		FROM sales_orders
+		WHERE order_date >= CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
+		  AND ($2::bigint[] IS NULL OR distributor_id = ANY($2))
+	`, days, sc.DistributorIDs).Scan(&orderCount, &totalQty, &totalRevenue, &avgOrder)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	rows, err := a.db.Query(r.Context(), `
+		SELECT d.id, d.name,
+		       COALESCE(SUM(o.quantity_tons),0) AS qty,
+		       COALESCE(SUM(o.total_price),0) AS revenue
+		FROM distributors d
+		LEFT JOIN sales_orders o
+		  ON o.distributor_id = d.id
+		 AND o.order_date >= CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
+		WHERE d.archived_at IS NULL AND ($2::bigint[] IS NULL OR d.id = ANY($2))
+		GROUP BY d.id, d.name
+		ORDER BY revenue DESC, qty DESC, d.id
+	`, days, sc.DistributorIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var qty, revenue float64
+		_ = rows.Scan(&id, &name, &qty, &revenue)
+		items = append(items, map[string]any{
+			"distributorId":   id,
+			"distributorName": name,
+			"qtyTons":         qty,
+			"revenue":         revenue,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"days":            days,
+		"orderCount":      orderCount,
+		"totalQtyTons":    totalQty,
+		"totalRevenue":    totalRevenue,
+		"avgOrderValue":   avgOrder,
+		"topDistributors": items,
+		"approvedCount":   nil,
+	})
+}
+
+func (a *App) handleExecSalesOverview(w http.ResponseWriter, r *http.Request) {
+	monthStr := strings.TrimSpace(r.URL.Query().Get("month"))
+	if monthStr == "" {
+		monthStr = time.Now().Format("2006-01")
+	}
+	t, err := time.Parse("2006-01", monthStr)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "month must be YYYY-MM")
+		return
+	}
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	prevStart := start.AddDate(0, -1, 0)
+	prevEnd := start
+	sc := scopeFrom(r)
+
+	var orders, prevOrders int64
+	var qty, prevQty, revenue, prevRevenue float64
+	err = a.db.QueryRow(r.Context(), `
+		SELECT
+			COUNT(*)::bigint AS orders,
+			COALESCE(SUM(quantity_tons),0) AS qty,
+			COALESCE(SUM(total_price),0) AS revenue
+		FROM sales_orders
+		WHERE order_date >= $1 AND order_date < $2
+		  AND ($3::bigint[] IS NULL OR distributor_id = ANY($3))
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"), sc.DistributorIDs).Scan(&orders, &qty, &revenue)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	err = a.db.QueryRow(r.Context(), `
+		SELECT
+			COUNT(*)::bigint AS orders,
+			COALESCE(SUM(quantity_tons),0) AS qty,
+			COALESCE(SUM(total_price),0) AS revenue
+		FROM sales_orders
+		WHERE order_date >= $1 AND order_date < $2
+		  AND ($3::bigint[] IS NULL OR distributor_id = ANY($3))
+	`, prevStart.Format("2006-01-02"), prevEnd.Format("2006-01-02"), sc.DistributorIDs).Scan(&prevOrders, &prevQty, &prevRevenue)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	growthQtyPct := 0.0
+	if prevQty > 0 {
+		growthQtyPct = ((qty - prevQty) / prevQty) * 100
+	} else if qty > 0 {
+		growthQtyPct = 100
+	}
+	growthRevenuePct := 0.0
+	if prevRevenue > 0 {
+		growthRevenuePct = ((revenue - prevRevenue) / prevRevenue) * 100
+	} else if revenue > 0 {
+		growthRevenuePct = 100
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"month": monthStr,
+		"current": map[string]any{
+			"orderCount": orders,
+			"qtyTons":    qty,
+			"revenue":    revenue,
+			"avgOrderValue": func() float64 {
+				if orders > 0 {
+					return revenue / float64(orders)
+				}
+				return 0
+			}(),
+		},
+		"previous": map[string]any{
+			"orderCount": prevOrders,
+			"qtyTons":    prevQty,
+			"revenue":    prevRevenue,
+		},
+		"growth": map[string]any{
+			"qtyPct":     growthQtyPct,
+			"revenuePct": growthRevenuePct,
+		},
+	})
+}
+
+func (a *App) handleExecRegionalPerformance(w http.ResponseWriter, r *http.Request) {
+	daysStr := strings.TrimSpace(r.URL.Query().Get("days"))
+	days := int64(30)
+	if daysStr != "" {
+		v, err := strconv.ParseInt(daysStr, 10, 64)
+		if err != nil || v <= 0 || v > 3650 {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "days must be a number between 1 and 3650")
+			return
+		}
+		days = v
+	}
+	sc := scopeFrom(r)
+
+	rows, err := a.db.Query(r.Context(), `
+		WITH sales_win AS (
+			SELECT distributor_id,
+				   COUNT(*)::bigint AS orders,
+				   COALESCE(SUM(quantity_tons),0) AS qty,
+				   COALESCE(SUM(total_price),0) AS revenue
+			FROM sales_orders
+			WHERE order_date >= CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
+			GROUP BY distributor_id
+		), sales_prev AS (
+			SELECT distributor_id,
+				   COALESCE(SUM(quantity_tons),0) AS qty
+			FROM sales_orders
+			WHERE order_date >= CURRENT_DATE - (($1::bigint * 2) * INTERVAL '1 day')
+			  AND order_date <  CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
+			GROUP BY distributor_id
+		), ship_win AS (
+			SELECT to_distributor_id AS distributor_id,
+				   COUNT(*)::bigint AS total_shipments,
+				   COUNT(*) FILTER (
+					WHERE status IN ('PLANNED','IN_TRANSIT')
+					  AND arrive_eta IS NOT NULL
+					  AND arrive_eta < NOW()
+				)::bigint AS overdue_shipments
+			FROM shipments
+			WHERE (
+				(depart_at IS NOT NULL AND depart_at >= NOW() - ($1::bigint * INTERVAL '1 day'))
+			 OR (depart_at IS NULL AND arrive_eta IS NOT NULL AND arrive_eta >= NOW() - ($1::bigint * INTERVAL '1 day'))
+			)
+			  AND ($2::bigint[] IS NULL OR from_warehouse_id = ANY($2))
+			GROUP BY to_distributor_id
+		)
+		SELECT d.id, d.name,
+		       COALESCE(sw.orders,0) AS orders,
+		       COALESCE(sw.qty,0) AS qty,
+		       COALESCE(sw.revenue,0) AS revenue,
+		       COALESCE(sp.qty,0) AS prev_qty,
+		       COALESCE(sh.total_shipments,0) AS ship_total,
+		       COALESCE(sh.overdue_shipments,0) AS ship_overdue
+		FROM distributors d
+		LEFT JOIN sales_win sw ON sw.distributor_id = d.id
+		LEFT JOIN sales_prev sp ON sp.distributor_id = d.id
+		LEFT JOIN ship_win sh ON sh.distributor_id = d.id
+		WHERE d.archived_at IS NULL AND ($3::bigint[] IS NULL OR d.id = ANY($3))
+		ORDER BY revenue DESC, qty DESC, d.id
+	`, days, sc.WarehouseIDs, sc.DistributorIDs)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var orders, shipTotal, shipOverdue int64
+		var qty, revenue, prevQty float64
+		_ = rows.Scan(&id, &name, &orders, &qty, &revenue, &prevQty, &shipTotal, &shipOverdue)
+		growthPct := 0.0
+		if prevQty > 0 {
+			growthPct = ((qty - prevQty) / prevQty) * 100
+		} else if qty > 0 {
+			growthPct = 100
+		}
+		overduePct := 0.0
+		if shipTotal > 0 {
+			overduePct = (float64(shipOverdue) / float64(shipTotal)) * 100
+		}
+		avgOrderValue := 0.0
+		if orders > 0 {
+			avgOrderValue = revenue / float64(orders)
+		}
+		items = append(items, map[string]any{
+			"distributorId":    id,
+			"distributorName":  name,
+			"orderCount":       orders,
+			"qtyTons":          qty,
+			"revenue":          revenue,
+			"growthPct":        growthPct,
+			"avgOrderValue":    avgOrderValue,
+			"shipmentsTotal":   shipTotal,
+			"shipmentsOverdue": shipOverdue,
+			"overduePct":       overduePct,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"days":  days,
+		"items": items,
+		"note":  "Region tidak dimodelkan; agregasi menggunakan distributor sebagai proxy regional.",
+	})
+}
+
+// ---------- ops: update shipment status ----------
+
+func (a *App) handleOpsUpdateShipmentStatus(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Status = strings.TrimSpace(strings.ToUpper(body.Status))
+	allowed := map[string]bool{"SCHEDULED": true, "ON_DELIVERY": true, "COMPLETED": true, "DELAYED": true, "RECEIVED": true}
+	if !allowed[body.Status] {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be SCHEDULED|ON_DELIVERY|COMPLETED|DELAYED|RECEIVED")
+		return
+	}
+
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var fromID, toID int64
+	var currentStatus string
+	var orderReqID *int64
+	var depart, eta *time.Time
+	var wlat, wlng, dlat, dlng float64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT s.from_warehouse_id, s.to_distributor_id, s.status, s.order_request_id, s.depart_at, s.arrive_eta,
+           w.lat, w.lng, d.lat, d.lng
+    FROM shipments s
+    JOIN warehouses w ON w.id = s.from_warehouse_id
+    JOIN distributors d ON d.id = s.to_distributor_id
+    WHERE s.id=$1
+    FOR UPDATE
+  `, id).Scan(&fromID, &toID, &currentStatus, &orderReqID, &depart, &eta, &wlat, &wlng, &dlat, &dlng); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
+		return
+	}
+	if sc := scopeFrom(r); !sc.allowsWarehouse(fromID) || !sc.allowsDistributor(toID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "shipment is outside your scope")
+		return
+	}
+
+	// Enforce a simple lifecycle to avoid impossible transitions.
+	// SCHEDULED -> ON_DELIVERY|DELAYED|COMPLETED
+	// ON_DELIVERY -> DELAYED|COMPLETED
+	// DELAYED -> ON_DELIVERY|COMPLETED
+	// COMPLETED -> RECEIVED
+	// RECEIVED -> terminal
+	if body.Status != currentStatus {
+		allowedNext := map[string]map[string]bool{
+			"SCHEDULED":   {"ON_DELIVERY": true, "DELAYED": true, "COMPLETED": true},
+			"ON_DELIVERY": {"DELAYED": true, "COMPLETED": true},
+			"DELAYED":     {"ON_DELIVERY": true, "COMPLETED": true},
+			"COMPLETED":   {"RECEIVED": true},
+			"RECEIVED":    {},
+		}
+		if !allowedNext[currentStatus][body.Status] {
+			writeAPIError(w, http.StatusConflict, "INVALID_STATE", fmt.Sprintf("invalid transition %s -> %s", currentStatus, body.Status))
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	etaMinutes := 0
+	var lastLat, lastLng *float64
+	var lastUpdate *time.Time
+
+	// Default ETA if missing.
+	if eta == nil {
+		mins := estimateTravelMinutes(wlat, wlng, dlat, dlng)
+		e := now.Add(time.Duration(mins) * time.Minute)
+		eta = &e
+	}
+	if depart == nil {
+		d := now.Add(30 * time.Minute)
+		depart = &d
+	}
+
+	switch body.Status {
+	case "SCHEDULED":
+		// Keep schedule/eta as-is.
+		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
+	case "ON_DELIVERY":
+		// If starting delivery, set depart to now if it is in the future.
+		if depart.UTC().After(now) {
+			d := now
+			depart = &d
+		}
+		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
+		// initialize truck position at warehouse if missing
+		ll, lg := wlat, wlng
+		lastLat, lastLng = &ll, &lg
+		u := now
+		lastUpdate = &u
+	case "DELAYED":
+		// Push ETA forward by 60 minutes.
+		e2 := eta.UTC().Add(60 * time.Minute)
+		eta = &e2
+		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
+	case "COMPLETED":
+		etaMinutes = 0
+		ll, lg := dlat, dlng
+		lastLat, lastLng = &ll, &lg
+		u := now
+		lastUpdate = &u
+	case "RECEIVED":
+		etaMinutes = 0
+	}
+
+	if _, err := tx.Exec(r.Context(), `
+    UPDATE shipments
+    SET status=$1, depart_at=$2, arrive_eta=$3, eta_minutes=$4,
+        last_lat=COALESCE($5,last_lat), last_lng=COALESCE($6,last_lng), last_update=COALESCE($7,last_update),
+        updated_at=now()
+    WHERE id=$8
+  `, body.Status, depart, eta, etaMinutes, lastLat, lastLng, lastUpdate, id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	if orderReqID != nil && body.Status == "COMPLETED" {
+		_, _ = tx.Exec(r.Context(), `UPDATE order_requests SET status='FULFILLED', updated_at=now() WHERE id=$1`, *orderReqID)
+	}
+
+	if body.Status != currentStatus {
+		if err := notify.ToDistributor(r.Context(), tx, toID, shipmentStatusNotification(id, currentStatus, body.Status, eta)); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+			return
+		}
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "SHIPMENT_STATUS_UPDATED", "shipment", fmt.Sprintf("%d", id), map[string]any{"status": body.Status}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": body.Status})
+}
+
+func shipmentStatusNotification(shipmentID int64, from, to string, eta *time.Time) notify.Notification {
+	body := fmt.Sprintf("Shipment #%d moved from %s to %s.", shipmentID, from, to)
+	if eta != nil && (to == "ON_DELIVERY" || to == "DELAYED") {
+		body += " ETA " + eta.UTC().Format(time.RFC3339) + "."
+	}
+	return notify.Notification{
+		Kind:       notify.KindShipmentStatus,
+		Title:      fmt.Sprintf("Shipment #%d is %s", shipmentID, to),
+		Body:       body,
+		EntityType: "shipment",
+		EntityID:   fmt.Sprintf("%d", shipmentID),
+		Metadata:   map[string]any{"from": from, "to": to},
+	}
+}
+
+// ---------- ops: stock transfers ----------
+
+// errInsufficientStock is returned by moveStockTx when taking stock out would
+// leave the warehouse below zero.
+var errInsufficientStock = errors.New("insufficient stock")
+
+// moveStockTx adds delta tons of a cement type to a warehouse and records the
+// movement: IN for a positive delta, OUT (with a positive quantity) for a
+// negative one. It returns the new stock level.
+func moveStockTx(ctx context.Context, tx pgx.Tx, actorID *int64, warehouseID int64, cementType string, delta float64, reason, refType, refID string, meta map[string]any) (float64, error) {
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO stock_levels (warehouse_id, cement_type, quantity_tons)
+    VALUES ($1,$2,0)
+    ON CONFLICT (warehouse_id, cement_type) DO NOTHING
+  `, warehouseID, cementType); err != nil {
+		return 0, err
+	}
+	var current float64
+	if err := tx.QueryRow(ctx, `
+    SELECT quantity_tons FROM stock_levels
+    WHERE warehouse_id=$1 AND cement_type=$2
+    FOR UPDATE
+  `, warehouseID, cementType).Scan(&current); err != nil {
+		return 0, err
+	}
+	newQty := current + delta
+	if newQty < 0 {
+		return current, errInsufficientStock
+	}
+	if _, err := tx.Exec(ctx, `
+    UPDATE stock_levels SET quantity_tons=$1, updated_at=now()
+    WHERE warehouse_id=$2 AND cement_type=$3
+  `, newQty, warehouseID, cementType); err != nil {
+		return 0, err
+	}
+	movementType := "IN"
+	if delta < 0 {
+		movementType = "OUT"
+	}
+	if meta == nil {
+		meta = map[string]any{}
+	}
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO inventory_movements (actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata)
+    VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+  `, actorID, warehouseID, cementType, movementType, math.Abs(delta), reason, refType, refID, meta); err != nil {
+		return 0, err
+	}
+	return newQty, nil
+}
+
+// stockKey identifies one cement type at one warehouse.
+type stockKey struct {
+	wid int64
+	ct  string
+}
+
+// stockInTransit sums the tons currently on the road to (in) and from (out)
+// each warehouse: open transfers count on both sides, open plant dispatches
+// only at their destination.
+func (a *App) stockInTransit(ctx context.Context) (in, out map[stockKey]float64, err error) {
+	rows, err := a.db.Query(ctx, `
+    SELECT from_warehouse_id, to_warehouse_id, cement_type, quantity_tons
+    FROM stock_transfers
+    WHERE status IN ('SCHEDULED','IN_TRANSIT')
+    UNION ALL
+    SELECT NULL, to_warehouse_id, cement_type, quantity_tons
+    FROM plant_dispatches
+    WHERE status IN ('SCHEDULED','IN_TRANSIT')
+  `)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	in, out = map[stockKey]float64{}, map[stockKey]float64{}
+	for rows.Next() {
+		var fid *int64
+		var tid int64
+		var ct string
+		var qty float64
+		if err := rows.Scan(&fid, &tid, &ct, &qty); err != nil {
+			return nil, nil, err
+		}
+		in[stockKey{wid: tid, ct: ct}] += qty
+		if fid != nil {
+			out[stockKey{wid: *fid, ct: ct}] += qty
+		}
+	}
+	return in, out, rows.Err()
+}
+
+// transferScope limits a transfer query to rows where either warehouse is in
+// the caller's scope, so both the sending and the receiving side see it.
+const transferScope = `($1::bigint[] IS NULL OR t.from_warehouse_id = ANY($1) OR t.to_warehouse_id = ANY($1))`
+
+func (a *App) handleOpsTransfers(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	where := "WHERE " + transferScope
+	args := []any{scopeFrom(r).WarehouseIDs}
+	if status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status"))); status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND t.status=$%d", len(args))
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := a.db.Query(r.Context(), fmt.Sprintf(`
+    SELECT t.id, t.status, t.cement_type, t.quantity_tons, t.reason,
+           t.depart_at, t.arrive_eta, t.received_at, t.created_at,
+           fw.id, fw.name, tw.id, tw.name,
+           tr.id, tr.code, cu.name
+    FROM stock_transfers t
+    JOIN warehouses fw ON fw.id = t.from_warehouse_id
+    JOIN warehouses tw ON tw.id = t.to_warehouse_id
+    LEFT JOIN trucks tr ON tr.id = t.truck_id
+    LEFT JOIN users cu ON cu.id = t.created_by_user_id
+    %s
+    ORDER BY t.id DESC
+    LIMIT $%d OFFSET $%d
+  `, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id, fid, tid int64
+		var status, ct, reason, fname, tname string
+		var qty float64
+		var depart, eta, received *time.Time
+		var created time.Time
+		var truckID *int64
+		var truckCode, createdBy *string
+		if err := rows.Scan(&id, &status, &ct, &qty, &reason, &depart, &eta, &received, &created, &fid, &fname, &tid, &tname, &truckID, &truckCode, &createdBy); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		items = append(items, map[string]any{
+			"id":            id,
+			"status":        status,
+			"cementType":    ct,
+			"quantityTons":  qty,
+			"reason":        reason,
+			"departAt":      depart,
+			"arriveEta":     eta,
+			"receivedAt":    received,
+			"createdAt":     created,
+			"createdBy":     createdBy,
+			"truck":         map[string]any{"id": truckID, "code": truckCode},
+			"fromWarehouse": map[string]any{"id": fid, "name": fname},
+			"toWarehouse":   map[string]any{"id": tid, "name": tname},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "page": page, "pageSize": pageSize})
+}
+
+func (a *App) handleOpsTransferDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var fid, tid int64
+	var status, ct, reason, fname, tname string
+	var qty float64
+	var depart, eta, received *time.Time
+	var created, updated time.Time
+	var truckID, createdByID, receivedByID *int64
+	if err := a.db.QueryRow(r.Context(), `
+    SELECT t.status, t.cement_type, t.quantity_tons, t.reason, t.truck_id,
+           t.depart_at, t.arrive_eta, t.received_at, t.created_by_user_id, t.received_by_user_id,
+           t.created_at, t.updated_at,
+           fw.id, fw.name, tw.id, tw.name
+    FROM stock_transfers t
+    JOIN warehouses fw ON fw.id = t.from_warehouse_id
+    JOIN warehouses tw ON tw.id = t.to_warehouse_id
+    WHERE t.id=$2 AND `+transferScope+`
+  `, scopeFrom(r).WarehouseIDs, id).Scan(&status, &ct, &qty, &reason, &truckID, &depart, &eta, &received, &createdByID, &receivedByID, &created, &updated, &fid, &fname, &tid, &tname); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "transfer not found")
+		return
+	}
+
+	// Include archived movements, so an old transfer keeps its full history.
+	mrows, err := a.db.Query(r.Context(), `
+    SELECT id, ts, actor_user_id, warehouse_id, movement_type, quantity_tons, reason
+    FROM inventory_movements
+    WHERE ref_type='stock_transfer' AND ref_id=$1
+    UNION ALL
+    SELECT id, ts, actor_user_id, warehouse_id, movement_type, quantity_tons, reason
+    FROM inventory_movements_archive
+    WHERE ref_type='stock_transfer' AND ref_id=$1
+    ORDER BY ts, id
+  `, fmt.Sprintf("%d", id))
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer mrows.Close()
+	movements := []map[string]any{}
+	for mrows.Next() {
+		var mid, wid int64
+		var ts time.Time
+		var actorID *int64
+		var mt, mreason string
+		var mqty float64
+		if err := mrows.Scan(&mid, &ts, &actorID, &wid, &mt, &mqty, &mreason); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		movements = append(movements, map[string]any{
+			"id":           mid,
+			"ts":           ts,
+			"warehouseId":  wid,
+			"movementType": mt,
+			"quantityTons": mqty,
+			"reason":       mreason,
+			"actorUserId":  actorID,
+		})
+	}
+	if err := mrows.Err(); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":               id,
+		"status":           status,
+		"cementType":       ct,
+		"quantityTons":     qty,
+		"reason":           reason,
+		"truckId":          truckID,
+		"departAt":         depart,
+		"arriveEta":        eta,
+		"receivedAt":       received,
+		"createdByUserId":  createdByID,
+		"receivedByUserId": receivedByID,
+		"createdAt":        created,
+		"updatedAt":        updated,
+		"fromWarehouse":    map[string]any{"id": fid, "name": fname},
+		"toWarehouse":      map[string]any{"id": tid, "name": tname},
+		"movements":        movements,
+	})
+}
+
+// handleOpsCreateTransfer schedules a transfer and takes the stock out of the
+// source warehouse in the same transaction, so it cannot be shipped twice.
+func (a *App) handleOpsCreateTransfer(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		FromWarehouseID int64      `json:"fromWarehouseId"`
+		ToWarehouseID   int64      `json:"toWarehouseId"`
+		CementType      string     `json:"cementType"`
+		QuantityTons    float64    `json:"quantityTons"`
+		TruckID         *int64     `json:"truckId"`
+		DepartAt        *time.Time `json:"departAt"`
+		Reason          string     `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.CementType = strings.TrimSpace(body.CementType)
+	if body.FromWarehouseID <= 0 || body.ToWarehouseID <= 0 || body.CementType == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "fromWarehouseId, toWarehouseId and cementType required")
+		return
+	}
+	if body.FromWarehouseID == body.ToWarehouseID {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "source and destination must differ")
+		return
+	}
+	if body.QuantityTons <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "quantityTons must be positive")
+		return
+	}
+	if sc := scopeFrom(r); !sc.allowsWarehouse(body.FromWarehouseID) || !sc.allowsWarehouse(body.ToWarehouseID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "warehouse is outside your scope")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var flat, flng, tlat, tlng float64
+	var tname string
+	if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM warehouses WHERE id=$1 AND archived_at IS NULL FOR SHARE`, body.FromWarehouseID).Scan(&flat, &flng); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid source warehouse")
+		return
+	}
+	if err := tx.QueryRow(r.Context(), `SELECT name,lat,lng FROM warehouses WHERE id=$1 AND archived_at IS NULL FOR SHARE`, body.ToWarehouseID).Scan(&tname, &tlat, &tlng); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid destination warehouse")
+		return
+	}
+
+	departAt := time.Now().UTC().Add(45 * time.Minute)
+	if body.DepartAt != nil {
+		departAt = body.DepartAt.UTC()
+	}
+	eta := departAt.Add(time.Duration(estimateTravelMinutes(flat, flng, tlat, tlng)) * time.Minute)
+
+	var transferID int64
+	if err := tx.QueryRow(r.Context(), `
+    INSERT INTO stock_transfers (from_warehouse_id, to_warehouse_id, cement_type, quantity_tons, status, truck_id, reason, depart_at, arrive_eta, created_by_user_id)
+    VALUES ($1,$2,$3,$4,'SCHEDULED',$5,$6,$7,$8,$9)
+    RETURNING id
+  `, body.FromWarehouseID, body.ToWarehouseID, body.CementType, body.QuantityTons, body.TruckID, body.Reason, departAt, eta, u.ID).Scan(&transferID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	ref := fmt.Sprintf("%d", transferID)
+	if _, err := moveStockTx(r.Context(), tx, &u.ID, body.FromWarehouseID, body.CementType, -body.QuantityTons,
+		fmt.Sprintf("Transfer #%d to %s", transferID, tname), "stock_transfer", ref,
+		map[string]any{"toWarehouseId": body.ToWarehouseID}); err != nil {
+		if errors.Is(err, errInsufficientStock) {
+			writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "insufficient stock at source warehouse")
+			return
+		}
+		writeDBError(w, err)
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "TRANSFER_CREATED", "stock_transfer", ref, map[string]any{
+		"fromWarehouseId": body.FromWarehouseID,
+		"toWarehouseId":   body.ToWarehouseID,
+		"cementType":      body.CementType,
+		"quantityTons":    body.QuantityTons,
+		"reason":          body.Reason,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"ok": true, "transferId": transferID, "arriveEta": eta})
+}
+
+// handleOpsUpdateTransferStatus moves a transfer along its lifecycle:
+//
+//	SCHEDULED  -> IN_TRANSIT|CANCELLED
+//	IN_TRANSIT -> RECEIVED
+//
+// Dispatching and cancelling act on the source warehouse, receiving on the
+// destination, and the caller's scope must include that side. Receiving posts
+// IN at the destination; cancelling returns the reserved stock to the source,
+// so it is only allowed before the truck has left.
+func (a *App) handleOpsUpdateTransferStatus(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Status = strings.TrimSpace(strings.ToUpper(body.Status))
+	if body.Status != "IN_TRANSIT" && body.Status != "RECEIVED" && body.Status != "CANCELLED" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be IN_TRANSIT|RECEIVED|CANCELLED")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var fromID, toID int64
+	var status, ct string
+	var qty float64
+	var depart *time.Time
+	var flat, flng, tlat, tlng float64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT t.from_warehouse_id, t.to_warehouse_id, t.status, t.cement_type, t.quantity_tons, t.depart_at,
+           fw.lat, fw.lng, tw.lat, tw.lng
+    FROM stock_transfers t
+    JOIN warehouses fw ON fw.id = t.from_warehouse_id
+    JOIN warehouses tw ON tw.id = t.to_warehouse_id
+    WHERE t.id=$1
+    FOR UPDATE OF t
+  `, id).Scan(&fromID, &toID, &status, &ct, &qty, &depart, &flat, &flng, &tlat, &tlng); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "transfer not found")
+		return
+	}
+	actingWarehouse := fromID
+	if body.Status == "RECEIVED" {
+		actingWarehouse = toID
+	}
+	if !scopeFrom(r).allowsWarehouse(actingWarehouse) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "transfer is outside your scope")
+		return
+	}
+	allowedNext := map[string]map[string]bool{
+		"SCHEDULED":  {"IN_TRANSIT": true, "CANCELLED": true},
+		"IN_TRANSIT": {"RECEIVED": true},
+	}
+	if !allowedNext[status][body.Status] {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", fmt.Sprintf("invalid transition %s -> %s", status, body.Status))
+		return
+	}
+
+	ref := fmt.Sprintf("%d", id)
+	now := time.Now().UTC()
+	switch body.Status {
+	case "IN_TRANSIT":
+		// Leaving now: restart the clock so the ETA reflects the real departure.
+		if depart == nil || depart.After(now) {
+			depart = &now
+		}
+		eta := depart.Add(time.Duration(estimateTravelMinutes(flat, flng, tlat, tlng)) * time.Minute)
+		_, err = tx.Exec(r.Context(), `
+      UPDATE stock_transfers SET status='IN_TRANSIT', depart_at=$1, arrive_eta=$2, updated_at=now() WHERE id=$3
+    `, depart, eta, id)
+	case "RECEIVED":
+		if _, err = moveStockTx(r.Context(), tx, &u.ID, toID, ct, qty, fmt.Sprintf("Transfer #%d received", id), "stock_transfer", ref, map[string]any{"fromWarehouseId": fromID}); err == nil {
+			_, err = tx.Exec(r.Context(), `
+        UPDATE stock_transfers SET status='RECEIVED', received_at=now(), received_by_user_id=$1, updated_at=now() WHERE id=$2
+      `, u.ID, id)
+		}
+	case "CANCELLED":
+		if _, err = moveStockTx(r.Context(), tx, &u.ID, fromID, ct, qty, fmt.Sprintf("Transfer #%d cancelled", id), "stock_transfer", ref, map[string]any{"reason": body.Reason}); err == nil {
+			_, err = tx.Exec(r.Context(), `UPDATE stock_transfers SET status='CANCELLED', updated_at=now() WHERE id=$1`, id)
+		}
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "TRANSFER_STATUS_UPDATED", "stock_transfer", ref, map[string]any{"from": status, "status": body.Status, "reason": body.Reason}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": body.Status})
+}
+
+// ---------- ops: plant production and dispatch ----------
+
+// handleOpsPlants lists each plant with, per cement type, its daily capacity,
+// today's production and the stock it still holds.
+func (a *App) handleOpsPlants(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng FROM plants WHERE archived_at IS NULL ORDER BY id`)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	items := []map[string]any{}
+	byID := map[int64]map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var lat, lng float64
+		if err := rows.Scan(&id, &name, &lat, &lng); err != nil {
+			rows.Close()
+			writeDBError(w, err)
+			return
+		}
+		p := map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "cementTypes": []map[string]any{}}
+		items = append(items, p)
+		byID[id] = p
+	}
+	rows.Close()
+
+	crows, err := a.db.Query(r.Context(), `
+    SELECT k.plant_id, k.cement_type, c.daily_capacity_tons,
+           COALESCE(pt.quantity_tons,0), COALESCE(s.produced_tons,0), COALESCE(s.dispatched_tons,0), COALESCE(s.available_tons,0)
+    FROM (
+      SELECT plant_id, cement_type FROM plant_capacities
+      UNION
+      SELECT plant_id, cement_type FROM plant_stock
+    ) k
+    LEFT JOIN plant_capacities c ON c.plant_id=k.plant_id AND c.cement_type=k.cement_type
+    LEFT JOIN plant_production pt ON pt.plant_id=k.plant_id AND pt.cement_type=k.cement_type AND pt.production_date=CURRENT_DATE
+    LEFT JOIN plant_stock s ON s.plant_id=k.plant_id AND s.cement_type=k.cement_type
+    ORDER BY k.plant_id, k.cement_type
+  `)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer crows.Close()
+	for crows.Next() {
+		var pid int64
+		var ct string
+		var capacity *float64
+		var today, produced, dispatched, available float64
+		if err := crows.Scan(&pid, &ct, &capacity, &today, &produced, &dispatched, &available); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		p := byID[pid]
+		if p == nil {
+			continue
+		}
+		p["cementTypes"] = append(p["cementTypes"].([]map[string]any), map[string]any{
+			"cementType":        ct,
+			"dailyCapacityTons": capacity,
+			"producedTodayTons": today,
+			"producedTons":      produced,
+			"dispatchedTons":    dispatched,
+			"availableTons":     available,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// handleOpsPlantProduction returns a plant's daily production records between
+// from and to (default: the last 30 days), with utilization of capacity.
+func (a *App) handleOpsPlantProduction(w http.ResponseWriter, r *http.Request) {
+	plantID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -29)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "from must be YYYY-MM-DD")
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "to must be YYYY-MM-DD")
+			return
+		}
+	}
+
+	var name string
+	if err := a.db.QueryRow(r.Context(), `SELECT name FROM plants WHERE id=$1`, plantID).Scan(&name); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "plant not found")
+		return
+	}
+	rows, err := a.db.Query(r.Context(), `
+    SELECT p.id, p.production_date, p.cement_type, p.quantity_tons, p.note, u.name, c.daily_capacity_tons
+    FROM plant_production p
+    LEFT JOIN plant_capacities c ON c.plant_id=p.plant_id AND c.cement_type=p.cement_type
+    LEFT JOIN users u ON u.id = p.recorded_by_user_id
+    WHERE p.plant_id=$1 AND p.production_date BETWEEN $2 AND $3
+    ORDER BY p.production_date DESC, p.cement_type
+  `, plantID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	totals := map[string]float64{}
+	for rows.Next() {
+		var id int64
+		var day time.Time
+		var ct, note string
+		var qty float64
+		var recordedBy *string
+		var capacity *float64
+		if err := rows.Scan(&id, &day, &ct, &qty, &note, &recordedBy, &capacity); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		var utilization *float64
+		if capacity != nil && *capacity > 0 {
+			pct := qty / *capacity * 100
+			utilization = &pct
+		}
+		totals[ct] += qty
+		items = append(items, map[string]any{
+			"id":                id,
+			"date":              day.Format("2006-01-02"),
+			"cementType":        ct,
+			"quantityTons":      qty,
+			"dailyCapacityTons": capacity,
+			"utilizationPct":    utilization,
+			"note":              note,
+			"recordedBy":        recordedBy,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"plant":  map[string]any{"id": plantID, "name": name},
+		"from":   from.Format("2006-01-02"),
+		"to":     to.Format("2006-01-02"),
+		"items":  items,
+		"totals": totals,
+	})
+}
+
+// handleOpsRecordProduction records, or corrects, one day's output of a
+// cement type. Output above the configured capacity is refused, and so is a
+// correction that would leave the plant with less than it has dispatched.
+func (a *App) handleOpsRecordProduction(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	plantID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Date         string  `json:"date"`
+		CementType   string  `json:"cementType"`
+		QuantityTons float64 `json:"quantityTons"`
+		Note         string  `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.CementType = strings.TrimSpace(body.CementType)
+	if body.CementType == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "cementType required")
+		return
+	}
+	if body.QuantityTons < 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "quantityTons must not be negative")
+		return
+	}
+	day := time.Now().UTC()
+	if body.Date != "" {
+		if day, err = time.Parse("2006-01-02", body.Date); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "date must be YYYY-MM-DD")
+			return
+		}
+	}
+	if day.After(time.Now().UTC()) {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "date is in the future")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	// The plant row lock serializes production changes with dispatches.
+	if err := tx.QueryRow(r.Context(), `SELECT id FROM plants WHERE id=$1 AND archived_at IS NULL FOR UPDATE`, plantID).Scan(&plantID); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "plant not found")
+		return
+	}
+	var capacity float64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT daily_capacity_tons FROM plant_capacities WHERE plant_id=$1 AND cement_type=$2
+  `, plantID, body.CementType).Scan(&capacity); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "no capacity configured for this cement type")
+		return
+	}
+	if body.QuantityTons > capacity {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("quantityTons exceeds daily capacity of %.1f tons", capacity))
+		return
+	}
+
+	var id int64
+	if err := tx.QueryRow(r.Context(), `
+    INSERT INTO plant_production (plant_id, cement_type, production_date, quantity_tons, note, recorded_by_user_id)
+    VALUES ($1,$2,$3,$4,$5,$6)
+    ON CONFLICT (plant_id, cement_type, production_date) DO UPDATE
+      SET quantity_tons=EXCLUDED.quantity_tons, note=EXCLUDED.note, recorded_by_user_id=EXCLUDED.recorded_by_user_id, updated_at=now()
+    RETURNING id
+  `, plantID, body.CementType, day.Format("2006-01-02"), body.QuantityTons, body.Note, u.ID).Scan(&id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	var available float64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT available_tons FROM plant_stock WHERE plant_id=$1 AND cement_type=$2
+  `, plantID, body.CementType).Scan(&available); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if available < 0 {
+		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "more than this has already been dispatched")
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "PLANT_PRODUCTION_RECORDED", "plant_production", fmt.Sprintf("%d", id), map[string]any{
+		"plantId":      plantID,
+		"cementType":   body.CementType,
+		"date":         day.Format("2006-01-02"),
+		"quantityTons": body.QuantityTons,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "id": id, "availableTons": available})
+}
+
+func (a *App) handleOpsDispatches(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	where := "WHERE ($1::bigint[] IS NULL OR d.to_warehouse_id = ANY($1))"
+	args := []any{scopeFrom(r).WarehouseIDs}
+	if status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status"))); status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND d.status=$%d", len(args))
+	}
+	if v := r.URL.Query().Get("plantId"); v != "" {
+		plantID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid plantId")
+			return
+		}
+		args = append(args, plantID)
+		where += fmt.Sprintf(" AND d.plant_id=$%d", len(args))
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := a.db.Query(r.Context(), fmt.Sprintf(`
+    SELECT d.id, d.status, d.cement_type, d.quantity_tons, d.reason,
+           d.depart_at, d.arrive_eta, d.received_at, d.created_at,
+           p.id, p.name, w.id, w.name,
+           tr.id, tr.code, cu.name
+    FROM plant_dispatches d
+    JOIN plants p ON p.id = d.plant_id
+    JOIN warehouses w ON w.id = d.to_warehouse_id
+    LEFT JOIN trucks tr ON tr.id = d.truck_id
+    LEFT JOIN users cu ON cu.id = d.created_by_user_id
+    %s
+    ORDER BY d.id DESC
+    LIMIT $%d OFFSET $%d
+  `, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id, pid, wid int64
+		var status, ct, reason, pname, wname string
+		var qty float64
+		var depart, eta, received *time.Time
+		var created time.Time
+		var truckID *int64
+		var truckCode, createdBy *string
+		if err := rows.Scan(&id, &status, &ct, &qty, &reason, &depart, &eta, &received, &created, &pid, &pname, &wid, &wname, &truckID, &truckCode, &createdBy); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		items = append(items, map[string]any{
+			"id":           id,
+			"status":       status,
+			"cementType":   ct,
+			"quantityTons": qty,
+			"reason":       reason,
+			"departAt":     depart,
+			"arriveEta":    eta,
+			"receivedAt":   received,
+			"createdAt":    created,
+			"createdBy":    createdBy,
+			"truck":        map[string]any{"id": truckID, "code": truckCode},
+			"plant":        map[string]any{"id": pid, "name": pname},
+			"toWarehouse":  map[string]any{"id": wid, "name": wname},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "page": page, "pageSize": pageSize})
+}
+
+// handleOpsCreateDispatch schedules a plant-to-warehouse replenishment. The
+// tons are taken from the plant's stock at once; the warehouse only gets them
+// when the dispatch is received. Without a plantId, the plant holding the
+// most of the cement type is used.
+func (a *App) handleOpsCreateDispatch(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		PlantID       int64      `json:"plantId"`
+		ToWarehouseID int64      `json:"toWarehouseId"`
+		CementType    string     `json:"cementType"`
+		QuantityTons  float64    `json:"quantityTons"`
+		TruckID       *int64     `json:"truckId"`
+		DepartAt      *time.Time `json:"departAt"`
+		Reason        string     `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.CementType = strings.TrimSpace(body.CementType)
+	if body.ToWarehouseID <= 0 || body.CementType == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "toWarehouseId and cementType required")
+		return
+	}
+	if body.QuantityTons <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "quantityTons must be positive")
+		return
+	}
+	if !scopeFrom(r).allowsWarehouse(body.ToWarehouseID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "warehouse is outside your scope")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	if body.PlantID == 0 {
+		err := tx.QueryRow(r.Context(), `
+      SELECT s.plant_id
+      FROM plant_stock s
+      JOIN plants p ON p.id = s.plant_id
+      WHERE s.cement_type=$1 AND p.archived_at IS NULL
+      ORDER BY s.available_tons DESC
+      LIMIT 1
+    `, body.CementType).Scan(&body.PlantID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", "no plant stock for cement type")
+			return
+		}
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+	}
+	var plat, plng float64
+	if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM plants WHERE id=$1 AND archived_at IS NULL FOR UPDATE`, body.PlantID).Scan(&plat, &plng); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid plant")
+		return
+	}
+	// A plant with no production or dispatches of this type has no row: 0 tons.
+	var available float64
+	err = tx.QueryRow(r.Context(), `SELECT available_tons FROM plant_stock WHERE plant_id=$1 AND cement_type=$2`, body.PlantID, body.CementType).Scan(&available)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		writeDBError(w, err)
+		return
+	}
+	if available < body.QuantityTons {
+		writeAPIError(w, http.StatusConflict, "INSUFFICIENT_STOCK", fmt.Sprintf("plant holds only %.1f tons of %s", available, body.CementType))
+		return
+	}
+	var wlat, wlng float64
+	if err := tx.QueryRow(r.Context(), `SELECT lat,lng FROM warehouses WHERE id=$1 AND archived_at IS NULL FOR SHARE`, body.ToWarehouseID).Scan(&wlat, &wlng); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid warehouse")
+		return
+	}
+
+	departAt := time.Now().UTC().Add(45 * time.Minute)
+	if body.DepartAt != nil {
+		departAt = body.DepartAt.UTC()
+	}
+	eta := departAt.Add(time.Duration(estimateTravelMinutes(plat, plng, wlat, wlng)) * time.Minute)
+
+	var dispatchID int64
+	if err := tx.QueryRow(r.Context(), `
+    INSERT INTO plant_dispatches (plant_id, to_warehouse_id, cement_type, quantity_tons, status, truck_id, reason, depart_at, arrive_eta, created_by_user_id)
+    VALUES ($1,$2,$3,$4,'SCHEDULED',$5,$6,$7,$8,$9)
+    RETURNING id
+  `, body.PlantID, body.ToWarehouseID, body.CementType, body.QuantityTons, body.TruckID, body.Reason, departAt, eta, u.ID).Scan(&dispatchID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "PLANT_DISPATCH_CREATED", "plant_dispatch", fmt.Sprintf("%d", dispatchID), map[string]any{
+		"plantId":       body.PlantID,
+		"toWarehouseId": body.ToWarehouseID,
+		"cementType":    body.CementType,
+		"quantityTons":  body.QuantityTons,
+		"reason":        body.Reason,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"ok": true, "dispatchId": dispatchID, "plantId": body.PlantID, "arriveEta": eta})
+}
+
+// handleOpsUpdateDispatchStatus follows the same lifecycle as transfers:
+//
+//	SCHEDULED  -> IN_TRANSIT|CANCELLED
+//	IN_TRANSIT -> RECEIVED
+//
+// Receiving posts IN at the warehouse. Cancelling needs no movement: a
+// cancelled dispatch no longer counts against the plant's stock. Once the
+// truck has left, the tons are on the road and can only be received.
+func (a *App) handleOpsUpdateDispatchStatus(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Status = strings.TrimSpace(strings.ToUpper(body.Status))
+	if body.Status != "IN_TRANSIT" && body.Status != "RECEIVED" && body.Status != "CANCELLED" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be IN_TRANSIT|RECEIVED|CANCELLED")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var plantID, toID int64
+	var status, ct string
+	var qty float64
+	var depart *time.Time
+	var plat, plng, wlat, wlng float64
+	if err := tx.QueryRow(r.Context(), `
+    SELECT d.plant_id, d.to_warehouse_id, d.status, d.cement_type, d.quantity_tons, d.depart_at,
+           p.lat, p.lng, w.lat, w.lng
+    FROM plant_dispatches d
+    JOIN plants p ON p.id = d.plant_id
+    JOIN warehouses w ON w.id = d.to_warehouse_id
+    WHERE d.id=$1
+    FOR UPDATE OF d
+  `, id).Scan(&plantID, &toID, &status, &ct, &qty, &depart, &plat, &plng, &wlat, &wlng); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "dispatch not found")
+		return
+	}
+	if !scopeFrom(r).allowsWarehouse(toID) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "dispatch is outside your scope")
+		return
+	}
+	allowedNext := map[string]map[string]bool{
+		"SCHEDULED":  {"IN_TRANSIT": true, "CANCELLED": true},
+		"IN_TRANSIT": {"RECEIVED": true},
+	}
+	if !allowedNext[status][body.Status] {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", fmt.Sprintf("invalid transition %s -> %s", status, body.Status))
+		return
+	}
+
+	ref := fmt.Sprintf("%d", id)
+	now := time.Now().UTC()
+	switch body.Status {
+	case "IN_TRANSIT":
+		if depart == nil || depart.After(now) {
+			depart = &now
+		}
+		eta := depart.Add(time.Duration(estimateTravelMinutes(plat, plng, wlat, wlng)) * time.Minute)
+		_, err = tx.Exec(r.Context(), `
+      UPDATE plant_dispatches SET status='IN_TRANSIT', depart_at=$1, arrive_eta=$2, updated_at=now() WHERE id=$3
+    `, depart, eta, id)
+	case "RECEIVED":
+		if _, err = moveStockTx(r.Context(), tx, &u.ID, toID, ct, qty, fmt.Sprintf("Plant dispatch #%d received", id), "plant_dispatch", ref, map[string]any{"plantId": plantID}); err == nil {
+			_, err = tx.Exec(r.Context(), `
+        UPDATE plant_dispatches SET status='RECEIVED', received_at=now(), received_by_user_id=$1, updated_at=now() WHERE id=$2
+      `, u.ID, id)
+		}
+	case "CANCELLED":
+		_, err = tx.Exec(r.Context(), `UPDATE plant_dispatches SET status='CANCELLED', updated_at=now() WHERE id=$1`, id)
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	if err := a.insertAuditLogTx(tx, r, &u, "PLANT_DISPATCH_STATUS_UPDATED", "plant_dispatch", ref, map[string]any{"from": status, "status": body.Status, "reason": body.Reason}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": body.Status})
+}
+
+// ---------- admin: distributors CRUD ----------
+
+func (a *App) handleAdminListDistributors(w http.ResponseWriter, r *http.Request) {
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("includeArchived"))
+	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, service_radius_km, archived_at FROM distributors WHERE ($1 OR archived_at IS NULL) ORDER BY id`, includeArchived)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var lat, lng, rad float64
+		var archivedAt *time.Time
+		_ = rows.Scan(&id, &name, &lat, &lng, &rad, &archivedAt)
+		items = append(items, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "serviceRadiusKm": rad, "archivedAt": archivedAt})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleAdminCreateDistributor(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Name            string  `json:"name"`
+		Lat             float64 `json:"lat"`
+		Lng             float64 `json:"lng"`
+		ServiceRadiusKm float64 `json:"serviceRadiusKm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	if body.ServiceRadiusKm <= 0 {
+		body.ServiceRadiusKm = 10
+	}
+	var id int64
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	err = tx.QueryRow(r.Context(),
+		`INSERT INTO distributors (name, lat, lng, service_radius_km) VALUES ($1,$2,$3,$4) RETURNING id`,
+		body.Name, body.Lat, body.Lng, body.ServiceRadiusKm).Scan(&id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+}
+
+func (a *App) handleAdminUpdateDistributor(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Name            string  `json:"name"`
+		Lat             float64 `json:"lat"`
+		Lng             float64 `json:"lng"`
+		ServiceRadiusKm float64 `json:"serviceRadiusKm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	if body.ServiceRadiusKm <= 0 {
+		body.ServiceRadiusKm = 10
+	}
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(),
+		`UPDATE distributors SET name=$1, lat=$2, lng=$3, service_radius_km=$4 WHERE id=$5`,
+		body.Name, body.Lat, body.Lng, body.ServiceRadiusKm, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleAdminDeleteDistributor archives the distributor; see archiveEntity.
+func (a *App) handleAdminDeleteDistributor(w http.ResponseWriter, r *http.Request) {
+	a.archiveEntity(w, r, "distributors")
+}
+
+// ---------- admin: stores CRUD ----------
+
+func (a *App) handleAdminListStores(w http.ResponseWriter, r *http.Request) {
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("includeArchived"))
+	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, archived_at FROM stores WHERE ($1 OR archived_at IS NULL) ORDER BY id`, includeArchived)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var lat, lng float64
+		var archivedAt *time.Time
+		_ = rows.Scan(&id, &name, &lat, &lng, &archivedAt)
+		items = append(items, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "archivedAt": archivedAt})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleAdminCreateStore(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Name string  `json:"name"`
+		Lat  float64 `json:"lat"`
+		Lng  float64 `json:"lng"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	var id int64
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	err = tx.QueryRow(r.Context(),
+		`INSERT INTO stores (name, lat, lng) VALUES ($1,$2,$3) RETURNING id`,
+		body.Name, body.Lat, body.Lng).Scan(&id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+}
+
+func (a *App) handleAdminUpdateStore(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Name string  `json:"name"`
+		Lat  float64 `json:"lat"`
+		Lng  float64 `json:"lng"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(),
+		`UPDATE stores SET name=$1, lat=$2, lng=$3 WHERE id=$4`,
+		body.Name, body.Lat, body.Lng, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "store not found")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleAdminDeleteStore archives the store; see archiveEntity.
+func (a *App) handleAdminDeleteStore(w http.ResponseWriter, r *http.Request) {
+	a.archiveEntity(w, r, "stores")
+}
+
+// ---------- admin: projects CRUD ----------
+
+func (a *App) handleAdminListProjects(w http.ResponseWriter, r *http.Request) {
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("includeArchived"))
+	rows, err := a.db.Query(r.Context(), `SELECT id, name, type, lat, lng, start_date, end_date, demand_tons_month, archived_at FROM projects WHERE ($1 OR archived_at IS NULL) ORDER BY id`, includeArchived)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name, ptype string
+		var lat, lng, demand float64
+		var startDate, endDate time.Time
+		var archivedAt *time.Time
+		_ = rows.Scan(&id, &name, &ptype, &lat, &lng, &startDate, &endDate, &demand, &archivedAt)
+		items = append(items, map[string]any{
+			"id": id, "name": name, "type": ptype,
+			"lat": lat, "lng": lng,
+			"startDate":       startDate.Format("2006-01-02"),
+			"endDate":         endDate.Format("2006-01-02"),
+			"demandTonsMonth": demand,
+			"archivedAt":      archivedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleAdminCreateProject(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Name            string  `json:"name"`
+		Type            string  `json:"type"`
+		Lat             float64 `json:"lat"`
+		Lng             float64 `json:"lng"`
+		StartDate       string  `json:"startDate"`
+		EndDate         string  `json:"endDate"`
+		DemandTonsMonth float64 `json:"demandTonsMonth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	if body.Type == "" {
+		body.Type = "CONSTRUCTION"
+	}
+	if body.StartDate == "" {
+		body.StartDate = time.Now().Format("2006-01-02")
+	}
+	if body.EndDate == "" {
+		body.EndDate = time.Now().AddDate(0, 6, 0).Format("2006-01-02")
+	}
+	var id int64
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	err = tx.QueryRow(r.Context(),
+		`INSERT INTO projects (name, type, lat, lng, start_date, end_date, demand_tons_month) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id`,
+		body.Name, body.Type, body.Lat, body.Lng, body.StartDate, body.EndDate, body.DemandTonsMonth).Scan(&id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+}
+
+func (a *App) handleAdminUpdateProject(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Name            string  `json:"name"`
+		Type            string  `json:"type"`
+		Lat             float64 `json:"lat"`
+		Lng             float64 `json:"lng"`
+		StartDate       string  `json:"startDate"`
+		EndDate         string  `json:"endDate"`
+		DemandTonsMonth float64 `json:"demandTonsMonth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(),
+		`UPDATE projects SET name=$1, type=$2, lat=$3, lng=$4, start_date=$5, end_date=$6, demand_tons_month=$7 WHERE id=$8`,
+		body.Name, body.Type, body.Lat, body.Lng, body.StartDate, body.EndDate, body.DemandTonsMonth, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleAdminDeleteProject archives the project; see archiveEntity.
+func (a *App) handleAdminDeleteProject(w http.ResponseWriter, r *http.Request) {
+	a.archiveEntity(w, r, "projects")
+}
+
+// ---------- admin: users ----------
+
+func (a *App) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `
+    SELECT
+      u.id,
+      u.name,
+      u.email,
+      u.role,
+      u.distributor_id,
+      u.disabled_at,
+      COALESCE(MAX(s.created_at), NULL) AS last_login_at
+    FROM users u
+    LEFT JOIN sessions s ON s.user_id = u.id
+    GROUP BY u.id
+    ORDER BY u.id
+  `)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name, email, role string
+		var distributorID sql.NullInt64
+		var disabledAt sql.NullTime
+		var lastLoginAt sql.NullTime
+		_ = rows.Scan(&id, &name, &email, &role, &distributorID, &disabledAt, &lastLoginAt)
+
+		status := "ACTIVE"
+		if disabledAt.Valid {
+			status = "DISABLED"
+		}
+		last := ""
+		if lastLoginAt.Valid {
+			last = lastLoginAt.Time.Format("2006-01-02 15:04")
+		}
+		var dist any = nil
+		if distributorID.Valid {
+			dist = fmt.Sprintf("%d", distributorID.Int64)
+		}
+		items = append(items, map[string]any{
+			"id":            fmt.Sprintf("%d", id),
+			"name":          name,
+			"email":         email,
+			"role":          role,
+			"distributorId": dist,
+			"status":        status,
+			"lastLoginAt":   last,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		Password      string `json:"password"`
+		Role          string `json:"role"`
+		DistributorID *int64 `json:"distributorId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	body.Email = strings.TrimSpace(strings.ToLower(body.Email))
+	body.Role = strings.TrimSpace(body.Role)
+	if body.Name == "" || body.Email == "" || !strings.Contains(body.Email, "@") {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name and valid email required")
+		return
+	}
+	if strings.TrimSpace(body.Password) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "password required")
+		return
+	}
+	kind, err := a.roleKind(r.Context(), body.Role)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if kind == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid role")
+		return
+	}
+	if !a.mayAssignRole(w, r, body.Role, kind) {
+		return
+	}
+	if kind == roleKindDistributor && body.DistributorID == nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "distributorId required for distributor roles")
+		return
+	}
+	if kind != roleKindDistributor {
+		body.DistributorID = nil
+	}
+
+	hash, ok := a.hashPassword(w, r, body.Password, body.Email, body.Name)
+	if !ok {
+		return
+	}
+
+	var id int64
+	err = a.db.QueryRow(r.Context(), `
+    INSERT INTO users (name, email, password_hash, role, distributor_id)
+    VALUES ($1,$2,$3,$4,$5)
+    RETURNING id
+  `, body.Name, body.Email, hash, body.Role, body.DistributorID).Scan(&id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{"id": fmt.Sprintf("%d", id)})
+}
+
+func (a *App) handleAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		Role          string `json:"role"`
+		DistributorID *int64 `json:"distributorId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	body.Email = strings.TrimSpace(strings.ToLower(body.Email))
+	body.Role = strings.TrimSpace(body.Role)
+	if body.Name == "" || body.Email == "" || !strings.Contains(body.Email, "@") {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name and valid email required")
+		return
+	}
+	kind, err := a.roleKind(r.Context(), body.Role)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if kind == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid role")
+		return
+	}
+	if !a.mayManageUser(w, r, id) || !a.mayAssignRole(w, r, body.Role, kind) {
+		return
+	}
+	if kind == roleKindDistributor && body.DistributorID == nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "distributorId required for distributor roles")
+		return
+	}
+	if kind != roleKindDistributor {
+		body.DistributorID = nil
+	}
+
+	tag, err := a.db.Exec(r.Context(), `UPDATE users SET name=$1, email=$2, role=$3, distributor_id=$4 WHERE id=$5`, body.Name, body.Email, body.Role, body.DistributorID, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	if !a.mayManageUser(w, r, id) {
+		return
+	}
+	tag, err := a.db.Exec(r.Context(), `DELETE FROM users WHERE id=$1`, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) handleAdminUpdateUserStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Status = strings.TrimSpace(body.Status)
+	if body.Status != "ACTIVE" && body.Status != "DISABLED" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be ACTIVE|DISABLED")
+		return
+	}
+	if !a.mayManageUser(w, r, id) {
+		return
+	}
+	var tag pgconn.CommandTag
+	if body.Status == "DISABLED" {
+		// Disabling also ends every session of the user in the same statement.
+		tag, err = a.db.Exec(r.Context(), `
+      WITH revoked AS (DELETE FROM sessions WHERE user_id=$1)
+      UPDATE users SET disabled_at = now() WHERE id=$1
+    `, id)
+	} else {
+		tag, err = a.db.Exec(r.Context(), `UPDATE users SET disabled_at = NULL WHERE id=$1`, id)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) handleAdminResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	if !a.mayManageUser(w, r, id) {
+		return
+	}
+	temp, err := a.passwords.Temporary()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "could not generate password")
+		return
+	}
+	hash, ok := a.hashPassword(w, r, temp)
+	if !ok {
+		return
+	}
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var name, email string
+	if err := tx.QueryRow(r.Context(), `UPDATE users SET password_hash=$1, password_changed_at=now() WHERE id=$2 RETURNING name, email`, hash, id).Scan(&name, &email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM sessions WHERE user_id=$1`, id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `UPDATE password_reset_tokens SET used_at=now() WHERE user_id=$1 AND used_at IS NULL`, id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := mailer.Enqueue(r.Context(), tx, email, mailer.TemplatePasswordReset, map[string]any{
+		"Name":         name,
+		"Email":        email,
+		"TempPassword": temp,
+	}); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "tempPassword": temp, "emailQueued": true})
+}
+
+func (a *App) handleAdminRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var exists bool
+	if err := a.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)`, id).Scan(&exists); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+		return
+	}
+	if !a.mayManageUser(w, r, id) {
+		return
+	}
+	tag, err := a.db.Exec(r.Context(), `DELETE FROM sessions WHERE user_id=$1`, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	a.insertAuditLog(r, &u, "SESSIONS_REVOKED", "user", fmt.Sprintf("%d", id), map[string]any{"revoked": tag.RowsAffected()})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": tag.RowsAffected()})
+}
+
+// handleAdminResetUserMFA removes a user's authenticator and recovery codes,
+// e.g. after a lost phone. Their sessions end as well; if their role requires
+// 2FA they will enroll again at next login.
+func (a *App) handleAdminResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	if !a.mayManageUser(w, r, id) {
+		return
+	}
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `DELETE FROM user_mfa WHERE user_id=$1`, id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "two-factor authentication not set up for user")
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM user_recovery_codes WHERE user_id=$1`, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM sessions WHERE user_id=$1`, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "MFA_RESET", "user", fmt.Sprintf("%d", id), map[string]any{}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ---------- admin: user scope ----------
+
+func (a *App) handleAdminGetUserScope(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var exists bool
+	if err := a.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)`, id).Scan(&exists); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+		return
+	}
+	sc, err := a.loadUserScope(r.Context(), id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sc)
+}
+
+// handleAdminPutUserScope replaces a user's warehouse and distributor
+// assignments. An empty or null list lifts the restriction on that axis.
+func (a *App) handleAdminPutUserScope(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	var body userScope
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	slices.Sort(body.WarehouseIDs)
+	body.WarehouseIDs = slices.Compact(body.WarehouseIDs)
+	slices.Sort(body.DistributorIDs)
+	body.DistributorIDs = slices.Compact(body.DistributorIDs)
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var kind string
+	if err := tx.QueryRow(r.Context(), `
+    SELECT ro.kind FROM users u JOIN roles ro ON ro.key = u.role WHERE u.id=$1
+  `, id).Scan(&kind); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+			return
+		}
+		writeDBError(w, err)
+		return
+	}
+	if kind == roleKindDistributor {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "distributor users are scoped by distributorId")
+		return
+	}
+
+	var known int
+	if err := tx.QueryRow(r.Context(), `SELECT COUNT(*) FROM warehouses WHERE id = ANY($1) AND archived_at IS NULL`, body.WarehouseIDs).Scan(&known); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if known != len(body.WarehouseIDs) {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "unknown warehouseIds")
+		return
+	}
+	if err := tx.QueryRow(r.Context(), `SELECT COUNT(*) FROM distributors WHERE id = ANY($1) AND archived_at IS NULL`, body.DistributorIDs).Scan(&known); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if known != len(body.DistributorIDs) {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "unknown distributorIds")
+		return
+	}
+
+	if _, err := tx.Exec(r.Context(), `DELETE FROM user_warehouse_scopes WHERE user_id=$1`, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM user_distributor_scopes WHERE user_id=$1`, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+    INSERT INTO user_warehouse_scopes (user_id, warehouse_id)
+    SELECT $1, unnest($2::bigint[])
+  `, id, body.WarehouseIDs); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+    INSERT INTO user_distributor_scopes (user_id, distributor_id)
+    SELECT $1, unnest($2::bigint[])
+  `, id, body.DistributorIDs); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "USER_SCOPE_UPDATED", "user", fmt.Sprintf("%d", id), map[string]any{
+		"warehouseIds":   body.WarehouseIDs,
+		"distributorIds": body.DistributorIDs,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+
+	sc, err := a.loadUserScope(r.Context(), id)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sc)
+}
+
+// ---------- admin: roles ----------
+
+// roleKind returns the kind of the role with the given key, or "" if there is none.
+func (a *App) roleKind(ctx context.Context, key string) (string, error) {
+	var kind string
+	err := a.db.QueryRow(ctx, `SELECT kind FROM roles WHERE key=$1`, key).Scan(&kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return kind, err
+}
+
+// mayManageUser reports whether the acting user may change the account id.
+// Only administrators may touch an ADMIN-kind account; otherwise anyone with
+// Administration rights could lock out or take over the administrators. It
+// writes the error response itself.
+func (a *App) mayManageUser(w http.ResponseWriter, r *http.Request, id int64) bool {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	if u.RoleKind == roleKindAdmin {
+		return true
+	}
+	var kind string
+	err := a.db.QueryRow(r.Context(), `SELECT ro.kind FROM users u JOIN roles ro ON ro.key = u.role WHERE u.id=$1`, id).Scan(&kind)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeDBError(w, err)
+		return false
+	}
+	if kind == roleKindAdmin {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "only administrators can manage administrator accounts")
+		return false
+	}
+	return true
+}
+
+// mayAssignRole reports whether the acting user may put an account into role.
+// Administrators may assign any role. Anyone else may not assign an ADMIN-kind
+// role, which bypasses the matrix, nor one with permissions they lack
+// themselves. It writes the error response itself.
+func (a *App) mayAssignRole(w http.ResponseWriter, r *http.Request, role, kind string) bool {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	if u.RoleKind == roleKindAdmin {
+		return true
+	}
+	if kind == roleKindAdmin {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "only administrators can assign administrator roles")
+		return false
+	}
+	own, err := loadRoleConfig(r.Context(), a.db, u.Role)
+	if err != nil {
+		writeDBError(w, err)
+		return false
+	}
+	cfg, err := loadRoleConfig(r.Context(), a.db, role)
+	if err != nil {
+		writeDBError(w, err)
+		return false
+	}
+	if p := rbacGrantBeyond(own, rbacConfig{}, cfg); p != "" {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("role grants %s, which your role does not have", p))
+		return false
+	}
+	return true
+}
+
+// roleKeys returns the set of defined role keys.
+func (a *App) roleKeys(ctx context.Context) (map[string]bool, error) {
+	rows, err := a.db.Query(ctx, `SELECT key FROM roles`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := map[string]bool{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
+
+func (a *App) handleAdminListRoles(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `
+    SELECT ro.key, ro.name, ro.description, ro.kind, ro.system, ro.updated_at,
+           (SELECT COUNT(*) FROM users u WHERE u.role = ro.key)
+    FROM roles ro
+    ORDER BY ro.system DESC, ro.name
+  `)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var key, name, description, kind string
+		var system bool
+		var updated time.Time
+		var userCount int64
+		if err := rows.Scan(&key, &name, &description, &kind, &system, &updated, &userCount); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		items = append(items, map[string]any{
+			"key":         key,
+			"name":        name,
+			"description": description,
+			"kind":        kind,
+			"system":      system,
+			"userCount":   userCount,
+			"updatedAt":   updated.Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// handleAdminCreateRole creates a custom role together with its rbac_config
+// document. The permissions start empty unless copyFrom names a role to clone.
+func (a *App) handleAdminCreateRole(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Key         string `json:"key"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Kind        string `json:"kind"`
+		CopyFrom    string `json:"copyFrom"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Key = strings.TrimSpace(strings.ToUpper(body.Key))
+	body.Name = strings.TrimSpace(body.Name)
+	body.Description = strings.TrimSpace(body.Description)
+	body.Kind = strings.TrimSpace(strings.ToUpper(body.Kind))
+	if body.Kind == "" {
+		body.Kind = roleKindStaff
+	}
+	if !roleKeyPattern.MatchString(body.Key) {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "key must be 2-40 chars of A-Z, 0-9 or _ starting with a letter")
+		return
+	}
+	if body.Name == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	// ADMIN bypasses the permission matrix entirely and stays reserved for SUPER_ADMIN.
+	if body.Kind != roleKindStaff && body.Kind != roleKindDistributor {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "kind must be STAFF|DISTRIBUTOR")
+		return
+	}
+
+	cfg := rbacConfig{Permissions: map[string]rbacActions{}, Sidebar: []string{"Dashboard"}}
+	for _, module := range rbacModules {
+		cfg.Permissions[module] = rbacActions{}
+	}
+	if body.Kind == roleKindDistributor {
+		cfg.Sidebar = append(cfg.Sidebar, "Distributor")
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	if body.CopyFrom != "" {
+		var raw json.RawMessage
+		if err := tx.QueryRow(r.Context(), `SELECT config FROM rbac_config WHERE role=$1`, body.CopyFrom).Scan(&raw); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "copyFrom role not found")
+			return
+		}
+		if cfg, err = parseRBACConfig(raw); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "copyFrom role has an invalid config: "+err.Error())
+			return
+		}
+		if !a.mayEditRBAC(w, r, tx, body.Key, cfg) {
+			return
+		}
+	}
+
+	tag, err := tx.Exec(r.Context(), `
+    INSERT INTO roles (key, name, description, kind)
+    VALUES ($1,$2,$3,$4)
+    ON CONFLICT (key) DO NOTHING
+  `, body.Key, body.Name, body.Description, body.Kind)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "role key already exists")
+		return
+	}
+	if _, _, err := saveRBACConfig(r.Context(), tx, &u, body.Key, cfg, nil); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "ROLE_CREATED", "role", body.Key, map[string]any{"name": body.Name, "kind": body.Kind, "copyFrom": body.CopyFrom}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	a.rbac.invalidate()
+	writeJSON(w, http.StatusCreated, map[string]any{"key": body.Key})
+}
+
+func (a *App) handleAdminUpdateRole(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	key := strings.TrimSpace(chi.URLParam(r, "key"))
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	body.Description = strings.TrimSpace(body.Description)
+	if body.Name == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `
+    UPDATE roles SET name=$1, description=$2, updated_at=now() WHERE key=$3
+  `, body.Name, body.Description, key)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "role not found")
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "ROLE_UPDATED", "role", key, map[string]any{"name": body.Name, "description": body.Description}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
 
-	rows, err := a.db.Query(r.Context(), q, args...)
+func (a *App) handleAdminDeleteRole(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	key := strings.TrimSpace(chi.URLParam(r, "key"))
+
+	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
+	defer func() { _ = tx.Rollback(r.Context()) }()
 
-	items := []map[string]any{}
-	for rows.Next() {
-		var id int64
-		var it, sev, st string
-		var title, desc string
-		var shipmentID *int64
-		var warehouseID *int64
-		var warehouseName *string
-		var distributorID *int64
-		var distributorName *string
-		var reportedByID *int64
-		var reportedByName *string
-		var reportedAt time.Time
-		var resolvedByID *int64
-		var resolvedByName *string
-		var resolvedAt *time.Time
-		var resolutionNotes string
-		var metadata json.RawMessage
-		var createdAt, updatedAt time.Time
+	var system bool
+	if err := tx.QueryRow(r.Context(), `SELECT system FROM roles WHERE key=$1 FOR UPDATE`, key).Scan(&system); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "role not found")
+		return
+	}
+	if system {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "built-in roles cannot be deleted")
+		return
+	}
+	var assigned int64
+	if err := tx.QueryRow(r.Context(), `SELECT COUNT(*) FROM users WHERE role=$1`, key).Scan(&assigned); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if assigned > 0 {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", fmt.Sprintf("role is assigned to %d user(s)", assigned))
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM roles WHERE key=$1`, key); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "ROLE_DELETED", "role", key, nil); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	a.rbac.invalidate()
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
 
-		_ = rows.Scan(
-			&id, &it, &sev, &st,
-			&title, &desc,
-			&shipmentID,
-			&warehouseID, &warehouseName,
-			&distributorID, &distributorName,
-			&reportedByID, &reportedByName,
-			&reportedAt,
-			&resolvedByID, &resolvedByName,
-			&resolvedAt, &resolutionNotes,
-			&metadata,
-			&createdAt, &updatedAt,
-		)
+// ---------- admin: rbac ----------
 
-		wh := map[string]any{"id": nil, "name": nil}
-		if warehouseID != nil {
-			wh["id"] = *warehouseID
-			if warehouseName != nil {
-				wh["name"] = *warehouseName
-			}
+var roleKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{1,39}$`)
+
+// rbacModules are the permission-matrix rows enforced by requirePermission.
+var rbacModules = []string{"Planning", "Operations", "Executive", "Administration"}
+
+// rbacSidebarEntries are the sidebar sections the web app knows how to render.
+var rbacSidebarEntries = []string{"Dashboard", "Planning", "Operations", "Distributor", "Executive", "Administration"}
+
+type rbacActions struct {
+	View   bool `json:"view"`
+	Create bool `json:"create"`
+	Edit   bool `json:"edit"`
+	Delete bool `json:"delete"`
+}
+
+func (x rbacActions) allows(action string) bool {
+	switch action {
+	case "view":
+		return x.View
+	case "create":
+		return x.Create
+	case "edit":
+		return x.Edit
+	case "delete":
+		return x.Delete
+	}
+	return false
+}
+
+// rbacConfig is the document stored in rbac_config.config.
+type rbacConfig struct {
+	Permissions map[string]rbacActions `json:"permissions"`
+	Sidebar     []string               `json:"sidebar"`
+}
+
+// parseRBACConfig strictly decodes and validates an rbac_config document:
+// unknown fields (e.g. a misspelt action) are rejected and every module must be present.
+func parseRBACConfig(raw []byte) (rbacConfig, error) {
+	var c rbacConfig
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return rbacConfig{}, fmt.Errorf("invalid config: %v", err)
+	}
+	if c.Permissions == nil {
+		return rbacConfig{}, errors.New("permissions is required")
+	}
+	for module := range c.Permissions {
+		if !containsString(rbacModules, module) {
+			return rbacConfig{}, fmt.Errorf("unknown module %q in permissions", module)
 		}
-		di := map[string]any{"id": nil, "name": nil}
-		if distributorID != nil {
-			di["id"] = *distributorID
-			if distributorName != nil {
-				di["name"] = *distributorName
+	}
+	for _, module := range rbacModules {
+		if _, ok := c.Permissions[module]; !ok {
+			return rbacConfig{}, fmt.Errorf("permissions.%s is required", module)
+		}
+	}
+	if c.Sidebar == nil {
+		c.Sidebar = []string{}
+	}
+	seen := map[string]bool{}
+	for _, entry := range c.Sidebar {
+		if !containsString(rbacSidebarEntries, entry) {
+			return rbacConfig{}, fmt.Errorf("unknown sidebar entry %q", entry)
+		}
+		if seen[entry] {
+			return rbacConfig{}, fmt.Errorf("duplicate sidebar entry %q", entry)
+		}
+		seen[entry] = true
+	}
+	return c, nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// diffRBACConfig lists the permission flips and sidebar changes between two documents.
+func diffRBACConfig(prev, next rbacConfig) []map[string]any {
+	changes := []map[string]any{}
+	for _, module := range rbacModules {
+		p, n := prev.Permissions[module], next.Permissions[module]
+		for _, action := range []string{"view", "create", "edit", "delete"} {
+			if p.allows(action) != n.allows(action) {
+				changes = append(changes, map[string]any{
+					"path": "permissions." + module + "." + action,
+					"from": p.allows(action),
+					"to":   n.allows(action),
+				})
 			}
 		}
-		reportedBy := map[string]any{"id": nil, "name": nil}
-		if reportedByID != nil {
-			reportedBy["id"] = *reportedByID
-			if reportedByName != nil {
-				reportedBy["name"] = *reportedByName
+	}
+	for _, entry := range next.Sidebar {
+		if !containsString(prev.Sidebar, entry) {
+			changes = append(changes, map[string]any{"path": "sidebar", "added": entry})
+		}
+	}
+	for _, entry := range prev.Sidebar {
+		if !containsString(next.Sidebar, entry) {
+			changes = append(changes, map[string]any{"path": "sidebar", "removed": entry})
+		}
+	}
+	return changes
+}
+
+// dbQueryer is satisfied by both the pool and a pgx.Tx.
+type dbQueryer interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// loadRoleConfig returns a role's live rbac_config document. A role without
+// one, or with a malformed one, has no permissions, as in loadRBAC.
+func loadRoleConfig(ctx context.Context, q dbQueryer, role string) (rbacConfig, error) {
+	var raw json.RawMessage
+	err := q.QueryRow(ctx, `SELECT config FROM rbac_config WHERE role=$1`, role).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rbacConfig{}, nil
+	}
+	if err != nil {
+		return rbacConfig{}, err
+	}
+	var c rbacConfig
+	_ = json.Unmarshal(raw, &c)
+	return c, nil
+}
+
+// rbacGrantBeyond returns the first permission, as "Module.action", that next
+// grants on top of prev and own does not hold, or "".
+func rbacGrantBeyond(own, prev, next rbacConfig) string {
+	for _, module := range rbacModules {
+		for _, action := range []string{"view", "create", "edit", "delete"} {
+			if next.Permissions[module].allows(action) && !prev.Permissions[module].allows(action) && !own.Permissions[module].allows(action) {
+				return module + "." + action
 			}
 		}
-		resolvedBy := map[string]any{"id": nil, "name": nil}
-		if resolvedByID != nil {
-			resolvedBy["id"] = *resolvedByID
-			if resolvedByName != nil {
-				resolvedBy["name"] = *resolvedByName
-			}
+	}
+	return ""
+}
+
+// mayEditRBAC reports whether the acting user may store cfg as role's matrix.
+// Administrators may change any role. Anyone else may not change their own
+// role, and may only grant permissions their role holds, so Administration
+// rights cannot be turned into every other module. It writes the error
+// response itself.
+func (a *App) mayEditRBAC(w http.ResponseWriter, r *http.Request, tx pgx.Tx, role string, cfg rbacConfig) bool {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	if u.RoleKind == roleKindAdmin {
+		return true
+	}
+	if role == u.Role {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "you cannot change the permissions of your own role")
+		return false
+	}
+	own, err := loadRoleConfig(r.Context(), tx, u.Role)
+	if err != nil {
+		writeDBError(w, err)
+		return false
+	}
+	prev, err := loadRoleConfig(r.Context(), tx, role)
+	if err != nil {
+		writeDBError(w, err)
+		return false
+	}
+	if p := rbacGrantBeyond(own, prev, cfg); p != "" {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("you cannot grant %s, which your role does not have", p))
+		return false
+	}
+	return true
+}
+
+// saveRBACConfig stores cfg as the role's live document and appends it as the next
+// version. It returns the new version and the diff; no version is written when
+// nothing changed.
+func saveRBACConfig(ctx context.Context, tx pgx.Tx, actor *User, role string, cfg rbacConfig, rolledBackFrom *int) (int, []map[string]any, error) {
+	var current int
+	var prevRaw json.RawMessage
+	err := tx.QueryRow(ctx, `SELECT version, config FROM rbac_config WHERE role=$1 FOR UPDATE`, role).Scan(&current, &prevRaw)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, err
+	}
+	var prev rbacConfig
+	_ = json.Unmarshal(prevRaw, &prev)
+
+	changes := diffRBACConfig(prev, cfg)
+	if len(changes) == 0 && current > 0 {
+		return current, changes, nil
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return 0, nil, err
+	}
+	next := current + 1
+	var actorID *int64
+	if actor != nil {
+		actorID = &actor.ID
+	}
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO rbac_config (role, config, version, updated_at)
+    VALUES ($1,$2::jsonb,$3,now())
+    ON CONFLICT (role) DO UPDATE SET config=EXCLUDED.config, version=EXCLUDED.version, updated_at=now()
+  `, role, string(b), next); err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO rbac_config_versions (role, version, config, created_by_user_id, rolled_back_from)
+    VALUES ($1,$2,$3::jsonb,$4,$5)
+  `, role, next, string(b), actorID, rolledBackFrom); err != nil {
+		return 0, nil, err
+	}
+	return next, changes, nil
+}
+
+func (a *App) handleAdminGetRBAC(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `SELECT role, config, version, mfa_required, updated_at FROM rbac_config ORDER BY role`)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var role string
+		var config json.RawMessage
+		var version int
+		var mfaRequired bool
+		var updated time.Time
+		_ = rows.Scan(&role, &config, &version, &mfaRequired, &updated)
+		items = append(items, map[string]any{
+			"role":        role,
+			"config":      config,
+			"version":     version,
+			"mfaRequired": mfaRequired,
+			"updatedAt":   updated.Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *App) handleAdminPutRBAC(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	role := strings.TrimSpace(chi.URLParam(r, "role"))
+	if kind, err := a.roleKind(r.Context(), role); err != nil {
+		writeDBError(w, err)
+		return
+	} else if kind == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid role")
+		return
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid body")
+		return
+	}
+	cfg, err := parseRBACConfig(raw)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	if !a.mayEditRBAC(w, r, tx, role, cfg) {
+		return
+	}
+	version, changes, err := saveRBACConfig(r.Context(), tx, &u, role, cfg, nil)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if len(changes) > 0 {
+		if err := a.insertAuditLogTx(tx, r, &u, "RBAC_CONFIG_UPDATED", "rbac_config", role, map[string]any{"version": version, "changes": changes}); err != nil {
+			writeDBError(w, err)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	a.rbac.invalidate()
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "version": version, "changes": changes})
+}
+
+// handleAdminPutRBACMFA sets the role's 2FA policy. It is kept out of the
+// versioned config document since it governs login rather than module access.
+func (a *App) handleAdminPutRBACMFA(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	role := chi.URLParam(r, "role")
+	var body struct {
+		Required *bool `json:"required"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Required == nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "required must be true or false")
+		return
+	}
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `UPDATE rbac_config SET mfa_required=$2, updated_at=now() WHERE role=$1`, role, *body.Required)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "role not found")
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "RBAC_MFA_POLICY_UPDATED", "rbac_config", role, map[string]any{"mfaRequired": *body.Required}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "mfaRequired": *body.Required})
+}
+
+func (a *App) handleAdminListRBACVersions(w http.ResponseWriter, r *http.Request) {
+	role := strings.TrimSpace(chi.URLParam(r, "role"))
+	rows, err := a.db.Query(r.Context(), `
+    SELECT v.version, v.config, v.created_by_user_id, COALESCE(u.name,''), v.rolled_back_from, v.created_at,
+           v.version = COALESCE(c.version, 0)
+    FROM rbac_config_versions v
+    LEFT JOIN users u ON u.id = v.created_by_user_id
+    LEFT JOIN rbac_config c ON c.role = v.role
+    WHERE v.role=$1
+    ORDER BY v.version DESC
+  `, role)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var version int
+		var config json.RawMessage
+		var createdByID sql.NullInt64
+		var createdByName string
+		var rolledBackFrom *int
+		var createdAt time.Time
+		var current bool
+		if err := rows.Scan(&version, &config, &createdByID, &createdByName, &rolledBackFrom, &createdAt, &current); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		createdBy := ""
+		if createdByID.Valid {
+			createdBy = fmt.Sprintf("%d", createdByID.Int64)
 		}
-
 		items = append(items, map[string]any{
-			"id":              id,
-			"issueType":       it,
-			"severity":        sev,
-			"status":          st,
-			"title":           title,
-			"description":     desc,
-			"shipmentId":      shipmentID,
-			"warehouse":       wh,
-			"distributor":     di,
-			"reportedBy":      reportedBy,
-			"reportedAt":      reportedAt,
-			"resolvedBy":      resolvedBy,
-			"resolvedAt":      resolvedAt,
-			"resolutionNotes": resolutionNotes,
-			"metadata":        metadata,
-			"createdAt":       createdAt,
-			"updatedAt":       updatedAt,
+			"version":        version,
+			"config":         config,
+			"createdById":    createdBy,
+			"createdByName":  createdByName,
+			"rolledBackFrom": rolledBackFrom,
+			"createdAt":      createdAt.Format(time.RFC3339),
+			"current":        current,
 		})
 	}
-
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleOpsCreateIssue(w http.ResponseWriter, r *http.Request) {
+// handleAdminRollbackRBAC re-applies an earlier version as a new version, so the
+// history stays append-only.
+func (a *App) handleAdminRollbackRBAC(w http.ResponseWriter, r *http.Request) {
 	u, _ := r.Context().Value(ctxUserKey).(User)
-	var body struct {
-		IssueType     string         `json:"issueType"`
-		Severity      string         `json:"severity"`
-		Title         string         `json:"title"`
-		Description   string         `json:"description"`
-		ShipmentID    *int64         `json:"shipmentId"`
-		WarehouseID   *int64         `json:"warehouseId"`
-		DistributorID *int64         `json:"distributorId"`
-		Metadata      map[string]any `json:"metadata"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	role := strings.TrimSpace(chi.URLParam(r, "role"))
+	target, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || target < 1 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid version")
 		return
 	}
-	issueType := strings.TrimSpace(strings.ToUpper(body.IssueType))
-	severity := strings.TrimSpace(strings.ToUpper(body.Severity))
-	title := strings.TrimSpace(body.Title)
-	desc := strings.TrimSpace(body.Description)
 
-	allowedType := map[string]bool{"DELAY": true, "STOCK_SHORTAGE": true, "FLEET": true, "DAMAGED": true, "OTHER": true}
-	if !allowedType[issueType] {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "issueType must be DELAY|STOCK_SHORTAGE|FLEET|DAMAGED|OTHER")
-		return
-	}
-	if severity == "" {
-		severity = "MED"
-	}
-	allowedSev := map[string]bool{"LOW": true, "MED": true, "HIGH": true}
-	if !allowedSev[severity] {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "severity must be LOW|MED|HIGH")
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if title == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "title required")
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var raw json.RawMessage
+	if err := tx.QueryRow(r.Context(), `SELECT config FROM rbac_config_versions WHERE role=$1 AND version=$2`, role, target).Scan(&raw); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "version not found")
+			return
+		}
+		writeDBError(w, err)
 		return
 	}
-	if body.ShipmentID != nil && *body.ShipmentID <= 0 {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "shipmentId must be positive")
+	// Older versions predate validation, so re-check before making one live again.
+	cfg, err := parseRBACConfig(raw)
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "version cannot be restored: "+err.Error())
 		return
 	}
-	if body.WarehouseID != nil && *body.WarehouseID <= 0 {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "warehouseId must be positive")
+	if !a.mayEditRBAC(w, r, tx, role, cfg) {
 		return
 	}
-	if body.DistributorID != nil && *body.DistributorID <= 0 {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "distributorId must be positive")
+	version, changes, err := saveRBACConfig(r.Context(), tx, &u, role, cfg, &target)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if body.Metadata == nil {
-		body.Metadata = map[string]any{}
+	if len(changes) > 0 {
+		if err := a.insertAuditLogTx(tx, r, &u, "RBAC_CONFIG_ROLLED_BACK", "rbac_config", role, map[string]any{"version": version, "rolledBackFrom": target, "changes": changes}); err != nil {
+			writeDBError(w, err)
+			return
+		}
 	}
-	metaBytes, _ := json.Marshal(body.Metadata)
-
-	var id int64
-	if err := a.db.QueryRow(r.Context(), `
-    INSERT INTO ops_issues (
-      issue_type, severity, status,
-      title, description,
-      shipment_id, warehouse_id, distributor_id,
-      reported_by_user_id, reported_at,
-      resolution_notes,
-      metadata,
-      created_at, updated_at
-    )
-    VALUES ($1,$2,'OPEN',$3,$4,$5,$6,$7,$8,now(),'',$9,now(),now())
-    RETURNING id
-  `, issueType, severity, title, desc, body.ShipmentID, body.WarehouseID, body.DistributorID, u.ID, metaBytes).Scan(&id); err != nil {
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
+	a.rbac.invalidate()
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "version": version, "changes": changes})
+}
 
-	a.insertAuditLog(r, &u, "ISSUE_CREATED", "issue", fmt.Sprintf("%d", id), map[string]any{
-		"issueType":     issueType,
-		"severity":      severity,
-		"shipmentId":    body.ShipmentID,
-		"warehouseId":   body.WarehouseID,
-		"distributorId": body.DistributorID,
-	})
+// ---------- admin: thresholds ----------
 
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "id": id})
+func (a *App) handleAdminListThresholds(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `
+    SELECT t.id, t.warehouse_id, w.name, t.cement_type, t.min_stock, t.safety_stock, t.warning_level, t.critical_level, t.lead_time_days, t.updated_at
+    FROM threshold_settings t
+    JOIN warehouses w ON w.id = t.warehouse_id
+    WHERE w.archived_at IS NULL
+    ORDER BY w.id, t.cement_type
+  `)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id, warehouseID int64
+		var warehouseName, cementType string
+		var minStock, safetyStock, warning, critical float64
+		var lead int
+		var updated time.Time
+		_ = rows.Scan(&id, &warehouseID, &warehouseName, &cementType, &minStock, &safetyStock, &warning, &critical, &lead, &updated)
+		items = append(items, map[string]any{
+			"id":            fmt.Sprintf("%d", id),
+			"warehouseId":   fmt.Sprintf("%d", warehouseID),
+			"warehouseName": warehouseName,
+			"product":       cementType,
+			"minStock":      minStock,
+			"safetyStock":   safetyStock,
+			"warningLevel":  warning,
+			"criticalLevel": critical,
+			"leadTimeDays":  lead,
+			"updatedAt":     updated.Format("2006-01-02 15:04"),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleOpsResolveIssue(w http.ResponseWriter, r *http.Request) {
-	u, _ := r.Context().Value(ctxUserKey).(User)
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+func (a *App) handleAdminUpdateThreshold(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
+	u, _ := r.Context().Value(ctxUserKey).(User)
 	var body struct {
-		ResolutionNotes string `json:"resolutionNotes"`
+		MinStock      float64 `json:"minStock"`
+		SafetyStock   float64 `json:"safetyStock"`
+		WarningLevel  float64 `json:"warningLevel"`
+		CriticalLevel float64 `json:"criticalLevel"`
+		LeadTimeDays  int     `json:"leadTimeDays"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	notes := strings.TrimSpace(body.ResolutionNotes)
-	if notes == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "resolutionNotes required")
+	if body.MinStock < 0 || body.SafetyStock < 0 || body.WarningLevel < 0 || body.CriticalLevel < 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "threshold values must be >= 0")
 		return
 	}
-
-	ct, err := a.db.Exec(r.Context(), `
-    UPDATE ops_issues
-    SET status='RESOLVED', resolved_by_user_id=$1, resolved_at=now(), resolution_notes=$2, updated_at=now()
-    WHERE id=$3 AND status='OPEN'
-  `, u.ID, notes, id)
+	if body.CriticalLevel > body.WarningLevel || body.WarningLevel > body.MinStock || body.MinStock > body.SafetyStock {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid thresholds order: ensure Critical <= Warning <= Min <= Safety")
+		return
+	}
+	if body.LeadTimeDays <= 0 {
+		body.LeadTimeDays = 3
+	}
+	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if ct.RowsAffected() == 0 {
-		var exists bool
-		_ = a.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM ops_issues WHERE id=$1)`, id).Scan(&exists)
-		if !exists {
-			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "issue not found")
-			return
-		}
-		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "issue already resolved")
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `
+    UPDATE threshold_settings
+    SET min_stock=$1, safety_stock=$2, warning_level=$3, critical_level=$4, lead_time_days=$5, updated_at=now()
+    WHERE id=$6
+  `, body.MinStock, body.SafetyStock, body.WarningLevel, body.CriticalLevel, body.LeadTimeDays, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "threshold not found")
 		return
 	}
 
-	a.insertAuditLog(r, &u, "ISSUE_RESOLVED", "issue", fmt.Sprintf("%d", id), map[string]any{"resolutionNotes": notes})
+	if err := a.insertAuditLogTx(tx, r, &u, "THRESHOLD_UPDATED", "threshold_settings", fmt.Sprintf("%d", id), map[string]any{
+		"minStock":      body.MinStock,
+		"safetyStock":   body.SafetyStock,
+		"warningLevel":  body.WarningLevel,
+		"criticalLevel": body.CriticalLevel,
+		"leadTimeDays":  body.LeadTimeDays,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-// ---------- executive ----------
+// ---------- admin: alerts ----------
+
+func (a *App) handleAdminListAlerts(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `
+    SELECT id, name, description, enabled, severity, recipients_roles, recipients_users, channels, params, rule_type
+    FROM alert_configs
+    ORDER BY id
+  `)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var name, description, severity, ruleType string
+		var enabled bool
+		var roles []string
+		var users []int64
+		var channels json.RawMessage
+		var params json.RawMessage
+		_ = rows.Scan(&id, &name, &description, &enabled, &severity, &roles, &users, &channels, &params, &ruleType)
+		userIDs := make([]string, 0, len(users))
+		for _, uid := range users {
+			userIDs = append(userIDs, fmt.Sprintf("%d", uid))
+		}
+		items = append(items, map[string]any{
+			"id":          fmt.Sprintf("%d", id),
+			"name":        name,
+			"description": description,
+			"enabled":     enabled,
+			"severity":    severity,
+			"recipients": map[string]any{
+				"roles": roles,
+				"users": userIDs,
+			},
+			"channels": channels,
+			"params":   params,
+			"ruleType": ruleType,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+type adminAlertRecipients struct {
+	Roles []string `json:"roles"`
+	Users []string `json:"users"`
+}
+
+type adminAlertItem struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Enabled     bool                 `json:"enabled"`
+	Severity    string               `json:"severity"`
+	Recipients  adminAlertRecipients `json:"recipients"`
+	Channels    map[string]bool      `json:"channels"`
+	Params      map[string]any       `json:"params"`
+	RuleType    string               `json:"ruleType"`
+}
 
-func (a *App) handleExecTargetVsActual(w http.ResponseWriter, r *http.Request) {
-	monthStr := strings.TrimSpace(r.URL.Query().Get("month"))
-	if monthStr == "" {
-		monthStr = time.Now().Format("2006-01")
+func (a *App) handleAdminPutAlerts(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Items []adminAlertItem `json:"items"`
 	}
-	t, err := time.Parse("2006-01", monthStr)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	allowedRole, err := a.roleKeys(r.Context())
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "month must be YYYY-MM")
+		writeDBError(w, err)
 		return
 	}
-	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(0, 1, 0)
-	daysInMonth := int(end.Sub(start).Hours() / 24)
-
-	var target float64
-	_ = a.db.QueryRow(r.Context(), `SELECT target_tons FROM sales_targets WHERE month = $1`, start.Format("2006-01-02")).Scan(&target)
-	if target == 0 {
-		target = 15000
+	allowedSeverity := map[string]bool{"Low": true, "Medium": true, "High": true}
+	allowedRuleType := map[string]bool{}
+	for _, t := range alerts.RuleTypes {
+		allowedRuleType[t] = true
 	}
 
-	// Actual per day
-	rows, err := a.db.Query(r.Context(), `
-    SELECT order_date, SUM(quantity_tons)
-    FROM sales_orders
-    WHERE order_date >= $1 AND order_date < $2
-    GROUP BY order_date
-    ORDER BY order_date
-  `, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
-	actualByDay := map[string]float64{}
-	for rows.Next() {
-		var d time.Time
-		var qty float64
-		_ = rows.Scan(&d, &qty)
-		actualByDay[d.Format("2006-01-02")] = qty
-	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
 
-	series := []map[string]any{}
-	cumulativeActual := 0.0
-	for i := 0; i < daysInMonth; i++ {
-		day := start.AddDate(0, 0, i)
-		key := day.Format("2006-01-02")
-		cumulativeActual += actualByDay[key]
-		cumulativeTarget := target * (float64(i+1) / float64(daysInMonth))
-		series = append(series, map[string]any{
-			"date":   key,
-			"target": cumulativeTarget,
-			"actual": cumulativeActual,
-		})
-	}
+	for _, item := range body.Items {
+		id, err := strconv.ParseInt(item.ID, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid alert id")
+			return
+		}
+		if id <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid alert id")
+			return
+		}
+		if strings.TrimSpace(item.Name) == "" {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "alert name is required")
+			return
+		}
+		if !allowedSeverity[item.Severity] {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid severity")
+			return
+		}
+		item.RuleType = strings.TrimSpace(strings.ToUpper(item.RuleType))
+		if item.RuleType != "" && !allowedRuleType[item.RuleType] {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid ruleType")
+			return
+		}
+		for _, role := range item.Recipients.Roles {
+			if !allowedRole[role] {
+				writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid recipient role")
+				return
+			}
+		}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"month":         monthStr,
-		"targetMonthly": target,
-		"series":        series,
-	})
-}
+		userIDs := make([]int64, 0, len(item.Recipients.Users))
+		for _, s := range item.Recipients.Users {
+			if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+				userIDs = append(userIDs, v)
+			}
+		}
+		if len(userIDs) > 0 {
+			var okCount int
+			_ = a.db.QueryRow(r.Context(), `SELECT COUNT(*) FROM users WHERE id = ANY($1::bigint[])`, userIDs).Scan(&okCount)
+			if okCount != len(userIDs) {
+				writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "one or more recipient users not found")
+				return
+			}
+		}
 
-func (a *App) handleExecCompetitorMap(w http.ResponseWriter, r *http.Request) {
-	bboxStr := r.URL.Query().Get("bbox")
-	minLat, minLng, maxLat, maxLng, ok := parseBBox(bboxStr)
-	if !ok {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "bbox must be minLat,minLng,maxLat,maxLng")
+		channels := map[string]bool{"inApp": true, "email": false}
+		if item.Channels != nil {
+			if v, ok := item.Channels["inApp"]; ok {
+				channels["inApp"] = v
+			}
+			if v, ok := item.Channels["email"]; ok {
+				channels["email"] = v
+			}
+		}
+		chBytes, _ := json.Marshal(channels)
+		paramBytes, _ := json.Marshal(item.Params)
+		_, err = tx.Exec(r.Context(), `
+		INSERT INTO alert_configs (id, name, description, enabled, severity, recipients_roles, recipients_users, channels, params, rule_type, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8::jsonb,$9::jsonb,$10,now())
+		ON CONFLICT (id) DO UPDATE SET
+			name=EXCLUDED.name,
+			description=EXCLUDED.description,
+			enabled=EXCLUDED.enabled,
+			severity=EXCLUDED.severity,
+			recipients_roles=EXCLUDED.recipients_roles,
+			recipients_users=EXCLUDED.recipients_users,
+			channels=EXCLUDED.channels,
+			params=EXCLUDED.params,
+			rule_type=CASE WHEN EXCLUDED.rule_type = '' THEN alert_configs.rule_type ELSE EXCLUDED.rule_type END,
+			updated_at=now()
+	`, id, item.Name, item.Description, item.Enabled, item.Severity, item.Recipients.Roles, userIDs, chBytes, paramBytes, item.RuleType)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+			return
+		}
+
+		if err := a.insertAuditLogTx(tx, r, &u, "ALERT_CONFIG_UPDATED", "alert_configs", fmt.Sprintf("%d", id), map[string]any{
+			"name":       item.Name,
+			"enabled":    item.Enabled,
+			"severity":   item.Severity,
+			"rolesCount": len(item.Recipients.Roles),
+			"usersCount": len(userIDs),
+			"channels":   channels,
+			"paramsKeys": func() []string {
+				keys := []string{}
+				for k := range item.Params {
+					keys = append(keys, k)
+				}
+				return keys
+			}(),
+		}); err != nil {
+			writeDBError(w, err)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	rows, err := a.db.Query(r.Context(), `
-    SELECT s.id, s.name, s.lat, s.lng, c.our_share_pct, c.competitor_share_pct, c.updated_at
-    FROM stores s
-    JOIN competitor_presence c ON c.store_id = s.id
-    WHERE s.lat BETWEEN $1 AND $2 AND s.lng BETWEEN $3 AND $4
-    ORDER BY s.id
-  `, minLat, maxLat, minLng, maxLng)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) handleAdminListAlertEvents(w http.ResponseWriter, r *http.Request) {
+	status := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("status")))
+	where := ""
+	switch status {
+	case "", "open":
+		where = "WHERE e.resolved_at IS NULL"
+	case "resolved":
+		where = "WHERE e.resolved_at IS NOT NULL"
+	case "all":
+	default:
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be open|resolved|all")
+		return
+	}
+	rows, err := a.db.Query(r.Context(), fmt.Sprintf(`
+    SELECT e.id, e.alert_config_id, c.name, e.rule_type, e.severity, e.title, e.message,
+           e.entity_type, e.entity_id, e.metadata, e.recipient_user_ids,
+           e.first_seen_at, e.last_seen_at, e.resolved_at
+    FROM alert_events e
+    JOIN alert_configs c ON c.id = e.alert_config_id
+    %s
+    ORDER BY e.first_seen_at DESC, e.id DESC
+    LIMIT 200
+  `, where))
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
 	defer rows.Close()
-
 	items := []map[string]any{}
 	for rows.Next() {
-		var id int64
-		var name string
-		var lat, lng, our, comp float64
-		var updated time.Time
-		_ = rows.Scan(&id, &name, &lat, &lng, &our, &comp, &updated)
+		var id, configID int64
+		var configName, ruleType, severity, title, message, entityType, entityID string
+		var metadata json.RawMessage
+		var recipients []int64
+		var firstSeen, lastSeen time.Time
+		var resolvedAt *time.Time
+		_ = rows.Scan(&id, &configID, &configName, &ruleType, &severity, &title, &message, &entityType, &entityID, &metadata, &recipients, &firstSeen, &lastSeen, &resolvedAt)
+		recipientIDs := make([]string, 0, len(recipients))
+		for _, uid := range recipients {
+			recipientIDs = append(recipientIDs, fmt.Sprintf("%d", uid))
+		}
 		items = append(items, map[string]any{
-			"id":                 id,
-			"name":               name,
-			"lat":                lat,
-			"lng":                lng,
-			"ourSharePct":        our,
-			"competitorSharePct": comp,
-			"updatedAt":          updated,
+			"id":          fmt.Sprintf("%d", id),
+			"alertId":     fmt.Sprintf("%d", configID),
+			"alertName":   configName,
+			"ruleType":    ruleType,
+			"severity":    severity,
+			"title":       title,
+			"message":     message,
+			"entityType":  entityType,
+			"entityId":    entityID,
+			"metadata":    metadata,
+			"recipients":  recipientIDs,
+			"firstSeenAt": firstSeen.Format(time.RFC3339),
+			"lastSeenAt":  lastSeen.Format(time.RFC3339),
+			"resolvedAt":  resolvedAt,
 		})
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleExecPartnersPerformance(w http.ResponseWriter, r *http.Request) {
-	// Summary per distributor for last 90 days + trend vs previous 30 days.
-	rows, err := a.db.Query(r.Context(), `
-    WITH last30 AS (
-      SELECT distributor_id, COALESCE(SUM(quantity_tons),0) AS qty
-      FROM sales_orders
-      WHERE order_date >= CURRENT_DATE - INTERVAL '30 days'
-      GROUP BY distributor_id
-    ), prev30 AS (
-      SELECT distributor_id, COALESCE(SUM(quantity_tons),0) AS qty
-      FROM sales_orders
-      WHERE order_date >= CURRENT_DATE - INTERVAL '60 days'
-        AND order_date < CURRENT_DATE - INTERVAL '30 days'
-      GROUP BY distributor_id
-    ), total90 AS (
-      SELECT distributor_id,
-             COALESCE(SUM(quantity_tons),0) AS qty,
-             COALESCE(SUM(total_price),0) AS total_price,
-             MAX(order_date) AS last_order
-      FROM sales_orders
-      WHERE order_date >= CURRENT_DATE - INTERVAL '90 days'
-      GROUP BY distributor_id
-    )
-    SELECT d.id, d.name,
-           COALESCE(t.qty,0) AS qty90,
-           COALESCE(t.total_price,0) AS price90,
-           t.last_order,
-           COALESCE(l.qty,0) AS qty_last30,
-           COALESCE(p.qty,0) AS qty_prev30
-    FROM distributors d
-    LEFT JOIN total90 t ON t.distributor_id = d.id
-    LEFT JOIN last30 l ON l.distributor_id = d.id
-    LEFT JOIN prev30 p ON p.distributor_id = d.id
-    ORDER BY d.id
-  `)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
-	}
-	defer rows.Close()
+// ---------- admin: audit logs ----------
 
-	items := []map[string]any{}
-	for rows.Next() {
-		var id int64
-		var name string
-		var qty90, price90, qLast30, qPrev30 float64
-		var lastOrder *time.Time
-		_ = rows.Scan(&id, &name, &qty90, &price90, &lastOrder, &qLast30, &qPrev30)
-		trendPct := 0.0
-		if qPrev30 > 0 {
-			trendPct = ((qLast30 - qPrev30) / qPrev30) * 100
-		} else if qLast30 > 0 {
-			trendPct = 100
+// auditLogFilter narrows audit_logs by the query parameters shared by the
+// list and export endpoints.
+type auditLogFilter struct {
+	ActorID    *int64     `json:"actorId,omitempty"`
+	Actions    []string   `json:"actions,omitempty"`
+	EntityType string     `json:"entityType,omitempty"`
+	EntityID   string     `json:"entityId,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	Search     string     `json:"q,omitempty"`
+}
+
+// auditLogFilterSQL applies an auditLogFilter to audit_logs (alias l) with
+// its args bound to $1..$8.
+const auditLogFilterSQL = `($1::bigint IS NULL OR l.actor_user_id = $1)
+      AND ($2::text[] IS NULL OR l.action = ANY($2))
+      AND ($3 = '' OR l.entity_type = $3)
+      AND ($4 = '' OR l.entity_id = $4)
+      AND ($5 = '' OR l.ip = $5)
+      AND ($6::timestamptz IS NULL OR l.ts >= $6)
+      AND ($7::timestamptz IS NULL OR l.ts < $7)
+      AND ($8 = '' OR jsonb_to_tsvector('simple', l.metadata, '["all"]') @@ websearch_to_tsquery('simple', $8))`
+
+func (f auditLogFilter) args() []any {
+	return []any{f.ActorID, f.Actions, f.EntityType, f.EntityID, f.IP, f.From, f.To, f.Search}
+}
+
+// parseAuditLogFilter reads actorId, action (comma separated), entityType,
+// entityId, ip, from, to and q. from/to take RFC3339 or a date; a date in to
+// includes that whole day.
+func parseAuditLogFilter(q url.Values) (auditLogFilter, error) {
+	var f auditLogFilter
+	if v := strings.TrimSpace(q.Get("actorId")); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, errors.New("invalid actorId")
 		}
-		items = append(items, map[string]any{
-			"distributorId":   id,
-			"distributorName": name,
-			"totalQtyTons90d": qty90,
-			"totalPrice90d":   price90,
-			"lastOrderDate":   lastOrder,
-			"trendPct":        trendPct,
-		})
+		f.ActorID = &id
+	}
+	for _, action := range strings.Split(q.Get("action"), ",") {
+		if action = strings.ToUpper(strings.TrimSpace(action)); action != "" {
+			f.Actions = append(f.Actions, action)
+		}
+	}
+	f.EntityType = strings.TrimSpace(q.Get("entityType"))
+	f.EntityID = strings.TrimSpace(q.Get("entityId"))
+	f.IP = strings.TrimSpace(q.Get("ip"))
+	f.Search = strings.TrimSpace(q.Get("q"))
+	for _, p := range []struct {
+		name   string
+		dst    **time.Time
+		endDay bool
+	}{{"from", &f.From, false}, {"to", &f.To, true}} {
+		v := strings.TrimSpace(q.Get(p.name))
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			d, derr := time.Parse("2006-01-02", v)
+			if derr != nil {
+				return f, fmt.Errorf("%s must be RFC3339 or YYYY-MM-DD", p.name)
+			}
+			if p.endDay {
+				d = d.AddDate(0, 0, 1)
+			}
+			t = d
+		}
+		*p.dst = &t
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return f, errors.New("from must be before to")
+	}
+	return f, nil
 }
 
-func (a *App) handleExecShipmentsSummary(w http.ResponseWriter, r *http.Request) {
-	daysStr := strings.TrimSpace(r.URL.Query().Get("days"))
-	days := int64(0)
-	if daysStr != "" {
-		v, err := strconv.ParseInt(daysStr, 10, 64)
-		if err != nil || v < 0 || v > 3650 {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "days must be a number between 0 and 3650")
-			return
-		}
-		days = v
-	}
+// encodeAuditCursor and decodeAuditCursor turn the (ts, id) of the last row
+// on a page into an opaque cursor for the next one.
+func encodeAuditCursor(ts time.Time, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", ts.UnixMicro(), id)))
+}
 
-	var total, delivered, planned, inTransit, cancelled, overdue int64
-	err := a.db.QueryRow(r.Context(), `
-		SELECT
-			COUNT(*)::bigint AS total,
-			COUNT(*) FILTER (WHERE status = 'DELIVERED')::bigint AS delivered,
-			COUNT(*) FILTER (WHERE status = 'PLANNED')::bigint AS planned,
-			COUNT(*) FILTER (WHERE status = 'IN_TRANSIT')::bigint AS in_transit,
-			COUNT(*) FILTER (WHERE status = 'CANCELLED')::bigint AS cancelled,
-			COUNT(*) FILTER (
-				WHERE status IN ('PLANNED','IN_TRANSIT')
-				  AND arrive_eta IS NOT NULL
-				  AND arrive_eta < NOW()
-			)::bigint AS overdue
-		FROM shipments
-		WHERE ($1::bigint = 0)
-		   OR (
-				(depart_at IS NOT NULL AND depart_at >= NOW() - ($1::bigint * INTERVAL '1 day'))
-			 OR (depart_at IS NULL AND arrive_eta IS NOT NULL AND arrive_eta >= NOW() - ($1::bigint * INTERVAL '1 day'))
-		   )
-	`, days).Scan(&total, &delivered, &planned, &inTransit, &cancelled, &overdue)
+func decodeAuditCursor(c string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+		return time.Time{}, 0, err
 	}
-
-	overduePct := 0.0
-	if total > 0 {
-		overduePct = (float64(overdue) / float64(total)) * 100
+	tsPart, idPart, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return time.Time{}, 0, errors.New("malformed cursor")
+	}
+	micros, err := strconv.ParseInt(tsPart, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
 	}
+	return time.UnixMicro(micros), id, nil
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"days":       days,
-		"total":      total,
-		"delivered":  delivered,
-		"overdue":    overdue,
-		"overduePct": overduePct,
-		"byStatus": map[string]any{
-			"PLANNED":    planned,
-			"IN_TRANSIT": inTransit,
-			"DELIVERED":  delivered,
-			"CANCELLED":  cancelled,
-		},
-	})
+const auditLogColumns = `l.id, l.ts, l.actor_user_id, COALESCE(u.name,''), l.action, l.entity_type, l.entity_id, l.metadata, l.ip, l.chain_seq, l.hash`
+
+type auditLogRow struct {
+	ID         int64
+	TS         time.Time
+	ActorID    sql.NullInt64
+	ActorName  string
+	Action     string
+	EntityType string
+	EntityID   string
+	Metadata   json.RawMessage
+	IP         string
+	ChainSeq   int64
+	Hash       string
 }
 
-func (a *App) handleExecSalesSummary(w http.ResponseWriter, r *http.Request) {
-	daysStr := strings.TrimSpace(r.URL.Query().Get("days"))
-	days := int64(90)
-	if daysStr != "" {
-		v, err := strconv.ParseInt(daysStr, 10, 64)
-		if err != nil || v <= 0 || v > 3650 {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "days must be a number between 1 and 3650")
-			return
-		}
-		days = v
+func scanAuditLogRow(rows pgx.Rows) (auditLogRow, error) {
+	var l auditLogRow
+	err := rows.Scan(&l.ID, &l.TS, &l.ActorID, &l.ActorName, &l.Action, &l.EntityType, &l.EntityID, &l.Metadata, &l.IP, &l.ChainSeq, &l.Hash)
+	return l, err
+}
+
+func (l auditLogRow) actor() string {
+	if l.ActorID.Valid {
+		return fmt.Sprintf("%d", l.ActorID.Int64)
 	}
+	return ""
+}
 
-	var orderCount int64
-	var totalQty, totalRevenue, avgOrder float64
-	err := a.db.QueryRow(r.Context(), `
-		SELECT
-			COUNT(*)::bigint AS orders,
-			COALESCE(SUM(quantity_tons),0) AS qty,
-			COALESCE(SUM(total_price),0) AS revenue,
-			COALESCE(AVG(total_price),0) AS avg_order
-		FROM sales_orders
-		WHERE order_date >= CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
-	`, days).Scan(&orderCount, &totalQty, &totalRevenue, &avgOrder)
+func (l auditLogRow) item() map[string]any {
+	return map[string]any{
+		"id":         fmt.Sprintf("%d", l.ID),
+		"ts":         l.TS.Format(time.RFC3339),
+		"actorId":    l.actor(),
+		"actorName":  l.ActorName,
+		"action":     l.Action,
+		"entityType": l.EntityType,
+		"entityId":   l.EntityID,
+		"metadata":   l.Metadata,
+		"ip":         l.IP,
+		"chainSeq":   l.ChainSeq,
+		"hash":       l.Hash,
+	}
+}
+
+func (a *App) handleAdminListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	a.listAuditLogs(w, r, auditLogSource(r.URL.Query()))
+}
+
+// auditLogsWithArchive reads archived and live audit rows as one table, so an
+// entity's history stays complete after its older entries are archived.
+const auditLogsWithArchive = `(
+      SELECT id, ts, actor_user_id, action, entity_type, entity_id, metadata, ip, chain_seq, hash FROM audit_logs_archive
+      UNION ALL
+      SELECT id, ts, actor_user_id, action, entity_type, entity_id, metadata, ip, chain_seq, hash FROM audit_logs
+    )`
+
+// auditLogSource is the table the list and export endpoints read: the live
+// audit_logs, or archive and live together when includeArchived=true.
+func auditLogSource(q url.Values) string {
+	if v, _ := strconv.ParseBool(q.Get("includeArchived")); v {
+		return auditLogsWithArchive
+	}
+	return "audit_logs"
+}
+
+// listAuditLogs serves one page of table (audit_logs, audit_logs_archive or
+// auditLogsWithArchive) newest first, using the auditLogFilter parameters plus limit and cursor.
+func (a *App) listAuditLogs(w http.ResponseWriter, r *http.Request, table string) {
+	q := r.URL.Query()
+	f, err := parseAuditLogFilter(q)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit < 1 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	var cursorTS *time.Time
+	var cursorID int64
+	if c := q.Get("cursor"); c != "" {
+		ts, id, err := decodeAuditCursor(c)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid cursor")
+			return
+		}
+		cursorTS, cursorID = &ts, id
+	}
 
 	rows, err := a.db.Query(r.Context(), `
-		SELECT d.id, d.name,
-		       COALESCE(SUM(o.quantity_tons),0) AS qty,
-		       COALESCE(SUM(o.total_price),0) AS revenue
-		FROM distributors d
-		LEFT JOIN sales_orders o
-		  ON o.distributor_id = d.id
-		 AND o.order_date >= CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
-		GROUP BY d.id, d.name
-		ORDER BY revenue DESC, qty DESC, d.id
-	`, days)
+    SELECT `+auditLogColumns+`
+    FROM `+table+` l
+    LEFT JOIN users u ON u.id = l.actor_user_id
+    WHERE `+auditLogFilterSQL+`
+      AND ($9::timestamptz IS NULL OR (l.ts, l.id) < ($9, $10))
+    ORDER BY l.ts DESC, l.id DESC
+    LIMIT $11
+  `, append(f.args(), cursorTS, cursorID, limit+1)...)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
 	defer rows.Close()
-	items := []map[string]any{}
+	page := []auditLogRow{}
 	for rows.Next() {
-		var id int64
-		var name string
-		var qty, revenue float64
-		_ = rows.Scan(&id, &name, &qty, &revenue)
-		items = append(items, map[string]any{
-			"distributorId":   id,
-			"distributorName": name,
-			"qtyTons":         qty,
-			"revenue":         revenue,
-		})
+		l, err := scanAuditLogRow(rows)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		page = append(page, l)
+	}
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
+		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"days":            days,
-		"orderCount":      orderCount,
-		"totalQtyTons":    totalQty,
-		"totalRevenue":    totalRevenue,
-		"avgOrderValue":   avgOrder,
-		"topDistributors": items,
-		"approvedCount":   nil,
-	})
+	var next any
+	if len(page) > limit {
+		page = page[:limit]
+		last := page[limit-1]
+		next = encodeAuditCursor(last.TS, last.ID)
+	}
+	items := make([]map[string]any, 0, len(page))
+	for _, l := range page {
+		items = append(items, l.item())
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "nextCursor": next})
 }
 
-func (a *App) handleExecSalesOverview(w http.ResponseWriter, r *http.Request) {
-	monthStr := strings.TrimSpace(r.URL.Query().Get("month"))
-	if monthStr == "" {
-		monthStr = time.Now().Format("2006-01")
-	}
-	t, err := time.Parse("2006-01", monthStr)
+// handleAdminExportAuditLogs streams every row matching the filter, oldest
+// first, as CSV (default) or NDJSON (format=ndjson). Rows are written as they
+// are read, so long ranges do not have to fit in memory.
+func (a *App) handleAdminExportAuditLogs(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	q := r.URL.Query()
+	f, err := parseAuditLogFilter(q)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "month must be YYYY-MM")
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "format must be csv or ndjson")
 		return
 	}
-	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(0, 1, 0)
-	prevStart := start.AddDate(0, -1, 0)
-	prevEnd := start
 
-	var orders, prevOrders int64
-	var qty, prevQty, revenue, prevRevenue float64
-	err = a.db.QueryRow(r.Context(), `
-		SELECT
-			COUNT(*)::bigint AS orders,
-			COALESCE(SUM(quantity_tons),0) AS qty,
-			COALESCE(SUM(total_price),0) AS revenue
-		FROM sales_orders
-		WHERE order_date >= $1 AND order_date < $2
-	`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&orders, &qty, &revenue)
+	rows, err := a.db.Query(r.Context(), `
+    SELECT `+auditLogColumns+`
+    FROM `+auditLogSource(q)+` l
+    LEFT JOIN users u ON u.id = l.actor_user_id
+    WHERE `+auditLogFilterSQL+`
+    ORDER BY l.ts, l.id
+  `, f.args()...)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
-	err = a.db.QueryRow(r.Context(), `
-		SELECT
-			COUNT(*)::bigint AS orders,
-			COALESCE(SUM(quantity_tons),0) AS qty,
-			COALESCE(SUM(total_price),0) AS revenue
-		FROM sales_orders
-		WHERE order_date >= $1 AND order_date < $2
-	`, prevStart.Format("2006-01-02"), prevEnd.Format("2006-01-02")).Scan(&prevOrders, &prevQty, &prevRevenue)
+	defer rows.Close()
+
+	a.insertAuditLog(r, &u, "AUDIT_LOG_EXPORTED", "audit_logs", "", map[string]any{"format": format, "filter": f})
+
+	filename := "audit-logs-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	if format == "csv" {
+		_ = cw.Write([]string{"id", "ts", "actorId", "actorName", "action", "entityType", "entityId", "ip", "metadata", "chainSeq", "hash"})
+	}
+	n := 0
+	for rows.Next() {
+		l, err := scanAuditLogRow(rows)
+		if err != nil {
+			log.Printf("audit export: %v", err)
+			return
+		}
+		if format == "csv" {
+			err = cw.Write([]string{
+				fmt.Sprintf("%d", l.ID), l.TS.Format(time.RFC3339Nano), l.actor(), l.ActorName, l.Action,
+				l.EntityType, l.EntityID, l.IP, string(l.Metadata), fmt.Sprintf("%d", l.ChainSeq), l.Hash,
+			})
+		} else {
+			err = enc.Encode(l.item())
+		}
+		if err != nil {
+			// The client went away; nothing more to send.
+			return
+		}
+		if n++; n%500 == 0 {
+			cw.Flush()
+			_ = rc.Flush()
+		}
+	}
+	cw.Flush()
+	if err := rows.Err(); err != nil {
+		// Headers are already sent, so the truncated body is all we can signal.
+		log.Printf("audit export: %v", err)
+	}
+}
+
+// auditChainHash mirrors the audit_log_hash SQL function: SHA-256 over each
+// field written as "<byte length>:<value>", with ts in UTC at microsecond
+// precision. It is recomputed here rather than in SQL so a tampered database
+// function cannot vouch for itself.
+func auditChainHash(prevHash string, seq int64, ts time.Time, actorID *int64, action, entityType, entityID, metadata, ip string) string {
+	actor := ""
+	if actorID != nil {
+		actor = strconv.FormatInt(*actorID, 10)
+	}
+	h := sha256.New()
+	for _, f := range []string{prevHash, strconv.FormatInt(seq, 10), ts.UTC().Format("2006-01-02T15:04:05.000000Z"), actor, action, entityType, entityID, metadata, ip} {
+		fmt.Fprintf(h, "%d:%s", len(f), f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handleAdminVerifyAuditLogs walks the audit chain, archived rows included, in
+// chain_seq order and reports the first row whose sequence, link or hash does
+// not check out. The returned head (last verified seq and hash) can be
+// recorded elsewhere so that later removal of the newest rows is detectable
+// too.
+func (a *App) handleAdminVerifyAuditLogs(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	rows, err := a.db.Query(r.Context(), `
+    SELECT id, chain_seq, prev_hash, hash, ts, actor_user_id, action, entity_type, entity_id, metadata::text, ip
+    FROM audit_logs_archive
+    UNION ALL
+    SELECT id, chain_seq, prev_hash, hash, ts, actor_user_id, action, entity_type, entity_id, metadata::text, ip
+    FROM audit_logs
+    ORDER BY chain_seq
+  `)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
+	defer rows.Close()
 
-	growthQtyPct := 0.0
-	if prevQty > 0 {
-		growthQtyPct = ((qty - prevQty) / prevQty) * 100
-	} else if qty > 0 {
-		growthQtyPct = 100
+	var checked, headSeq int64
+	headHash := ""
+	var broken map[string]any
+	for rows.Next() {
+		var id, seq int64
+		var prevHash, hash, action, entityType, entityID, metadata, ip string
+		var ts time.Time
+		var actorID *int64
+		if err := rows.Scan(&id, &seq, &prevHash, &hash, &ts, &actorID, &action, &entityType, &entityID, &metadata, &ip); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		reason := ""
+		switch {
+		case seq != headSeq+1:
+			reason = "SEQUENCE_GAP"
+		case prevHash != headHash:
+			reason = "PREV_HASH_MISMATCH"
+		case hash != auditChainHash(prevHash, seq, ts, actorID, action, entityType, entityID, metadata, ip):
+			reason = "HASH_MISMATCH"
+		}
+		if reason != "" {
+			broken = map[string]any{
+				"id":       fmt.Sprintf("%d", id),
+				"chainSeq": seq,
+				"ts":       ts.Format(time.RFC3339),
+				"action":   action,
+				"reason":   reason,
+			}
+			break
+		}
+		checked++
+		headSeq, headHash = seq, hash
 	}
-	growthRevenuePct := 0.0
-	if prevRevenue > 0 {
-		growthRevenuePct = ((revenue - prevRevenue) / prevRevenue) * 100
-	} else if revenue > 0 {
-		growthRevenuePct = 100
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
+		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"month": monthStr,
-		"current": map[string]any{
-			"orderCount": orders,
-			"qtyTons":    qty,
-			"revenue":    revenue,
-			"avgOrderValue": func() float64 {
-				if orders > 0 {
-					return revenue / float64(orders)
-				}
-				return 0
-			}(),
-		},
-		"previous": map[string]any{
-			"orderCount": prevOrders,
-			"qtyTons":    prevQty,
-			"revenue":    prevRevenue,
-		},
-		"growth": map[string]any{
-			"qtyPct":     growthQtyPct,
-			"revenuePct": growthRevenuePct,
-		},
+	meta := map[string]any{"ok": broken == nil, "checked": checked, "headSeq": headSeq}
+	if broken != nil {
+		meta["brokenId"] = broken["id"]
+		meta["reason"] = broken["reason"]
+	}
+	a.insertAuditLog(r, &u, "AUDIT_CHAIN_VERIFIED", "audit_logs", "", meta)
+
+	out := map[string]any{
+		"ok":      broken == nil,
+		"checked": checked,
+		"head":    map[string]any{"chainSeq": headSeq, "hash": headHash},
+	}
+	if broken != nil {
+		out["firstBroken"] = broken
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// ---------- admin: retention ----------
+
+func (a *App) handleAdminRetention(w http.ResponseWriter, r *http.Request) {
+	partitions, err := a.archiver.Archived(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"policy": a.archiver.Policy(), "partitions": partitions})
+}
+
+// handleAdminRetentionReport is a dry run: what the archiver would move now.
+func (a *App) handleAdminRetentionReport(w http.ResponseWriter, r *http.Request) {
+	rep, err := a.archiver.Plan(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+func (a *App) handleAdminRetentionRun(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	res, err := a.archiver.ArchiveOnce(r.Context())
+	// Batches commit independently, so report what moved even on failure.
+	a.insertAuditLog(r, &u, "RETENTION_RUN", "retention", "", map[string]any{
+		"auditLogs":          res.AuditLogs,
+		"inventoryMovements": res.InventoryMovements,
+		"failed":             err != nil,
 	})
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (a *App) handleExecRegionalPerformance(w http.ResponseWriter, r *http.Request) {
-	daysStr := strings.TrimSpace(r.URL.Query().Get("days"))
-	days := int64(30)
-	if daysStr != "" {
-		v, err := strconv.ParseInt(daysStr, 10, 64)
-		if err != nil || v <= 0 || v > 3650 {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "days must be a number between 1 and 3650")
+func (a *App) handleAdminArchivedAuditLogs(w http.ResponseWriter, r *http.Request) {
+	a.listAuditLogs(w, r, "audit_logs_archive")
+}
+
+// handleAdminArchivedMovements pages through archived inventory movements
+// newest first, filtered by warehouseId, cementType, movementType and from/to.
+func (a *App) handleAdminArchivedMovements(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var warehouseID *int64
+	if v := strings.TrimSpace(q.Get("warehouseId")); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid warehouseId")
 			return
 		}
-		days = v
+		warehouseID = &id
+	}
+	// from/to parse exactly as for the audit log.
+	f, err := parseAuditLogFilter(url.Values{"from": {q.Get("from")}, "to": {q.Get("to")}})
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit < 1 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	var cursorTS *time.Time
+	var cursorID int64
+	if c := q.Get("cursor"); c != "" {
+		ts, id, err := decodeAuditCursor(c)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid cursor")
+			return
+		}
+		cursorTS, cursorID = &ts, id
 	}
 
 	rows, err := a.db.Query(r.Context(), `
-		WITH sales_win AS (
-			SELECT distributor_id,
-				   COUNT(*)::bigint AS orders,
-				   COALESCE(SUM(quantity_tons),0) AS qty,
-				   COALESCE(SUM(total_price),0) AS revenue
-			FROM sales_orders
-			WHERE order_date >= CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
-			GROUP BY distributor_id
-		), sales_prev AS (
-			SELECT distributor_id,
-				   COALESCE(SUM(quantity_tons),0) AS qty
-			FROM sales_orders
-			WHERE order_date >= CURRENT_DATE - (($1::bigint * 2) * INTERVAL '1 day')
-			  AND order_date <  CURRENT_DATE - ($1::bigint * INTERVAL '1 day')
-			GROUP BY distributor_id
-		), ship_win AS (
-			SELECT to_distributor_id AS distributor_id,
-				   COUNT(*)::bigint AS total_shipments,
-				   COUNT(*) FILTER (
-					WHERE status IN ('PLANNED','IN_TRANSIT')
-					  AND arrive_eta IS NOT NULL
-					  AND arrive_eta < NOW()
-				)::bigint AS overdue_shipments
-			FROM shipments
-			WHERE (
-				(depart_at IS NOT NULL AND depart_at >= NOW() - ($1::bigint * INTERVAL '1 day'))
-			 OR (depart_at IS NULL AND arrive_eta IS NOT NULL AND arrive_eta >= NOW() - ($1::bigint * INTERVAL '1 day'))
-			)
-			GROUP BY to_distributor_id
-		)
-		SELECT d.id, d.name,
-		       COALESCE(sw.orders,0) AS orders,
-		       COALESCE(sw.qty,0) AS qty,
-		       COALESCE(sw.revenue,0) AS revenue,
-		       COALESCE(sp.qty,0) AS prev_qty,
-		       COALESCE(sh.total_shipments,0) AS ship_total,
-		       COALESCE(sh.overdue_shipments,0) AS ship_overdue
-		FROM distributors d
-		LEFT JOIN sales_win sw ON sw.distributor_id = d.id
-		LEFT JOIN sales_prev sp ON sp.distributor_id = d.id
-		LEFT JOIN ship_win sh ON sh.distributor_id = d.id
-		ORDER BY revenue DESC, qty DESC, d.id
-	`, days)
+    SELECT id, ts, actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata, archived_at
+    FROM inventory_movements_archive
+    WHERE ($1::bigint IS NULL OR warehouse_id = $1)
+      AND ($2 = '' OR cement_type = $2)
+      AND ($3 = '' OR movement_type = $3)
+      AND ($4::timestamptz IS NULL OR ts >= $4)
+      AND ($5::timestamptz IS NULL OR ts < $5)
+      AND ($6::timestamptz IS NULL OR (ts, id) < ($6, $7))
+    ORDER BY ts DESC, id DESC
+    LIMIT $8
+  `, warehouseID, strings.TrimSpace(q.Get("cementType")), strings.ToUpper(strings.TrimSpace(q.Get("movementType"))),
+		f.From, f.To, cursorTS, cursorID, limit+1)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
 	defer rows.Close()
-
 	items := []map[string]any{}
+	var next any
+	lastCursor := ""
 	for rows.Next() {
-		var id int64
-		var name string
-		var orders, shipTotal, shipOverdue int64
-		var qty, revenue, prevQty float64
-		_ = rows.Scan(&id, &name, &orders, &qty, &revenue, &prevQty, &shipTotal, &shipOverdue)
-		growthPct := 0.0
-		if prevQty > 0 {
-			growthPct = ((qty - prevQty) / prevQty) * 100
-		} else if qty > 0 {
-			growthPct = 100
-		}
-		overduePct := 0.0
-		if shipTotal > 0 {
-			overduePct = (float64(shipOverdue) / float64(shipTotal)) * 100
+		var id, wid int64
+		var ts, archivedAt time.Time
+		var actorID *int64
+		var ct, mt, reason, refType, refID string
+		var qty float64
+		var meta json.RawMessage
+		if err := rows.Scan(&id, &ts, &actorID, &wid, &ct, &mt, &qty, &reason, &refType, &refID, &meta, &archivedAt); err != nil {
+			writeDBError(w, err)
+			return
 		}
-		avgOrderValue := 0.0
-		if orders > 0 {
-			avgOrderValue = revenue / float64(orders)
+		if len(items) == limit {
+			// The extra row only signals another page; the cursor points at the last row served.
+			next = lastCursor
+			break
 		}
+		lastCursor = encodeAuditCursor(ts, id)
 		items = append(items, map[string]any{
-			"distributorId":    id,
-			"distributorName":  name,
-			"orderCount":       orders,
-			"qtyTons":          qty,
-			"revenue":          revenue,
-			"growthPct":        growthPct,
-			"avgOrderValue":    avgOrderValue,
-			"shipmentsTotal":   shipTotal,
-			"shipmentsOverdue": shipOverdue,
-			"overduePct":       overduePct,
+			"id":           fmt.Sprintf("%d", id),
+			"ts":           ts.Format(time.RFC3339),
+			"actorUserId":  actorID,
+			"warehouseId":  wid,
+			"cementType":   ct,
+			"movementType": mt,
+			"quantityTons": qty,
+			"reason":       reason,
+			"refType":      refType,
+			"refId":        refID,
+			"metadata":     meta,
+			"archivedAt":   archivedAt.Format(time.RFC3339),
 		})
 	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"days":  days,
-		"items": items,
-		"note":  "Region tidak dimodelkan; agregasi menggunakan distributor sebagai proxy regional.",
-	})
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "nextCursor": next})
 }
 
-// ---------- ops: update shipment status ----------
+// ---------- admin: storage ----------
 
-func (a *App) handleOpsUpdateShipmentStatus(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+// handleAdminStorageUsage reports attachment storage in total, by uploader
+// and by upload month, optionally limited to uploads between from and to.
+// Sizes are those of the stored files, not counting thumbnails.
+func (a *App) handleAdminStorageUsage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, err := parseAuditLogFilter(url.Values{"from": {q.Get("from")}, "to": {q.Get("to")}})
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
-	var body struct {
-		Status string `json:"status"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
-		return
-	}
-	body.Status = strings.TrimSpace(strings.ToUpper(body.Status))
-	allowed := map[string]bool{"SCHEDULED": true, "ON_DELIVERY": true, "COMPLETED": true, "DELAYED": true, "RECEIVED": true}
-	if !allowed[body.Status] {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be SCHEDULED|ON_DELIVERY|COMPLETED|DELAYED|RECEIVED")
+	const window = `($1::timestamptz IS NULL OR t.created_at >= $1) AND ($2::timestamptz IS NULL OR t.created_at < $2)`
+
+	var files, bytes, unlinkedFiles, unlinkedBytes int64
+	if err := a.db.QueryRow(r.Context(), `
+    SELECT COUNT(*),
+           COALESCE(SUM(t.size_bytes),0)::bigint,
+           COUNT(*) FILTER (WHERE num_nonnulls(t.issue_id, t.shipment_id, t.order_request_id) = 0),
+           COALESCE(SUM(t.size_bytes) FILTER (WHERE num_nonnulls(t.issue_id, t.shipment_id, t.order_request_id) = 0),0)::bigint
+    FROM attachments t
+    WHERE `+window, f.From, f.To).Scan(&files, &bytes, &unlinkedFiles, &unlinkedBytes); err != nil {
+		writeDBError(w, err)
 		return
 	}
 
-	u, _ := r.Context().Value(ctxUserKey).(User)
-	tx, err := a.db.Begin(r.Context())
+	rows, err := a.db.Query(r.Context(), `
+    SELECT t.uploaded_by_user_id, u.name, u.email, u.role,
+           COUNT(*), COALESCE(SUM(t.size_bytes),0)::bigint, MAX(t.created_at)
+    FROM attachments t
+    LEFT JOIN users u ON u.id = t.uploaded_by_user_id
+    WHERE `+window+`
+    GROUP BY t.uploaded_by_user_id, u.name, u.email, u.role
+    ORDER BY 6 DESC, 1
+  `, f.From, f.To)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
-	defer func() { _ = tx.Rollback(r.Context()) }()
-
-	var fromID, toID int64
-	var currentStatus string
-	var orderReqID *int64
-	var depart, eta *time.Time
-	var wlat, wlng, dlat, dlng float64
-	if err := tx.QueryRow(r.Context(), `
-    SELECT s.from_warehouse_id, s.to_distributor_id, s.status, s.order_request_id, s.depart_at, s.arrive_eta,
-           w.lat, w.lng, d.lat, d.lng
-    FROM shipments s
-    JOIN warehouses w ON w.id = s.from_warehouse_id
-    JOIN distributors d ON d.id = s.to_distributor_id
-    WHERE s.id=$1
-    FOR UPDATE
-  `, id).Scan(&fromID, &toID, &currentStatus, &orderReqID, &depart, &eta, &wlat, &wlng, &dlat, &dlng); err != nil {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
+	byUploader := []map[string]any{}
+	for rows.Next() {
+		var userID *int64
+		var name, email, role *string
+		var n, size int64
+		var last time.Time
+		if err := rows.Scan(&userID, &name, &email, &role, &n, &size, &last); err != nil {
+			rows.Close()
+			writeDBError(w, err)
+			return
+		}
+		byUploader = append(byUploader, map[string]any{
+			"user":         map[string]any{"id": userID, "name": name, "email": email, "role": role},
+			"files":        n,
+			"bytes":        size,
+			"lastUploadAt": last,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
 		return
 	}
 
-	// Enforce a simple lifecycle to avoid impossible transitions.
-	// SCHEDULED -> ON_DELIVERY|DELAYED|COMPLETED
-	// ON_DELIVERY -> DELAYED|COMPLETED
-	// DELAYED -> ON_DELIVERY|COMPLETED
-	// COMPLETED -> RECEIVED
-	// RECEIVED -> terminal
-	if body.Status != currentStatus {
-		allowedNext := map[string]map[string]bool{
-			"SCHEDULED":   {"ON_DELIVERY": true, "DELAYED": true, "COMPLETED": true},
-			"ON_DELIVERY": {"DELAYED": true, "COMPLETED": true},
-			"DELAYED":     {"ON_DELIVERY": true, "COMPLETED": true},
-			"COMPLETED":   {"RECEIVED": true},
-			"RECEIVED":    {},
-		}
-		if !allowedNext[currentStatus][body.Status] {
-			writeAPIError(w, http.StatusConflict, "INVALID_STATE", fmt.Sprintf("invalid transition %s -> %s", currentStatus, body.Status))
+	rows, err = a.db.Query(r.Context(), `
+    SELECT to_char(date_trunc('month', t.created_at AT TIME ZONE 'UTC'), 'YYYY-MM'),
+           COUNT(*), COALESCE(SUM(t.size_bytes),0)::bigint
+    FROM attachments t
+    WHERE `+window+`
+    GROUP BY 1
+    ORDER BY 1 DESC
+  `, f.From, f.To)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	byMonth := []map[string]any{}
+	for rows.Next() {
+		var month string
+		var n, size int64
+		if err := rows.Scan(&month, &n, &size); err != nil {
+			rows.Close()
+			writeDBError(w, err)
 			return
 		}
+		byMonth = append(byMonth, map[string]any{"month": month, "files": n, "bytes": size})
 	}
-
-	now := time.Now().UTC()
-	etaMinutes := 0
-	var lastLat, lastLng *float64
-	var lastUpdate *time.Time
-
-	// Default ETA if missing.
-	if eta == nil {
-		mins := estimateTravelMinutes(wlat, wlng, dlat, dlng)
-		e := now.Add(time.Duration(mins) * time.Minute)
-		eta = &e
-	}
-	if depart == nil {
-		d := now.Add(30 * time.Minute)
-		depart = &d
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
+		return
 	}
 
-	switch body.Status {
-	case "SCHEDULED":
-		// Keep schedule/eta as-is.
-		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
-	case "ON_DELIVERY":
-		// If starting delivery, set depart to now if it is in the future.
-		if depart.UTC().After(now) {
-			d := now
-			depart = &d
-		}
-		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
-		// initialize truck position at warehouse if missing
-		ll, lg := wlat, wlng
-		lastLat, lastLng = &ll, &lg
-		u := now
-		lastUpdate = &u
-	case "DELAYED":
-		// Push ETA forward by 60 minutes.
-		e2 := eta.UTC().Add(60 * time.Minute)
-		eta = &e2
-		etaMinutes = int(math.Max(0, eta.UTC().Sub(now).Minutes()))
-	case "COMPLETED":
-		etaMinutes = 0
-		ll, lg := dlat, dlng
-		lastLat, lastLng = &ll, &lg
-		u := now
-		lastUpdate = &u
-	case "RECEIVED":
-		etaMinutes = 0
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":          f.From,
+		"to":            f.To,
+		"files":         files,
+		"bytes":         bytes,
+		"unlinkedFiles": unlinkedFiles,
+		"unlinkedBytes": unlinkedBytes,
+		"byUploader":    byUploader,
+		"byMonth":       byMonth,
+	})
+}
+
+// handleAdminStorageGCReport is a dry run of the upload collector.
+func (a *App) handleAdminStorageGCReport(w http.ResponseWriter, r *http.Request) {
+	res, err := a.collector.Plan(r.Context())
+	if err != nil {
+		writeDBError(w, err)
+		return
 	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"graceSeconds":    int64(a.collector.Grace() / time.Second),
+		"intervalSeconds": int64(a.collector.Interval() / time.Second),
+		"pending":         res,
+	})
+}
 
-	if _, err := tx.Exec(r.Context(), `
-    UPDATE shipments
-    SET status=$1, depart_at=$2, arrive_eta=$3, eta_minutes=$4,
-        last_lat=COALESCE($5,last_lat), last_lng=COALESCE($6,last_lng), last_update=COALESCE($7,last_update),
-        updated_at=now()
-    WHERE id=$8
-  `, body.Status, depart, eta, etaMinutes, lastLat, lastLng, lastUpdate, id); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+func (a *App) handleAdminStorageGCRun(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	res, err := a.collector.CollectOnce(r.Context())
+	// Batches commit independently, so report what was removed even on failure.
+	a.insertAuditLog(r, &u, "STORAGE_GC_RUN", "attachment", "", map[string]any{
+		"attachments": res.Attachments,
+		"bytes":       res.Bytes,
+		"legacyFiles": res.LegacyFiles,
+		"legacyBytes": res.LegacyBytes,
+		"failed":      err != nil,
+	})
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, res)
+}
 
-	if orderReqID != nil && body.Status == "COMPLETED" {
-		_, _ = tx.Exec(r.Context(), `UPDATE order_requests SET status='FULFILLED', updated_at=now() WHERE id=$1`, *orderReqID)
+// ---------- admin: login security ----------
+
+func (a *App) handleAdminListLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if pageSize < 1 {
+		pageSize = 50
 	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+	offset := (page - 1) * pageSize
+	email := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("email")))
+	ip := strings.TrimSpace(r.URL.Query().Get("ip"))
+	failedOnly := r.URL.Query().Get("failed") == "true" || r.URL.Query().Get("failed") == "1"
 
-	if err := tx.Commit(r.Context()); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	rows, err := a.db.Query(r.Context(), `
+    SELECT id, email, user_id, ip, user_agent, success, reason, created_at
+    FROM login_attempts
+    WHERE ($1 = '' OR email = $1)
+      AND ($2 = '' OR ip = $2)
+      AND ($3::bool = false OR success = false)
+    ORDER BY id DESC
+    LIMIT $4 OFFSET $5
+  `, email, ip, failedOnly, pageSize, offset)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-	a.insertAuditLog(r, &u, "SHIPMENT_STATUS_UPDATED", "shipment", fmt.Sprintf("%d", id), map[string]any{"status": body.Status})
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": body.Status})
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var em, aip, ua, reason string
+		var userID *int64
+		var success bool
+		var createdAt time.Time
+		if err := rows.Scan(&id, &em, &userID, &aip, &ua, &success, &reason, &createdAt); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		items = append(items, map[string]any{
+			"id":        fmt.Sprintf("%d", id),
+			"email":     em,
+			"userId":    userID,
+			"ip":        aip,
+			"userAgent": ua,
+			"success":   success,
+			"reason":    reason,
+			"createdAt": createdAt.Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "page": page, "pageSize": pageSize})
 }
 
-// ---------- admin: distributors CRUD ----------
-
-func (a *App) handleAdminListDistributors(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, service_radius_km FROM distributors ORDER BY id`)
+func (a *App) handleAdminListLockouts(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(r.Context(), `
+    SELECT scope, subject, locked_until, updated_at
+    FROM login_lockouts
+    WHERE locked_until > now()
+    ORDER BY locked_until DESC
+  `)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
 	defer rows.Close()
 	items := []map[string]any{}
 	for rows.Next() {
-		var id int64
-		var name string
-		var lat, lng, rad float64
-		_ = rows.Scan(&id, &name, &lat, &lng, &rad)
-		items = append(items, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng, "serviceRadiusKm": rad})
+		var scope, subject string
+		var until, updated time.Time
+		if err := rows.Scan(&scope, &subject, &until, &updated); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		items = append(items, map[string]any{
+			"scope":       scope,
+			"subject":     subject,
+			"lockedUntil": until.Format(time.RFC3339),
+			"updatedAt":   updated.Format(time.RFC3339),
+		})
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleAdminCreateDistributor(w http.ResponseWriter, r *http.Request) {
-	var body struct {
-		Name            string  `json:"name"`
-		Lat             float64 `json:"lat"`
-		Lng             float64 `json:"lng"`
-		ServiceRadiusKm float64 `json:"serviceRadiusKm"`
+// handleAdminClearLockout lifts a lock and resets its failure counter. The
+// subject is passed as a query parameter since emails and IPv6 addresses do not
+// sit well in a path segment.
+func (a *App) handleAdminClearLockout(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	scope := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("scope")))
+	subject := strings.TrimSpace(r.URL.Query().Get("subject"))
+	if scope == lockoutScopeEmail {
+		subject = strings.ToLower(subject)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	if (scope != lockoutScopeEmail && scope != lockoutScopeIP) || subject == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "scope (EMAIL|IP) and subject required")
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+	tag, err := a.db.Exec(r.Context(), `DELETE FROM login_lockouts WHERE scope=$1 AND subject=$2`, scope, subject)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if body.ServiceRadiusKm <= 0 {
-		body.ServiceRadiusKm = 10
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "lockout not found")
+		return
 	}
-	var id int64
-	err := a.db.QueryRow(r.Context(),
-		`INSERT INTO distributors (name, lat, lng, service_radius_km) VALUES ($1,$2,$3,$4) RETURNING id`,
-		body.Name, body.Lat, body.Lng, body.ServiceRadiusKm).Scan(&id)
+	a.insertAuditLog(r, &u, "LOGIN_LOCKOUT_CLEARED", "login", subject, map[string]any{"scope": scope})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// ---------- admin: api tokens ----------
+
+func (a *App) handleAdminListAPITokens(w http.ResponseWriter, r *http.Request) {
+	var userID *int64
+	if v := strings.TrimSpace(r.URL.Query().Get("userId")); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid userId")
+			return
+		}
+		userID = &id
+	}
+	includeRevoked := r.URL.Query().Get("includeRevoked") == "true" || r.URL.Query().Get("includeRevoked") == "1"
+	items, err := a.listAPITokens(r.Context(), userID, includeRevoked)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleAdminUpdateDistributor(w http.ResponseWriter, r *http.Request) {
+// handleAdminCreateAPIToken issues a token for any non-distributor user, e.g. a
+// dedicated service account for an integration.
+func (a *App) handleAdminCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body apiTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if body.UserID == nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "userId required")
+		return
+	}
+	item, ok := a.createAPIToken(w, r, u, *body.UserID, body)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// handleAdminUpdateAPIToken changes a token's name, scopes and expiry. The
+// secret itself never changes; issue a new token to rotate it.
+func (a *App) handleAdminUpdateAPIToken(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	var body struct {
-		Name            string  `json:"name"`
-		Lat             float64 `json:"lat"`
-		Lng             float64 `json:"lng"`
-		ServiceRadiusKm float64 `json:"serviceRadiusKm"`
-	}
+	var body apiTokenRequest
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
 		return
 	}
-	if body.ServiceRadiusKm <= 0 {
-		body.ServiceRadiusKm = 10
+	scopes, err := normalizeAPITokenScopes(body.Scopes)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
 	}
-	tag, err := a.db.Exec(r.Context(),
-		`UPDATE distributors SET name=$1, lat=$2, lng=$3, service_radius_km=$4 WHERE id=$5`,
-		body.Name, body.Lat, body.Lng, body.ServiceRadiusKm, id)
+	expiresAt, err := parseAPITokenExpiry(body.ExpiresAt)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `
+    UPDATE api_tokens SET name=$2, scopes=$3, expires_at=$4
+    WHERE id=$1 AND revoked_at IS NULL
+  `, id, name, scopes, expiresAt)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
 	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "token not found")
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, &u, "API_TOKEN_UPDATED", "api_token", fmt.Sprintf("%d", id), map[string]any{
+		"name":      name,
+		"scopes":    scopes,
+		"expiresAt": expiresAt,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (a *App) handleAdminDeleteDistributor(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleAdminRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	a.revokeAPIToken(w, r, u, nil)
+}
+
+// ---------- admin: master data history ----------
+
+// beginChangeTx starts a transaction whose master-data writes the
+// entity_history trigger attributes to actor.
+func (a *App) beginChangeTx(ctx context.Context, actor User) (pgx.Tx, error) {
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `SELECT set_config('app.actor_user_id', $1, true)`, fmt.Sprintf("%d", actor.ID)); err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// fieldChange is one field's old and new value in a history entry.
+type fieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// diffSnapshots lists the fields that differ between two row snapshots,
+// sorted by name and camelCased to match the API. A nil snapshot (before an
+// insert, after a delete) reads as every field being null.
+func diffSnapshots(before, after map[string]any) []fieldChange {
+	names := []string{}
+	for k := range before {
+		names = append(names, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	slices.Sort(names)
+	out := []fieldChange{}
+	for _, k := range names {
+		if k == "id" || reflect.DeepEqual(before[k], after[k]) {
+			continue
+		}
+		out = append(out, fieldChange{Field: snakeToCamel(k), From: before[k], To: after[k]})
+	}
+	return out
+}
+
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// handleAdminEntityHistory returns the change timeline of one plant,
+// warehouse, distributor, store or project, newest first, each entry with
+// its field-level diff. nextCursor is passed back as cursor for older entries.
+func (a *App) handleAdminEntityHistory(w http.ResponseWriter, r *http.Request) {
+	entity := chi.URLParam(r, "entity")
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	tag, err := a.db.Exec(r.Context(), `DELETE FROM distributors WHERE id=$1`, id)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error: "+err.Error())
-		return
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit < 1 {
+		limit = 50
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
-		return
+	if limit > 500 {
+		limit = 500
+	}
+	var cursor *int64
+	if c := q.Get("cursor"); c != "" {
+		v, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid cursor")
+			return
+		}
+		cursor = &v
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
-
-// ---------- admin: stores CRUD ----------
 
-func (a *App) handleAdminListStores(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng FROM stores ORDER BY id`)
+	rows, err := a.db.Query(r.Context(), `
+    SELECT h.id, h.ts, h.op, h.before, h.after, h.actor_user_id, COALESCE(u.name,'')
+    FROM entity_history h
+    LEFT JOIN users u ON u.id = h.actor_user_id
+    WHERE h.entity_type=$1 AND h.entity_id=$2 AND ($3::bigint IS NULL OR h.id < $3)
+    ORDER BY h.id DESC
+    LIMIT $4
+  `, entity, id, cursor, limit+1)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeDBError(w, err)
 		return
 	}
 	defer rows.Close()
 	items := []map[string]any{}
+	var next any
 	for rows.Next() {
-		var id int64
-		var name string
-		var lat, lng float64
-		_ = rows.Scan(&id, &name, &lat, &lng)
-		items = append(items, map[string]any{"id": id, "name": name, "lat": lat, "lng": lng})
+		var hid int64
+		var ts time.Time
+		var op, actorName string
+		var before, after map[string]any
+		var actorID *int64
+		if err := rows.Scan(&hid, &ts, &op, &before, &after, &actorID, &actorName); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if len(items) == limit {
+			next = items[len(items)-1]["id"]
+			break
+		}
+		items = append(items, map[string]any{
+			"id":          fmt.Sprintf("%d", hid),
+			"ts":          ts.Format(time.RFC3339),
+			"op":          op,
+			"actorUserId": actorID,
+			"actorName":   actorName,
+			"changes":     diffSnapshots(before, after),
+		})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entityType": entity, "entityId": fmt.Sprintf("%d", id), "items": items, "nextCursor": next})
 }
 
-func (a *App) handleAdminCreateStore(w http.ResponseWriter, r *http.Request) {
-	var body struct {
-		Name string  `json:"name"`
-		Lat  float64 `json:"lat"`
-		Lng  float64 `json:"lng"`
+// ---------- admin: master data archiving ----------
+
+// masterEntityParam matches the master-data tables in admin routes as
+// {entity}.
+const masterEntityParam = "{entity:plants|warehouses|distributors|stores|projects}"
+
+// masterEntityNouns names the soft-deletable master-data tables in messages.
+var masterEntityNouns = map[string]string{
+	"plants":       "plant",
+	"warehouses":   "warehouse",
+	"distributors": "distributor",
+	"stores":       "store",
+	"projects":     "project",
+}
+
+// deleteBlocker is a kind of open record that keeps a master-data row from
+// being archived, with up to 20 of the blocking ids.
+type deleteBlocker struct {
+	Kind  string   `json:"kind"`
+	Count int64    `json:"count"`
+	IDs   []string `json:"ids"`
+}
+
+// deleteBlockerQueries list, per table, the open records that would be
+// orphaned by archiving row $1: shipments, transfers and plant dispatches
+// still on the road and orders not yet fulfilled or rejected.
+var deleteBlockerQueries = map[string][]struct{ kind, sql string }{
+	"plants": {
+		{"plant_dispatch", `SELECT id FROM plant_dispatches WHERE plant_id=$1 AND status IN ('SCHEDULED','IN_TRANSIT') ORDER BY id`},
+	},
+	"warehouses": {
+		{"shipment", `SELECT id FROM shipments WHERE from_warehouse_id=$1 AND status IN ('SCHEDULED','ON_DELIVERY','DELAYED') ORDER BY id`},
+		{"stock_transfer", `SELECT id FROM stock_transfers WHERE $1 IN (from_warehouse_id, to_warehouse_id) AND status IN ('SCHEDULED','IN_TRANSIT') ORDER BY id`},
+		{"plant_dispatch", `SELECT id FROM plant_dispatches WHERE to_warehouse_id=$1 AND status IN ('SCHEDULED','IN_TRANSIT') ORDER BY id`},
+	},
+	"distributors": {
+		{"shipment", `SELECT id FROM shipments WHERE to_distributor_id=$1 AND status IN ('SCHEDULED','ON_DELIVERY','DELAYED') ORDER BY id`},
+		{"order_request", `SELECT id FROM order_requests WHERE distributor_id=$1 AND status IN ('PENDING','APPROVED') ORDER BY id`},
+	},
+}
+
+func deleteBlockers(ctx context.Context, tx pgx.Tx, table string, id int64) ([]deleteBlocker, error) {
+	out := []deleteBlocker{}
+	for _, q := range deleteBlockerQueries[table] {
+		rows, err := tx.Query(ctx, q.sql, id)
+		if err != nil {
+			return nil, err
+		}
+		b := deleteBlocker{Kind: q.kind, IDs: []string{}}
+		for rows.Next() {
+			var bid int64
+			if err := rows.Scan(&bid); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			b.Count++
+			if len(b.IDs) < 20 {
+				b.IDs = append(b.IDs, fmt.Sprintf("%d", bid))
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if b.Count > 0 {
+			out = append(out, b)
+		}
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	return out, nil
+}
+
+// archiveEntity soft-deletes row {id} of table by setting archived_at, which
+// hides it from lists and planning while keeping the orders, stock and sales
+// that reference it. A row with open shipments or orders is left alone and the
+// 409 response lists the blockers.
+func (a *App) archiveEntity(w http.ResponseWriter, r *http.Request, table string) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	noun := masterEntityNouns[table]
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	var id int64
-	err := a.db.QueryRow(r.Context(),
-		`INSERT INTO stores (name, lat, lng) VALUES ($1,$2,$3) RETURNING id`,
-		body.Name, body.Lat, body.Lng).Scan(&id)
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var archived bool
+	if err := tx.QueryRow(r.Context(), `SELECT archived_at IS NOT NULL FROM `+table+` WHERE id=$1 FOR UPDATE`, id).Scan(&archived); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", noun+" not found")
+			return
+		}
+		writeDBError(w, err)
+		return
+	}
+	if archived {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", noun+" is already archived")
+		return
+	}
+	blockers, err := deleteBlockers(r.Context(), tx, table, id)
 	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if len(blockers) > 0 {
+		parts := make([]string, len(blockers))
+		for i, b := range blockers {
+			parts[i] = fmt.Sprintf("%d open %s(s)", b.Count, strings.ReplaceAll(b.Kind, "_", " "))
+		}
+		writeJSON(w, http.StatusConflict, map[string]any{"error": map[string]any{
+			"code":     "IN_USE",
+			"message":  fmt.Sprintf("%s has %s; close them before deleting", noun, strings.Join(parts, " and ")),
+			"blockers": blockers,
+		}})
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `UPDATE `+table+` SET archived_at=now() WHERE id=$1`, id); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (a *App) handleAdminUpdateStore(w http.ResponseWriter, r *http.Request) {
+// handleAdminRestoreEntity clears archived_at on an archived plant,
+// warehouse, distributor, store or project.
+func (a *App) handleAdminRestoreEntity(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	table := chi.URLParam(r, "entity")
+	noun := masterEntityNouns[table]
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	var body struct {
-		Name string  `json:"name"`
-		Lat  float64 `json:"lat"`
-		Lng  float64 `json:"lng"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	var archived bool
+	if err := tx.QueryRow(r.Context(), `SELECT archived_at IS NOT NULL FROM `+table+` WHERE id=$1 FOR UPDATE`, id).Scan(&archived); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", noun+" not found")
+			return
+		}
+		writeDBError(w, err)
+		return
+	}
+	if !archived {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", noun+" is not archived")
 		return
 	}
-	tag, err := a.db.Exec(r.Context(),
-		`UPDATE stores SET name=$1, lat=$2, lng=$3 WHERE id=$4`,
-		body.Name, body.Lat, body.Lng, id)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	if _, err := tx.Exec(r.Context(), `UPDATE `+table+` SET archived_at=NULL WHERE id=$1`, id); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "store not found")
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (a *App) handleAdminDeleteStore(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
-		return
+// ---------- admin: master data import ----------
+
+// maxImportRows caps the data rows of one import file.
+const maxImportRows = 5000
+
+type importColumnKind int
+
+const (
+	importText importColumnKind = iota
+	importLat
+	importLng
+	importNonNegative
+	importPositive
+	importDate
+)
+
+// importColumn is one importable column: its header as named in the API
+// (matched ignoring case, spaces and underscores) and its table column.
+type importColumn struct {
+	header   string
+	column   string
+	kind     importColumnKind
+	required bool
+	// def fills the column on create when the cell is blank.
+	def func() any
+}
+
+var (
+	importName = importColumn{header: "name", column: "name", kind: importText, required: true}
+	importLatC = importColumn{header: "lat", column: "lat", kind: importLat, required: true}
+	importLngC = importColumn{header: "lng", column: "lng", kind: importLng, required: true}
+)
+
+// masterImportColumns lists the importable columns per table, with the same
+// defaults as the create endpoints. An optional id column turns a row into an
+// update of that existing row; blank cells then keep their current values.
+var masterImportColumns = map[string][]importColumn{
+	"plants": {importName, importLatC, importLngC},
+	"warehouses": {importName, importLatC, importLngC,
+		{header: "capacityTons", column: "capacity_tons", kind: importNonNegative, def: func() any { return 0.0 }}},
+	"distributors": {importName, importLatC, importLngC,
+		{header: "serviceRadiusKm", column: "service_radius_km", kind: importPositive, def: func() any { return 10.0 }}},
+	"stores": {importName, importLatC, importLngC},
+	"projects": {importName,
+		{header: "type", column: "type", kind: importText, def: func() any { return "CONSTRUCTION" }},
+		importLatC, importLngC,
+		{header: "startDate", column: "start_date", kind: importDate, def: func() any { return time.Now().Format("2006-01-02") }},
+		{header: "endDate", column: "end_date", kind: importDate, def: func() any { return time.Now().AddDate(0, 6, 0).Format("2006-01-02") }},
+		{header: "demandTonsMonth", column: "demand_tons_month", kind: importNonNegative, def: func() any { return 0.0 }}},
+}
+
+// importIssue is one problem found in an import file. Row is the spreadsheet
+// line number (the header is row 1).
+type importIssue struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type importReport struct {
+	Entity    string        `json:"entity"`
+	Mode      string        `json:"mode"`
+	File      string        `json:"file"`
+	Rows      int           `json:"rows"`
+	Valid     int           `json:"valid"`
+	Creates   int           `json:"creates"`
+	Updates   int           `json:"updates"`
+	Committed bool          `json:"committed"`
+	Errors    []importIssue `json:"errors"`
+}
+
+// importRow is a parsed data row: id is set for updates, values holds the
+// non-blank cells by table column.
+type importRow struct {
+	line   int
+	id     *int64
+	values map[string]any
+	bad    bool
+}
+
+func normalizeImportHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimSpace(h))
+}
+
+// parseImportNumber accepts a decimal comma as well as a point.
+func parseImportNumber(v string) (float64, error) {
+	if strings.Count(v, ",") == 1 && !strings.Contains(v, ".") {
+		v = strings.Replace(v, ",", ".", 1)
 	}
-	tag, err := a.db.Exec(r.Context(), `DELETE FROM stores WHERE id=$1`, id)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error: "+err.Error())
-		return
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("must be a number")
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "store not found")
-		return
+	return f, nil
+}
+
+func parseImportCell(kind importColumnKind, v string) (any, error) {
+	switch kind {
+	case importText:
+		return v, nil
+	case importDate:
+		if t, err := time.Parse("2006-01-02", v); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+		// XLSX stores dates as serial day numbers.
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f < 2958466 {
+			return sheet.ExcelDate(f).Format("2006-01-02"), nil
+		}
+		return nil, errors.New("must be a date (YYYY-MM-DD)")
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	f, err := parseImportNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case kind == importLat && (f < -90 || f > 90):
+		return nil, errors.New("must be between -90 and 90")
+	case kind == importLng && (f < -180 || f > 180):
+		return nil, errors.New("must be between -180 and 180")
+	case kind == importNonNegative && f < 0:
+		return nil, errors.New("must be >= 0")
+	case kind == importPositive && f <= 0:
+		return nil, errors.New("must be > 0")
+	}
+	return f, nil
 }
 
-// ---------- admin: projects CRUD ----------
+// parseMasterImport checks the header and every cell of rows against the
+// table's columns, recording problems in rep.
+func parseMasterImport(table string, rows [][]string, rep *importReport) []importRow {
+	cols := masterImportColumns[table]
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+	if len(rows) == 0 {
+		rep.Errors = append(rep.Errors, importIssue{Row: 1, Message: "file is empty"})
+		return nil
+	}
 
-func (a *App) handleAdminListProjects(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `SELECT id, name, type, lat, lng, start_date, end_date, demand_tons_month FROM projects ORDER BY id`)
+	// Map header positions to columns; -1 is the id column.
+	byHeader := map[string]int{"id": -1}
+	for i, c := range cols {
+		byHeader[normalizeImportHeader(c.header)] = i
+	}
+	pos := map[int]int{}
+	seen := map[int]bool{}
+	for i, h := range rows[0] {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		ci, ok := byHeader[normalizeImportHeader(h)]
+		if !ok {
+			rep.Errors = append(rep.Errors, importIssue{Row: 1, Field: h, Message: "unknown column"})
+			continue
+		}
+		if seen[ci] {
+			rep.Errors = append(rep.Errors, importIssue{Row: 1, Field: h, Message: "duplicate column"})
+			continue
+		}
+		seen[ci] = true
+		pos[i] = ci
+	}
+	for i, c := range cols {
+		if c.required && !seen[i] {
+			rep.Errors = append(rep.Errors, importIssue{Row: 1, Field: c.header, Message: "missing required column"})
+		}
+	}
+	if len(rep.Errors) > 0 {
+		return nil
+	}
+	out := []importRow{}
+	ids := map[int64]int{}
+	for n, cells := range rows[1:] {
+		line := n + 2
+		blank := true
+		for _, c := range cells {
+			if strings.TrimSpace(c) != "" {
+				blank = false
+			}
+		}
+		if blank {
+			continue
+		}
+		rep.Rows++
+		row := importRow{line: line, values: map[string]any{}}
+		fail := func(field, msg string) {
+			rep.Errors = append(rep.Errors, importIssue{Row: line, Field: field, Message: msg})
+			row.bad = true
+		}
+		for i, raw := range cells {
+			ci, ok := pos[i]
+			v := strings.TrimSpace(raw)
+			if !ok || v == "" {
+				continue
+			}
+			if ci == -1 {
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || id <= 0 {
+					fail("id", "must be a positive integer")
+					continue
+				}
+				if first, dup := ids[id]; dup {
+					fail("id", fmt.Sprintf("same id as row %d", first))
+					continue
+				}
+				ids[id] = line
+				row.id = &id
+				continue
+			}
+			val, err := parseImportCell(cols[ci].kind, v)
+			if err != nil {
+				fail(cols[ci].header, err.Error())
+				continue
+			}
+			row.values[cols[ci].column] = val
+		}
+		if row.id == nil {
+			for _, c := range cols {
+				if _, ok := row.values[c.column]; ok {
+					continue
+				}
+				if c.required {
+					fail(c.header, "required")
+				} else if c.def != nil {
+					row.values[c.column] = c.def()
+				}
+			}
+		}
+		if start, ok := row.values["start_date"].(string); ok {
+			if end, ok := row.values["end_date"].(string); ok && end < start {
+				fail("endDate", "must not be before startDate")
+			}
+		}
+		out = append(out, row)
+	}
+	if rep.Rows == 0 {
+		rep.Errors = append(rep.Errors, importIssue{Row: 2, Message: "no data rows"})
+	}
+	return out
+}
+
+// checkImportReferences checks parsed rows against the table inside tx:
+// update ids must name existing, unarchived rows, and new rows must not reuse
+// the name of a live row or of another row in the file, so that importing
+// the same file twice does not create duplicates.
+func checkImportReferences(ctx context.Context, tx pgx.Tx, table string, rows []importRow, rep *importReport) error {
+	live := map[int64]bool{}
+	names := map[string]int64{}
+	dbRows, err := tx.Query(ctx, `SELECT id, lower(name), archived_at IS NULL FROM `+table)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+		return err
 	}
-	defer rows.Close()
-	items := []map[string]any{}
-	for rows.Next() {
+	archived := map[int64]bool{}
+	for dbRows.Next() {
 		var id int64
-		var name, ptype string
-		var lat, lng, demand float64
-		var startDate, endDate time.Time
-		_ = rows.Scan(&id, &name, &ptype, &lat, &lng, &startDate, &endDate, &demand)
-		items = append(items, map[string]any{
-			"id": id, "name": name, "type": ptype,
-			"lat": lat, "lng": lng,
-			"startDate":       startDate.Format("2006-01-02"),
-			"endDate":         endDate.Format("2006-01-02"),
-			"demandTonsMonth": demand,
-		})
+		var name string
+		var isLive bool
+		if err := dbRows.Scan(&id, &name, &isLive); err != nil {
+			dbRows.Close()
+			return err
+		}
+		if isLive {
+			live[id] = true
+			names[name] = id
+		} else {
+			archived[id] = true
+		}
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	dbRows.Close()
+	if err := dbRows.Err(); err != nil {
+		return err
+	}
+
+	fileNames := map[string]int{}
+	for i := range rows {
+		row := &rows[i]
+		fail := func(field, msg string) {
+			rep.Errors = append(rep.Errors, importIssue{Row: row.line, Field: field, Message: msg})
+			row.bad = true
+		}
+		if row.id != nil {
+			switch {
+			case archived[*row.id]:
+				fail("id", fmt.Sprintf("%s %d is archived; restore it first", masterEntityNouns[table], *row.id))
+			case !live[*row.id]:
+				fail("id", fmt.Sprintf("%s %d not found", masterEntityNouns[table], *row.id))
+			}
+		}
+		name, ok := row.values["name"].(string)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(name)
+		if first, dup := fileNames[key]; dup {
+			fail("name", fmt.Sprintf("same name as row %d", first))
+			continue
+		}
+		fileNames[key] = row.line
+		if existing, ok := names[key]; ok && (row.id == nil || *row.id != existing) {
+			fail("name", fmt.Sprintf("already used by %s %d; add an id column to update it", masterEntityNouns[table], existing))
+		}
+	}
+	return nil
 }
 
-func (a *App) handleAdminCreateProject(w http.ResponseWriter, r *http.Request) {
-	var body struct {
-		Name            string  `json:"name"`
-		Type            string  `json:"type"`
-		Lat             float64 `json:"lat"`
-		Lng             float64 `json:"lng"`
-		StartDate       string  `json:"startDate"`
-		EndDate         string  `json:"endDate"`
-		DemandTonsMonth float64 `json:"demandTonsMonth"`
+// handleAdminImportEntity imports plants, warehouses, distributors, stores or
+// projects from a CSV or XLSX upload (multipart field "file"). mode=dry-run
+// (the default) only validates and reports; mode=commit applies every row in
+// one transaction, or none when any row is invalid, and writes one audit
+// entry for the import.
+func (a *App) handleAdminImportEntity(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	table := chi.URLParam(r, "entity")
+	mode := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mode")))
+	if mode == "" {
+		mode = "dry-run"
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	if mode != "dry-run" && mode != "commit" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "mode must be dry-run or commit")
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid multipart form")
 		return
 	}
-	if body.Type == "" {
-		body.Type = "CONSTRUCTION"
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "file required")
+		return
 	}
-	if body.StartDate == "" {
-		body.StartDate = time.Now().Format("2006-01-02")
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "file error")
+		return
 	}
-	if body.EndDate == "" {
-		body.EndDate = time.Now().AddDate(0, 6, 0).Format("2006-01-02")
+	// One header line plus the data rows.
+	cells, err := sheet.Read(data, header.Filename, maxImportRows+1)
+	if errors.Is(err, sheet.ErrTooLarge) {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("at most %d rows and %d columns per import", maxImportRows, sheet.MaxColumns))
+		return
 	}
-	var id int64
-	err := a.db.QueryRow(r.Context(),
-		`INSERT INTO projects (name, type, lat, lng, start_date, end_date, demand_tons_month) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id`,
-		body.Name, body.Type, body.Lat, body.Lng, body.StartDate, body.EndDate, body.DemandTonsMonth).Scan(&id)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
-	writeJSON(w, http.StatusCreated, map[string]any{"id": id})
-}
 
-func (a *App) handleAdminUpdateProject(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	rep := importReport{Entity: table, Mode: mode, File: header.Filename, Errors: []importIssue{}}
+	rows := parseMasterImport(table, cells, &rep)
+
+	tx, err := a.beginChangeTx(r.Context(), u)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	var body struct {
-		Name            string  `json:"name"`
-		Type            string  `json:"type"`
-		Lat             float64 `json:"lat"`
-		Lng             float64 `json:"lng"`
-		StartDate       string  `json:"startDate"`
-		EndDate         string  `json:"endDate"`
-		DemandTonsMonth float64 `json:"demandTonsMonth"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	// Block concurrent imports and edits of this table until we are done.
+	if _, err := tx.Exec(r.Context(), `LOCK TABLE `+table+` IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+	if err := checkImportReferences(r.Context(), tx, table, rows, &rep); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	tag, err := a.db.Exec(r.Context(),
-		`UPDATE projects SET name=$1, type=$2, lat=$3, lng=$4, start_date=$5, end_date=$6, demand_tons_month=$7 WHERE id=$8`,
-		body.Name, body.Type, body.Lat, body.Lng, body.StartDate, body.EndDate, body.DemandTonsMonth, id)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	for _, row := range rows {
+		switch {
+		case row.bad:
+		case row.id != nil:
+			rep.Valid++
+			rep.Updates++
+		default:
+			rep.Valid++
+			rep.Creates++
+		}
+	}
+	slices.SortStableFunc(rep.Errors, func(x, y importIssue) int { return x.Row - y.Row })
+
+	if mode == "dry-run" {
+		writeJSON(w, http.StatusOK, rep)
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+	if len(rep.Errors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, rep)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
 
-func (a *App) handleAdminDeleteProject(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
-		return
+	for _, row := range rows {
+		keys := make([]string, 0, len(row.values))
+		for k := range row.values {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		args := make([]any, 0, len(keys)+1)
+		for _, k := range keys {
+			args = append(args, row.values[k])
+		}
+		var q string
+		if row.id != nil {
+			if len(keys) == 0 {
+				continue
+			}
+			sets := make([]string, len(keys))
+			for i, k := range keys {
+				sets[i] = fmt.Sprintf("%s=$%d", k, i+1)
+			}
+			q = fmt.Sprintf(`UPDATE %s SET %s WHERE id=$%d`, table, strings.Join(sets, ", "), len(keys)+1)
+			args = append(args, *row.id)
+		} else {
+			ph := make([]string, len(keys))
+			for i := range keys {
+				ph[i] = fmt.Sprintf("$%d", i+1)
+			}
+			q = fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, table, strings.Join(keys, ", "), strings.Join(ph, ", "))
+		}
+		if _, err := tx.Exec(r.Context(), q, args...); err != nil {
+			log.Printf("import %s row %d: %v", table, row.line, err)
+			writeAPIError(w, http.StatusInternalServerError, "INTERNAL", fmt.Sprintf("db error at row %d", row.line))
+			return
+		}
 	}
-	tag, err := a.db.Exec(r.Context(), `DELETE FROM projects WHERE id=$1`, id)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	sum := sha256.Sum256(data)
+	if err := a.insertAuditLogTx(tx, r, &u, "MASTER_DATA_IMPORTED", table, "", map[string]any{
+		"file":    header.Filename,
+		"sha256":  hex.EncodeToString(sum[:]),
+		"rows":    rep.Rows,
+		"created": rep.Creates,
+		"updated": rep.Updates,
+	}); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	rep.Committed = true
+	writeJSON(w, http.StatusOK, rep)
 }
 
-// ---------- admin: users ----------
+// ---------- admin: plants CRUD ----------
 
-func (a *App) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `
-    SELECT
-      u.id,
-      u.name,
-      u.email,
-      u.role,
-      u.distributor_id,
-      u.disabled_at,
-      COALESCE(MAX(s.created_at), NULL) AS last_login_at
-    FROM users u
-    LEFT JOIN sessions s ON s.user_id = u.id
-    GROUP BY u.id
-    ORDER BY u.id
-  `)
+func (a *App) handleAdminListPlants(w http.ResponseWriter, r *http.Request) {
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("includeArchived"))
+	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, archived_at FROM plants WHERE ($1 OR archived_at IS NULL) ORDER BY id`, includeArchived)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	defer rows.Close()
-
 	items := []map[string]any{}
 	for rows.Next() {
 		var id int64
-		var name, email, role string
-		var distributorID sql.NullInt64
-		var disabledAt sql.NullTime
-		var lastLoginAt sql.NullTime
-		_ = rows.Scan(&id, &name, &email, &role, &distributorID, &disabledAt, &lastLoginAt)
-
-		status := "ACTIVE"
-		if disabledAt.Valid {
-			status = "DISABLED"
-		}
-		last := ""
-		if lastLoginAt.Valid {
-			last = lastLoginAt.Time.Format("2006-01-02 15:04")
-		}
-		var dist any = nil
-		if distributorID.Valid {
-			dist = fmt.Sprintf("%d", distributorID.Int64)
-		}
-		items = append(items, map[string]any{
-			"id":            fmt.Sprintf("%d", id),
-			"name":          name,
-			"email":         email,
-			"role":          role,
-			"distributorId": dist,
-			"status":        status,
-			"lastLoginAt":   last,
-		})
+		var name string
+		var lat, lng float64
+		var archivedAt *time.Time
+		_ = rows.Scan(&id, &name, &lat, &lng, &archivedAt)
+		items = append(items, map[string]any{"id": fmt.Sprintf("%d", id), "name": name, "lat": lat, "lng": lng, "archivedAt": archivedAt})
 	}
-
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleAdminCreatePlant(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
 	var body struct {
-		Name          string `json:"name"`
-		Email         string `json:"email"`
-		Password      string `json:"password"`
-		Role          string `json:"role"`
-		DistributorID *int64 `json:"distributorId"`
+		Name string  `json:"name"`
+		Lat  float64 `json:"lat"`
+		Lng  float64 `json:"lng"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	body.Name = strings.TrimSpace(body.Name)
-	body.Email = strings.TrimSpace(strings.ToLower(body.Email))
-	body.Role = strings.TrimSpace(body.Role)
-	if body.Name == "" || body.Email == "" || !strings.Contains(body.Email, "@") {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name and valid email required")
-		return
-	}
-	if strings.TrimSpace(body.Password) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "password required")
-		return
-	}
-	allowedRole := map[string]bool{"SUPER_ADMIN": true, "MANAGEMENT": true, "OPERATOR": true, "DISTRIBUTOR": true}
-	if !allowedRole[body.Role] {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid role")
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
 		return
 	}
-	if body.Role == "DISTRIBUTOR" && body.DistributorID == nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "distributorId required for DISTRIBUTOR")
+	var id int64
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "could not hash password")
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	if err = tx.QueryRow(r.Context(), `INSERT INTO plants (name, lat, lng) VALUES ($1,$2,$3) RETURNING id`, body.Name, body.Lat, body.Lng).Scan(&id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-
-	var id int64
-	err = a.db.QueryRow(r.Context(), `
-    INSERT INTO users (name, email, password_hash, role, distributor_id)
-    VALUES ($1,$2,$3,$4,$5)
-    RETURNING id
-  `, body.Name, body.Email, string(hash), body.Role, body.DistributorID).Scan(&id)
-	if err != nil {
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-
 	writeJSON(w, http.StatusCreated, map[string]any{"id": fmt.Sprintf("%d", id)})
 }
 
-func (a *App) handleAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleAdminUpdatePlant(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
 	var body struct {
-		Name          string `json:"name"`
-		Email         string `json:"email"`
-		Role          string `json:"role"`
-		DistributorID *int64 `json:"distributorId"`
+		Name string  `json:"name"`
+		Lat  float64 `json:"lat"`
+		Lng  float64 `json:"lng"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	body.Name = strings.TrimSpace(body.Name)
-	body.Email = strings.TrimSpace(strings.ToLower(body.Email))
-	body.Role = strings.TrimSpace(body.Role)
-	if body.Name == "" || body.Email == "" || !strings.Contains(body.Email, "@") {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name and valid email required")
-		return
-	}
-	allowedRole := map[string]bool{"SUPER_ADMIN": true, "MANAGEMENT": true, "OPERATOR": true, "DISTRIBUTOR": true}
-	if !allowedRole[body.Role] {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid role")
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
 		return
 	}
-	if body.Role == "DISTRIBUTOR" && body.DistributorID == nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "distributorId required for DISTRIBUTOR")
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if body.Role != "DISTRIBUTOR" {
-		body.DistributorID = nil
-	}
-
-	tag, err := a.db.Exec(r.Context(), `UPDATE users SET name=$1, email=$2, role=$3, distributor_id=$4 WHERE id=$5`, body.Name, body.Email, body.Role, body.DistributorID, id)
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `UPDATE plants SET name=$1, lat=$2, lng=$3 WHERE id=$4`, body.Name, body.Lat, body.Lng, id)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "plant not found")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (a *App) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+// handleAdminDeletePlant archives the plant; see archiveEntity.
+func (a *App) handleAdminDeletePlant(w http.ResponseWriter, r *http.Request) {
+	a.archiveEntity(w, r, "plants")
+}
+
+func (a *App) handleAdminGetPlantCapacity(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	tag, err := a.db.Exec(r.Context(), `DELETE FROM users WHERE id=$1`, id)
+	rows, err := a.db.Query(r.Context(), `
+    SELECT cement_type, daily_capacity_tons, updated_at
+    FROM plant_capacities
+    WHERE plant_id=$1
+    ORDER BY cement_type
+  `, id)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
-		return
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var ct string
+		var capacity float64
+		var updated time.Time
+		_ = rows.Scan(&ct, &capacity, &updated)
+		items = append(items, map[string]any{"cementType": ct, "dailyCapacityTons": capacity, "updatedAt": updated})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleAdminUpdateUserStatus(w http.ResponseWriter, r *http.Request) {
+// handleAdminPutPlantCapacity replaces the plant's capacity list. Cement types
+// left out can no longer have production recorded.
+func (a *App) handleAdminPutPlantCapacity(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
 	var body struct {
-		Status string `json:"status"`
+		Items []struct {
+			CementType        string  `json:"cementType"`
+			DailyCapacityTons float64 `json:"dailyCapacityTons"`
+		} `json:"items"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	body.Status = strings.TrimSpace(body.Status)
-	if body.Status != "ACTIVE" && body.Status != "DISABLED" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be ACTIVE|DISABLED")
-		return
-	}
-	var tag pgconn.CommandTag
-	if body.Status == "DISABLED" {
-		tag, err = a.db.Exec(r.Context(), `UPDATE users SET disabled_at = now() WHERE id=$1`, id)
-	} else {
-		tag, err = a.db.Exec(r.Context(), `UPDATE users SET disabled_at = NULL WHERE id=$1`, id)
+	types := []string{}
+	seen := map[string]bool{}
+	for i := range body.Items {
+		it := &body.Items[i]
+		it.CementType = strings.TrimSpace(it.CementType)
+		if it.CementType == "" || it.DailyCapacityTons <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "each item needs a cementType and a positive dailyCapacityTons")
+			return
+		}
+		if seen[it.CementType] {
+			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "duplicate cementType "+it.CementType)
+			return
+		}
+		seen[it.CementType] = true
+		types = append(types, it.CementType)
 	}
+
+	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "user not found")
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	if err := tx.QueryRow(r.Context(), `SELECT id FROM plants WHERE id=$1 AND archived_at IS NULL FOR UPDATE`, id).Scan(&id); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "plant not found")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
-
-func (a *App) handleAdminResetUserPassword(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+	if _, err := tx.Exec(r.Context(), `DELETE FROM plant_capacities WHERE plant_id=$1 AND NOT (cement_type = ANY($2))`, id, types); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	temp := strings.ReplaceAll(uuid.NewString(), "-", "")
-	if len(temp) > 12 {
-		temp = temp[:12]
+	for _, it := range body.Items {
+		if _, err := tx.Exec(r.Context(), `
+      INSERT INTO plant_capacities (plant_id, cement_type, daily_capacity_tons)
+      VALUES ($1,$2,$3)
+      ON CONFLICT (plant_id, cement_type) DO UPDATE
+        SET daily_capacity_tons=EXCLUDED.daily_capacity_tons, updated_at=now()
+    `, id, it.CementType, it.DailyCapacityTons); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+			return
+		}
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(temp), bcrypt.DefaultCost)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "could not hash password")
+	if err := a.insertAuditLogTx(tx, r, &u, "PLANT_CAPACITY_UPDATED", "plant", fmt.Sprintf("%d", id), map[string]any{"items": body.Items}); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if _, err := a.db.Exec(r.Context(), `UPDATE users SET password_hash=$1 WHERE id=$2`, string(hash), id); err != nil {
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "tempPassword": temp})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-// ---------- admin: rbac ----------
+// ---------- admin: warehouses CRUD ----------
 
-func (a *App) handleAdminGetRBAC(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `SELECT role, config, updated_at FROM rbac_config ORDER BY role`)
+func (a *App) handleAdminListWarehouses(w http.ResponseWriter, r *http.Request) {
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("includeArchived"))
+	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, capacity_tons, archived_at FROM warehouses WHERE ($1 OR archived_at IS NULL) ORDER BY id`, includeArchived)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
@@ -3444,307 +10585,234 @@ func (a *App) handleAdminGetRBAC(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	items := []map[string]any{}
 	for rows.Next() {
-		var role string
-		var config json.RawMessage
-		var updated time.Time
-		_ = rows.Scan(&role, &config, &updated)
-		items = append(items, map[string]any{
-			"role":      role,
-			"config":    config,
-			"updatedAt": updated.Format(time.RFC3339),
-		})
+		var id int64
+		var name string
+		var lat, lng, cap float64
+		var archivedAt *time.Time
+		_ = rows.Scan(&id, &name, &lat, &lng, &cap, &archivedAt)
+		items = append(items, map[string]any{"id": fmt.Sprintf("%d", id), "name": name, "lat": lat, "lng": lng, "capacityTons": cap, "archivedAt": archivedAt})
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleAdminPutRBAC(w http.ResponseWriter, r *http.Request) {
-	role := strings.TrimSpace(chi.URLParam(r, "role"))
-	allowedRole := map[string]bool{"SUPER_ADMIN": true, "MANAGEMENT": true, "OPERATOR": true, "DISTRIBUTOR": true}
-	if !allowedRole[role] {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid role")
-		return
+func (a *App) handleAdminCreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var body struct {
+		Name         string  `json:"name"`
+		Lat          float64 `json:"lat"`
+		Lng          float64 `json:"lng"`
+		CapacityTons float64 `json:"capacityTons"`
 	}
-	var config json.RawMessage
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	if len(config) == 0 {
-		config = json.RawMessage(`{}`)
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
 	}
-	if _, err := a.db.Exec(r.Context(), `
-    INSERT INTO rbac_config (role, config, updated_at)
-    VALUES ($1,$2,now())
-    ON CONFLICT (role) DO UPDATE SET config=EXCLUDED.config, updated_at=now()
-  `, role, config); err != nil {
+	var id int64
+	tx, err := a.beginChangeTx(r.Context(), u)
+	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
-
-// ---------- admin: thresholds ----------
-
-func (a *App) handleAdminListThresholds(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `
-    SELECT t.id, t.warehouse_id, w.name, t.cement_type, t.min_stock, t.safety_stock, t.warning_level, t.critical_level, t.lead_time_days, t.updated_at
-    FROM threshold_settings t
-    JOIN warehouses w ON w.id = t.warehouse_id
-    ORDER BY w.id, t.cement_type
-  `)
-	if err != nil {
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	if err = tx.QueryRow(r.Context(), `INSERT INTO warehouses (name, lat, lng, capacity_tons) VALUES ($1,$2,$3,$4) RETURNING id`, body.Name, body.Lat, body.Lng, body.CapacityTons).Scan(&id); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
-	items := []map[string]any{}
-	for rows.Next() {
-		var id, warehouseID int64
-		var warehouseName, cementType string
-		var minStock, safetyStock, warning, critical float64
-		var lead int
-		var updated time.Time
-		_ = rows.Scan(&id, &warehouseID, &warehouseName, &cementType, &minStock, &safetyStock, &warning, &critical, &lead, &updated)
-		items = append(items, map[string]any{
-			"id":            fmt.Sprintf("%d", id),
-			"warehouseId":   fmt.Sprintf("%d", warehouseID),
-			"warehouseName": warehouseName,
-			"product":       cementType,
-			"minStock":      minStock,
-			"safetyStock":   safetyStock,
-			"warningLevel":  warning,
-			"criticalLevel": critical,
-			"leadTimeDays":  lead,
-			"updatedAt":     updated.Format("2006-01-02 15:04"),
-		})
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	writeJSON(w, http.StatusCreated, map[string]any{"id": fmt.Sprintf("%d", id)})
 }
 
-func (a *App) handleAdminUpdateThreshold(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleAdminUpdateWarehouse(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
 	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	u, _ := r.Context().Value(ctxUserKey).(User)
 	var body struct {
-		MinStock      float64 `json:"minStock"`
-		SafetyStock   float64 `json:"safetyStock"`
-		WarningLevel  float64 `json:"warningLevel"`
-		CriticalLevel float64 `json:"criticalLevel"`
-		LeadTimeDays  int     `json:"leadTimeDays"`
+		Name         string  `json:"name"`
+		Lat          float64 `json:"lat"`
+		Lng          float64 `json:"lng"`
+		CapacityTons float64 `json:"capacityTons"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
-	}
-	if body.MinStock < 0 || body.SafetyStock < 0 || body.WarningLevel < 0 || body.CriticalLevel < 0 {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "threshold values must be >= 0")
-		return
-	}
-	if body.CriticalLevel > body.WarningLevel || body.WarningLevel > body.MinStock || body.MinStock > body.SafetyStock {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid thresholds order: ensure Critical <= Warning <= Min <= Safety")
-		return
-	}
-	if body.LeadTimeDays <= 0 {
-		body.LeadTimeDays = 3
-	}
-	tag, err := a.db.Exec(r.Context(), `
-    UPDATE threshold_settings
-    SET min_stock=$1, safety_stock=$2, warning_level=$3, critical_level=$4, lead_time_days=$5, updated_at=now()
-    WHERE id=$6
-  `, body.MinStock, body.SafetyStock, body.WarningLevel, body.CriticalLevel, body.LeadTimeDays, id)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
-	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "threshold not found")
-		return
-	}
-
-	a.insertAuditLog(r, &u, "THRESHOLD_UPDATED", "threshold_settings", fmt.Sprintf("%d", id), map[string]any{
-		"minStock":      body.MinStock,
-		"safetyStock":   body.SafetyStock,
-		"warningLevel":  body.WarningLevel,
-		"criticalLevel": body.CriticalLevel,
-		"leadTimeDays":  body.LeadTimeDays,
-	})
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
-
-// ---------- admin: alerts ----------
-
-func (a *App) handleAdminListAlerts(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `
-    SELECT id, name, description, enabled, severity, recipients_roles, recipients_users, channels, params
-    FROM alert_configs
-    ORDER BY id
-  `)
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+		return
+	}
+	tx, err := a.beginChangeTx(r.Context(), u)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
-	items := []map[string]any{}
-	for rows.Next() {
-		var id int64
-		var name, description, severity string
-		var enabled bool
-		var roles []string
-		var users []int64
-		var channels json.RawMessage
-		var params json.RawMessage
-		_ = rows.Scan(&id, &name, &description, &enabled, &severity, &roles, &users, &channels, &params)
-		userIDs := make([]string, 0, len(users))
-		for _, uid := range users {
-			userIDs = append(userIDs, fmt.Sprintf("%d", uid))
-		}
-		items = append(items, map[string]any{
-			"id":          fmt.Sprintf("%d", id),
-			"name":        name,
-			"description": description,
-			"enabled":     enabled,
-			"severity":    severity,
-			"recipients": map[string]any{
-				"roles": roles,
-				"users": userIDs,
-			},
-			"channels": channels,
-			"params":   params,
-		})
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	tag, err := tx.Exec(r.Context(), `UPDATE warehouses SET name=$1, lat=$2, lng=$3, capacity_tons=$4 WHERE id=$5`, body.Name, body.Lat, body.Lng, body.CapacityTons, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "warehouse not found")
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-type adminAlertRecipients struct {
-	Roles []string `json:"roles"`
-	Users []string `json:"users"`
+// handleAdminDeleteWarehouse archives the warehouse; see archiveEntity.
+func (a *App) handleAdminDeleteWarehouse(w http.ResponseWriter, r *http.Request) {
+	a.archiveEntity(w, r, "warehouses")
 }
 
-type adminAlertItem struct {
-	ID          string               `json:"id"`
-	Name        string               `json:"name"`
-	Description string               `json:"description"`
-	Enabled     bool                 `json:"enabled"`
-	Severity    string               `json:"severity"`
-	Recipients  adminAlertRecipients `json:"recipients"`
-	Channels    map[string]bool      `json:"channels"`
-	Params      map[string]any       `json:"params"`
+// ---------- distributor portal ----------
+
+func (a *App) requireDistributorID(w http.ResponseWriter, r *http.Request) (*User, int64, bool) {
+	u, ok := r.Context().Value(ctxUserKey).(User)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
+		return nil, 0, false
+	}
+	if u.DistributorID == nil || *u.DistributorID == 0 {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "distributorId not set for this user")
+		return &u, 0, false
+	}
+	return &u, *u.DistributorID, true
 }
 
-func (a *App) handleAdminPutAlerts(w http.ResponseWriter, r *http.Request) {
-	u, _ := r.Context().Value(ctxUserKey).(User)
-	var body struct {
-		Items []adminAlertItem `json:"items"`
+func (a *App) handleDistributorInventory(w http.ResponseWriter, r *http.Request) {
+	_, distributorID, ok := a.requireDistributorID(w, r)
+	if !ok {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+
+	var dname string
+	if err := a.db.QueryRow(r.Context(), `SELECT name FROM distributors WHERE id=$1`, distributorID).Scan(&dname); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
 		return
 	}
-	allowedRole := map[string]bool{"SUPER_ADMIN": true, "MANAGEMENT": true, "OPERATOR": true, "DISTRIBUTOR": true}
-	allowedSeverity := map[string]bool{"Low": true, "Medium": true, "High": true}
 
-	for _, item := range body.Items {
-		id, err := strconv.ParseInt(item.ID, 10, 64)
-		if err != nil {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid alert id")
-			return
-		}
-		if id <= 0 {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid alert id")
-			return
-		}
-		if strings.TrimSpace(item.Name) == "" {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "alert name is required")
-			return
-		}
-		if !allowedSeverity[item.Severity] {
-			writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid severity")
-			return
-		}
-		for _, role := range item.Recipients.Roles {
-			if !allowedRole[role] {
-				writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid recipient role")
-				return
-			}
+	byType := []map[string]any{}
+	rows, err := a.db.Query(r.Context(), `
+		SELECT cement_type, COALESCE(SUM(quantity_tons),0) AS delivered
+		FROM shipments
+		WHERE to_distributor_id=$1 AND status IN ('COMPLETED','RECEIVED')
+		GROUP BY cement_type
+		ORDER BY cement_type
+	`, distributorID)
+	if err == nil {
+		for rows.Next() {
+			var ct string
+			var delivered float64
+			_ = rows.Scan(&ct, &delivered)
+			byType = append(byType, map[string]any{"cementType": ct, "deliveredTons": delivered})
 		}
+		rows.Close()
+	}
 
-		userIDs := make([]int64, 0, len(item.Recipients.Users))
-		for _, s := range item.Recipients.Users {
-			if v, err := strconv.ParseInt(s, 10, 64); err == nil {
-				userIDs = append(userIDs, v)
-			}
-		}
-		if len(userIDs) > 0 {
-			var okCount int
-			_ = a.db.QueryRow(r.Context(), `SELECT COUNT(*) FROM users WHERE id = ANY($1::bigint[])`, userIDs).Scan(&okCount)
-			if okCount != len(userIDs) {
-				writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "one or more recipient users not found")
-				return
-			}
-		}
+	var deliveredTotal float64
+	_ = a.db.QueryRow(r.Context(), `
+		SELECT COALESCE(SUM(quantity_tons),0)
+		FROM shipments
+		WHERE to_distributor_id=$1 AND status IN ('COMPLETED','RECEIVED')
+	`, distributorID).Scan(&deliveredTotal)
 
-		channels := map[string]bool{"inApp": true, "email": false}
-		if item.Channels != nil {
-			if v, ok := item.Channels["inApp"]; ok {
-				channels["inApp"] = v
-			}
-			if v, ok := item.Channels["email"]; ok {
-				channels["email"] = v
-			}
-		}
-		chBytes, _ := json.Marshal(channels)
-		paramBytes, _ := json.Marshal(item.Params)
-		_, err = a.db.Exec(r.Context(), `
-		INSERT INTO alert_configs (id, name, description, enabled, severity, recipients_roles, recipients_users, channels, params, updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8::jsonb,$9::jsonb,now())
-		ON CONFLICT (id) DO UPDATE SET
-			name=EXCLUDED.name,
-			description=EXCLUDED.description,
-			enabled=EXCLUDED.enabled,
-			severity=EXCLUDED.severity,
-			recipients_roles=EXCLUDED.recipients_roles,
-			recipients_users=EXCLUDED.recipients_users,
-			channels=EXCLUDED.channels,
-			params=EXCLUDED.params,
-			updated_at=now()
-	`, id, item.Name, item.Description, item.Enabled, item.Severity, item.Recipients.Roles, userIDs, chBytes, paramBytes)
-		if err != nil {
-			writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-			return
-		}
+	var soldTotal float64
+	_ = a.db.QueryRow(r.Context(), `
+    SELECT COALESCE(SUM(quantity_tons),0)
+    FROM sales_orders
+    WHERE distributor_id=$1
+  `, distributorID).Scan(&soldTotal)
 
-		a.insertAuditLog(r, &u, "ALERT_CONFIG_UPDATED", "alert_configs", fmt.Sprintf("%d", id), map[string]any{
-			"name":       item.Name,
-			"enabled":    item.Enabled,
-			"severity":   item.Severity,
-			"rolesCount": len(item.Recipients.Roles),
-			"usersCount": len(userIDs),
-			"channels":   channels,
-			"paramsKeys": func() []string {
-				keys := []string{}
-				for k := range item.Params {
-					keys = append(keys, k)
-				}
-				return keys
-			}(),
-		})
+	estimatedOnHand := deliveredTotal - soldTotal
+
+	recentShipments := []map[string]any{}
+	srows, err := a.db.Query(r.Context(), `
+    SELECT s.id, s.status, s.cement_type, s.quantity_tons, s.depart_at, s.arrive_eta, s.eta_minutes,
+           s.last_lat, s.last_lng, s.last_update,
+           w.id, w.name
+    FROM shipments s
+    JOIN warehouses w ON w.id = s.from_warehouse_id
+    WHERE s.to_distributor_id=$1
+    ORDER BY s.id DESC
+    LIMIT 20
+  `, distributorID)
+	if err == nil {
+		for srows.Next() {
+			var id int64
+			var status, ct string
+			var qty float64
+			var departAt, arriveEta *time.Time
+			var etaMinutes int
+			var lastLat, lastLng *float64
+			var lastUpdate *time.Time
+			var wid int64
+			var wname string
+			_ = srows.Scan(&id, &status, &ct, &qty, &departAt, &arriveEta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname)
+			recentShipments = append(recentShipments, map[string]any{
+				"id":            id,
+				"status":        status,
+				"cementType":    ct,
+				"quantityTons":  qty,
+				"departAt":      departAt,
+				"arriveEta":     arriveEta,
+				"etaMinutes":    etaMinutes,
+				"truck":         map[string]any{"lastLat": lastLat, "lastLng": lastLng, "lastUpdate": lastUpdate},
+				"fromWarehouse": map[string]any{"id": wid, "name": wname},
+			})
+		}
+		srows.Close()
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"distributor": map[string]any{"id": distributorID, "name": dname},
+		"totals": map[string]any{
+			"deliveredTons":       deliveredTotal,
+			"soldTons":            soldTotal,
+			"estimatedOnHandTons": estimatedOnHand,
+			"note":                "Inventory distributor dihitung estimasi: total shipment COMPLETED/RECEIVED - total sales_orders.",
+		},
+		"deliveredByCementType": byType,
+		"recentShipments":       recentShipments,
+	})
 }
 
-// ---------- admin: audit logs ----------
+func (a *App) handleDistributorOrders(w http.ResponseWriter, r *http.Request) {
+	_, distributorID, ok := a.requireDistributorID(w, r)
+	if !ok {
+		return
+	}
 
-func (a *App) handleAdminListAuditLogs(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `
-    SELECT l.id, l.ts, l.actor_user_id, COALESCE(u.name,''), l.action, l.entity_type, l.entity_id, l.metadata, l.ip
-    FROM audit_logs l
-    LEFT JOIN users u ON u.id = l.actor_user_id
-    ORDER BY l.ts DESC
+	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
+	where := "WHERE o.distributor_id=$1"
+	args := []any{distributorID}
+	if status != "" {
+		where += " AND o.status=$2"
+		args = append(args, status)
+	}
+
+	q := fmt.Sprintf(`
+    SELECT o.id, o.cement_type, o.quantity_tons, o.status, o.requested_at,
+           o.decided_at, o.decided_by_user_id, o.decision_reason, o.approved_shipment_id
+    FROM order_requests o
+    %s
+    ORDER BY o.requested_at DESC, o.id DESC
     LIMIT 200
-  `)
+  `, where)
+
+	rows, err := a.db.Query(r.Context(), q, args...)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
@@ -3753,34 +10821,118 @@ func (a *App) handleAdminListAuditLogs(w http.ResponseWriter, r *http.Request) {
 	items := []map[string]any{}
 	for rows.Next() {
 		var id int64
-		var ts time.Time
-		var actorID sql.NullInt64
-		var actorName, action, entityType, entityID, ip string
-		var metadata json.RawMessage
-		_ = rows.Scan(&id, &ts, &actorID, &actorName, &action, &entityType, &entityID, &metadata, &ip)
-		actor := ""
-		if actorID.Valid {
-			actor = fmt.Sprintf("%d", actorID.Int64)
-		}
+		var ct, st, reason string
+		var qty float64
+		var requested time.Time
+		var decided *time.Time
+		var decidedBy *int64
+		var approvedShipment *int64
+		_ = rows.Scan(&id, &ct, &qty, &st, &requested, &decided, &decidedBy, &reason, &approvedShipment)
 		items = append(items, map[string]any{
-			"id":         fmt.Sprintf("%d", id),
-			"ts":         ts.Format(time.RFC3339),
-			"actorId":    actor,
-			"actorName":  actorName,
-			"action":     action,
-			"entityType": entityType,
-			"entityId":   entityID,
-			"metadata":   metadata,
-			"ip":         ip,
+			"id":                 id,
+			"status":             st,
+			"requestedAt":        requested,
+			"decidedAt":          decided,
+			"decidedBy":          decidedBy,
+			"decisionReason":     reason,
+			"approvedShipmentId": approvedShipment,
+			"cementType":         ct,
+			"quantityTons":       qty,
 		})
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-// ---------- admin: plants CRUD ----------
+func (a *App) handleDistributorCreateOrder(w http.ResponseWriter, r *http.Request) {
+	u, distributorID, ok := a.requireDistributorID(w, r)
+	if !ok {
+		return
+	}
 
-func (a *App) handleAdminListPlants(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng FROM plants ORDER BY id`)
+	var body struct {
+		CementType   string  `json:"cementType"`
+		QuantityTons float64 `json:"quantityTons"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	body.CementType = strings.TrimSpace(body.CementType)
+	if body.CementType == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "cementType required")
+		return
+	}
+	if body.QuantityTons <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "quantityTons must be > 0")
+		return
+	}
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
+	// FOR SHARE keeps the distributor from being archived until the order is in.
+	var archived bool
+	if err := tx.QueryRow(r.Context(), `SELECT archived_at IS NOT NULL FROM distributors WHERE id=$1 FOR SHARE`, distributorID).Scan(&archived); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
+		return
+	}
+	if archived {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "distributor is archived")
+		return
+	}
+
+	var id int64
+	var requestedAt time.Time
+	if err := tx.QueryRow(r.Context(), `
+    INSERT INTO order_requests (distributor_id, cement_type, quantity_tons, status, requested_at, updated_at)
+    VALUES ($1,$2,$3,'PENDING', now(), now())
+    RETURNING id, requested_at
+  `, distributorID, body.CementType, body.QuantityTons).Scan(&id, &requestedAt); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	if err := a.insertAuditLogTx(tx, r, u, "DISTRIBUTOR_ORDER_CREATED", "order_requests", fmt.Sprintf("%d", id), map[string]any{"distributorId": distributorID, "cementType": body.CementType, "quantityTons": body.QuantityTons}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id, "requestedAt": requestedAt})
+}
+
+func (a *App) handleDistributorIssues(w http.ResponseWriter, r *http.Request) {
+	_, distributorID, ok := a.requireDistributorID(w, r)
+	if !ok {
+		return
+	}
+	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
+	where := "WHERE i.distributor_id=$1"
+	args := []any{distributorID}
+	if status != "" && status != "ALL" {
+		where += " AND i.status=$2"
+		args = append(args, status)
+	}
+
+	q := fmt.Sprintf(`
+    SELECT i.id, i.issue_type, i.severity, i.status,
+           i.title, i.description,
+           i.shipment_id,
+           i.reported_at,
+           i.resolved_at, i.resolution_notes,
+           i.metadata
+    FROM ops_issues i
+    %s
+    ORDER BY i.created_at DESC, i.id DESC
+    LIMIT 200
+  `, where)
+
+	rows, err := a.db.Query(r.Context(), q, args...)
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
@@ -3789,573 +10941,793 @@ func (a *App) handleAdminListPlants(w http.ResponseWriter, r *http.Request) {
 	items := []map[string]any{}
 	for rows.Next() {
 		var id int64
-		var name string
-		var lat, lng float64
-		_ = rows.Scan(&id, &name, &lat, &lng)
-		items = append(items, map[string]any{"id": fmt.Sprintf("%d", id), "name": name, "lat": lat, "lng": lng})
+		var it, sev, st string
+		var title, desc string
+		var shipmentID *int64
+		var reportedAt time.Time
+		var resolvedAt *time.Time
+		var resolutionNotes string
+		var metadata json.RawMessage
+		_ = rows.Scan(&id, &it, &sev, &st, &title, &desc, &shipmentID, &reportedAt, &resolvedAt, &resolutionNotes, &metadata)
+		items = append(items, map[string]any{
+			"id":              id,
+			"issueType":       it,
+			"severity":        sev,
+			"status":          st,
+			"title":           title,
+			"description":     desc,
+			"shipmentId":      shipmentID,
+			"reportedAt":      reportedAt,
+			"resolvedAt":      resolvedAt,
+			"resolutionNotes": resolutionNotes,
+			"metadata":        metadata,
+		})
+	}
+	if err := a.attachIssueAttachments(r, items); err != nil {
+		writeDBError(w, err)
+		return
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
-func (a *App) handleAdminCreatePlant(w http.ResponseWriter, r *http.Request) {
+func (a *App) handleDistributorUpdateShipmentStatus(w http.ResponseWriter, r *http.Request) {
+	_, distributorID, ok := a.requireDistributorID(w, r)
+	if !ok {
+		return
+	}
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
 	var body struct {
-		Name string  `json:"name"`
-		Lat  float64 `json:"lat"`
-		Lng  float64 `json:"lng"`
+		Status string `json:"status"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+	body.Status = strings.TrimSpace(strings.ToUpper(body.Status))
+	if body.Status != "RECEIVED" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be RECEIVED")
 		return
 	}
-	var id int64
-	if err := a.db.QueryRow(r.Context(), `INSERT INTO plants (name, lat, lng) VALUES ($1,$2,$3) RETURNING id`, body.Name, body.Lat, body.Lng).Scan(&id); err != nil {
+
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	writeJSON(w, http.StatusCreated, map[string]any{"id": fmt.Sprintf("%d", id)})
-}
+	defer func() { _ = tx.Rollback(r.Context()) }()
 
-func (a *App) handleAdminUpdatePlant(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+	var currentStatus, distributorName string
+	if err := tx.QueryRow(r.Context(), `
+    SELECT s.status, d.name
+    FROM shipments s
+    JOIN distributors d ON d.id = s.to_distributor_id
+    WHERE s.id=$1 AND s.to_distributor_id=$2
+    FOR UPDATE OF s
+  `, id, distributorID).Scan(&currentStatus, &distributorName); err != nil {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
 		return
 	}
-	var body struct {
-		Name string  `json:"name"`
-		Lat  float64 `json:"lat"`
-		Lng  float64 `json:"lng"`
+	if currentStatus == "RECEIVED" {
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": currentStatus})
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	if currentStatus != "COMPLETED" {
+		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "shipment must be COMPLETED before RECEIVED")
 		return
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+
+	if _, err := tx.Exec(r.Context(), `
+    UPDATE shipments SET status='RECEIVED', updated_at=now() WHERE id=$1
+  `, id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	tag, err := a.db.Exec(r.Context(), `UPDATE plants SET name=$1, lat=$2, lng=$3 WHERE id=$4`, body.Name, body.Lat, body.Lng, id)
-	if err != nil {
+	// Operators close the loop on deliveries, so receipt confirmations go to them.
+	if err := notify.ToRoles(r.Context(), tx, []string{"OPERATOR"}, notify.Notification{
+		Kind:       notify.KindShipmentStatus,
+		Title:      fmt.Sprintf("Shipment #%d received", id),
+		Body:       fmt.Sprintf("%s confirmed receipt of shipment #%d.", distributorName, id),
+		EntityType: "shipment",
+		EntityID:   fmt.Sprintf("%d", id),
+		Metadata:   map[string]any{"from": currentStatus, "to": "RECEIVED", "distributorId": distributorID},
+	}); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "plant not found")
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	if err := a.insertAuditLogTx(tx, r, &u, "SHIPMENT_STATUS_UPDATED", "shipment", fmt.Sprintf("%d", id), map[string]any{"status": "RECEIVED"}); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": "RECEIVED"})
 }
 
-func (a *App) handleAdminDeletePlant(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+// ---------- attachments ----------
+
+// attachmentTypes lists the accepted upload types, as sniffed from the
+// content, with the extension used for their storage key.
+var attachmentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+// thumbnailSide is the longest edge, in pixels, of attachment previews.
+const thumbnailSide = 320
+
+// attachmentLink names the record an attachment belongs to. Column is one of
+// issue_id, shipment_id or order_request_id, or "" for an unlinked upload.
+type attachmentLink struct {
+	Column string
+	ID     int64
+}
+
+var attachmentLinkParams = []struct{ param, column, noun string }{
+	{"issueId", "issue_id", "issue"},
+	{"shipmentId", "shipment_id", "shipment"},
+	{"orderId", "order_request_id", "order"},
+}
+
+// parseAttachmentLink reads at most one of issueId, shipmentId and orderId.
+func parseAttachmentLink(get func(string) string) (attachmentLink, error) {
+	var link attachmentLink
+	for _, p := range attachmentLinkParams {
+		v := strings.TrimSpace(get(p.param))
+		if v == "" {
+			continue
+		}
+		if link.Column != "" {
+			return attachmentLink{}, errors.New("give only one of issueId, shipmentId or orderId")
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return attachmentLink{}, fmt.Errorf("invalid %s", p.param)
+		}
+		link = attachmentLink{Column: p.column, ID: id}
+	}
+	return link, nil
+}
+
+func (l attachmentLink) noun() string {
+	for _, p := range attachmentLinkParams {
+		if p.column == l.Column {
+			return p.noun
+		}
+	}
+	return "record"
+}
+
+// ids spreads the link over the issue, shipment and order columns.
+func (l attachmentLink) ids() (issueID, shipmentID, orderID *int64) {
+	id := l.ID
+	switch l.Column {
+	case "issue_id":
+		issueID = &id
+	case "shipment_id":
+		shipmentID = &id
+	case "order_request_id":
+		orderID = &id
+	}
+	return issueID, shipmentID, orderID
+}
+
+// owner returns the warehouse and distributor of the linked record, which
+// decide who may read its attachments, or pgx.ErrNoRows if it does not exist.
+func (l attachmentLink) owner(ctx context.Context, db *pgxpool.Pool) (warehouseID, distributorID *int64, err error) {
+	var q string
+	switch l.Column {
+	case "issue_id":
+		q = `SELECT warehouse_id, distributor_id FROM ops_issues WHERE id=$1`
+	case "shipment_id":
+		q = `SELECT from_warehouse_id, to_distributor_id FROM shipments WHERE id=$1`
+	case "order_request_id":
+		q = `
+      SELECT s.from_warehouse_id, o.distributor_id
+      FROM order_requests o
+      LEFT JOIN shipments s ON s.id = o.approved_shipment_id
+      WHERE o.id=$1
+    `
+	default:
+		return nil, nil, nil
+	}
+	err = db.QueryRow(ctx, q, l.ID).Scan(&warehouseID, &distributorID)
+	return warehouseID, distributorID, err
+}
+
+type attachmentUpload struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// readAttachmentUpload parses the multipart "file" field, checks its type and
+// size and strips image metadata. It writes the error response itself.
+func (a *App) readAttachmentUpload(w http.ResponseWriter, r *http.Request) (attachmentUpload, bool) {
+	limit := a.cfg.AttachmentMaxBytes
+	// Leave room for the multipart framing and the link fields.
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+	if err := r.ParseMultipartForm(limit + 64<<10); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "TOO_LARGE", fmt.Sprintf("file exceeds %s", formatBytes(limit)))
+			return attachmentUpload{}, false
+		}
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid multipart form")
+		return attachmentUpload{}, false
+	}
+	file, header, err := r.FormFile("file")
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
-		return
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "file required")
+		return attachmentUpload{}, false
 	}
-	tag, err := a.db.Exec(r.Context(), `DELETE FROM plants WHERE id=$1`, id)
+	defer file.Close()
+	data, err := io.ReadAll(file)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "file error")
+		return attachmentUpload{}, false
+	}
+	if int64(len(data)) > limit {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "TOO_LARGE", fmt.Sprintf("file exceeds %s", formatBytes(limit)))
+		return attachmentUpload{}, false
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := attachmentTypes[contentType]; !ok {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "file must be jpg, png, webp or pdf")
+		return attachmentUpload{}, false
+	}
+	if data, err = media.Strip(data, contentType); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "image could not be read")
+		return attachmentUpload{}, false
+	}
+	name := strings.TrimSpace(filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/")))
+	if name == "." || name == "/" {
+		name = ""
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "plant not found")
-		return
+	if len(name) > 200 {
+		name = name[:200]
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	return attachmentUpload{Filename: name, ContentType: contentType, Data: data}, true
 }
 
-// ---------- admin: warehouses CRUD ----------
+// storeAttachment writes the upload to the object store and records it, linked
+// to link and readable according to warehouseID and distributorID. The object
+// is removed again if the row cannot be written.
+func (a *App) storeAttachment(w http.ResponseWriter, r *http.Request, u *User, up attachmentUpload, link attachmentLink, warehouseID, distributorID *int64) {
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	storageKey := "att-" + hex.EncodeToString(key) + attachmentTypes[up.ContentType]
+	sum := sha256.Sum256(up.Data)
+	checksum := hex.EncodeToString(sum[:])
+
+	var thumbKey *string
+	thumb, err := media.Thumbnail(up.Data, up.ContentType, thumbnailSide)
+	switch {
+	case err == nil:
+		k := strings.TrimSuffix(storageKey, attachmentTypes[up.ContentType]) + "-thumb.jpg"
+		thumbKey = &k
+	case errors.Is(err, media.ErrNoPreview):
+	default:
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "image could not be read")
+		return
+	}
+
+	keys := []string{}
+	stored := false
+	defer func() {
+		if stored {
+			return
+		}
+		for _, k := range keys {
+			if err := a.store.Delete(context.WithoutCancel(r.Context()), k); err != nil {
+				log.Printf("attachments: delete %s: %v", k, err)
+			}
+		}
+	}()
+	keys = append(keys, storageKey)
+	if err := a.store.Put(r.Context(), storageKey, up.Data, up.ContentType); err != nil {
+		log.Printf("attachments: put %s: %v", storageKey, err)
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "storage error")
+		return
+	}
+	if thumbKey != nil {
+		keys = append(keys, *thumbKey)
+		if err := a.store.Put(r.Context(), *thumbKey, thumb, "image/jpeg"); err != nil {
+			log.Printf("attachments: put %s: %v", *thumbKey, err)
+			writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "storage error")
+			return
+		}
+	}
 
-func (a *App) handleAdminListWarehouses(w http.ResponseWriter, r *http.Request) {
-	rows, err := a.db.Query(r.Context(), `SELECT id, name, lat, lng, capacity_tons FROM warehouses ORDER BY id`)
+	tx, err := a.db.Begin(r.Context())
 	if err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	defer rows.Close()
-	items := []map[string]any{}
-	for rows.Next() {
-		var id int64
-		var name string
-		var lat, lng, cap float64
-		_ = rows.Scan(&id, &name, &lat, &lng, &cap)
-		items = append(items, map[string]any{"id": fmt.Sprintf("%d", id), "name": name, "lat": lat, "lng": lng, "capacityTons": cap})
-	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
-}
+	defer func() { _ = tx.Rollback(r.Context()) }()
 
-func (a *App) handleAdminCreateWarehouse(w http.ResponseWriter, r *http.Request) {
-	var body struct {
-		Name         string  `json:"name"`
-		Lat          float64 `json:"lat"`
-		Lng          float64 `json:"lng"`
-		CapacityTons float64 `json:"capacityTons"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
-		return
-	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
-		return
-	}
+	issueID, shipmentID, orderID := link.ids()
 	var id int64
-	if err := a.db.QueryRow(r.Context(), `INSERT INTO warehouses (name, lat, lng, capacity_tons) VALUES ($1,$2,$3,$4) RETURNING id`, body.Name, body.Lat, body.Lng, body.CapacityTons).Scan(&id); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	var createdAt time.Time
+	if err := tx.QueryRow(r.Context(), `
+    INSERT INTO attachments (
+      storage_key, thumbnail_key, filename, content_type, size_bytes, sha256,
+      uploaded_by_user_id, distributor_id, warehouse_id,
+      issue_id, shipment_id, order_request_id, linked_at
+    )
+    VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12, CASE WHEN $13 THEN now() END)
+    RETURNING id, created_at
+  `, storageKey, thumbKey, up.Filename, up.ContentType, len(up.Data), checksum,
+		u.ID, distributorID, warehouseID,
+		issueID, shipmentID, orderID, link.Column != "").Scan(&id, &createdAt); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusCreated, map[string]any{"id": fmt.Sprintf("%d", id)})
-}
-
-func (a *App) handleAdminUpdateWarehouse(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
-		return
+	if issueID != nil {
+		if err := a.checkIssueAttachmentQuotaTx(r.Context(), tx, *issueID); err != nil {
+			writeAttachmentLinkError(w, err)
+			return
+		}
 	}
-	var body struct {
-		Name         string  `json:"name"`
-		Lat          float64 `json:"lat"`
-		Lng          float64 `json:"lng"`
-		CapacityTons float64 `json:"capacityTons"`
+	meta := map[string]any{
+		"filename":    up.Filename,
+		"contentType": up.ContentType,
+		"sizeBytes":   len(up.Data),
+		"sha256":      checksum,
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
-		return
+	if link.Column != "" {
+		meta[link.noun()+"Id"] = link.ID
 	}
-	if strings.TrimSpace(body.Name) == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "name required")
+	if err := a.insertAuditLogTx(tx, r, u, "ATTACHMENT_UPLOADED", "attachment", fmt.Sprintf("%d", id), meta); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	tag, err := a.db.Exec(r.Context(), `UPDATE warehouses SET name=$1, lat=$2, lng=$3, capacity_tons=$4 WHERE id=$5`, body.Name, body.Lat, body.Lng, body.CapacityTons, id)
-	if err != nil {
+	if err := tx.Commit(r.Context()); err != nil {
 		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
 		return
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "warehouse not found")
-		return
+	stored = true
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":           id,
+		"url":          attachmentURL(id),
+		"thumbnailUrl": thumbnailURL(id, thumbKey != nil),
+		"filename":     up.Filename,
+		"contentType":  up.ContentType,
+		"sizeBytes":    len(up.Data),
+		"sha256":       checksum,
+		"createdAt":    createdAt,
+	})
+}
+
+func attachmentURL(id int64) string {
+	return fmt.Sprintf("/api/attachments/%d", id)
+}
+
+// thumbnailURL returns nil for attachments without a preview, so clients can
+// fall back to an icon.
+func thumbnailURL(id int64, ok bool) *string {
+	if !ok {
+		return nil
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	u := attachmentURL(id) + "/thumbnail"
+	return &u
 }
 
-func (a *App) handleAdminDeleteWarehouse(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
-		return
+// errAttachmentsUnavailable means some attachmentIds are not the caller's own
+// unlinked uploads.
+var errAttachmentsUnavailable = errors.New("attachments unavailable")
+
+// attachmentQuotaError reports an issue going over its attachment count or
+// size limit.
+type attachmentQuotaError struct{ msg string }
+
+func (e attachmentQuotaError) Error() string { return e.msg }
+
+// checkIssueAttachmentQuotaTx fails once an issue holds more attachments, or
+// more bytes, than configured. It runs after the new rows are written in tx
+// and locks the issue first, so concurrent uploads see each other's rows.
+func (a *App) checkIssueAttachmentQuotaTx(ctx context.Context, tx pgx.Tx, issueID int64) error {
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM ops_issues WHERE id=$1 FOR UPDATE`, issueID); err != nil {
+		return err
 	}
-	tag, err := a.db.Exec(r.Context(), `DELETE FROM warehouses WHERE id=$1`, id)
-	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+	var n int
+	var total int64
+	if err := tx.QueryRow(ctx, `
+    SELECT COUNT(*), COALESCE(SUM(size_bytes),0)::bigint FROM attachments WHERE issue_id=$1
+  `, issueID).Scan(&n, &total); err != nil {
+		return err
 	}
-	if tag.RowsAffected() == 0 {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "warehouse not found")
-		return
+	if limit := a.cfg.AttachmentsPerIssue; limit > 0 && n > limit {
+		return attachmentQuotaError{fmt.Sprintf("an issue can have at most %d attachments", limit)}
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	if limit := a.cfg.AttachmentIssueMaxBytes; limit > 0 && total > limit {
+		return attachmentQuotaError{fmt.Sprintf("attachments of an issue can total at most %s", formatBytes(limit))}
+	}
+	return nil
 }
 
-// ---------- distributor portal ----------
+// writeAttachmentLinkError answers for linkAttachmentsTx and quota failures.
+func writeAttachmentLinkError(w http.ResponseWriter, err error) {
+	var quota attachmentQuotaError
+	switch {
+	case errors.Is(err, errAttachmentsUnavailable):
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "attachmentIds must be your own unlinked uploads")
+	case errors.As(err, &quota):
+		writeAPIError(w, http.StatusConflict, "QUOTA_EXCEEDED", quota.msg)
+	default:
+		writeDBError(w, err)
+	}
+}
 
-func (a *App) requireDistributorID(w http.ResponseWriter, r *http.Request) (*User, int64, bool) {
-	u, ok := r.Context().Value(ctxUserKey).(User)
-	if !ok {
-		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "not authenticated")
-		return nil, 0, false
+// formatBytes renders a size limit for error messages, e.g. "10 MB".
+func formatBytes(n int64) string {
+	if n >= 1<<20 && n%(1<<20) == 0 {
+		return fmt.Sprintf("%d MB", n>>20)
 	}
-	if u.DistributorID == nil || *u.DistributorID == 0 {
-		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", "distributorId not set for this user")
-		return &u, 0, false
+	if n >= 1<<10 && n%(1<<10) == 0 {
+		return fmt.Sprintf("%d KB", n>>10)
 	}
-	return &u, *u.DistributorID, true
+	return fmt.Sprintf("%d bytes", n)
 }
 
-func (a *App) handleDistributorInventory(w http.ResponseWriter, r *http.Request) {
-	_, distributorID, ok := a.requireDistributorID(w, r)
-	if !ok {
-		return
+// linkAttachmentsTx attaches the user's unlinked uploads to a newly created
+// issue, taking over the issue's warehouse and distributor for access checks,
+// and enforces the issue's attachment quota.
+func (a *App) linkAttachmentsTx(ctx context.Context, tx pgx.Tx, userID int64, ids []int64, issueID int64, warehouseID, distributorID *int64) error {
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+	if len(ids) == 0 {
+		return nil
+	}
+	tag, err := tx.Exec(ctx, `
+    UPDATE attachments
+    SET issue_id=$1,
+        warehouse_id=COALESCE($2, warehouse_id),
+        distributor_id=COALESCE($3, distributor_id),
+        linked_at=now()
+    WHERE id = ANY($4)
+      AND uploaded_by_user_id=$5
+      AND num_nonnulls(issue_id, shipment_id, order_request_id) = 0
+  `, issueID, warehouseID, distributorID, ids, userID)
+	if err != nil {
+		return err
 	}
-
-	var dname string
-	if err := a.db.QueryRow(r.Context(), `SELECT name FROM distributors WHERE id=$1`, distributorID).Scan(&dname); err != nil {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "distributor not found")
-		return
+	if tag.RowsAffected() != int64(len(ids)) {
+		return errAttachmentsUnavailable
 	}
+	return a.checkIssueAttachmentQuotaTx(ctx, tx, issueID)
+}
 
-	byType := []map[string]any{}
-	rows, err := a.db.Query(r.Context(), `
-		SELECT cement_type, COALESCE(SUM(quantity_tons),0) AS delivered
-		FROM shipments
-		WHERE to_distributor_id=$1 AND status IN ('COMPLETED','RECEIVED')
-		GROUP BY cement_type
-		ORDER BY cement_type
-	`, distributorID)
-	if err == nil {
-		for rows.Next() {
-			var ct string
-			var delivered float64
-			_ = rows.Scan(&ct, &delivered)
-			byType = append(byType, map[string]any{"cementType": ct, "deliveredTons": delivered})
+// withAttachmentAccess admits distributors, who only ever see their own
+// attachments, and sends everyone else through the Operations.view permission
+// and user scope checks, so one download URL works from both portals.
+func (a *App) withAttachmentAccess(next http.Handler) http.Handler {
+	staff := a.requirePermission("Operations", "view")(a.withUserScope(next))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := r.Context().Value(ctxUserKey).(User)
+		if u.RoleKind == roleKindDistributor {
+			if _, _, ok := a.requireDistributorID(w, r); !ok {
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
 		}
-		rows.Close()
-	}
-
-	var deliveredTotal float64
-	_ = a.db.QueryRow(r.Context(), `
-		SELECT COALESCE(SUM(quantity_tons),0)
-		FROM shipments
-		WHERE to_distributor_id=$1 AND status IN ('COMPLETED','RECEIVED')
-	`, distributorID).Scan(&deliveredTotal)
-
-	var soldTotal float64
-	_ = a.db.QueryRow(r.Context(), `
-    SELECT COALESCE(SUM(quantity_tons),0)
-    FROM sales_orders
-    WHERE distributor_id=$1
-  `, distributorID).Scan(&soldTotal)
-
-	estimatedOnHand := deliveredTotal - soldTotal
+		staff.ServeHTTP(w, r)
+	})
+}
 
-	recentShipments := []map[string]any{}
-	srows, err := a.db.Query(r.Context(), `
-    SELECT s.id, s.status, s.cement_type, s.quantity_tons, s.depart_at, s.arrive_eta, s.eta_minutes,
-           s.last_lat, s.last_lng, s.last_update,
-           w.id, w.name
-    FROM shipments s
-    JOIN warehouses w ON w.id = s.from_warehouse_id
-    WHERE s.to_distributor_id=$1
-    ORDER BY s.id DESC
-    LIMIT 20
-  `, distributorID)
-	if err == nil {
-		for srows.Next() {
-			var id int64
-			var status, ct string
-			var qty float64
-			var departAt, arriveEta *time.Time
-			var etaMinutes int
-			var lastLat, lastLng *float64
-			var lastUpdate *time.Time
-			var wid int64
-			var wname string
-			_ = srows.Scan(&id, &status, &ct, &qty, &departAt, &arriveEta, &etaMinutes, &lastLat, &lastLng, &lastUpdate, &wid, &wname)
-			recentShipments = append(recentShipments, map[string]any{
-				"id":            id,
-				"status":        status,
-				"cementType":    ct,
-				"quantityTons":  qty,
-				"departAt":      departAt,
-				"arriveEta":     arriveEta,
-				"etaMinutes":    etaMinutes,
-				"truck":         map[string]any{"lastLat": lastLat, "lastLng": lastLng, "lastUpdate": lastUpdate},
-				"fromWarehouse": map[string]any{"id": wid, "name": wname},
-			})
-		}
-		srows.Close()
+// ownerAccess returns the condition, over a table aliased t with
+// warehouse_id and distributor_id columns, that limits rows to what the
+// request's user may read, with its arguments numbered from $1.
+func ownerAccess(r *http.Request) (string, []any) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	if u.RoleKind == roleKindDistributor {
+		return "t.distributor_id = $1", []any{*u.DistributorID}
 	}
+	sc := scopeFrom(r)
+	return `(t.warehouse_id IS NULL OR $1::bigint[] IS NULL OR t.warehouse_id = ANY($1))
+      AND (t.distributor_id IS NULL OR $2::bigint[] IS NULL OR t.distributor_id = ANY($2))`,
+		[]any{sc.WarehouseIDs, sc.DistributorIDs}
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"distributor": map[string]any{"id": distributorID, "name": dname},
-		"totals": map[string]any{
-			"deliveredTons":       deliveredTotal,
-			"soldTons":            soldTotal,
-			"estimatedOnHandTons": estimatedOnHand,
-			"note":                "Inventory distributor dihitung estimasi: total shipment COMPLETED/RECEIVED - total sales_orders.",
-		},
-		"deliveredByCementType": byType,
-		"recentShipments":       recentShipments,
-	})
+// attachmentAccess is ownerAccess for the attachments table. Uploads not yet
+// linked to an issue, shipment or order have no owner to scope by, so only
+// their uploader may read them.
+func attachmentAccess(r *http.Request) (string, []any) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	cond, args := ownerAccess(r)
+	args = append(args, u.ID)
+	return fmt.Sprintf(`%s
+      AND (num_nonnulls(t.issue_id, t.shipment_id, t.order_request_id) > 0 OR t.uploaded_by_user_id = $%d)`, cond, len(args)), args
 }
 
-func (a *App) handleDistributorOrders(w http.ResponseWriter, r *http.Request) {
-	_, distributorID, ok := a.requireDistributorID(w, r)
+func (a *App) handleDistributorUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	u, distributorID, ok := a.requireDistributorID(w, r)
 	if !ok {
 		return
 	}
-
-	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
-	where := "WHERE o.distributor_id=$1"
-	args := []any{distributorID}
-	if status != "" {
-		where += " AND o.status=$2"
-		args = append(args, status)
+	up, ok := a.readAttachmentUpload(w, r)
+	if !ok {
+		return
 	}
-
-	q := fmt.Sprintf(`
-    SELECT o.id, o.cement_type, o.quantity_tons, o.status, o.requested_at,
-           o.decided_at, o.decided_by_user_id, o.decision_reason, o.approved_shipment_id
-    FROM order_requests o
-    %s
-    ORDER BY o.requested_at DESC, o.id DESC
-    LIMIT 200
-  `, where)
-
-	rows, err := a.db.Query(r.Context(), q, args...)
+	link, err := parseAttachmentLink(r.FormValue)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
-	defer rows.Close()
-	items := []map[string]any{}
-	for rows.Next() {
-		var id int64
-		var ct, st, reason string
-		var qty float64
-		var requested time.Time
-		var decided *time.Time
-		var decidedBy *int64
-		var approvedShipment *int64
-		_ = rows.Scan(&id, &ct, &qty, &st, &requested, &decided, &decidedBy, &reason, &approvedShipment)
-		items = append(items, map[string]any{
-			"id":                 id,
-			"status":             st,
-			"requestedAt":        requested,
-			"decidedAt":          decided,
-			"decidedBy":          decidedBy,
-			"decisionReason":     reason,
-			"approvedShipmentId": approvedShipment,
-			"cementType":         ct,
-			"quantityTons":       qty,
-		})
+	var warehouseID *int64
+	if link.Column != "" {
+		wid, did, err := link.owner(r.Context(), a.db)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			writeDBError(w, err)
+			return
+		}
+		if err != nil || did == nil || *did != distributorID {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", link.noun()+" not found")
+			return
+		}
+		warehouseID = wid
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	a.storeAttachment(w, r, u, up, link, warehouseID, &distributorID)
 }
 
-func (a *App) handleDistributorCreateOrder(w http.ResponseWriter, r *http.Request) {
-	u, distributorID, ok := a.requireDistributorID(w, r)
+func (a *App) handleOpsUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	up, ok := a.readAttachmentUpload(w, r)
 	if !ok {
 		return
 	}
-
-	var body struct {
-		CementType   string  `json:"cementType"`
-		QuantityTons float64 `json:"quantityTons"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	link, err := parseAttachmentLink(r.FormValue)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
-	body.CementType = strings.TrimSpace(body.CementType)
-	if body.CementType == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "cementType required")
+	wid, did, err := link.owner(r.Context(), a.db)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", link.noun()+" not found")
 		return
 	}
-	if body.QuantityTons <= 0 {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "quantityTons must be > 0")
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-
-	var id int64
-	var requestedAt time.Time
-	if err := a.db.QueryRow(r.Context(), `
-    INSERT INTO order_requests (distributor_id, cement_type, quantity_tons, status, requested_at, updated_at)
-    VALUES ($1,$2,$3,'PENDING', now(), now())
-    RETURNING id, requested_at
-  `, distributorID, body.CementType, body.QuantityTons).Scan(&id, &requestedAt); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+	sc := scopeFrom(r)
+	if (wid != nil && !sc.allowsWarehouse(*wid)) || (did != nil && !sc.allowsDistributor(*did)) {
+		writeAPIError(w, http.StatusForbidden, "FORBIDDEN", link.noun()+" is outside your scope")
 		return
 	}
-	a.insertAuditLog(r, u, "DISTRIBUTOR_ORDER_CREATED", "order_requests", fmt.Sprintf("%d", id), map[string]any{"distributorId": distributorID, "cementType": body.CementType, "quantityTons": body.QuantityTons})
-	writeJSON(w, http.StatusCreated, map[string]any{"id": id, "requestedAt": requestedAt})
+	a.storeAttachment(w, r, &u, up, link, wid, did)
 }
 
-func (a *App) handleDistributorIssues(w http.ResponseWriter, r *http.Request) {
-	_, distributorID, ok := a.requireDistributorID(w, r)
-	if !ok {
-		return
-	}
-	status := strings.TrimSpace(strings.ToUpper(r.URL.Query().Get("status")))
-	where := "WHERE i.distributor_id=$1"
-	args := []any{distributorID}
-	if status != "" && status != "ALL" {
-		where += " AND i.status=$2"
-		args = append(args, status)
-	}
-
-	q := fmt.Sprintf(`
-    SELECT i.id, i.issue_type, i.severity, i.status,
-           i.title, i.description,
-           i.shipment_id,
-           i.reported_at,
-           i.resolved_at, i.resolution_notes,
-           i.metadata
-    FROM ops_issues i
-    %s
-    ORDER BY i.created_at DESC, i.id DESC
-    LIMIT 200
-  `, where)
-
-	rows, err := a.db.Query(r.Context(), q, args...)
+// issueAttachments loads the readable attachments of the given issues for the
+// issue lists, keyed by issue id.
+func (a *App) issueAttachments(r *http.Request, issueIDs []int64) (map[int64][]map[string]any, error) {
+	out := map[int64][]map[string]any{}
+	if len(issueIDs) == 0 {
+		return out, nil
+	}
+	cond, args := attachmentAccess(r)
+	args = append(args, issueIDs)
+	rows, err := a.db.Query(r.Context(), fmt.Sprintf(`
+    SELECT t.issue_id, t.id, t.filename, t.content_type, t.size_bytes, t.thumbnail_key IS NOT NULL
+    FROM attachments t
+    WHERE t.issue_id = ANY($%d) AND %s
+    ORDER BY t.id
+  `, len(args), cond), args...)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
+		return nil, err
 	}
 	defer rows.Close()
-	items := []map[string]any{}
 	for rows.Next() {
-		var id int64
-		var it, sev, st string
-		var title, desc string
-		var shipmentID *int64
-		var reportedAt time.Time
-		var resolvedAt *time.Time
-		var resolutionNotes string
-		var metadata json.RawMessage
-		_ = rows.Scan(&id, &it, &sev, &st, &title, &desc, &shipmentID, &reportedAt, &resolvedAt, &resolutionNotes, &metadata)
-		items = append(items, map[string]any{
-			"id":              id,
-			"issueType":       it,
-			"severity":        sev,
-			"status":          st,
-			"title":           title,
-			"description":     desc,
-			"shipmentId":      shipmentID,
-			"reportedAt":      reportedAt,
-			"resolvedAt":      resolvedAt,
-			"resolutionNotes": resolutionNotes,
-			"metadata":        metadata,
+		var issueID, id, size int64
+		var filename, contentType string
+		var hasThumb bool
+		if err := rows.Scan(&issueID, &id, &filename, &contentType, &size, &hasThumb); err != nil {
+			return nil, err
+		}
+		out[issueID] = append(out[issueID], map[string]any{
+			"id":           id,
+			"url":          attachmentURL(id),
+			"thumbnailUrl": thumbnailURL(id, hasThumb),
+			"filename":     filename,
+			"contentType":  contentType,
+			"sizeBytes":    size,
 		})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+	return out, rows.Err()
 }
 
-func (a *App) handleDistributorUpdateShipmentStatus(w http.ResponseWriter, r *http.Request) {
-	_, distributorID, ok := a.requireDistributorID(w, r)
-	if !ok {
-		return
+// attachIssueAttachments sets "attachments" on each issue list item.
+func (a *App) attachIssueAttachments(r *http.Request, items []map[string]any) error {
+	ids := make([]int64, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it["id"].(int64))
 	}
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	byIssue, err := a.issueAttachments(r, ids)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
-		return
+		return err
 	}
-	var body struct {
-		Status string `json:"status"`
+	for _, it := range items {
+		atts := byIssue[it["id"].(int64)]
+		if atts == nil {
+			atts = []map[string]any{}
+		}
+		it["attachments"] = atts
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+	return nil
+}
+
+// handleListAttachments lists the readable attachments of one issue, shipment
+// or order.
+func (a *App) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	link, err := parseAttachmentLink(r.URL.Query().Get)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
-	body.Status = strings.TrimSpace(strings.ToUpper(body.Status))
-	if body.Status != "RECEIVED" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "status must be RECEIVED")
+	if link.Column == "" {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "issueId, shipmentId or orderId required")
 		return
 	}
-
-	var currentStatus string
-	if err := a.db.QueryRow(r.Context(), `
-    SELECT status
-    FROM shipments
-    WHERE id=$1 AND to_distributor_id=$2
-  `, id, distributorID).Scan(&currentStatus); err != nil {
-		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "shipment not found")
+	cond, args := attachmentAccess(r)
+	args = append(args, link.ID)
+	rows, err := a.db.Query(r.Context(), fmt.Sprintf(`
+    SELECT t.id, t.filename, t.content_type, t.size_bytes, t.sha256, t.thumbnail_key IS NOT NULL,
+           t.uploaded_by_user_id, u.name, t.created_at
+    FROM attachments t
+    LEFT JOIN users u ON u.id = t.uploaded_by_user_id
+    WHERE t.%s = $%d AND %s
+    ORDER BY t.id
+  `, link.Column, len(args), cond), args...)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
-	if currentStatus == "RECEIVED" {
-		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": currentStatus})
-		return
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id, size int64
+		var filename, contentType, checksum string
+		var hasThumb bool
+		var uploaderID *int64
+		var uploaderName *string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &filename, &contentType, &size, &checksum, &hasThumb, &uploaderID, &uploaderName, &createdAt); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		items = append(items, map[string]any{
+			"id":           id,
+			"url":          attachmentURL(id),
+			"thumbnailUrl": thumbnailURL(id, hasThumb),
+			"filename":     filename,
+			"contentType":  contentType,
+			"sizeBytes":    size,
+			"sha256":       checksum,
+			"uploadedBy":   map[string]any{"id": uploaderID, "name": uploaderName},
+			"createdAt":    createdAt,
+		})
 	}
-	if currentStatus != "COMPLETED" {
-		writeAPIError(w, http.StatusConflict, "INVALID_STATE", "shipment must be COMPLETED before RECEIVED")
+	if err := rows.Err(); err != nil {
+		writeDBError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
 
-	if _, err := a.db.Exec(r.Context(), `
-    UPDATE shipments SET status='RECEIVED', updated_at=now() WHERE id=$1
-  `, id); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
-		return
-	}
-	u, _ := r.Context().Value(ctxUserKey).(User)
-	a.insertAuditLog(r, &u, "SHIPMENT_STATUS_UPDATED", "shipment", fmt.Sprintf("%d", id), map[string]any{"status": "RECEIVED"})
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": "RECEIVED"})
+func (a *App) handleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	a.serveAttachment(w, r, false)
 }
 
-func (a *App) handleDistributorIssueUpload(w http.ResponseWriter, r *http.Request) {
-	_, _, ok := a.requireDistributorID(w, r)
-	if !ok {
+func (a *App) handleAttachmentThumbnail(w http.ResponseWriter, r *http.Request) {
+	a.serveAttachment(w, r, true)
+}
+
+// serveAttachment streams an attachment, or its preview, that the user may
+// read. Others get 404 rather than 403 so ids of foreign attachments are not
+// confirmed.
+func (a *App) serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
 		return
 	}
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
-	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid multipart form")
+	cond, args := attachmentAccess(r)
+	args = append(args, id)
+	var storageKey, filename, contentType, checksum string
+	var thumbKey *string
+	var size int64
+	err = a.db.QueryRow(r.Context(), fmt.Sprintf(`
+    SELECT t.storage_key, t.thumbnail_key, t.filename, t.content_type, t.size_bytes, t.sha256
+    FROM attachments t
+    WHERE t.id = $%d AND %s
+  `, len(args), cond), args...).Scan(&storageKey, &thumbKey, &filename, &contentType, &size, &checksum)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "attachment not found")
 		return
 	}
-	file, header, err := r.FormFile("file")
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "file required")
+		writeDBError(w, err)
 		return
 	}
-	defer file.Close()
 
-	buf := make([]byte, 512)
-	n, _ := file.Read(buf)
-	contentType := http.DetectContentType(buf[:n])
-	allowedTypes := map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
-	if !allowedTypes[contentType] {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "file must be jpg, png, or webp")
-		return
+	etag := `"` + checksum + `"`
+	if thumbnail {
+		if thumbKey == nil {
+			writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "attachment has no thumbnail")
+			return
+		}
+		storageKey, contentType, filename, size = *thumbKey, "image/jpeg", "", -1
+		etag = `"` + checksum + `-thumb"`
 	}
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "file error")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if ext == "" {
-		switch contentType {
-		case "image/jpeg":
-			ext = ".jpg"
-		case "image/png":
-			ext = ".png"
-		case "image/webp":
-			ext = ".webp"
-		}
-	}
-	key := make([]byte, 12)
-	_, _ = rand.Read(key)
-	name := fmt.Sprintf("issue-%s%s", hex.EncodeToString(key), ext)
-
-	if err := os.MkdirAll(uploadsDir(), 0755); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "upload dir error")
+	body, err := a.store.Open(r.Context(), storageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "file not found")
 		return
 	}
-	path := filepath.Join(uploadsDir(), name)
-	out, err := os.Create(path)
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "file write error")
+		log.Printf("attachments: open %s: %v", storageKey, err)
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "storage error")
 		return
 	}
-	defer out.Close()
-	if _, err := io.Copy(out, file); err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "file write error")
-		return
+	defer body.Close()
+	w.Header().Set("Content-Type", contentType)
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("attachments: send %s: %v", storageKey, err)
 	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"url": "/uploads/" + name,
-	})
 }
 
-func (a *App) handleServeUpload(w http.ResponseWriter, r *http.Request) {
-	key := chi.URLParam(r, "key")
-	key = filepath.Base(strings.TrimSpace(key))
-	if key == "" {
-		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid file")
+// handleServeLegacyUpload serves evidence photos uploaded before attachments
+// existed, which issues reference by /uploads/{key} URL in
+// metadata.evidenceUrls. A file is only served to users who can read an issue
+// that references it.
+func (a *App) handleServeLegacyUpload(w http.ResponseWriter, r *http.Request) {
+	key := filepath.Base(strings.TrimSpace(chi.URLParam(r, "key")))
+	if !strings.HasPrefix(key, "issue-") || !storage.ValidKey(key) {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "file not found")
+		return
+	}
+	cond, args := ownerAccess(r)
+	args = append(args, "/uploads/"+key)
+	var referenced bool
+	if err := a.db.QueryRow(r.Context(), fmt.Sprintf(`
+    SELECT EXISTS(
+      SELECT 1 FROM ops_issues t
+      WHERE t.metadata->'evidenceUrls' ? $%d AND %s
+    )
+  `, len(args), cond), args...).Scan(&referenced); err != nil {
+		writeDBError(w, err)
 		return
 	}
-	path := filepath.Join(uploadsDir(), key)
-	if _, err := os.Stat(path); err != nil {
+	path := filepath.Join(LegacyUploadsDir(), key)
+	if _, err := os.Stat(path); err != nil || !referenced {
 		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "file not found")
 		return
 	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 	http.ServeFile(w, r, path)
 }
 
@@ -4366,11 +11738,12 @@ func (a *App) handleDistributorCreateIssue(w http.ResponseWriter, r *http.Reques
 	}
 
 	var body struct {
-		Severity    string         `json:"severity"`
-		Title       string         `json:"title"`
-		Description string         `json:"description"`
-		ShipmentID  *int64         `json:"shipmentId"`
-		Metadata    map[string]any `json:"metadata"`
+		Severity      string         `json:"severity"`
+		Title         string         `json:"title"`
+		Description   string         `json:"description"`
+		ShipmentID    *int64         `json:"shipmentId"`
+		Metadata      map[string]any `json:"metadata"`
+		AttachmentIDs []int64        `json:"attachmentIds"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
@@ -4412,8 +11785,15 @@ func (a *App) handleDistributorCreateIssue(w http.ResponseWriter, r *http.Reques
 	}
 	metaBytes, _ := json.Marshal(body.Metadata)
 
+	tx, err := a.db.Begin(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+
 	var id int64
-	if err := a.db.QueryRow(r.Context(), `
+	if err := tx.QueryRow(r.Context(), `
     INSERT INTO ops_issues (
       issue_type, severity, status,
       title, description,
@@ -4429,12 +11809,24 @@ func (a *App) handleDistributorCreateIssue(w http.ResponseWriter, r *http.Reques
 		writeDBError(w, err)
 		return
 	}
+	if err := a.linkAttachmentsTx(r.Context(), tx, u.ID, body.AttachmentIDs, id, nil, &distributorID); err != nil {
+		writeAttachmentLinkError(w, err)
+		return
+	}
 
-	a.insertAuditLog(r, u, "DISTRIBUTOR_ISSUE_REPORTED", "issue", fmt.Sprintf("%d", id), map[string]any{
+	if err := a.insertAuditLogTx(tx, r, u, "DISTRIBUTOR_ISSUE_REPORTED", "issue", fmt.Sprintf("%d", id), map[string]any{
 		"issueType":     "DAMAGED",
 		"shipmentId":    body.ShipmentID,
 		"distributorId": distributorID,
-	})
+		"attachmentIds": body.AttachmentIDs,
+	}); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "INTERNAL", "db error")
+		return
+	}
 
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "id": id})
 }
@@ -4520,6 +11912,114 @@ func (a *App) handleDistributorTransactions(w http.ResponseWriter, r *http.Reque
 	writeJSON(w, http.StatusOK, map[string]any{"items": items})
 }
 
+// ---------- notifications ----------
+
+func (a *App) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	offset := (page - 1) * pageSize
+	unreadOnly := r.URL.Query().Get("unread") == "true" || r.URL.Query().Get("unread") == "1"
+
+	rows, err := a.db.Query(r.Context(), `
+    SELECT id, kind, title, body, entity_type, entity_id, metadata, created_at, read_at
+    FROM notifications
+    WHERE user_id=$1 AND ($2::bool = false OR read_at IS NULL)
+    ORDER BY id DESC
+    LIMIT $3 OFFSET $4
+  `, u.ID, unreadOnly, pageSize, offset)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	for rows.Next() {
+		var id int64
+		var kind, title, body, entityType, entityID string
+		var metadata json.RawMessage
+		var createdAt time.Time
+		var readAt *time.Time
+		if err := rows.Scan(&id, &kind, &title, &body, &entityType, &entityID, &metadata, &createdAt, &readAt); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		item := map[string]any{
+			"id":         fmt.Sprintf("%d", id),
+			"kind":       kind,
+			"title":      title,
+			"body":       body,
+			"entityType": entityType,
+			"entityId":   entityID,
+			"metadata":   metadata,
+			"createdAt":  createdAt.Format(time.RFC3339),
+			"readAt":     nil,
+		}
+		if readAt != nil {
+			item["readAt"] = readAt.Format(time.RFC3339)
+		}
+		items = append(items, item)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "page": page, "pageSize": pageSize})
+}
+
+func (a *App) handleNotificationsUnreadCount(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	var count int64
+	if err := a.db.QueryRow(r.Context(), `
+    SELECT count(*) FROM notifications WHERE user_id=$1 AND read_at IS NULL
+  `, u.ID).Scan(&count); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"count": count})
+}
+
+func (a *App) handleMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid id")
+		return
+	}
+	// Scoped by user_id so one user cannot probe or touch another user's inbox.
+	tag, err := a.db.Exec(r.Context(), `
+    UPDATE notifications SET read_at=COALESCE(read_at, now())
+    WHERE id=$1 AND user_id=$2
+  `, id, u.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		writeAPIError(w, http.StatusNotFound, "NOT_FOUND", "notification not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (a *App) handleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxUserKey).(User)
+	tag, err := a.db.Exec(r.Context(), `
+    UPDATE notifications SET read_at=now()
+    WHERE user_id=$1 AND read_at IS NULL
+  `, u.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "updated": tag.RowsAffected()})
+}
+
 // ---------- math utils ----------
 func haversineKM(lat1, lng1, lat2, lng2 float64) float64 {
 	const R = 6371.0
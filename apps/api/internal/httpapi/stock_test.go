@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestMoveStockTx runs inside a transaction that is rolled back, so stock
+// levels and the movement ledger are left untouched.
+func TestMoveStockTx(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var warehouseID int64
+	if err := tx.QueryRow(ctx, `SELECT id FROM warehouses ORDER BY id LIMIT 1`).Scan(&warehouseID); err != nil {
+		t.Fatal(err)
+	}
+	const ct = "MOVE-STOCK-TEST"
+	level := func() float64 {
+		t.Helper()
+		var q float64
+		if err := tx.QueryRow(ctx, `SELECT quantity_tons FROM stock_levels WHERE warehouse_id=$1 AND cement_type=$2`, warehouseID, ct).Scan(&q); err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	// The first movement creates the stock level row.
+	if got, err := moveStockTx(ctx, tx, nil, warehouseID, ct, 10, "delivery", "stock_transfer", "901", nil); err != nil || got != 10 {
+		t.Fatalf("move +10 = %v, %v", got, err)
+	}
+	if got, err := moveStockTx(ctx, tx, nil, warehouseID, ct, -4, "transfer out", "stock_transfer", "902", map[string]any{"toWarehouseId": 7}); err != nil || got != 6 {
+		t.Fatalf("move -4 = %v, %v", got, err)
+	}
+	if got, err := moveStockTx(ctx, tx, nil, warehouseID, ct, -6, "empty it", "", "", nil); err != nil || got != 0 {
+		t.Fatalf("move -6 = %v, %v", got, err)
+	}
+	if q := level(); q != 0 {
+		t.Fatalf("stock level = %v, want 0", q)
+	}
+
+	// Going below zero reports the current level and changes nothing.
+	got, err := moveStockTx(ctx, tx, nil, warehouseID, ct, -0.5, "too much", "stock_transfer", "903", nil)
+	if !errors.Is(err, errInsufficientStock) || got != 0 {
+		t.Fatalf("move -0.5 = %v, %v; want 0, errInsufficientStock", got, err)
+	}
+	if q := level(); q != 0 {
+		t.Fatalf("stock level after refused move = %v", q)
+	}
+
+	rows, err := tx.Query(ctx, `
+    SELECT movement_type, quantity_tons, reason, ref_type, ref_id, metadata->>'toWarehouseId'
+    FROM inventory_movements
+    WHERE warehouse_id=$1 AND cement_type=$2
+    ORDER BY id
+  `, warehouseID, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type movement struct {
+		typ, reason, refType, refID string
+		qty                         float64
+		to                          *string
+	}
+	var ledger []movement
+	for rows.Next() {
+		var m movement
+		if err := rows.Scan(&m.typ, &m.qty, &m.reason, &m.refType, &m.refID, &m.to); err != nil {
+			t.Fatal(err)
+		}
+		ledger = append(ledger, m)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger) != 3 {
+		t.Fatalf("ledger has %d movements, want 3: %+v", len(ledger), ledger)
+	}
+	want := []struct {
+		typ   string
+		qty   float64
+		refID string
+	}{{"IN", 10, "901"}, {"OUT", 4, "902"}, {"OUT", 6, ""}}
+	for i, w := range want {
+		if m := ledger[i]; m.typ != w.typ || m.qty != w.qty || m.refID != w.refID {
+			t.Errorf("movement %d = %+v, want %s %v ref %q", i, m, w.typ, w.qty, w.refID)
+		}
+	}
+	if to := ledger[1].to; to == nil || *to != "7" {
+		t.Errorf("metadata of the OUT movement = %v", to)
+	}
+}
@@ -0,0 +1,153 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Sender delivers a single rendered message. Implementations must be safe for
+// concurrent use.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ---------- SMTP ----------
+
+// SMTPSender delivers through an SMTP relay, upgrading to TLS via STARTTLS when
+// the server offers it.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.Host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	envelopeFrom := s.From
+	if a, err := mail.ParseAddress(s.From); err == nil {
+		envelopeFrom = a.Address
+	}
+	if err := c.Mail(envelopeFrom); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(buildMessage(s.From, msg)); err != nil {
+		_ = wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// ---------- log / file ----------
+
+// LogSender is the local-dev sender: it logs each message and, when Dir is set,
+// writes it as an .eml file that can be opened in any mail client. Bodies can
+// hold reset links and temporary passwords, so they are only logged when
+// LogBodies is set.
+type LogSender struct {
+	Dir       string
+	From      string
+	LogBodies bool
+
+	seq atomic.Int64
+}
+
+func (s *LogSender) Send(_ context.Context, msg Message) error {
+	if s.Dir == "" {
+		if s.LogBodies {
+			log.Printf("mail: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Text)
+		} else {
+			log.Printf("mail: to=%s subject=%q (body not logged)", msg.To, msg.Subject)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().UTC().Format("20060102T150405"), s.seq.Add(1)%1000)
+	path := filepath.Join(s.Dir, name)
+	if err := os.WriteFile(path, buildMessage(s.From, msg), 0o644); err != nil {
+		return err
+	}
+	log.Printf("mail: to=%s subject=%q written to %s", msg.To, msg.Subject, path)
+	return nil
+}
+
+func buildMessage(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	_, _ = qp.Write([]byte(strings.ReplaceAll(msg.Text, "\n", "\r\n")))
+	_ = qp.Close()
+	return b.Bytes()
+}
@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(prev)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestLogSenderOmitsBodiesByDefault(t *testing.T) {
+	buf := captureLog(t)
+	msg := Message{To: "a@example.com", Subject: "Reset", Text: "https://example.com/reset?token=secret"}
+
+	if err := (&LogSender{}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); strings.Contains(out, "token=secret") || !strings.Contains(out, "a@example.com") {
+		t.Fatalf("default log output = %q", out)
+	}
+
+	buf.Reset()
+	if err := (&LogSender{LogBodies: true}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "token=secret") {
+		t.Fatalf("LogBodies output = %q", buf.String())
+	}
+}
+
+func TestLogSenderWritesEML(t *testing.T) {
+	buf := captureLog(t)
+	dir := t.TempDir()
+	s := &LogSender{Dir: dir, From: "CementOps <no-reply@example.com>"}
+	if err := s.Send(context.Background(), Message{To: "a@example.com", Subject: "Hi", Text: "secret body"}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("got %d .eml files", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("To: a@example.com\r\n")) || !bytes.Contains(data, []byte("secret body")) {
+		t.Fatalf("eml = %q", data)
+	}
+	if strings.Contains(buf.String(), "secret body") {
+		t.Fatalf("body was logged: %q", buf.String())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 7: maxBackoff, 20: maxBackoff}
+	for attempts, want := range cases {
+		if got := backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Execer is satisfied by *pgxpool.Pool and pgx.Tx, so mail can be queued in the
+// same transaction as the change that triggers it and is dropped if that rolls back.
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Enqueue renders a template and queues it for a single address.
+func Enqueue(ctx context.Context, q Execer, to, tmpl string, data map[string]any) error {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return nil
+	}
+	subject, body, err := Render(tmpl, data)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+    INSERT INTO email_outbox (template, to_address, subject, body_text)
+    VALUES ($1,$2,$3,$4)
+  `, tmpl, to, subject, body)
+	return err
+}
+
+// EnqueueToUsers queues one message per active user in userIDs.
+func EnqueueToUsers(ctx context.Context, q Execer, userIDs []int64, tmpl string, data map[string]any) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	subject, body, err := Render(tmpl, data)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+    INSERT INTO email_outbox (template, to_address, subject, body_text)
+    SELECT $2, u.email, $3, $4
+    FROM users u
+    WHERE u.id = ANY($1::bigint[]) AND u.disabled_at IS NULL
+  `, userIDs, tmpl, subject, body)
+	return err
+}
+
+// EnqueueToDistributor queues one message per active portal user of a distributor.
+func EnqueueToDistributor(ctx context.Context, q Execer, distributorID int64, tmpl string, data map[string]any) error {
+	subject, body, err := Render(tmpl, data)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+    INSERT INTO email_outbox (template, to_address, subject, body_text)
+    SELECT $2, u.email, $3, $4
+    FROM users u
+    WHERE u.distributor_id = $1 AND u.disabled_at IS NULL
+  `, distributorID, tmpl, subject, body)
+	return err
+}
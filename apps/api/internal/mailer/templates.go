@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Template names stored in email_outbox.template.
+const (
+	TemplateAlert         = "alert"
+	TemplateOrderDecision = "order_decision"
+	TemplatePasswordReset = "password_reset"
+	// TemplatePasswordResetLink is the self-service forgot-password email.
+	TemplatePasswordResetLink = "password_reset_link"
+)
+
+type emailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+	// sensitive bodies (credentials, reset links) are scrubbed from the outbox
+	// once the message is no longer pending.
+	sensitive bool
+}
+
+func mustTemplate(name, subject, body string, sensitive bool) emailTemplate {
+	return emailTemplate{
+		subject:   template.Must(template.New(name + ".subject").Parse(subject)),
+		body:      template.Must(template.New(name + ".body").Parse(body)),
+		sensitive: sensitive,
+	}
+}
+
+var templates = map[string]emailTemplate{
+	TemplateAlert: mustTemplate(TemplateAlert,
+		`[CementOps {{.Severity}}] {{.Title}}`,
+		`{{.Title}}
+
+{{.Message}}
+
+Rule:     {{.RuleName}}
+Severity: {{.Severity}}
+Detected: {{.DetectedAt}}
+
+This alert stays open until the condition clears. Open the CementOps dashboard for details.
+`, false),
+
+	TemplateOrderDecision: mustTemplate(TemplateOrderDecision,
+		`Order #{{.OrderID}} {{if eq .Decision "APPROVED"}}approved{{else}}rejected{{end}}`,
+		`Hello,
+
+Your order request #{{.OrderID}} for {{.QuantityTons}} tons of {{.CementType}} was {{if eq .Decision "APPROVED"}}approved{{else}}rejected{{end}}.
+{{- if .ShipmentID}}
+
+Shipment #{{.ShipmentID}} has been scheduled{{if .ETA}} with an estimated arrival of {{.ETA}}{{end}}.
+{{- end}}
+{{- if .Reason}}
+
+Reason: {{.Reason}}
+{{- end}}
+
+You can follow up in the CementOps distributor portal.
+`, false),
+
+	TemplatePasswordReset: mustTemplate(TemplatePasswordReset,
+		`Your CementOps password was reset`,
+		`Hello {{.Name}},
+
+An administrator reset the password for {{.Email}}.
+
+Temporary password: {{.TempPassword}}
+
+Sign in with it and change your password right away. If you did not expect this, contact your administrator.
+`, true),
+
+	TemplatePasswordResetLink: mustTemplate(TemplatePasswordResetLink,
+		`Reset your CementOps password`,
+		`Hello {{.Name}},
+
+Someone asked to reset the password for {{.Email}}. To choose a new password, open this link:
+
+{{.Link}}
+
+The link works once and expires at {{.ExpiresAt}}. If you did not ask for this, you can ignore this email; your password has not changed.
+`, true),
+}
+
+// Render produces the subject and plain-text body for a template.
+func Render(name string, data map[string]any) (subject, body string, err error) {
+	t, ok := templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("mailer: unknown template %q", name)
+	}
+	var sb, bb bytes.Buffer
+	if err := t.subject.Execute(&sb, data); err != nil {
+		return "", "", fmt.Errorf("mailer: render %s subject: %w", name, err)
+	}
+	if err := t.body.Execute(&bb, data); err != nil {
+		return "", "", fmt.Errorf("mailer: render %s body: %w", name, err)
+	}
+	return strings.TrimSpace(sb.String()), bb.String(), nil
+}
+
+func isSensitive(name string) bool {
+	return templates[name].sensitive
+}
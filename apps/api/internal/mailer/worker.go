@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	batchSize   = 20
+	maxAttempts = 8
+	maxBackoff  = time.Hour
+	// claimLease is how long a claimed message is hidden from other workers
+	// while it is being sent.
+	claimLease = 10 * time.Minute
+)
+
+// Worker drains email_outbox through a Sender, retrying failures with
+// exponential backoff until maxAttempts is reached.
+type Worker struct {
+	db       *pgxpool.Pool
+	sender   Sender
+	interval time.Duration
+}
+
+func NewWorker(db *pgxpool.Pool, sender Sender, interval time.Duration) *Worker {
+	return &Worker{db: db, sender: sender, interval: interval}
+}
+
+// Run flushes the outbox immediately and then on every tick until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	if w.interval <= 0 {
+		log.Printf("mailer: outbox worker disabled")
+		return
+	}
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		if err := w.FlushOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("mailer: flush: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// FlushOnce sends due messages in batches until none are left.
+func (w *Worker) FlushOnce(ctx context.Context) error {
+	for {
+		n, err := w.flushBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if n < batchSize || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+type outboxRow struct {
+	id       int64
+	template string
+	to       string
+	subject  string
+	body     string
+	attempts int
+}
+
+// flushBatch claims a batch and then sends it. The claim is its own committed
+// statement: it picks due rows with SKIP LOCKED, so several API instances can
+// drain the same outbox, and pushes their next_attempt_at out by claimLease so
+// nobody else picks them up while they are being sent. Each result is then
+// recorded on its own, so a failure to record one message never causes the
+// rest of the batch to be sent again. A worker that dies mid-batch leaves its
+// unsent rows to be retried once the lease runs out.
+func (w *Worker) flushBatch(ctx context.Context) (int, error) {
+	rows, err := w.db.Query(ctx, `
+    UPDATE email_outbox
+    SET next_attempt_at = now() + $2::bigint * INTERVAL '1 second'
+    WHERE id IN (
+      SELECT id FROM email_outbox
+      WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()
+      ORDER BY id
+      LIMIT $1
+      FOR UPDATE SKIP LOCKED
+    )
+    RETURNING id, template, to_address, subject, body_text, attempts
+  `, batchSize, int64(claimLease/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	var batch []outboxRow
+	for rows.Next() {
+		var m outboxRow
+		if err := rows.Scan(&m.id, &m.template, &m.to, &m.subject, &m.body, &m.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	slices.SortFunc(batch, func(a, b outboxRow) int { return cmp.Compare(a.id, b.id) })
+
+	for _, m := range batch {
+		sendErr := w.sender.Send(ctx, Message{To: m.to, Subject: m.subject, Text: m.body})
+		if err := w.record(ctx, m, sendErr); err != nil {
+			log.Printf("mailer: record outbox %d: %v", m.id, err)
+		}
+	}
+	return len(batch), nil
+}
+
+// record stores the outcome of one send attempt.
+func (w *Worker) record(ctx context.Context, m outboxRow, sendErr error) error {
+	scrub := isSensitive(m.template)
+	if sendErr == nil {
+		_, err := w.db.Exec(ctx, `
+      UPDATE email_outbox
+      SET sent_at=now(), attempts=attempts+1, last_error='',
+          body_text=CASE WHEN $2 THEN '' ELSE body_text END
+      WHERE id=$1
+    `, m.id, scrub)
+		return err
+	}
+
+	attempts := m.attempts + 1
+	log.Printf("mailer: send outbox %d to %s (attempt %d): %v", m.id, m.to, attempts, sendErr)
+	if attempts >= maxAttempts {
+		_, err := w.db.Exec(ctx, `
+      UPDATE email_outbox
+      SET failed_at=now(), attempts=$2, last_error=$3,
+          body_text=CASE WHEN $4 THEN '' ELSE body_text END
+      WHERE id=$1
+    `, m.id, attempts, sendErr.Error(), scrub)
+		return err
+	}
+	_, err := w.db.Exec(ctx, `
+    UPDATE email_outbox
+    SET attempts=$2, last_error=$3, next_attempt_at=$4
+    WHERE id=$1
+  `, m.id, attempts, sendErr.Error(), time.Now().UTC().Add(backoff(attempts)))
+	return err
+}
+
+// backoff doubles from one minute per failed attempt, capped at maxBackoff.
+func backoff(attempts int) time.Duration {
+	d := time.Minute
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,336 @@
+// Package media prepares uploaded images for storage: it strips embedded
+// metadata (EXIF, XMP, text chunks) and renders small JPEG previews. It works
+// on the container formats directly, so JPEG, PNG and WebP files keep their
+// original encoding unless a JPEG orientation has to be applied to the pixels.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+var (
+	// ErrNoPreview is returned by Thumbnail for types it cannot decode
+	// (WebP, PDF).
+	ErrNoPreview = errors.New("media: no preview for this type")
+	// ErrCorrupt is returned for files whose structure cannot be parsed.
+	ErrCorrupt = errors.New("media: malformed image")
+	// ErrTooLarge is returned for images with more pixels than maxPixels.
+	ErrTooLarge = errors.New("media: image dimensions too large")
+)
+
+// maxPixels bounds how large an image is decoded, so a small, highly
+// compressed file cannot exhaust memory.
+const maxPixels = 50_000_000
+
+// Strip removes metadata from a JPEG, PNG or WebP image and returns other
+// types unchanged. A JPEG whose EXIF orientation is not upright is decoded,
+// rotated and re-encoded, since dropping the tag would otherwise display it
+// sideways.
+func Strip(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		out, orientation, err := stripJPEG(data)
+		if err != nil || orientation <= 1 || orientation > 8 {
+			return out, err
+		}
+		img, err := decode(out, jpeg.Decode)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, orient(img, orientation), &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	default:
+		return data, nil
+	}
+}
+
+// Thumbnail renders a JPEG no larger than maxSide on either edge, with
+// transparency flattened onto white. Images already smaller are not enlarged.
+func Thumbnail(data []byte, contentType string, maxSide int) ([]byte, error) {
+	var img image.Image
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		img, err = decode(data, jpeg.Decode)
+	case "image/png":
+		img, err = decode(data, png.Decode)
+	default:
+		return nil, ErrNoPreview
+	}
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, fit(img, maxSide), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, fn func(r io.Reader) (image.Image, error)) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, err := fn(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return img, nil
+}
+
+// ---------- JPEG ----------
+
+// stripJPEG drops APP1 (EXIF, XMP) and APP13 (IPTC) segments and reports the
+// EXIF orientation found, or 0.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, ErrCorrupt
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	orientation := 0
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return nil, 0, ErrCorrupt
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // fill byte
+			i++
+			continue
+		case marker == 0xD9: // EOI
+			return append(out, data[i:]...), orientation, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, 0, ErrCorrupt
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, 0, ErrCorrupt
+		}
+		switch marker {
+		case 0xDA: // SOS: entropy-coded data runs to the end of the image
+			return append(out, data[i:]...), orientation, nil
+		case 0xE1:
+			if o := exifOrientation(data[i+4 : end]); o > 0 {
+				orientation = o
+			}
+		case 0xED:
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return nil, 0, ErrCorrupt
+}
+
+// exifOrientation reads tag 0x0112 from IFD0 of an APP1 EXIF payload.
+func exifOrientation(seg []byte) int {
+	if !bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+		return 0
+	}
+	tiff := seg[6:]
+	if len(tiff) < 8 {
+		return 0
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(bo.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(bo.Uint16(tiff[ifd:]))
+	for k := 0; k < n; k++ {
+		e := ifd + 2 + k*12
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if bo.Uint16(tiff[e:]) == 0x0112 {
+			return int(bo.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
+
+// orient applies an EXIF orientation (2-8) to the pixels.
+func orient(src image.Image, o int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// ---------- PNG ----------
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are ancillary chunks that carry metadata rather than
+// pixels or colour information.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "iTXt": true, "zTXt": true, "tIME": true}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrCorrupt
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrCorrupt
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, ErrCorrupt
+		}
+		typ := string(data[i+4 : i+8])
+		if !pngMetadataChunks[typ] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if typ == "IEND" {
+			break
+		}
+	}
+	return out, nil
+}
+
+// ---------- WebP ----------
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrCorrupt
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			if i+8+size != len(data) { // tolerate a missing final pad byte
+				return nil, ErrCorrupt
+			}
+			end = len(data)
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// ---------- scaling ----------
+
+// fit downscales by averaging each destination pixel's source area,
+// compositing onto white.
+func fit(src image.Image, maxSide int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			tw, th = maxSide, max(1, h*maxSide/w)
+		} else {
+			tw, th = max(1, w*maxSide/h), maxSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// Premultiplied colour over white: c + (1 - alpha).
+			white := n*0xffff - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((bl + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
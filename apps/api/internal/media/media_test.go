@@ -0,0 +1,343 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// halves returns a w×h image whose left half is red and right half blue.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegSegment builds a marker segment with its length prefix.
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// exif builds an APP1 EXIF payload whose IFD0 holds only the orientation tag.
+func exif(bo binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if bo == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	bo.PutUint16(tiff[2:], 42)
+	bo.PutUint32(tiff[4:], 8)
+	bo.PutUint16(tiff[8:], 1)
+	bo.PutUint16(tiff[10:], 0x0112)
+	bo.PutUint16(tiff[12:], 3) // SHORT
+	bo.PutUint32(tiff[14:], 1)
+	bo.PutUint16(tiff[18:], orientation)
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// withSegments inserts segments right after the SOI marker.
+func withSegments(jpg []byte, segs ...[]byte) []byte {
+	out := append([]byte(nil), jpg[:2]...)
+	for _, s := range segs {
+		out = append(out, s...)
+	}
+	return append(out, jpg[2:]...)
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	c := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(c, uint32(len(payload)))
+	copy(c[4:], typ)
+	c = append(c, payload...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+func webpChunk(typ string, payload []byte) []byte {
+	c := make([]byte, 8, 9+len(payload))
+	copy(c, typ)
+	binary.LittleEndian.PutUint32(c[4:], uint32(len(payload)))
+	c = append(c, payload...)
+	if len(payload)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func riff(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	out := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	return append(out, body...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	plain := encodeJPEG(t, halves(16, 16))
+	for _, o := range []uint16{0, 1, 9} {
+		tagged := withSegments(plain,
+			jpegSegment(0xE1, exif(binary.BigEndian, o)),
+			jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+			jpegSegment(0xED, []byte("Photoshop 3.0\x00IPTC")),
+		)
+		got, err := Strip(tagged, "image/jpeg")
+		if err != nil {
+			t.Fatalf("orientation %d: %v", o, err)
+		}
+		// Upright and unknown orientations keep the original encoding.
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("orientation %d: stripped file differs from the untagged encoding", o)
+		}
+	}
+
+	// Segments that carry no metadata, such as a JFIF APP0, are kept.
+	app0 := jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	got, err := Strip(withSegments(plain, app0, jpegSegment(0xE1, exif(binary.LittleEndian, 1))), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := withSegments(plain, app0); !bytes.Equal(got, want) {
+		t.Fatal("APP0 segment was not preserved")
+	}
+}
+
+func TestStripJPEGOrientation(t *testing.T) {
+	// Orientation 6 is a 90° clockwise turn: the red left half ends up on top.
+	for _, bo := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		tagged := withSegments(encodeJPEG(t, halves(32, 16)), jpegSegment(0xE1, exif(bo, 6)))
+		got, err := Strip(tagged, "image/jpeg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(got, []byte("Exif")) {
+			t.Fatal("EXIF survived the rotation")
+		}
+		img, err := jpeg.Decode(bytes.NewReader(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 32 {
+			t.Fatalf("%v: bounds = %v, want 16x32", bo, b)
+		}
+		top, bottom := rgb(img.At(8, 8)), rgb(img.At(8, 24))
+		if top[0] < 200 || top[2] > 60 || bottom[2] < 200 || bottom[0] > 60 {
+			t.Fatalf("%v: top = %v, bottom = %v", bo, top, bottom)
+		}
+	}
+}
+
+func rgb(c color.Color) [3]uint32 {
+	r, g, b, _ := c.RGBA()
+	return [3]uint32{r >> 8, g >> 8, b >> 8}
+}
+
+func TestStripCorrupt(t *testing.T) {
+	jpg := encodeJPEG(t, halves(8, 8))
+	pngData := encodePNG(t, halves(8, 8))
+	cases := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{name: "jpeg without SOI", data: []byte("not a jpeg"), contentType: "image/jpeg"},
+		{name: "jpeg truncated before SOS", data: jpg[:20], contentType: "image/jpeg"},
+		{name: "jpeg segment past the end", data: withSegments(jpg, []byte{0xFF, 0xE1, 0xFF, 0xFF})[:40], contentType: "image/jpeg"},
+		{name: "jpeg segment length below 2", data: withSegments(jpg, []byte{0xFF, 0xE1, 0x00, 0x01}), contentType: "image/jpeg"},
+		{name: "jpeg junk between segments", data: withSegments(jpg, []byte{0x00}), contentType: "image/jpeg"},
+		{name: "png without signature", data: []byte("GIF89a"), contentType: "image/png"},
+		{name: "png chunk past the end", data: pngData[:len(pngData)-4], contentType: "image/png"},
+		{name: "png truncated chunk header", data: append(append([]byte(nil), pngSignature...), 0, 0), contentType: "image/png"},
+		{name: "webp without header", data: []byte("RIFF\x00\x00\x00\x00WAVE"), contentType: "image/webp"},
+		{name: "webp chunk past the end", data: riff(webpChunk("VP8L", make([]byte, 10)))[:24], contentType: "image/webp"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Strip(tc.data, tc.contentType); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("err = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	plain := encodePNG(t, halves(4, 4))
+	ihdrEnd := len(pngSignature) + 12 + 13
+	var tagged []byte
+	tagged = append(tagged, plain[:ihdrEnd]...)
+	for _, typ := range []string{"tEXt", "iTXt", "zTXt", "eXIf", "tIME"} {
+		tagged = append(tagged, pngChunk(typ, []byte("Author\x00someone"))...)
+	}
+	gamma := pngChunk("gAMA", []byte{0, 0, 0xB1, 0x8F})
+	tagged = append(tagged, gamma...)
+	tagged = append(tagged, plain[ihdrEnd:]...)
+	// Anything after IEND is dropped.
+	tagged = append(tagged, "trailing"...)
+
+	got, err := Strip(tagged, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append(append([]byte(nil), plain[:ihdrEnd]...), gamma...), plain[ihdrEnd:]...)
+	if !bytes.Equal(got, want) {
+		t.Fatal("stripped PNG is not the original plus the colour chunk")
+	}
+	if _, err := png.Decode(bytes.NewReader(got)); err != nil {
+		t.Fatalf("stripped PNG does not decode: %v", err)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | 0x04 | 0x10                     // EXIF, XMP and alpha
+	vp8l := webpChunk("VP8L", []byte{1, 2, 3, 4, 5}) // odd size, padded
+	data := riff(
+		webpChunk("VP8X", vp8x),
+		webpChunk("ICCP", []byte{9, 9}),
+		vp8l,
+		webpChunk("EXIF", []byte("II*\x00")),
+		webpChunk("XMP ", []byte("<x:xmpmeta/>")),
+	)
+
+	got, err := Strip(data, "image/webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleared := append([]byte(nil), vp8x...)
+	cleared[0] = 0x10
+	want := riff(webpChunk("VP8X", cleared), webpChunk("ICCP", []byte{9, 9}), vp8l)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+	if vp8x[0] != 0x08|0x04|0x10 {
+		t.Fatal("Strip modified its input")
+	}
+
+	// A final odd-sized chunk may omit its pad byte.
+	unpadded := riff(vp8l)
+	unpadded = unpadded[:len(unpadded)-1]
+	binary.LittleEndian.PutUint32(unpadded[4:], uint32(len(unpadded)-8))
+	if got, err := Strip(unpadded, "image/webp"); err != nil || !bytes.Equal(got, unpadded) {
+		t.Fatalf("unpadded final chunk: %q, %v", got, err)
+	}
+}
+
+func TestStripOtherTypes(t *testing.T) {
+	data := []byte("%PDF-1.7 /Author (someone)")
+	got, err := Strip(data, "application/pdf")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Strip(pdf) = %q, %v", got, err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	cases := []struct {
+		name        string
+		data        []byte
+		contentType string
+		w, h        int
+	}{
+		{name: "landscape jpeg", data: encodeJPEG(t, halves(400, 100)), contentType: "image/jpeg", w: 100, h: 25},
+		{name: "portrait png", data: encodePNG(t, halves(30, 300)), contentType: "image/png", w: 10, h: 100},
+		{name: "thin strip keeps one pixel", data: encodePNG(t, halves(1000, 2)), contentType: "image/png", w: 100, h: 1},
+		{name: "small image is not enlarged", data: encodePNG(t, halves(40, 20)), contentType: "image/png", w: 40, h: 20},
+		{name: "transparent png", data: encodePNG(t, transparent), contentType: "image/png", w: 100, h: 50},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Thumbnail(tc.data, tc.contentType, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("thumbnail is not a JPEG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tc.w || b.Dy() != tc.h {
+				t.Fatalf("bounds = %v, want %dx%d", b, tc.w, tc.h)
+			}
+		})
+	}
+
+	out, err := Thumbnail(encodePNG(t, transparent), "image/png", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := jpeg.Decode(bytes.NewReader(out))
+	if c := rgb(img.At(50, 25)); c[0] < 250 || c[1] < 250 || c[2] < 250 {
+		t.Fatalf("transparent pixel rendered as %v, want white", c)
+	}
+	out, err = Thumbnail(encodeJPEG(t, halves(400, 100)), "image/jpeg", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ = jpeg.Decode(bytes.NewReader(out))
+	if left, right := rgb(img.At(10, 12)), rgb(img.At(90, 12)); left[0] < 200 || right[2] < 200 {
+		t.Fatalf("left = %v, right = %v", left, right)
+	}
+}
+
+func TestThumbnailErrors(t *testing.T) {
+	// A valid header claiming 10000×10000 pixels must be refused before decoding.
+	huge := encodePNG(t, halves(1, 1))
+	ihdr := huge[len(pngSignature):]
+	binary.BigEndian.PutUint32(ihdr[8:], 10000)
+	binary.BigEndian.PutUint32(ihdr[12:], 10000)
+	binary.BigEndian.PutUint32(ihdr[21:], crc32.ChecksumIEEE(ihdr[4:21]))
+
+	cases := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        error
+	}{
+		{name: "webp", data: riff(), contentType: "image/webp", want: ErrNoPreview},
+		{name: "pdf", data: []byte("%PDF"), contentType: "application/pdf", want: ErrNoPreview},
+		{name: "garbage jpeg", data: []byte("not a jpeg"), contentType: "image/jpeg", want: ErrCorrupt},
+		{name: "truncated png", data: encodePNG(t, halves(50, 50))[:60], contentType: "image/png", want: ErrCorrupt},
+		{name: "too many pixels", data: huge, contentType: "image/png", want: ErrTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Thumbnail(tc.data, tc.contentType, 100); !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Kinds stored in notifications.kind.
+const (
+	KindAlert          = "ALERT"
+	KindOrderApproved  = "ORDER_APPROVED"
+	KindOrderRejected  = "ORDER_REJECTED"
+	KindShipmentStatus = "SHIPMENT_STATUS"
+)
+
+// Execer is satisfied by *pgxpool.Pool and pgx.Tx so notifications can be written
+// inside the caller's transaction.
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type Notification struct {
+	Kind       string
+	Title      string
+	Body       string
+	EntityType string
+	EntityID   string
+	Metadata   map[string]any
+}
+
+func (n Notification) metadataJSON() string {
+	if n.Metadata == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(n.Metadata)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// ToUsers stores one inbox entry per active user in userIDs.
+func ToUsers(ctx context.Context, q Execer, userIDs []int64, n Notification) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, `
+    INSERT INTO notifications (user_id, kind, title, body, entity_type, entity_id, metadata)
+    SELECT u.id, $2, $3, $4, $5, $6, $7::jsonb
+    FROM users u
+    WHERE u.id = ANY($1::bigint[]) AND u.disabled_at IS NULL
+  `, userIDs, n.Kind, n.Title, n.Body, n.EntityType, n.EntityID, n.metadataJSON())
+	return err
+}
+
+// ToRoles notifies every active user holding one of roles.
+func ToRoles(ctx context.Context, q Execer, roles []string, n Notification) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, `
+    INSERT INTO notifications (user_id, kind, title, body, entity_type, entity_id, metadata)
+    SELECT u.id, $2, $3, $4, $5, $6, $7::jsonb
+    FROM users u
+    WHERE u.role = ANY($1::text[]) AND u.disabled_at IS NULL
+  `, roles, n.Kind, n.Title, n.Body, n.EntityType, n.EntityID, n.metadataJSON())
+	return err
+}
+
+// ToDistributor notifies the active portal users linked to a distributor.
+func ToDistributor(ctx context.Context, q Execer, distributorID int64, n Notification) error {
+	_, err := q.Exec(ctx, `
+    INSERT INTO notifications (user_id, kind, title, body, entity_type, entity_id, metadata)
+    SELECT u.id, $2, $3, $4, $5, $6, $7::jsonb
+    FROM users u
+    WHERE u.distributor_id = $1 AND u.disabled_at IS NULL
+  `, distributorID, n.Kind, n.Title, n.Body, n.EntityType, n.EntityID, n.metadataJSON())
+	return err
+}
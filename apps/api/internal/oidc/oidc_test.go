@@ -0,0 +1,196 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"cementops/api/internal/oidc"
+	"cementops/api/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://app.test/api/auth/oidc/callback"
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	idp := oidctest.NewServer(t, "cementops", "secret")
+	return idp, oidc.New(oidc.Config{
+		IssuerURL:    idp.URL,
+		ClientID:     "cementops",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	})
+}
+
+func TestAuthCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	idp, p := newProvider(t)
+	idp.SetClaims(map[string]any{"email": "op@example.com", "email_verified": true})
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" || q.Get("redirect_uri") != redirectURL {
+		t.Fatalf("auth URL query = %v", q)
+	}
+	if q.Get("code_challenge") != oidc.CodeChallenge("verifier-1") || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("PKCE params = %q %q", q.Get("code_challenge"), q.Get("code_challenge_method"))
+	}
+
+	cb, err := idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.Query().Get("state") != "state-1" {
+		t.Fatalf("callback state = %q", cb.Query().Get("state"))
+	}
+	claims, err := p.Exchange(ctx, cb.Query().Get("code"), "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("sub") != "user-1" || claims.String("email") != "op@example.com" || !claims.Bool("email_verified") {
+		t.Fatalf("claims = %v", claims)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		verifier string
+		nonce    string
+		want     string
+	}{
+		{name: "wrong PKCE verifier", verifier: "other-verifier", nonce: "nonce-1", want: "invalid_grant"},
+		{name: "wrong nonce", verifier: "verifier-1", nonce: "nonce-2", want: "nonce mismatch"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			idp, p := newProvider(t)
+			authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cb, err := idp.Authorize(authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.Exchange(ctx, cb.Query().Get("code"), tc.verifier, tc.nonce)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Exchange error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	idp, p := newProvider(t)
+	authURL, _ := p.AuthCodeURL(ctx, "s", "n", "v")
+	cb, err := idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(ctx, cb.Query().Get("code"), "v", "n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(ctx, cb.Query().Get("code"), "v", "n"); err == nil {
+		t.Fatal("second exchange of the same code succeeded")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	idp, p := newProvider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		token func(claims map[string]any) string
+		edit  func(claims map[string]any)
+		want  string
+	}{
+		{name: "valid", want: ""},
+		{
+			name:  "bad signature",
+			token: func(c map[string]any) string { return oidctest.SignWith(otherKey, idp.KeyID, c) },
+			want:  "invalid id_token signature",
+		},
+		{
+			name: "tampered payload",
+			token: func(c map[string]any) string {
+				parts := strings.Split(idp.Sign(c), ".")
+				c["sub"] = "someone-else"
+				forged := strings.Split(idp.Sign(c), ".")
+				return parts[0] + "." + forged[1] + "." + parts[2]
+			},
+			want: "invalid id_token signature",
+		},
+		{
+			name:  "unknown key id",
+			token: func(c map[string]any) string { return oidctest.SignWith(otherKey, "rotated", c) },
+			want:  "unknown signing key",
+		},
+		{name: "wrong issuer", edit: func(c map[string]any) { c["iss"] = "https://evil.example" }, want: "unexpected issuer"},
+		{name: "wrong audience", edit: func(c map[string]any) { c["aud"] = "another-client" }, want: "not issued for this client"},
+		{
+			name: "foreign authorized party",
+			edit: func(c map[string]any) {
+				c["aud"] = []string{"cementops", "another-client"}
+				c["azp"] = "another-client"
+			},
+			want: "authorized party mismatch",
+		},
+		{name: "expired", edit: func(c map[string]any) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, want: "expired"},
+		{name: "missing exp", edit: func(c map[string]any) { delete(c, "exp") }, want: "expired"},
+		{name: "not yet valid", edit: func(c map[string]any) { c["nbf"] = time.Now().Add(5 * time.Minute).Unix() }, want: "not yet valid"},
+		{name: "wrong nonce", edit: func(c map[string]any) { c["nonce"] = "replayed" }, want: "nonce mismatch"},
+		{name: "no subject", edit: func(c map[string]any) { delete(c, "sub") }, want: "no subject"},
+		{
+			name: "alg none",
+			token: func(c map[string]any) string {
+				return "eyJhbGciOiJub25lIn0." + strings.Split(idp.Sign(c), ".")[1] + "."
+			},
+			want: "unsupported id_token alg",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := idp.Claims("nonce-1")
+			if tc.edit != nil {
+				tc.edit(claims)
+			}
+			raw := ""
+			if tc.token != nil {
+				raw = tc.token(claims)
+			} else {
+				raw = idp.Sign(claims)
+			}
+			_, err := p.Verify(ctx, raw, "nonce-1")
+			switch {
+			case tc.want == "" && err != nil:
+				t.Fatalf("Verify: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Fatalf("Verify error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewServer(t, "cementops", "secret")
+	p := oidc.New(oidc.Config{IssuerURL: strings.Replace(idp.URL, "127.0.0.1", "localhost", 1), ClientID: "cementops"})
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("AuthCodeURL error = %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Package password enforces the password policy and hashes passwords. Every
+// password chosen by a person goes through Policy.Hash so the rules cannot be
+// skipped by one endpoint.
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxBytes is bcrypt's input limit; longer passwords would be truncated.
+const MaxBytes = 72
+
+//go:embed common.txt
+var commonList string
+
+var common = func() map[string]bool {
+	m := map[string]bool{}
+	sc := bufio.NewScanner(strings.NewReader(commonList))
+	for sc.Scan() {
+		if w := strings.TrimSpace(sc.Text()); w != "" {
+			m[strings.ToLower(w)] = true
+		}
+	}
+	return m
+}()
+
+// PolicyError is a password rejected by the policy. Its message is safe to
+// show to the user.
+type PolicyError struct{ msg string }
+
+func (e *PolicyError) Error() string { return e.msg }
+
+// Policy holds the password rules. When CheckBreached is set, candidates are
+// looked up in the Have I Been Pwned range API by k-anonymity (only the first
+// five hex characters of the SHA-1 leave the server). The lookup fails open:
+// if the service is unreachable the password is accepted and the error logged.
+type Policy struct {
+	MinLength     int
+	CheckBreached bool
+	// BreachAPI defaults to https://api.pwnedpasswords.com/range/.
+	BreachAPI  string
+	HTTPClient *http.Client
+}
+
+// Validate checks pw against the policy. userInputs are values the password
+// must not contain, such as the account's email and name.
+func (p Policy) Validate(ctx context.Context, pw string, userInputs ...string) error {
+	if utf8.RuneCountInString(pw) < p.MinLength {
+		return &PolicyError{fmt.Sprintf("password must be at least %d characters", p.MinLength)}
+	}
+	if len(pw) > MaxBytes {
+		return &PolicyError{fmt.Sprintf("password must be at most %d bytes", MaxBytes)}
+	}
+	lower := strings.ToLower(pw)
+	if common[lower] {
+		return &PolicyError{"password is too common"}
+	}
+	for _, in := range userInputs {
+		in = strings.ToLower(strings.TrimSpace(in))
+		if local, _, ok := strings.Cut(in, "@"); ok {
+			in = local
+		}
+		if len(in) >= 4 && strings.Contains(lower, in) {
+			return &PolicyError{"password must not contain your name or email"}
+		}
+	}
+	if p.CheckBreached {
+		breached, err := p.breached(ctx, pw)
+		if err != nil {
+			log.Printf("password: breach check: %v", err)
+		} else if breached {
+			return &PolicyError{"password has appeared in a data breach; choose a different one"}
+		}
+	}
+	return nil
+}
+
+// Hash validates pw and returns its bcrypt hash.
+func (p Policy) Hash(ctx context.Context, pw string, userInputs ...string) (string, error) {
+	if err := p.Validate(ctx, pw, userInputs...); err != nil {
+		return "", err
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// tempAlphabet leaves out characters that are easy to misread (0/O, 1/l/I).
+const tempAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Temporary returns a random password for administrator resets. It is at
+// least 16 characters, or MinLength when the policy asks for more, so it
+// always passes Validate.
+func (p Policy) Temporary() (string, error) {
+	n := min(max(16, p.MinLength), MaxBytes)
+	limit := big.NewInt(int64(len(tempAlphabet)))
+	b := make([]byte, n)
+	for i := range b {
+		k, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		b[i] = tempAlphabet[k.Int64()]
+	}
+	return string(b), nil
+}
+
+func (p Policy) breached(ctx context.Context, pw string) (bool, error) {
+	sum := sha1.Sum([]byte(pw))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	api := p.BreachAPI
+	if api == "" {
+		api = "https://api.pwnedpasswords.com/range/"
+	}
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// Padding hides the real number of matches from anyone watching the traffic.
+	req.Header.Set("Add-Padding", "true")
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("range API returned %d", res.StatusCode)
+	}
+	sc := bufio.NewScanner(res.Body)
+	for sc.Scan() {
+		// Lines are "SUFFIX:COUNT"; padding entries have a count of 0.
+		s, count, _ := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if s == suffix && count != "0" {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
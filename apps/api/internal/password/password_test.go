@@ -0,0 +1,135 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidate(t *testing.T) {
+	p := Policy{MinLength: 10}
+	cases := []struct {
+		pw     string
+		inputs []string
+		want   string
+	}{
+		{pw: "quiet-harbor-lantern", want: ""},
+		{pw: "short1!", want: "at least 10 characters"},
+		{pw: "ñandúñandú", want: ""}, // counted in characters, not bytes
+		{pw: strings.Repeat("a", MaxBytes+1), want: "at most 72 bytes"},
+		{pw: "1234567890", want: "too common"},
+		{pw: "Password123", want: "too common"},
+		{pw: "xx-alice.smith-2024", inputs: []string{"Alice.Smith@example.com"}, want: "name or email"},
+		{pw: "builder-Priya-9", inputs: []string{"a@b.c", "Priya"}, want: "name or email"},
+		{pw: "bob-is-not-here-2024", inputs: []string{"Bob"}, want: ""}, // inputs under four characters are ignored
+	}
+	for _, tc := range cases {
+		err := p.Validate(context.Background(), tc.pw, tc.inputs...)
+		var perr *PolicyError
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("Validate(%q) = %v", tc.pw, err)
+		case tc.want != "" && (!errors.As(err, &perr) || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("Validate(%q) = %v, want %q", tc.pw, err, tc.want)
+		}
+	}
+}
+
+// rangeAPI serves the k-anonymity range endpoint with the given hashes
+// marked as breached, and records the prefixes it was asked for.
+func rangeAPI(t *testing.T, breached ...string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var prefixes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+		prefixes = append(prefixes, prefix)
+		if r.Header.Get("Add-Padding") != "true" {
+			t.Errorf("request without Add-Padding")
+		}
+		fmt.Fprintln(w, "0000000000000000000000000000000000A:0")
+		for _, pw := range breached {
+			sum := sha1.Sum([]byte(pw))
+			digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+			if digest[:5] == prefix {
+				fmt.Fprintf(w, "%s:42\r\n", digest[5:])
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &prefixes
+}
+
+func TestValidateBreached(t *testing.T) {
+	srv, prefixes := rangeAPI(t, "correct horse battery")
+	p := Policy{MinLength: 10, CheckBreached: true, BreachAPI: srv.URL + "/range/"}
+
+	err := p.Validate(context.Background(), "correct horse battery")
+	if err == nil || !strings.Contains(err.Error(), "data breach") {
+		t.Fatalf("breached password: %v", err)
+	}
+	if err := p.Validate(context.Background(), "violet-tangent-otter"); err != nil {
+		t.Fatalf("clean password: %v", err)
+	}
+	for _, prefix := range *prefixes {
+		if len(prefix) != 5 {
+			t.Fatalf("sent %q, want only a 5-character hash prefix", prefix)
+		}
+	}
+}
+
+func TestValidateBreachCheckFailsOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	p := Policy{MinLength: 10, CheckBreached: true, BreachAPI: srv.URL + "/range/"}
+	if err := p.Validate(context.Background(), "violet-tangent-otter"); err != nil {
+		t.Fatalf("Validate with the range API down = %v", err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	p := Policy{MinLength: 10}
+	if _, err := p.Hash(context.Background(), "short"); err == nil {
+		t.Fatal("Hash accepted a password the policy rejects")
+	}
+	h, err := p.Hash(context.Background(), "violet-tangent-otter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(h), []byte("violet-tangent-otter")) != nil {
+		t.Fatal("hash does not match the password")
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	for _, minLength := range []int{0, 10, 16, 24, 200} {
+		p := Policy{MinLength: minLength}
+		pw, err := p.Temporary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := min(max(16, minLength), MaxBytes)
+		if len(pw) != want {
+			t.Errorf("MinLength %d: len = %d, want %d", minLength, len(pw), want)
+		}
+		if minLength <= MaxBytes {
+			if err := p.Validate(context.Background(), pw); err != nil {
+				t.Errorf("MinLength %d: temporary password fails the policy: %v", minLength, err)
+			}
+		}
+	}
+	a, _ := Policy{}.Temporary()
+	b, _ := Policy{}.Temporary()
+	if a == b {
+		t.Fatal("two temporary passwords are equal")
+	}
+}
@@ -0,0 +1,342 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// KeepRecentMovements is how many of the newest inventory_movements rows per
+// warehouse and cement type stay live regardless of age, so the inventory
+// view's recent-movement list never empties out.
+const KeepRecentMovements = 3
+
+// Policy says how many days rows stay in the live tables before they are
+// moved to the archive. Zero keeps a table's rows live forever.
+type Policy struct {
+	AuditLogDays          int `json:"auditLogDays"`
+	InventoryMovementDays int `json:"inventoryMovementDays"`
+	// BatchSize caps the rows moved per transaction.
+	BatchSize int `json:"batchSize"`
+}
+
+// Archiver moves audit_logs and inventory_movements rows past their retention
+// period into the monthly-partitioned *_archive tables.
+//
+// Audit rows are moved as a prefix in chain_seq order, so the archive
+// followed by the live table is always one unbroken hash chain. A row newer
+// than the cutoff therefore holds back everything after it in the chain, and
+// the newest row always stays live for the chain trigger to link to.
+type Archiver struct {
+	db       *pgxpool.Pool
+	policy   Policy
+	interval time.Duration
+}
+
+func NewArchiver(db *pgxpool.Pool, policy Policy, interval time.Duration) *Archiver {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 5000
+	}
+	return &Archiver{db: db, policy: policy, interval: interval}
+}
+
+// Policy returns the retention policy the archiver applies.
+func (a *Archiver) Policy() Policy { return a.policy }
+
+// Run archives immediately and then on every tick until ctx is cancelled.
+func (a *Archiver) Run(ctx context.Context) {
+	if a.interval <= 0 || (a.policy.AuditLogDays <= 0 && a.policy.InventoryMovementDays <= 0) {
+		log.Printf("retention: archiver disabled")
+		return
+	}
+	t := time.NewTicker(a.interval)
+	defer t.Stop()
+	for {
+		if res, err := a.ArchiveOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("retention: archive: %v", err)
+		} else if res.AuditLogs > 0 || res.InventoryMovements > 0 {
+			log.Printf("retention: archived %d audit log and %d inventory movement rows", res.AuditLogs, res.InventoryMovements)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Result counts the rows moved by one ArchiveOnce call.
+type Result struct {
+	AuditLogs          int64 `json:"auditLogs"`
+	InventoryMovements int64 `json:"inventoryMovements"`
+}
+
+// ArchiveOnce moves every row currently past its retention period, one batch
+// per transaction.
+func (a *Archiver) ArchiveOnce(ctx context.Context) (Result, error) {
+	var res Result
+	now := time.Now()
+	if cutoff := cutoffFor(now, a.policy.AuditLogDays); cutoff != nil {
+		for {
+			n, err := a.archiveAuditBatch(ctx, *cutoff)
+			res.AuditLogs += n
+			if err != nil {
+				return res, fmt.Errorf("audit_logs: %w", err)
+			}
+			if n < int64(a.policy.BatchSize) {
+				break
+			}
+		}
+	}
+	if cutoff := cutoffFor(now, a.policy.InventoryMovementDays); cutoff != nil {
+		for {
+			n, err := a.archiveMovementBatch(ctx, *cutoff)
+			res.InventoryMovements += n
+			if err != nil {
+				return res, fmt.Errorf("inventory_movements: %w", err)
+			}
+			if n < int64(a.policy.BatchSize) {
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+func cutoffFor(now time.Time, days int) *time.Time {
+	if days <= 0 {
+		return nil
+	}
+	t := now.AddDate(0, 0, -days)
+	return &t
+}
+
+// auditArchiveBoundarySQL yields the first chain_seq that must stay live for
+// cutoff $1: the oldest row at or after the cutoff, and never past the newest
+// row.
+const auditArchiveBoundarySQL = `
+  SELECT LEAST(
+    COALESCE((SELECT MIN(chain_seq) FROM audit_logs WHERE ts >= $1), (SELECT MAX(chain_seq) FROM audit_logs)),
+    (SELECT MAX(chain_seq) FROM audit_logs)
+  )`
+
+func (a *Archiver) archiveAuditBatch(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var boundary, first *int64
+	if err := tx.QueryRow(ctx, auditArchiveBoundarySQL, cutoff).Scan(&boundary); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow(ctx, `SELECT MIN(chain_seq) FROM audit_logs`).Scan(&first); err != nil {
+		return 0, err
+	}
+	if boundary == nil || first == nil || *first >= *boundary {
+		return 0, nil
+	}
+	upTo := min(*boundary, *first+int64(a.policy.BatchSize))
+
+	if err := ensurePartitions(ctx, tx, "audit_logs_archive", `SELECT ts FROM audit_logs WHERE chain_seq < $1`, upTo); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO audit_logs_archive (id, ts, actor_user_id, action, entity_type, entity_id, metadata, ip, chain_seq, prev_hash, hash)
+    SELECT id, ts, actor_user_id, action, entity_type, entity_id, metadata, ip, chain_seq, prev_hash, hash
+    FROM audit_logs
+    WHERE chain_seq < $1
+  `, upTo); err != nil {
+		return 0, err
+	}
+	// The append-only trigger lets these through because each now has an
+	// identical archived copy.
+	tag, err := tx.Exec(ctx, `DELETE FROM audit_logs WHERE chain_seq < $1`, upTo)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// movementArchiveCandidatesSQL selects inventory_movements older than $1,
+// except the newest KeepRecentMovements per warehouse and cement type ($2).
+const movementArchiveCandidatesSQL = `
+  SELECT id, ts FROM (
+    SELECT id, ts, ROW_NUMBER() OVER (PARTITION BY warehouse_id, cement_type ORDER BY ts DESC, id DESC) AS rn
+    FROM inventory_movements
+  ) m
+  WHERE ts < $1 AND rn > $2`
+
+func (a *Archiver) archiveMovementBatch(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx, movementArchiveCandidatesSQL+` ORDER BY id LIMIT $3`, cutoff, KeepRecentMovements, a.policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		var ts time.Time
+		if err := rows.Scan(&id, &ts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err := ensurePartitions(ctx, tx, "inventory_movements_archive", `SELECT ts FROM inventory_movements WHERE id = ANY($1)`, ids); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+    INSERT INTO inventory_movements_archive (id, ts, actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata)
+    SELECT id, ts, actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata
+    FROM inventory_movements
+    WHERE id = ANY($1)
+  `, ids); err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM inventory_movements WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// ensurePartitions creates the archive partitions for every month touched by
+// the ts values that tsQuery (with arg) returns.
+func ensurePartitions(ctx context.Context, tx pgx.Tx, parent, tsQuery string, arg any) error {
+	rows, err := tx.Query(ctx, `
+    SELECT DISTINCT date_trunc('month', ts AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' FROM (`+tsQuery+`) t
+  `, arg)
+	if err != nil {
+		return err
+	}
+	months := []time.Time{}
+	for rows.Next() {
+		var m time.Time
+		if err := rows.Scan(&m); err != nil {
+			rows.Close()
+			return err
+		}
+		months = append(months, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range months {
+		if _, err := tx.Exec(ctx, `SELECT ensure_archive_partition($1, $2)`, parent, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TableReport describes what the next run would archive from one table.
+type TableReport struct {
+	RetentionDays int        `json:"retentionDays"`
+	Cutoff        *time.Time `json:"cutoff"`
+	Rows          int64      `json:"rows"`
+	Oldest        *time.Time `json:"oldest"`
+	Newest        *time.Time `json:"newest"`
+	// HeldBack counts rows past the cutoff that stay live anyway: audit rows
+	// after a newer row in the chain, or the most recent movements per stock
+	// line.
+	HeldBack int64 `json:"heldBack"`
+}
+
+// Report is a dry run of ArchiveOnce.
+type Report struct {
+	AuditLogs          TableReport `json:"auditLogs"`
+	InventoryMovements TableReport `json:"inventoryMovements"`
+}
+
+// Plan reports what ArchiveOnce would move right now without moving anything.
+func (a *Archiver) Plan(ctx context.Context) (Report, error) {
+	now := time.Now()
+	rep := Report{
+		AuditLogs:          TableReport{RetentionDays: a.policy.AuditLogDays, Cutoff: cutoffFor(now, a.policy.AuditLogDays)},
+		InventoryMovements: TableReport{RetentionDays: a.policy.InventoryMovementDays, Cutoff: cutoffFor(now, a.policy.InventoryMovementDays)},
+	}
+	if c := rep.AuditLogs.Cutoff; c != nil {
+		if err := a.db.QueryRow(ctx, `
+      WITH b AS (`+auditArchiveBoundarySQL+` AS seq)
+      SELECT
+        COUNT(*) FILTER (WHERE l.chain_seq < b.seq),
+        MIN(l.ts) FILTER (WHERE l.chain_seq < b.seq),
+        MAX(l.ts) FILTER (WHERE l.chain_seq < b.seq),
+        COUNT(*) FILTER (WHERE l.chain_seq >= b.seq AND l.ts < $1)
+      FROM audit_logs l, b
+    `, *c).Scan(&rep.AuditLogs.Rows, &rep.AuditLogs.Oldest, &rep.AuditLogs.Newest, &rep.AuditLogs.HeldBack); err != nil {
+			return rep, err
+		}
+	}
+	if c := rep.InventoryMovements.Cutoff; c != nil {
+		if err := a.db.QueryRow(ctx, `
+      SELECT
+        COUNT(*) FILTER (WHERE rn > $2),
+        MIN(ts) FILTER (WHERE rn > $2),
+        MAX(ts) FILTER (WHERE rn > $2),
+        COUNT(*) FILTER (WHERE rn <= $2)
+      FROM (
+        SELECT ts, ROW_NUMBER() OVER (PARTITION BY warehouse_id, cement_type ORDER BY ts DESC, id DESC) AS rn
+        FROM inventory_movements
+      ) m
+      WHERE ts < $1
+    `, *c, KeepRecentMovements).Scan(&rep.InventoryMovements.Rows, &rep.InventoryMovements.Oldest, &rep.InventoryMovements.Newest, &rep.InventoryMovements.HeldBack); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// Partition summarises one monthly archive partition.
+type Partition struct {
+	Table  string     `json:"table"`
+	Name   string     `json:"name"`
+	Rows   int64      `json:"rows"`
+	Oldest *time.Time `json:"oldest"`
+	Newest *time.Time `json:"newest"`
+}
+
+// Archived lists the archive partitions that hold rows, oldest first.
+func (a *Archiver) Archived(ctx context.Context) ([]Partition, error) {
+	rows, err := a.db.Query(ctx, `
+    SELECT 'audit_logs', tableoid::regclass::text, COUNT(*), MIN(ts), MAX(ts)
+    FROM audit_logs_archive GROUP BY tableoid
+    UNION ALL
+    SELECT 'inventory_movements', tableoid::regclass::text, COUNT(*), MIN(ts), MAX(ts)
+    FROM inventory_movements_archive GROUP BY tableoid
+    ORDER BY 1, 4
+  `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Partition{}
+	for rows.Next() {
+		var p Partition
+		if err := rows.Scan(&p.Table, &p.Name, &p.Rows, &p.Oldest, &p.Newest); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,62 @@
+package secretbox
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	box, err := New("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := box.Open(sealed); err != nil || got != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+	// A fresh nonce per Seal means equal plaintexts do not seal alike.
+	again, err := box.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Fatal("sealing twice gave the same ciphertext")
+	}
+	if got, err := box.Open(again); err != nil || got != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Open second seal = %q, %v", got, err)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	box, err := New("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := New("another-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := other.Open(sealed); err == nil {
+		t.Fatalf("Open with the wrong key = %q", got)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(sealed)
+	raw[len(raw)-1] ^= 1
+	if got, err := box.Open(base64.StdEncoding.EncodeToString(raw)); err == nil {
+		t.Fatalf("Open of tampered ciphertext = %q", got)
+	}
+	if _, err := box.Open(base64.StdEncoding.EncodeToString(raw[:4])); err == nil {
+		t.Fatal("Open accepted a short ciphertext")
+	}
+	if _, err := box.Open("not base64!"); err == nil {
+		t.Fatal("Open accepted invalid base64")
+	}
+}
@@ -0,0 +1,67 @@
+package sessions
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Sweeper deletes sessions that are past their absolute expiry or have been
+// idle longer than the idle timeout. authMiddleware rejects such sessions on
+// its own; the sweeper only keeps the table from growing.
+type Sweeper struct {
+	db          *pgxpool.Pool
+	interval    time.Duration
+	idleTimeout time.Duration
+}
+
+func NewSweeper(db *pgxpool.Pool, interval, idleTimeout time.Duration) *Sweeper {
+	return &Sweeper{db: db, interval: interval, idleTimeout: idleTimeout}
+}
+
+// Run sweeps immediately and then on every tick until ctx is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		log.Printf("sessions: sweeper disabled")
+		return
+	}
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		if n, err := s.SweepOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("sessions: sweep: %v", err)
+		} else if n > 0 {
+			log.Printf("sessions: swept %d expired sessions", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// SweepOnce deletes expired sessions, login challenges, SSO login states and
+// password reset tokens, and returns how many sessions were removed.
+func (s *Sweeper) SweepOnce(ctx context.Context) (int64, error) {
+	if _, err := s.db.Exec(ctx, `DELETE FROM login_challenges WHERE expires_at <= now()`); err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec(ctx, `DELETE FROM oidc_login_states WHERE expires_at <= now()`); err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec(ctx, `DELETE FROM password_reset_tokens WHERE expires_at <= now()`); err != nil {
+		return 0, err
+	}
+	tag, err := s.db.Exec(ctx, `
+    DELETE FROM sessions
+    WHERE expires_at <= now()
+       OR ($1::bigint > 0 AND last_seen_at <= now() - ($1::bigint * INTERVAL '1 second'))
+  `, int64(s.idleTimeout/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,260 @@
+// Package sheet reads a CSV file or the first worksheet of an XLSX workbook
+// as rows of strings. It covers what spreadsheet exports of tabular data use:
+// shared and inline strings, numbers and booleans. Formatting is ignored, so
+// dates in XLSX come back as Excel serial numbers (see ExcelDate).
+package sheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrFormat is returned for files that are neither CSV nor XLSX.
+var ErrFormat = errors.New("file must be .csv or .xlsx")
+
+// ErrTooLarge is returned for sheets with more rows than the caller allows
+// or more than MaxColumns columns.
+var ErrTooLarge = errors.New("sheet is too large")
+
+// maxPartBytes caps how much of any one XLSX part is decompressed.
+const maxPartBytes = 64 << 20
+
+// MaxColumns is the widest sheet Read accepts (the old .xls limit). Rows are
+// padded up to the referenced column, so the bound keeps a stray "XFD1"
+// from allocating thousands of empty cells per row.
+const MaxColumns = 256
+
+// Read parses data as XLSX when it is a zip archive or filename ends in
+// .xlsx, and as CSV otherwise. Rows keep their position in the sheet, so
+// rows[i] is spreadsheet line i+1; empty lines come back as empty rows.
+// Content past line maxRows or column MaxColumns fails with ErrTooLarge
+// before anything is padded.
+func Read(data []byte, filename string, maxRows int) ([][]string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || ext == ".xlsx":
+		return readXLSX(data, maxRows)
+	case ext == ".csv" || ext == ".txt" || ext == "":
+		return readCSV(data, maxRows)
+	default:
+		return nil, ErrFormat
+	}
+}
+
+// readCSV accepts comma or semicolon separated files (the latter is what
+// Excel writes in locales with a decimal comma) with an optional UTF-8 BOM.
+func readCSV(data []byte, maxRows int) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(first, []byte(";")) > bytes.Count(first, []byte(",")) {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows := [][]string{}
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		if line > maxRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrTooLarge, maxRows)
+		}
+		if len(rec) > MaxColumns {
+			return nil, fmt.Errorf("%w: line %d has more than %d columns", ErrTooLarge, line, MaxColumns)
+		}
+		for len(rows) < line-1 {
+			rows = append(rows, nil)
+		}
+		rows = append(rows, rec)
+	}
+}
+
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.R {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRels struct {
+	Rels []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSST struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Ref string   `xml:"r,attr"`
+			T   string   `xml:"t,attr"`
+			V   string   `xml:"v"`
+			IS  xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readXLSX(data []byte, maxRows int) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	decode := func(name string, v any) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("xlsx: missing %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("xlsx: %w", err)
+		}
+		defer rc.Close()
+		if err := xml.NewDecoder(io.LimitReader(rc, maxPartBytes)).Decode(v); err != nil {
+			return fmt.Errorf("xlsx: %s: %w", name, err)
+		}
+		return nil
+	}
+
+	sheetPath := "xl/worksheets/sheet1.xml"
+	var wb xlsxWorkbook
+	var rels xlsxRels
+	if decode("xl/workbook.xml", &wb) == nil && decode("xl/_rels/workbook.xml.rels", &rels) == nil && len(wb.Sheets) > 0 {
+		for _, rel := range rels.Rels {
+			if rel.ID != wb.Sheets[0].RID {
+				continue
+			}
+			if strings.HasPrefix(rel.Target, "/") {
+				sheetPath = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				sheetPath = path.Join("xl", rel.Target)
+			}
+		}
+	}
+
+	var sst xlsxSST
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &sst); err != nil {
+			return nil, err
+		}
+	}
+	var ws xlsxWorksheet
+	if err := decode(sheetPath, &ws); err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{}
+	for _, row := range ws.Rows {
+		n := row.R
+		if n <= len(rows) {
+			n = len(rows) + 1
+		}
+		if n > maxRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrTooLarge, maxRows)
+		}
+		for len(rows) < n-1 {
+			rows = append(rows, nil)
+		}
+		cells := []string{}
+		for _, c := range row.Cells {
+			col := len(cells)
+			if c.Ref != "" {
+				col = columnIndex(c.Ref)
+			}
+			if col < 0 {
+				return nil, fmt.Errorf("xlsx: bad cell reference %q", c.Ref)
+			}
+			if col >= MaxColumns {
+				return nil, fmt.Errorf("%w: more than %d columns", ErrTooLarge, MaxColumns)
+			}
+			for len(cells) < col {
+				cells = append(cells, "")
+			}
+			var v string
+			switch c.T {
+			case "s":
+				i, err := strconv.Atoi(c.V)
+				if err != nil || i < 0 || i >= len(sst.Items) {
+					return nil, fmt.Errorf("xlsx: cell %s: bad shared string %q", c.Ref, c.V)
+				}
+				v = sst.Items[i].String()
+			case "inlineStr":
+				v = c.IS.String()
+			case "b":
+				v = map[bool]string{true: "TRUE", false: "FALSE"}[c.V == "1"]
+			default:
+				v = c.V
+			}
+			if col < len(cells) {
+				cells[col] = v
+			} else {
+				cells = append(cells, v)
+			}
+		}
+		rows = append(rows, cells)
+	}
+	return rows, nil
+}
+
+// columnIndex turns the letters of a cell reference ("C7") into a zero-based
+// column index (2). It returns -1 when there are no letters and stops
+// counting at MaxColumns, so long references cannot overflow.
+func columnIndex(ref string) int {
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A'+1)
+		if n > MaxColumns {
+			return MaxColumns
+		}
+	}
+	return n - 1
+}
+
+// ExcelDate converts an Excel serial day number (1900 date system) to a date.
+func ExcelDate(serial float64) time.Time {
+	return time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(serial))
+}
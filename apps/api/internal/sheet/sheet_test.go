@@ -0,0 +1,194 @@
+package sheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// xlsx builds a minimal workbook from part name to XML body.
+func xlsx(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range parts {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func worksheet(rows string) string {
+	return `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + rows + `</sheetData></worksheet>`
+}
+
+func TestReadCSV(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		want [][]string
+	}{
+		{name: "comma", data: "name,lat\nDepot A,1.5\n", want: [][]string{{"name", "lat"}, {"Depot A", "1.5"}}},
+		{name: "semicolon with BOM", data: "\xef\xbb\xbfname;lat\nDepot A;1,5\n", want: [][]string{{"name", "lat"}, {"Depot A", "1,5"}}},
+		{name: "blank lines keep positions", data: "name\n\n\nDepot A\n", want: [][]string{{"name"}, nil, nil, {"Depot A"}}},
+		{name: "ragged rows", data: "a,b,c\n1\n1,2,3,4\n", want: [][]string{{"a", "b", "c"}, {"1"}, {"1", "2", "3", "4"}}},
+		{name: "quoted newline", data: "name,note\n\"Depot A\",\"two\nlines\"\n", want: [][]string{{"name", "note"}, {"Depot A", "two\nlines"}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Read([]byte(tc.data), "plants.csv", 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadCSVRejects(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		tooBig  bool
+		wantErr string
+	}{
+		{name: "unterminated quote", data: "name\n\"Depot A\n", wantErr: "csv:"},
+		{name: "bare quote", data: "name\nDe\"pot\n", wantErr: "csv:"},
+		{name: "too many rows", data: strings.Repeat("x\n", 11), tooBig: true},
+		{name: "row far below the limit", data: "name" + strings.Repeat("\n", 1<<20) + "x\n", tooBig: true},
+		{name: "too many columns", data: strings.Repeat("x,", MaxColumns) + "x\n", tooBig: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Read([]byte(tc.data), "plants.csv", 10)
+			switch {
+			case err == nil:
+				t.Fatal("Read succeeded")
+			case tc.tooBig != errors.Is(err, ErrTooLarge):
+				t.Fatalf("err = %v, ErrTooLarge = %v", err, errors.Is(err, ErrTooLarge))
+			case tc.wantErr != "" && !strings.Contains(err.Error(), tc.wantErr):
+				t.Fatalf("err = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+	if _, err := Read([]byte(strings.Repeat("x\n", 10)), "plants.csv", 10); err != nil {
+		t.Fatalf("exactly maxRows lines: %v", err)
+	}
+}
+
+func TestReadXLSX(t *testing.T) {
+	data := xlsx(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <sheets><sheet name="Plants" sheetId="1" r:id="rId7"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId7" Target="worksheets/data.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <si><t>name</t></si><si><t>capacityTons</t></si><si><r><t>Depot </t></r><r><t>A</t></r></si></sst>`,
+		"xl/worksheets/data.xml": worksheet(`
+  <row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="D1" t="inlineStr"><is><t>active</t></is></c></row>
+  <row r="3"><c r="A3" t="s"><v>2</v></c><c r="B3"><v>1200.5</v></c><c r="D3" t="b"><v>1</v></c></row>
+  <row><c><v>x</v></c><c><v>y</v></c></row>`),
+	})
+	got, err := Read(data, "upload.bin", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"name", "capacityTons", "", "active"},
+		nil,
+		{"Depot A", "1200.5", "", "TRUE"},
+		{"x", "y"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadXLSXRejects(t *testing.T) {
+	cases := []struct {
+		name    string
+		rows    string
+		tooBig  bool
+		wantErr string
+	}{
+		{name: "row index past the limit", rows: `<row r="1048576"><c r="A1048576"><v>1</v></c></row>`, tooBig: true},
+		{name: "last Excel column", rows: `<row r="1"><c r="XFD1"><v>1</v></c></row>`, tooBig: true},
+		{name: "overflowing column letters", rows: `<row r="1"><c r="` + strings.Repeat("Z", 40) + `1"><v>1</v></c></row>`, tooBig: true},
+		{name: "many unreferenced cells", rows: `<row r="1">` + strings.Repeat(`<c><v>1</v></c>`, MaxColumns+1) + `</row>`, tooBig: true},
+		{name: "reference without letters", rows: `<row r="1"><c r="7"><v>1</v></c></row>`, wantErr: "bad cell reference"},
+		{name: "bad shared string", rows: `<row r="1"><c r="A1" t="s"><v>3</v></c></row>`, wantErr: "bad shared string"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := xlsx(t, map[string]string{"xl/worksheets/sheet1.xml": worksheet(tc.rows)})
+			_, err := Read(data, "plants.xlsx", 10)
+			switch {
+			case err == nil:
+				t.Fatal("Read succeeded")
+			case tc.tooBig != errors.Is(err, ErrTooLarge):
+				t.Fatalf("err = %v, ErrTooLarge = %v", err, errors.Is(err, ErrTooLarge))
+			case tc.wantErr != "" && !strings.Contains(err.Error(), tc.wantErr):
+				t.Fatalf("err = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadXLSXOutOfOrderRows(t *testing.T) {
+	// Row numbers that go backwards or below 1 become the next row.
+	data := xlsx(t, map[string]string{"xl/worksheets/sheet1.xml": worksheet(
+		`<row r="2"><c r="A2"><v>a</v></c></row><row r="1"><c r="A1"><v>b</v></c></row><row r="-5"><c r="A1"><v>c</v></c></row>`)})
+	got, err := Read(data, "plants.xlsx", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{nil, {"a"}, {"b"}, {"c"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadMalformedFiles(t *testing.T) {
+	if _, err := Read([]byte("%PDF-1.7"), "plants.pdf", 10); !errors.Is(err, ErrFormat) {
+		t.Fatalf("pdf: %v", err)
+	}
+	if _, err := Read([]byte("not a zip"), "plants.xlsx", 10); err == nil || !strings.Contains(err.Error(), "xlsx:") {
+		t.Fatalf("non-zip xlsx: %v", err)
+	}
+	noSheet := xlsx(t, map[string]string{"xl/workbook.xml": `<workbook/>`})
+	if _, err := Read(noSheet, "plants.xlsx", 10); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("workbook without sheet: %v", err)
+	}
+	badXML := xlsx(t, map[string]string{"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row>`})
+	if _, err := Read(badXML, "plants.xlsx", 10); err == nil {
+		t.Fatal("truncated worksheet XML was accepted")
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	cases := map[string]int{"A1": 0, "C7": 2, "Z9": 25, "AA1": 26, "IV1": 255, "IW1": MaxColumns, "XFD1": MaxColumns, "7": -1}
+	for ref, want := range cases {
+		if got := columnIndex(ref); got != want {
+			t.Errorf("columnIndex(%q) = %d, want %d", ref, got, want)
+		}
+	}
+}
+
+func TestExcelDate(t *testing.T) {
+	if got := ExcelDate(45658); !got.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("ExcelDate(45658) = %v", got)
+	}
+}
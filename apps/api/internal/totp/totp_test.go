@@ -0,0 +1,76 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 appendix B, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 test vectors from RFC 6238 appendix B,
+// truncated to the last six of their eight digits.
+func TestCodeRFC6238(t *testing.T) {
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tc := range cases {
+		got, err := Code(rfcSecret, Step(time.Unix(tc.unix, 0)))
+		if err != nil || got != tc.want {
+			t.Errorf("Code at %d = %q, %v; want %q", tc.unix, got, err, tc.want)
+		}
+	}
+	// Secrets are accepted the way users paste them.
+	if got, err := Code(" "+strings.ToLower(rfcSecret)+" ", 1); err != nil || got != "287082" {
+		t.Errorf("Code with a lower-case secret = %q, %v", got, err)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(s int64) string {
+		t.Helper()
+		c, err := Code(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for d := int64(-Skew); d <= Skew; d++ {
+		if got, ok := Validate(rfcSecret, code(step+d), now); !ok || got != step+d {
+			t.Errorf("code from step %+d = %d, %v; want %d, true", d, got, ok, step+d)
+		}
+	}
+	for _, d := range []int64{-Skew - 1, Skew + 1} {
+		if _, ok := Validate(rfcSecret, code(step+d), now); ok {
+			t.Errorf("code from step %+d accepted outside the skew window", d)
+		}
+	}
+
+	c := code(step)
+	if _, ok := Validate(rfcSecret, " "+c[:3]+" "+c[3:]+" ", now); !ok {
+		t.Error("code with spaces rejected")
+	}
+	for _, bad := range []string{"", c[:5], c + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("Validate(%q) accepted", bad)
+		}
+	}
+	if _, ok := Validate("not base32!", c, now); ok {
+		t.Error("Validate accepted a code for an invalid secret")
+	}
+}
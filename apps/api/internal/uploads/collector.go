@@ -0,0 +1,235 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cementops/api/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultGrace is how long an upload may stay unlinked when no grace period
+// is configured. It covers a user filling in the issue form slowly.
+const DefaultGrace = 24 * time.Hour
+
+const batchSize = 500
+
+// Collector removes uploads nobody kept: attachments still not linked to an
+// issue, shipment or order once the grace period has passed, and legacy
+// issue-* photos in the legacy upload directory that no issue's
+// metadata.evidenceUrls mentions.
+//
+// Attachment rows are deleted before their files, so a failed file delete
+// leaves a stray object behind rather than a row pointing at nothing.
+type Collector struct {
+	db        *pgxpool.Pool
+	store     storage.Store
+	legacyDir string
+	grace     time.Duration
+	interval  time.Duration
+}
+
+func NewCollector(db *pgxpool.Pool, store storage.Store, legacyDir string, grace, interval time.Duration) *Collector {
+	if grace <= 0 {
+		grace = DefaultGrace
+	}
+	return &Collector{db: db, store: store, legacyDir: legacyDir, grace: grace, interval: interval}
+}
+
+// Grace returns how long uploads may stay unlinked.
+func (c *Collector) Grace() time.Duration { return c.grace }
+
+// Interval returns how often Run collects; zero means never.
+func (c *Collector) Interval() time.Duration { return c.interval }
+
+// Run collects immediately and then on every tick until ctx is cancelled.
+func (c *Collector) Run(ctx context.Context) {
+	if c.interval <= 0 {
+		log.Printf("uploads: collector disabled")
+		return
+	}
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		if res, err := c.CollectOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("uploads: collect: %v", err)
+		} else if res.Attachments > 0 || res.LegacyFiles > 0 {
+			log.Printf("uploads: removed %d orphaned attachments and %d legacy files", res.Attachments, res.LegacyFiles)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Result counts what one CollectOnce call removed, or what Plan would remove.
+// Byte counts cover the original files, not their thumbnails.
+type Result struct {
+	Attachments int64 `json:"attachments"`
+	Bytes       int64 `json:"bytes"`
+	LegacyFiles int64 `json:"legacyFiles"`
+	LegacyBytes int64 `json:"legacyBytes"`
+}
+
+// CollectOnce removes every upload currently past the grace period.
+func (c *Collector) CollectOnce(ctx context.Context) (Result, error) {
+	var res Result
+	for {
+		n, bytes, err := c.collectBatch(ctx)
+		res.Attachments += n
+		res.Bytes += bytes
+		if err != nil {
+			return res, err
+		}
+		if n < batchSize {
+			break
+		}
+	}
+	files, err := c.orphanedLegacyFiles(ctx)
+	if err != nil {
+		return res, err
+	}
+	for _, f := range files {
+		if err := os.Remove(filepath.Join(c.legacyDir, f.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("uploads: remove %s: %v", f.Name(), err)
+			continue
+		}
+		res.LegacyFiles++
+		res.LegacyBytes += f.Size()
+	}
+	return res, nil
+}
+
+func (c *Collector) collectBatch(ctx context.Context) (int64, int64, error) {
+	rows, err := c.db.Query(ctx, `
+    DELETE FROM attachments
+    WHERE id IN (
+      SELECT id FROM attachments
+      WHERE num_nonnulls(issue_id, shipment_id, order_request_id) = 0
+        AND created_at < now() - ($1::bigint * INTERVAL '1 second')
+      ORDER BY id
+      LIMIT $2
+      FOR UPDATE SKIP LOCKED
+    )
+    RETURNING storage_key, thumbnail_key, size_bytes
+  `, int64(c.grace/time.Second), batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	var keys []string
+	var n, total int64
+	for rows.Next() {
+		var key string
+		var thumb *string
+		var size int64
+		if err := rows.Scan(&key, &thumb, &size); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		keys = append(keys, key)
+		if thumb != nil {
+			keys = append(keys, *thumb)
+		}
+		n++
+		total += size
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	for _, k := range keys {
+		if err := c.store.Delete(ctx, k); err != nil {
+			log.Printf("uploads: delete %s: %v", k, err)
+		}
+	}
+	return n, total, nil
+}
+
+// Plan reports what CollectOnce would remove right now.
+func (c *Collector) Plan(ctx context.Context) (Result, error) {
+	var res Result
+	if err := c.db.QueryRow(ctx, `
+    SELECT COUNT(*), COALESCE(SUM(size_bytes),0)::bigint
+    FROM attachments
+    WHERE num_nonnulls(issue_id, shipment_id, order_request_id) = 0
+      AND created_at < now() - ($1::bigint * INTERVAL '1 second')
+  `, int64(c.grace/time.Second)).Scan(&res.Attachments, &res.Bytes); err != nil {
+		return res, err
+	}
+	files, err := c.orphanedLegacyFiles(ctx)
+	if err != nil {
+		return res, err
+	}
+	for _, f := range files {
+		res.LegacyFiles++
+		res.LegacyBytes += f.Size()
+	}
+	return res, nil
+}
+
+// orphanedLegacyFiles lists issue-* files in the legacy directory older than
+// the grace period that no issue references.
+func (c *Collector) orphanedLegacyFiles(ctx context.Context) ([]os.FileInfo, error) {
+	if c.legacyDir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(c.legacyDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-c.grace)
+	var candidates []os.FileInfo
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), "issue-") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		candidates = append(candidates, info)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	rows, err := c.db.Query(ctx, `
+    SELECT DISTINCT url
+    FROM ops_issues,
+         jsonb_array_elements_text(CASE WHEN jsonb_typeof(metadata->'evidenceUrls') = 'array'
+                                        THEN metadata->'evidenceUrls' ELSE '[]'::jsonb END) AS url
+  `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	referenced := map[string]bool{}
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		referenced[u] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var orphans []os.FileInfo
+	for _, f := range candidates {
+		if !referenced["/uploads/"+f.Name()] {
+			orphans = append(orphans, f)
+		}
+	}
+	return orphans, nil
+}
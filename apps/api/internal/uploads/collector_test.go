@@ -0,0 +1,177 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"cementops/api/internal/db"
+	"cementops/api/internal/storage"
+)
+
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	pool, err := db.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if err := db.Migrate(url, filepath.Join("..", "..", "..", "..", "db", "migrations")); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := db.Seed(ctx, pool); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	return pool
+}
+
+func TestOrphanedLegacyFilesWithoutCandidates(t *testing.T) {
+	// Nothing to match against means the database is never queried.
+	ctx := context.Background()
+	dir := t.TempDir()
+	fresh := filepath.Join(dir, "issue-fresh.jpg")
+	if err := os.WriteFile(fresh, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.WriteFile(filepath.Join(dir, "photo-old.jpg"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "photo-old.jpg"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	for _, legacyDir := range []string{"", filepath.Join(dir, "missing"), dir} {
+		files, err := NewCollector(nil, nil, legacyDir, time.Hour, 0).orphanedLegacyFiles(ctx)
+		if err != nil || len(files) != 0 {
+			t.Errorf("orphanedLegacyFiles(%q) = %v, %v", legacyDir, files, err)
+		}
+	}
+}
+
+func TestCollectOnce(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	store := &storage.Local{Dir: t.TempDir()}
+	legacyDir := t.TempDir()
+	c := NewCollector(pool, store, legacyDir, time.Hour, 0)
+	tag := time.Now().Format("150405.000000")
+	old := time.Now().Add(-2 * time.Hour)
+
+	var issueID int64
+	if err := pool.QueryRow(ctx, `
+    INSERT INTO ops_issues (issue_type, title, metadata)
+    VALUES ('TEST', 'Collector test', jsonb_build_object('evidenceUrls', jsonb_build_array('/uploads/issue-' || $1 || '-kept.jpg')))
+    RETURNING id
+  `, tag).Scan(&issueID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_, _ = pool.Exec(ctx, `DELETE FROM attachments WHERE storage_key LIKE 'collect-' || $1 || '-%'`, tag)
+		_, _ = pool.Exec(ctx, `DELETE FROM ops_issues WHERE id=$1`, issueID)
+	})
+
+	// attach stores an upload and its thumbnail and records it as created at.
+	attach := func(name string, created time.Time, issue *int64) string {
+		t.Helper()
+		key, thumb := "collect-"+tag+"-"+name+".jpg", "collect-"+tag+"-"+name+"-thumb.jpg"
+		for _, k := range []string{key, thumb} {
+			if err := store.Put(ctx, k, []byte(name), "image/jpeg"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := pool.Exec(ctx, `
+      INSERT INTO attachments (storage_key, thumbnail_key, content_type, size_bytes, sha256, issue_id, linked_at, created_at)
+      VALUES ($1,$2,'image/jpeg',$3,'test',$4,CASE WHEN $4::bigint IS NULL THEN NULL ELSE now() END,$5)
+    `, key, thumb, len(name), issue, created); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	expired := attach("expired", old, nil)
+	fresh := attach("fresh", time.Now(), nil)
+	linked := attach("linked", old, &issueID)
+
+	// legacy writes an issue-* photo into the legacy directory.
+	legacy := func(name string, mod time.Time) string {
+		t.Helper()
+		name = "issue-" + tag + "-" + name + ".jpg"
+		p := filepath.Join(legacyDir, name)
+		if err := os.WriteFile(p, []byte("legacy"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	kept := legacy("kept", old)
+	orphan := legacy("orphan", old)
+	recent := legacy("recent", time.Now())
+
+	plan, err := c.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Attachments < 1 || plan.LegacyFiles != 1 || plan.LegacyBytes != int64(len("legacy")) {
+		t.Fatalf("Plan = %+v, want the expired attachment and one legacy file", plan)
+	}
+	res, err := c.CollectOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != plan {
+		t.Fatalf("CollectOnce = %+v, Plan = %+v", res, plan)
+	}
+
+	exists := func(key string) bool {
+		t.Helper()
+		var ok bool
+		if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM attachments WHERE storage_key=$1)`, key).Scan(&ok); err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	stored := func(key string) bool {
+		t.Helper()
+		rc, err := store.Open(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			return false
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+		return true
+	}
+	if exists(expired) || stored(expired) || stored("collect-"+tag+"-expired-thumb.jpg") {
+		t.Error("expired unlinked attachment was not removed with its thumbnail")
+	}
+	if !exists(fresh) || !stored(fresh) {
+		t.Error("unlinked attachment inside the grace period was removed")
+	}
+	if !exists(linked) || !stored(linked) || !stored("collect-"+tag+"-linked-thumb.jpg") {
+		t.Error("linked attachment was removed")
+	}
+	for name, want := range map[string]bool{kept: true, orphan: false, recent: true} {
+		_, err := os.Stat(filepath.Join(legacyDir, name))
+		if got := err == nil; got != want {
+			t.Errorf("legacy file %s present = %v, want %v", name, got, want)
+		}
+	}
+
+	// Nothing is left to collect.
+	if again, err := c.CollectOnce(ctx); err != nil || again.LegacyFiles != 0 {
+		t.Fatalf("second CollectOnce = %+v, %v", again, err)
+	}
+}
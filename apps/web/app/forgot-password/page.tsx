@@ -0,0 +1,88 @@
+"use client";
+
+import Link from "next/link";
+import { useState } from "react";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+
+export default function ForgotPasswordPage() {
+    const [email, setEmail] = useState("");
+    const [error, setError] = useState<string | null>(null);
+    const [busy, setBusy] = useState(false);
+    const [sent, setSent] = useState(false);
+
+    async function onSubmit(e: React.FormEvent) {
+        e.preventDefault();
+        setBusy(true);
+        setError(null);
+        try {
+            const res = await fetch("/api/auth/forgot-password", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ email }),
+            });
+            if (!res.ok) {
+                const data = (await res.json().catch(() => null)) as
+                    | { error?: { message?: string } }
+                    | null;
+                setError(data?.error?.message ?? "Request failed");
+                return;
+            }
+            setSent(true);
+        } catch {
+            setError("Network error");
+        } finally {
+            setBusy(false);
+        }
+    }
+
+    return (
+        <div className="flex min-h-screen items-center justify-center bg-gradient-to-br from-slate-900 via-blue-950 to-slate-900 p-4">
+            <div className="w-full max-w-sm">
+                <div className="mb-8 text-center">
+                    <div className="mx-auto mb-4 flex h-14 w-14 items-center justify-center rounded-2xl bg-blue-600 text-2xl font-bold text-white shadow-lg shadow-blue-600/30">
+                        C
+                    </div>
+                    <h1 className="text-2xl font-bold text-white">Forgot password</h1>
+                    <p className="mt-1 text-sm text-slate-400">We will email you a link to choose a new one</p>
+                </div>
+
+                <div className="rounded-2xl border border-white/10 bg-white/5 p-6 shadow-xl backdrop-blur-sm">
+                    {sent ? (
+                        <p className="text-sm text-slate-300">
+                            If an account exists for {email}, a reset link is on its way. The link can be used once.
+                        </p>
+                    ) : (
+                        <form onSubmit={onSubmit} className="space-y-4">
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium text-slate-300">Email</label>
+                                <Input
+                                    type="email"
+                                    value={email}
+                                    autoComplete="email"
+                                    onChange={(e) => setEmail(e.target.value)}
+                                    className="border-white/15 bg-white/10 text-white placeholder:text-slate-500 focus-visible:border-blue-500"
+                                />
+                            </div>
+
+                            {error && (
+                                <div className="rounded-lg border border-red-500/30 bg-red-500/10 px-3 py-2 text-sm text-red-300">
+                                    {error}
+                                </div>
+                            )}
+
+                            <Button className="w-full" size="lg" disabled={busy || email.trim() === ""}>
+                                {busy ? "Sending…" : "Send reset link"}
+                            </Button>
+                        </form>
+                    )}
+                    <div className="mt-4 text-center text-xs">
+                        <Link href="/login" className="text-slate-400 hover:text-white">
+                            Back to sign in
+                        </Link>
+                    </div>
+                </div>
+            </div>
+        </div>
+    );
+}
@@ -1,10 +1,21 @@
 "use client";
 
 import { useRouter } from "next/navigation";
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 
+const SSO_ERRORS: Record<string, string> = {
+    sso_state: "Single sign-on expired or was started in another browser. Please try again.",
+    sso_denied: "Single sign-on was cancelled.",
+    sso_unavailable: "The identity provider is unavailable.",
+    sso_failed: "Single sign-on failed.",
+    oidc_no_account: "No CementOps account is linked to this identity.",
+    oidc_no_role: "Your identity provider account has no CementOps role.",
+    oidc_email_in_use: "An account with this email already exists. Ask an administrator to link it.",
+    disabled: "This account is disabled.",
+};
+
 const DEMO_ACCOUNTS = [
     { email: "superadmin@cementops.local", password: "super123", role: "SUPER_ADMIN" },
     { email: "management@cementops.local", password: "management123", role: "MANAGEMENT" },
@@ -19,6 +30,49 @@ export default function LoginPage() {
     const [error, setError] = useState<string | null>(null);
     const [busy, setBusy] = useState(false);
 
+    // Second step (two-factor authentication).
+    const [challengeId, setChallengeId] = useState<string | null>(null);
+    const [enrollment, setEnrollment] = useState<{ secret: string; otpauthUri: string } | null>(null);
+    const [code, setCode] = useState("");
+    const [useRecovery, setUseRecovery] = useState(false);
+    const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+
+    const [sso, setSso] = useState<{ enabled: boolean; displayName: string } | null>(null);
+
+    // Single sign-on returns here with ?error=, or with ?challenge= when the
+    // account still has to pass two-factor authentication.
+    useEffect(() => {
+        fetch("/api/auth/providers")
+            .then((res) => (res.ok ? res.json() : null))
+            .then((data: { oidc?: { enabled: boolean; displayName: string } } | null) => setSso(data?.oidc ?? null))
+            .catch(() => setSso(null));
+
+        const params = new URLSearchParams(window.location.search);
+        const ssoError = params.get("error");
+        if (ssoError) {
+            setError(SSO_ERRORS[ssoError] ?? "Single sign-on failed.");
+        }
+        const challenge = params.get("challenge");
+        if (challenge) {
+            setChallengeId(challenge);
+            if (params.get("enroll") === "1") {
+                startEnrollment(challenge);
+            }
+        }
+    }, []);
+
+    async function readError(res: Response, fallback: string) {
+        const data = (await res.json().catch(() => null)) as
+            | { error?: { message?: string } }
+            | null;
+        return data?.error?.message ?? fallback;
+    }
+
+    function finish() {
+        router.push("/dashboard");
+        router.refresh();
+    }
+
     async function onSubmit(e: React.FormEvent) {
         e.preventDefault();
         setBusy(true);
@@ -30,14 +84,23 @@ export default function LoginPage() {
                 body: JSON.stringify({ email, password }),
             });
             if (!res.ok) {
-                const data = (await res.json().catch(() => null)) as
-                    | { error?: { message?: string } }
-                    | null;
-                setError(data?.error?.message ?? "Login failed");
+                setError(await readError(res, "Login failed"));
                 return;
             }
-            router.push("/dashboard");
-            router.refresh();
+            const data = (await res.json()) as {
+                mfaRequired?: boolean;
+                enrollmentRequired?: boolean;
+                challengeId?: string;
+            };
+            if (data.mfaRequired && data.challengeId) {
+                setChallengeId(data.challengeId);
+                setCode("");
+                if (data.enrollmentRequired) {
+                    await startEnrollment(data.challengeId);
+                }
+                return;
+            }
+            finish();
         } catch {
             setError("Network error");
         } finally {
@@ -45,6 +108,66 @@ export default function LoginPage() {
         }
     }
 
+    async function startEnrollment(challenge: string) {
+        try {
+            const er = await fetch("/api/auth/login/mfa/enroll", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ challengeId: challenge }),
+            });
+            if (!er.ok) {
+                setError(await readError(er, "Could not start 2FA enrollment"));
+                return;
+            }
+            setEnrollment((await er.json()) as { secret: string; otpauthUri: string });
+        } catch {
+            setError("Network error");
+        }
+    }
+
+    async function onSubmitCode(e: React.FormEvent) {
+        e.preventDefault();
+        setBusy(true);
+        setError(null);
+        try {
+            const res = await fetch("/api/auth/login/mfa", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify(
+                    useRecovery ? { challengeId, recoveryCode: code } : { challengeId, code },
+                ),
+            });
+            if (!res.ok) {
+                setError(await readError(res, "Verification failed"));
+                return;
+            }
+            const data = (await res.json()) as { recoveryCodes?: string[] };
+            if (data.recoveryCodes?.length) {
+                setRecoveryCodes(data.recoveryCodes);
+                return;
+            }
+            finish();
+        } catch {
+            setError("Network error");
+        } finally {
+            setBusy(false);
+        }
+    }
+
+    function backToPassword() {
+        setChallengeId(null);
+        setEnrollment(null);
+        setCode("");
+        setUseRecovery(false);
+        setError(null);
+    }
+
+    const errorBox = error && (
+        <div className="rounded-lg border border-red-500/30 bg-red-500/10 px-3 py-2 text-sm text-red-300">
+            {error}
+        </div>
+    );
+
     return (
         <div className="flex min-h-screen items-center justify-center bg-gradient-to-br from-slate-900 via-blue-950 to-slate-900 p-4">
             <div className="w-full max-w-sm">
@@ -59,38 +182,112 @@ export default function LoginPage() {
 
                 {/* Card */}
                 <div className="rounded-2xl border border-white/10 bg-white/5 p-6 shadow-xl backdrop-blur-sm">
-                    <form onSubmit={onSubmit} className="space-y-4">
-                        <div className="space-y-1.5">
-                            <label className="text-xs font-medium text-slate-300">Email</label>
-                            <Input
-                                type="email"
-                                value={email}
-                                autoComplete="email"
-                                onChange={(e) => setEmail(e.target.value)}
-                                className="border-white/15 bg-white/10 text-white placeholder:text-slate-500 focus-visible:border-blue-500"
-                            />
+                    {recoveryCodes ? (
+                        <div className="space-y-4">
+                            <p className="text-sm text-slate-300">
+                                Two-factor authentication is on. Save these recovery codes somewhere safe; each can be used once if you lose your authenticator.
+                            </p>
+                            <div className="grid grid-cols-2 gap-2 rounded-lg border border-white/10 bg-black/20 p-3 font-mono text-sm text-white">
+                                {recoveryCodes.map((c) => (
+                                    <span key={c}>{c}</span>
+                                ))}
+                            </div>
+                            <Button className="w-full" size="lg" onClick={finish}>
+                                Continue
+                            </Button>
                         </div>
-                        <div className="space-y-1.5">
-                            <label className="text-xs font-medium text-slate-300">Password</label>
+                    ) : challengeId ? (
+                        <form onSubmit={onSubmitCode} className="space-y-4">
+                            {enrollment ? (
+                                <div className="space-y-2 text-sm text-slate-300">
+                                    <p>Your role requires two-factor authentication. Add this key to your authenticator app, then enter the 6-digit code it shows.</p>
+                                    <div className="break-all rounded-lg border border-white/10 bg-black/20 p-2 font-mono text-xs text-white">
+                                        {enrollment.secret}
+                                    </div>
+                                    <a href={enrollment.otpauthUri} className="text-xs text-blue-400 hover:underline">
+                                        Open in authenticator app
+                                    </a>
+                                </div>
+                            ) : (
+                                <p className="text-sm text-slate-300">
+                                    {useRecovery
+                                        ? "Enter one of your recovery codes."
+                                        : "Enter the 6-digit code from your authenticator app."}
+                                </p>
+                            )}
                             <Input
-                                type="password"
-                                value={password}
-                                autoComplete="current-password"
-                                onChange={(e) => setPassword(e.target.value)}
+                                value={code}
+                                autoFocus
+                                autoComplete="one-time-code"
+                                inputMode={useRecovery ? "text" : "numeric"}
+                                onChange={(e) => setCode(e.target.value)}
                                 className="border-white/15 bg-white/10 text-white placeholder:text-slate-500 focus-visible:border-blue-500"
                             />
-                        </div>
 
-                        {error && (
-                            <div className="rounded-lg border border-red-500/30 bg-red-500/10 px-3 py-2 text-sm text-red-300">
-                                {error}
+                            {errorBox}
+
+                            <Button className="w-full" size="lg" disabled={busy || code.trim() === ""}>
+                                {busy ? "Verifying…" : "Verify"}
+                            </Button>
+                            <div className="flex justify-between text-xs">
+                                <button type="button" onClick={backToPassword} className="text-slate-400 hover:text-white">
+                                    Back
+                                </button>
+                                {!enrollment && (
+                                    <button
+                                        type="button"
+                                        onClick={() => { setUseRecovery(!useRecovery); setCode(""); setError(null); }}
+                                        className="text-slate-400 hover:text-white"
+                                    >
+                                        {useRecovery ? "Use authenticator code" : "Use a recovery code"}
+                                    </button>
+                                )}
+                            </div>
+                        </form>
+                    ) : (
+                        <form onSubmit={onSubmit} className="space-y-4">
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium text-slate-300">Email</label>
+                                <Input
+                                    type="email"
+                                    value={email}
+                                    autoComplete="email"
+                                    onChange={(e) => setEmail(e.target.value)}
+                                    className="border-white/15 bg-white/10 text-white placeholder:text-slate-500 focus-visible:border-blue-500"
+                                />
                             </div>
-                        )}
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium text-slate-300">Password</label>
+                                <Input
+                                    type="password"
+                                    value={password}
+                                    autoComplete="current-password"
+                                    onChange={(e) => setPassword(e.target.value)}
+                                    className="border-white/15 bg-white/10 text-white placeholder:text-slate-500 focus-visible:border-blue-500"
+                                />
+                            </div>
+
+                            {errorBox}
 
-                        <Button className="w-full" size="lg" disabled={busy}>
-                            {busy ? "Signing in…" : "Sign in"}
-                        </Button>
-                    </form>
+                            <Button className="w-full" size="lg" disabled={busy}>
+                                {busy ? "Signing in…" : "Sign in"}
+                            </Button>
+                            <div className="text-right text-xs">
+                                <a href="/forgot-password" className="text-slate-400 hover:text-white">
+                                    Forgot password?
+                                </a>
+                            </div>
+
+                            {sso?.enabled && (
+                                <a
+                                    href="/api/auth/oidc/login"
+                                    className="flex h-10 w-full items-center justify-center rounded-md border border-white/15 bg-white/5 text-sm font-medium text-white hover:border-blue-500/50 transition-colors"
+                                >
+                                    Sign in with {sso.displayName}
+                                </a>
+                            )}
+                        </form>
+                    )}
 
                     {/* Demo accounts */}
                     <div className="mt-5 space-y-2">
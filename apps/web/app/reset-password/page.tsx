@@ -0,0 +1,117 @@
+"use client";
+
+import Link from "next/link";
+import { useEffect, useState } from "react";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+
+export default function ResetPasswordPage() {
+    const [token, setToken] = useState("");
+    const [password, setPassword] = useState("");
+    const [confirm, setConfirm] = useState("");
+    const [error, setError] = useState<string | null>(null);
+    const [busy, setBusy] = useState(false);
+    const [done, setDone] = useState(false);
+
+    useEffect(() => {
+        setToken(new URLSearchParams(window.location.search).get("token") ?? "");
+    }, []);
+
+    async function onSubmit(e: React.FormEvent) {
+        e.preventDefault();
+        if (password !== confirm) {
+            setError("Passwords do not match");
+            return;
+        }
+        setBusy(true);
+        setError(null);
+        try {
+            const res = await fetch("/api/auth/reset-password", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ token, newPassword: password }),
+            });
+            if (!res.ok) {
+                const data = (await res.json().catch(() => null)) as
+                    | { error?: { message?: string } }
+                    | null;
+                setError(data?.error?.message ?? "Reset failed");
+                return;
+            }
+            setDone(true);
+        } catch {
+            setError("Network error");
+        } finally {
+            setBusy(false);
+        }
+    }
+
+    const inputClass =
+        "border-white/15 bg-white/10 text-white placeholder:text-slate-500 focus-visible:border-blue-500";
+
+    return (
+        <div className="flex min-h-screen items-center justify-center bg-gradient-to-br from-slate-900 via-blue-950 to-slate-900 p-4">
+            <div className="w-full max-w-sm">
+                <div className="mb-8 text-center">
+                    <div className="mx-auto mb-4 flex h-14 w-14 items-center justify-center rounded-2xl bg-blue-600 text-2xl font-bold text-white shadow-lg shadow-blue-600/30">
+                        C
+                    </div>
+                    <h1 className="text-2xl font-bold text-white">Choose a new password</h1>
+                </div>
+
+                <div className="rounded-2xl border border-white/10 bg-white/5 p-6 shadow-xl backdrop-blur-sm">
+                    {done ? (
+                        <p className="text-sm text-slate-300">
+                            Your password has been changed and all existing sessions were signed out.
+                        </p>
+                    ) : !token ? (
+                        <p className="text-sm text-slate-300">This reset link is incomplete. Request a new one.</p>
+                    ) : (
+                        <form onSubmit={onSubmit} className="space-y-4">
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium text-slate-300">New password</label>
+                                <Input
+                                    type="password"
+                                    value={password}
+                                    autoComplete="new-password"
+                                    onChange={(e) => setPassword(e.target.value)}
+                                    className={inputClass}
+                                />
+                            </div>
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium text-slate-300">Confirm password</label>
+                                <Input
+                                    type="password"
+                                    value={confirm}
+                                    autoComplete="new-password"
+                                    onChange={(e) => setConfirm(e.target.value)}
+                                    className={inputClass}
+                                />
+                            </div>
+
+                            {error && (
+                                <div className="rounded-lg border border-red-500/30 bg-red-500/10 px-3 py-2 text-sm text-red-300">
+                                    {error}
+                                </div>
+                            )}
+
+                            <Button className="w-full" size="lg" disabled={busy || password === ""}>
+                                {busy ? "Saving…" : "Set password"}
+                            </Button>
+                        </form>
+                    )}
+                    <div className="mt-4 flex justify-center gap-4 text-xs">
+                        <Link href="/login" className="text-slate-400 hover:text-white">
+                            Back to sign in
+                        </Link>
+                        {!done && (
+                            <Link href="/forgot-password" className="text-slate-400 hover:text-white">
+                                Request a new link
+                            </Link>
+                        )}
+                    </div>
+                </div>
+            </div>
+        </div>
+    );
+}
@@ -1,6 +1,6 @@
 "use client";
 
-import { useEffect, useMemo, useState } from "react";
+import { useCallback, useEffect, useMemo, useState } from "react";
 import { Eye } from "lucide-react";
 import { AdminPageHeader } from "@/components/admin/admin-page-header";
 import { FiltersBar } from "@/components/admin/filters-bar";
@@ -47,105 +47,119 @@ function toAuditLog(raw: unknown): AuditLog | null {
     };
 }
 
-function csvEscape(value: string) {
-    return `"${value.replaceAll('"', '""')}"`;
-}
+type LogsPage = { items: AuditLog[]; nextCursor: string | null };
 
-function downloadLogsCSV(logs: AuditLog[]) {
-    const header = ["ts", "actorName", "action", "entityType", "entityId", "ip", "metadata"];
-    const lines = [header.join(",")];
-    for (const log of logs) {
-        lines.push(
-            [
-                csvEscape(log.ts),
-                csvEscape(log.actorName ?? ""),
-                csvEscape(log.action ?? ""),
-                csvEscape(log.entityType ?? ""),
-                csvEscape(log.entityId ?? ""),
-                csvEscape(log.ip ?? ""),
-                csvEscape(JSON.stringify(log.metadata ?? {})),
-            ].join(","),
-        );
+async function fetchLogsPage(query: string, cursor: string | null): Promise<LogsPage> {
+    const params = new URLSearchParams(query);
+    if (cursor) params.set("cursor", cursor);
+    const res = await fetch(`/api/admin/logs?${params.toString()}`);
+    if (!res.ok) {
+        const body = (await res.json().catch(() => null)) as { error?: { message?: string } } | null;
+        throw new Error(body?.error?.message ?? `Failed to load logs (${res.status})`);
+    }
+    const data = (await res.json().catch(() => null)) as unknown;
+    const items = isRecord(data) && Array.isArray(data.items) ? data.items : [];
+    const parsed: AuditLog[] = [];
+    for (const it of items) {
+        const log = toAuditLog(it);
+        if (log) parsed.push(log);
     }
-    const blob = new Blob([lines.join("\n")], { type: "text/csv;charset=utf-8" });
-    const url = URL.createObjectURL(blob);
-    const a = document.createElement("a");
-    a.href = url;
-    a.download = `system-logs-${new Date().toISOString().slice(0, 10)}.csv`;
-    a.click();
-    URL.revokeObjectURL(url);
+    const nextCursor = isRecord(data) && typeof data.nextCursor === "string" ? data.nextCursor : null;
+    return { items: parsed, nextCursor };
 }
 
 export function LogsView() {
     const [logs, setLogs] = useState<AuditLog[]>([]);
+    const [nextCursor, setNextCursor] = useState<string | null>(null);
     const [busy, setBusy] = useState(true);
+    const [loadingMore, setLoadingMore] = useState(false);
     const [loadError, setLoadError] = useState<string | null>(null);
     const [startDate, setStartDate] = useState("");
     const [endDate, setEndDate] = useState("");
     const [actorFilter, setActorFilter] = useState("all");
-    const [actionFilter, setActionFilter] = useState("all");
+    const [actionFilter, setActionFilter] = useState("");
+    const [entityType, setEntityType] = useState("");
+    const [entityId, setEntityId] = useState("");
+    const [ipFilter, setIpFilter] = useState("");
+    const [search, setSearch] = useState("");
+    const [users, setUsers] = useState<Array<{ id: string; name: string }>>([]);
     const [selectedLog, setSelectedLog] = useState<AuditLog | null>(null);
 
+    const query = useMemo(() => {
+        const params = new URLSearchParams();
+        if (startDate) params.set("from", startDate);
+        if (endDate) params.set("to", endDate);
+        if (actorFilter !== "all") params.set("actorId", actorFilter);
+        if (actionFilter.trim()) params.set("action", actionFilter.trim());
+        if (entityType.trim()) params.set("entityType", entityType.trim());
+        if (entityId.trim()) params.set("entityId", entityId.trim());
+        if (ipFilter.trim()) params.set("ip", ipFilter.trim());
+        if (search.trim()) params.set("q", search.trim());
+        return params.toString();
+    }, [startDate, endDate, actorFilter, actionFilter, entityType, entityId, ipFilter, search]);
+
+    useEffect(() => {
+        async function loadUsers() {
+            const res = await fetch("/api/admin/users").catch(() => null);
+            if (!res?.ok) return;
+            const data = (await res.json().catch(() => null)) as { items?: Array<{ id: string; name: string }> } | null;
+            setUsers(data?.items ?? []);
+        }
+        void loadUsers();
+    }, []);
+
     useEffect(() => {
         let cancelled = false;
-        async function load() {
+        // Debounce so typing in the text filters does not fire a request per keystroke.
+        const timer = setTimeout(async () => {
             setBusy(true);
             setLoadError(null);
             try {
-                const res = await fetch("/api/admin/logs");
-                if (!res.ok) {
-                    const body = (await res.json().catch(() => null)) as { error?: { message?: string } } | null;
-                    throw new Error(body?.error?.message ?? `Failed to load logs (${res.status})`);
-                }
-                const data = (await res.json().catch(() => null)) as unknown;
-                const items = isRecord(data) && Array.isArray(data.items) ? data.items : [];
-                const parsed: AuditLog[] = [];
-                for (const it of items) {
-                    const log = toAuditLog(it);
-                    if (log) parsed.push(log);
+                const page = await fetchLogsPage(query, null);
+                if (!cancelled) {
+                    setLogs(page.items);
+                    setNextCursor(page.nextCursor);
                 }
-                if (!cancelled) setLogs(parsed);
             } catch (e) {
                 if (!cancelled) {
                     setLogs([]);
+                    setNextCursor(null);
                     setLoadError(e instanceof Error ? e.message : "Failed to load logs");
                 }
             } finally {
                 if (!cancelled) setBusy(false);
             }
-        }
-        load();
+        }, 300);
         return () => {
             cancelled = true;
+            clearTimeout(timer);
         };
-    }, []);
+    }, [query]);
 
-    const actorOptions = useMemo(() => {
-        const set = new Set<string>();
-        for (const log of logs) {
-            if (log.actorName) set.add(log.actorName);
+    const loadMore = useCallback(async () => {
+        if (!nextCursor) return;
+        setLoadingMore(true);
+        try {
+            const page = await fetchLogsPage(query, nextCursor);
+            setLogs((prev) => [...prev, ...page.items]);
+            setNextCursor(page.nextCursor);
+        } catch (e) {
+            setLoadError(e instanceof Error ? e.message : "Failed to load logs");
+        } finally {
+            setLoadingMore(false);
         }
-        return [{ value: "all", label: "All actors" }, ...Array.from(set).sort().map((a) => ({ value: a, label: a }))];
-    }, [logs]);
+    }, [query, nextCursor]);
 
-    const actionOptions = useMemo(() => {
-        const set = new Set<string>();
-        for (const log of logs) {
-            if (log.action) set.add(log.action);
-        }
-        return [{ value: "all", label: "All actions" }, ...Array.from(set).sort().map((a) => ({ value: a, label: a }))];
-    }, [logs]);
-
-    const filteredLogs = useMemo(() => {
-        return logs.filter((log) => {
-            const logDate = new Date(log.ts);
-            const matchesActor = actorFilter === "all" || log.actorName === actorFilter;
-            const matchesAction = actionFilter === "all" || log.action === actionFilter;
-            const afterStart = startDate ? logDate >= new Date(startDate) : true;
-            const beforeEnd = endDate ? logDate <= new Date(`${endDate}T23:59:59`) : true;
-            return matchesActor && matchesAction && afterStart && beforeEnd;
-        });
-    }, [logs, startDate, endDate, actorFilter, actionFilter]);
+    const actorOptions = useMemo(() => {
+        const sorted = [...users].sort((a, b) => a.name.localeCompare(b.name));
+        return [{ value: "all", label: "All actors" }, ...sorted.map((u) => ({ value: u.id, label: u.name }))];
+    }, [users]);
+
+    const exportHref = (format: "csv" | "ndjson") => {
+        const params = new URLSearchParams(query);
+        params.set("format", format);
+        return `/api/admin/logs/export?${params.toString()}`;
+    };
 
     return (
         <div className="space-y-5">
@@ -153,13 +167,23 @@ export function LogsView() {
                 title="System Logs"
                 description="Audit trail of configuration and access changes."
                 actions={
-                    <Button size="sm" variant="outline" disabled={filteredLogs.length === 0} onClick={() => downloadLogsCSV(filteredLogs)}>
-                        Download CSV
-                    </Button>
+                    <div className="flex items-center gap-2">
+                        <Button size="sm" variant="outline" onClick={() => window.location.assign(exportHref("csv"))}>
+                            Export CSV
+                        </Button>
+                        <Button size="sm" variant="outline" onClick={() => window.location.assign(exportHref("ndjson"))}>
+                            Export NDJSON
+                        </Button>
+                    </div>
                 }
             />
 
-            <FiltersBar label="Filters">
+            <FiltersBar
+                label="Filters"
+                searchValue={search}
+                onSearchChange={setSearch}
+                searchPlaceholder="Search metadata..."
+            >
                 <div className="grid gap-2 sm:grid-cols-2 lg:grid-cols-4">
                     <Input type="date" value={startDate} onChange={(event) => setStartDate(event.target.value)} />
                     <Input type="date" value={endDate} onChange={(event) => setEndDate(event.target.value)} />
@@ -168,11 +192,22 @@ export function LogsView() {
                         value={actorFilter}
                         onValueChange={setActorFilter}
                     />
-                    <Select
-                        options={actionOptions}
+                    <Input
                         value={actionFilter}
-                        onValueChange={setActionFilter}
+                        onChange={(event) => setActionFilter(event.target.value)}
+                        placeholder="Actions, e.g. LOGIN,STOCK_ADJUSTMENT"
+                    />
+                    <Input
+                        value={entityType}
+                        onChange={(event) => setEntityType(event.target.value)}
+                        placeholder="Entity type"
+                    />
+                    <Input
+                        value={entityId}
+                        onChange={(event) => setEntityId(event.target.value)}
+                        placeholder="Entity ID"
                     />
+                    <Input value={ipFilter} onChange={(event) => setIpFilter(event.target.value)} placeholder="IP address" />
                 </div>
             </FiltersBar>
 
@@ -188,11 +223,11 @@ export function LogsView() {
                     { key: "ip", label: "IP" },
                     { key: "actions", label: "Actions", className: "text-right" },
                 ]}
-                rowCount={filteredLogs.length}
+                rowCount={logs.length}
                 emptyLabel="No logs available for the selected filters."
                 loading={busy}
             >
-                {filteredLogs.map((log) => (
+                {logs.map((log) => (
                     <tr key={log.id} className="border-b border-border">
                         <td className="px-3 py-2 text-sm text-muted-foreground">
                             {new Date(log.ts).toLocaleString()}
@@ -213,6 +248,14 @@ export function LogsView() {
                 ))}
             </DataTable>
 
+            {nextCursor ? (
+                <div className="flex justify-center">
+                    <Button size="sm" variant="outline" disabled={loadingMore} onClick={() => void loadMore()}>
+                        {loadingMore ? "Loading..." : "Load more"}
+                    </Button>
+                </div>
+            ) : null}
+
             <Dialog open={!!selectedLog} onClose={() => setSelectedLog(null)}>
                 <DialogCard>
                     <DialogHeader>
@@ -75,6 +75,7 @@ export function MasterDataView() {
     const [formState, setFormState] = useState<FormState>(EMPTY_FORM);
     const [editingId, setEditingId] = useState<string | null>(null);
     const [confirm, setConfirm] = useState<ConfirmState | null>(null);
+    const [toast, setToast] = useState<string | null>(null);
 
     async function loadAll() {
         setLoading(true);
@@ -99,6 +100,12 @@ export function MasterDataView() {
         void loadAll();
     }, []);
 
+    useEffect(() => {
+        if (!toast) return;
+        const id = setTimeout(() => setToast(null), 4000);
+        return () => clearTimeout(id);
+    }, [toast]);
+
     const filteredFactories = useMemo(() => {
         const q = search.trim().toLowerCase();
         if (!q) return factories;
@@ -186,7 +193,7 @@ export function MasterDataView() {
         setConfirm({
             open: true,
             title: `Delete ${tabLabel(tab)}`,
-            description: `This will permanently remove ${label}.`,
+            description: `This will archive ${label}. An administrator can restore it later.`,
             confirmLabel: "Delete",
             tone: "danger",
             onConfirm: async () => {
@@ -198,8 +205,12 @@ export function MasterDataView() {
                             : tab === "distributors"
                                 ? `/api/admin/distributors/${id}`
                                 : `/api/admin/stores/${id}`;
-                await fetch(endpoint, { method: "DELETE" });
+                const res = await fetch(endpoint, { method: "DELETE" });
                 setConfirm(null);
+                if (!res.ok) {
+                    const json = (await res.json().catch(() => null)) as { error?: { message?: string } } | null;
+                    setToast(json?.error?.message ?? "Delete failed");
+                }
                 await loadAll();
             },
         });
@@ -475,6 +486,12 @@ export function MasterDataView() {
                 onClose={() => setConfirm(null)}
                 onConfirm={() => void confirm?.onConfirm()}
             />
+
+            {toast ? (
+                <div className="fixed bottom-6 right-6 rounded-lg border border-border bg-white px-4 py-3 text-sm shadow-lg">
+                    {toast}
+                </div>
+            ) : null}
         </div>
     );
 }
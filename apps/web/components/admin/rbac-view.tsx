@@ -140,7 +140,10 @@ export function RbacView() {
                 headers: { "Content-Type": "application/json" },
                 body: JSON.stringify(config),
             });
-            if (!res.ok) throw new Error("Failed to save RBAC config");
+            if (!res.ok) {
+                const body = (await res.json().catch(() => null)) as { error?: { message?: string } } | null;
+                throw new Error(body?.error?.message ?? "Failed to save RBAC config");
+            }
             setSaved(true);
         } catch (err) {
             setLoadError(err instanceof Error ? err.message : "Failed to save RBAC config");
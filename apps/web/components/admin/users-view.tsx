@@ -20,12 +20,14 @@ import { Select } from "@/components/ui/select";
 import { Form, FormControl, FormItem, FormLabel, FormMessage } from "@/components/ui/form";
 import type { AdminRole, AdminUser, UserStatus } from "@/lib/types/admin";
 
-const ROLE_OPTIONS = [
-    { value: "all", label: "All roles" },
-    { value: "SUPER_ADMIN", label: "SuperAdmin" },
-    { value: "MANAGEMENT", label: "Management" },
-    { value: "OPERATOR", label: "Operator" },
-    { value: "DISTRIBUTOR", label: "Distributor" },
+type RoleOption = { key: string; name: string; kind: string };
+
+// Built-in roles, used until /api/admin/roles responds.
+const DEFAULT_ROLES: RoleOption[] = [
+    { key: "SUPER_ADMIN", name: "SuperAdmin", kind: "ADMIN" },
+    { key: "MANAGEMENT", name: "Management", kind: "STAFF" },
+    { key: "OPERATOR", name: "Operator", kind: "STAFF" },
+    { key: "DISTRIBUTOR", name: "Distributor", kind: "DISTRIBUTOR" },
 ];
 
 const STATUS_OPTIONS = [
@@ -64,6 +66,7 @@ const EMPTY_FORM: UserFormState = {
 export function UsersView() {
     const [users, setUsers] = useState<AdminUser[]>([]);
     const [distributors, setDistributors] = useState<Array<{ id: string; name: string }>>([]);
+    const [roles, setRoles] = useState<RoleOption[]>(DEFAULT_ROLES);
     const [loading, setLoading] = useState(true);
     const [loadError, setLoadError] = useState<string | null>(null);
     const [search, setSearch] = useState("");
@@ -80,9 +83,10 @@ export function UsersView() {
         setLoading(true);
         setLoadError(null);
         try {
-            const [usersRes, distRes] = await Promise.all([
+            const [usersRes, distRes, rolesRes] = await Promise.all([
                 fetch("/api/admin/users"),
                 fetch("/api/admin/distributors"),
+                fetch("/api/admin/roles"),
             ]);
             if (!usersRes.ok) throw new Error("Failed to load users");
             if (!distRes.ok) throw new Error("Failed to load distributors");
@@ -94,6 +98,10 @@ export function UsersView() {
             setDistributors(
                 (distJson.items ?? []).map((d) => ({ id: String(d.id), name: d.name })),
             );
+            if (rolesRes.ok) {
+                const rolesJson = (await rolesRes.json()) as { items?: RoleOption[] };
+                if (rolesJson.items && rolesJson.items.length > 0) setRoles(rolesJson.items);
+            }
         } catch (err) {
             setLoadError(err instanceof Error ? err.message : "Failed to load");
         } finally {
@@ -122,6 +130,12 @@ export function UsersView() {
         });
     }, [users, search, roleFilter, statusFilter]);
 
+    const roleOptions = useMemo(
+        () => [{ value: "all", label: "All roles" }, ...roles.map((r) => ({ value: r.key, label: r.name }))],
+        [roles],
+    );
+    const isDistributorRole = (role: string) => roles.find((r) => r.key === role)?.kind === "DISTRIBUTOR";
+
     const distributorNameById = useMemo(() => {
         const map = new Map<string, string>();
         distributors.forEach((d) => map.set(d.id, d.name));
@@ -158,8 +172,8 @@ export function UsersView() {
             setFormError("Password is required when creating a user.");
             return;
         }
-        if (formState.role === "DISTRIBUTOR" && !formState.distributorId) {
-            setFormError("Distributor is required for Distributor role.");
+        if (isDistributorRole(formState.role) && !formState.distributorId) {
+            setFormError("Distributor is required for distributor roles.");
             return;
         }
 
@@ -174,7 +188,7 @@ export function UsersView() {
                         email: formState.email,
                         role: formState.role,
                         distributorId:
-                            formState.role === "DISTRIBUTOR" ? Number(formState.distributorId) : null,
+                            isDistributorRole(formState.role) ? Number(formState.distributorId) : null,
                     }),
                 });
                 if (!res.ok) throw new Error("Failed to update user");
@@ -194,7 +208,7 @@ export function UsersView() {
                         password: formState.password,
                         role: formState.role,
                         distributorId:
-                            formState.role === "DISTRIBUTOR" ? Number(formState.distributorId) : null,
+                            isDistributorRole(formState.role) ? Number(formState.distributorId) : null,
                     }),
                 });
                 if (!res.ok) throw new Error("Failed to create user");
@@ -294,7 +308,7 @@ export function UsersView() {
                 onSearchChange={setSearch}
                 searchPlaceholder="Search name or email"
             >
-                <Select options={ROLE_OPTIONS} value={roleFilter} onValueChange={setRoleFilter} />
+                <Select options={roleOptions} value={roleFilter} onValueChange={setRoleFilter} />
                 <Select options={STATUS_OPTIONS} value={statusFilter} onValueChange={setStatusFilter} />
             </FiltersBar>
 
@@ -323,7 +337,7 @@ export function UsersView() {
                         <td className="px-3 py-2 text-sm text-muted-foreground">{user.email}</td>
                         <td className="px-3 py-2">{user.role.replace("_", " ")}</td>
                         <td className="px-3 py-2 text-sm text-muted-foreground">
-                            {user.role === "DISTRIBUTOR" && user.distributorId
+                            {isDistributorRole(user.role) && user.distributorId
                                 ? distributorNameById.get(user.distributorId) ?? user.distributorId
                                 : "—"}
                         </td>
@@ -388,7 +402,7 @@ export function UsersView() {
                             <FormLabel>Role</FormLabel>
                             <FormControl>
                                 <Select
-                                    options={ROLE_OPTIONS.filter((item) => item.value !== "all")}
+                                    options={roleOptions.filter((item) => item.value !== "all")}
                                     value={formState.role}
                                     onValueChange={(value) =>
                                         setFormState({ ...formState, role: value as AdminRole })
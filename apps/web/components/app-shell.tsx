@@ -11,6 +11,7 @@ import {
     ClipboardList,
     Database,
     Globe,
+    KeyRound,
     LayoutDashboard,
     LineChart,
     LogOut,
@@ -26,6 +27,7 @@ import {
     Truck,
     Users,
 } from "lucide-react";
+import { ChangePasswordDialog } from "@/components/change-password-dialog";
 import type { Me } from "@/lib/types";
 import { cn } from "@/lib/utils";
 
@@ -252,6 +254,7 @@ export function AppShell({ user, children }: { user: Me; children: React.ReactNo
     const router = useRouter();
     const [busy, setBusy] = useState(false);
     const [mobileOpen, setMobileOpen] = useState(false);
+    const [changingPassword, setChangingPassword] = useState(false);
     // undefined = loading, null = no config / failed to load (fail-open)
     const [sidebar, setSidebar] = useState<string[] | null | undefined>(undefined);
 
@@ -363,10 +366,17 @@ export function AppShell({ user, children }: { user: Me; children: React.ReactNo
                         {user.role}
                     </span>
                 </div>
+                <button
+                    onClick={() => setChangingPassword(true)}
+                    className="mt-2 flex w-full items-center gap-2 rounded-lg px-3 py-2 text-sm text-slate-400 hover:bg-white/10 hover:text-white transition-colors"
+                >
+                    <KeyRound className="h-4 w-4" />
+                    Change password
+                </button>
                 <button
                     onClick={logout}
                     disabled={busy}
-                    className="mt-2 flex w-full items-center gap-2 rounded-lg px-3 py-2 text-sm text-slate-400 hover:bg-white/10 hover:text-white transition-colors disabled:opacity-50"
+                    className="flex w-full items-center gap-2 rounded-lg px-3 py-2 text-sm text-slate-400 hover:bg-white/10 hover:text-white transition-colors disabled:opacity-50"
                 >
                     <LogOut className="h-4 w-4" />
                     {busy ? "Signing out..." : "Sign out"}
@@ -377,6 +387,7 @@ export function AppShell({ user, children }: { user: Me; children: React.ReactNo
 
     return (
         <div className="flex min-h-dvh">
+            <ChangePasswordDialog open={changingPassword} onClose={() => setChangingPassword(false)} />
             <aside className="hidden w-64 shrink-0 md:block">
                 <SidebarContent />
             </aside>
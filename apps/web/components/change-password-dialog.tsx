@@ -0,0 +1,100 @@
+"use client";
+
+import { useState } from "react";
+import { Button } from "@/components/ui/button";
+import { Dialog, DialogBody, DialogCard, DialogFooter, DialogHeader, DialogTitle } from "@/components/ui/dialog";
+import { Input } from "@/components/ui/input";
+
+type ChangePasswordDialogProps = {
+    open: boolean;
+    onClose: () => void;
+};
+
+export function ChangePasswordDialog({ open, onClose }: ChangePasswordDialogProps) {
+    const [current, setCurrent] = useState("");
+    const [next, setNext] = useState("");
+    const [confirm, setConfirm] = useState("");
+    const [error, setError] = useState<string | null>(null);
+    const [busy, setBusy] = useState(false);
+    const [done, setDone] = useState(false);
+
+    function close() {
+        setCurrent("");
+        setNext("");
+        setConfirm("");
+        setError(null);
+        setDone(false);
+        onClose();
+    }
+
+    async function submit() {
+        if (next !== confirm) {
+            setError("New passwords do not match");
+            return;
+        }
+        setBusy(true);
+        setError(null);
+        try {
+            const res = await fetch("/api/auth/change-password", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ currentPassword: current, newPassword: next }),
+            });
+            if (!res.ok) {
+                const data = (await res.json().catch(() => null)) as
+                    | { error?: { message?: string } }
+                    | null;
+                setError(data?.error?.message ?? "Could not change password");
+                return;
+            }
+            setDone(true);
+        } catch {
+            setError("Network error");
+        } finally {
+            setBusy(false);
+        }
+    }
+
+    return (
+        <Dialog open={open} onClose={close}>
+            <DialogCard>
+                <DialogHeader>
+                    <DialogTitle>Change password</DialogTitle>
+                </DialogHeader>
+                <DialogBody>
+                    {done ? (
+                        <p className="text-sm text-muted-foreground">
+                            Password changed. Your other sessions have been signed out.
+                        </p>
+                    ) : (
+                        <>
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium">Current password</label>
+                                <Input type="password" autoComplete="current-password" value={current} onChange={(e) => setCurrent(e.target.value)} />
+                            </div>
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium">New password</label>
+                                <Input type="password" autoComplete="new-password" value={next} onChange={(e) => setNext(e.target.value)} />
+                            </div>
+                            <div className="space-y-1.5">
+                                <label className="text-xs font-medium">Confirm new password</label>
+                                <Input type="password" autoComplete="new-password" value={confirm} onChange={(e) => setConfirm(e.target.value)} />
+                            </div>
+                            {error && <p className="text-sm text-red-600">{error}</p>}
+                        </>
+                    )}
+                </DialogBody>
+                <DialogFooter>
+                    <Button variant="outline" onClick={close}>
+                        {done ? "Close" : "Cancel"}
+                    </Button>
+                    {!done && (
+                        <Button onClick={submit} disabled={busy || current === "" || next === ""}>
+                            {busy ? "Saving…" : "Change password"}
+                        </Button>
+                    )}
+                </DialogFooter>
+            </DialogCard>
+        </Dialog>
+    );
+}
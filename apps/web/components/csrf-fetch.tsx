@@ -0,0 +1,10 @@
+"use client";
+
+import { installCsrfFetch } from "@/lib/csrf";
+
+// Installed at module load so it is in place before any component effect runs.
+installCsrfFetch();
+
+export function CsrfFetch() {
+    return null;
+}
@@ -32,6 +32,15 @@ type IssueReport = {
     resolvedAt?: string | null;
     resolutionNotes?: string | null;
     metadata?: { evidenceUrls?: string[]; damageType?: string };
+    attachments?: IssueAttachment[];
+};
+
+type IssueAttachment = {
+    id: number;
+    url: string;
+    thumbnailUrl: string | null;
+    filename: string;
+    contentType: string;
 };
 
 type IssueFormState = {
@@ -141,7 +150,7 @@ export function DistributorProductReportClient() {
     };
 
     const uploadEvidence = useCallback(async (selected: File[]) => {
-        const urls: string[] = [];
+        const ids: number[] = [];
         for (const file of selected) {
             const formData = new FormData();
             formData.append("file", file);
@@ -153,10 +162,10 @@ export function DistributorProductReportClient() {
                 const body = (await res.json().catch(() => null)) as { error?: { message?: string } } | null;
                 throw new Error(body?.error?.message ?? "Upload gagal");
             }
-            const d = (await res.json()) as { url?: string };
-            if (d.url) urls.push(d.url);
+            const d = (await res.json()) as { id?: number };
+            if (d.id) ids.push(d.id);
         }
-        return urls;
+        return ids;
     }, []);
 
     const submitIssue = useCallback(async () => {
@@ -195,9 +204,9 @@ export function DistributorProductReportClient() {
         }
 
         try {
-            let evidenceUrls: string[] = [];
+            let attachmentIds: number[] = [];
             if (files.length > 0) {
-                evidenceUrls = await uploadEvidence(files);
+                attachmentIds = await uploadEvidence(files);
             }
             const res = await fetch("/api/distributor/issues", {
                 method: "POST",
@@ -208,11 +217,11 @@ export function DistributorProductReportClient() {
                     title: form.title.trim(),
                     description: form.description.trim(),
                     shipmentId,
+                    attachmentIds,
                     metadata: {
                         damageType: form.damageType,
                         damagedBags: damagedBagsNum,
                         damagedTons: damagedTonsNum,
-                        evidenceUrls,
                     },
                 }),
             });
@@ -303,10 +312,10 @@ export function DistributorProductReportClient() {
                     </div>
 
                     <div className="space-y-2">
-                        <div className="text-xs text-muted-foreground">Bukti foto (jpg/png/webp)</div>
+                        <div className="text-xs text-muted-foreground">Bukti foto atau dokumen (jpg/png/webp/pdf)</div>
                         <Input
                             type="file"
-                            accept="image/*"
+                            accept="image/jpeg,image/png,image/webp,application/pdf"
                             multiple
                             onChange={(e) => setFiles(e.target.files ? Array.from(e.target.files) : [])}
                         />
@@ -362,12 +371,19 @@ export function DistributorProductReportClient() {
                                         <td className="px-3 py-2">{r.status}</td>
                                         <td className="px-3 py-2 text-xs">{r.resolutionNotes || "—"}</td>
                                         <td className="px-3 py-2 text-xs">
-                                            {(r.metadata?.evidenceUrls ?? []).length > 0 ? (
-                                                (r.metadata?.evidenceUrls ?? []).map((url, index) => (
-                                                    <a key={`${url}-${index}`} href={url} target="_blank" rel="noreferrer" className="underline mr-2">
-                                                        Lihat
-                                                    </a>
-                                                ))
+                                            {(r.attachments ?? []).length + (r.metadata?.evidenceUrls ?? []).length > 0 ? (
+                                                <>
+                                                    {(r.attachments ?? []).map((a) => (
+                                                        <a key={a.id} href={a.url} target="_blank" rel="noreferrer" className="underline mr-2" title={a.filename}>
+                                                            {a.contentType === "application/pdf" ? "PDF" : "Lihat"}
+                                                        </a>
+                                                    ))}
+                                                    {(r.metadata?.evidenceUrls ?? []).map((url, index) => (
+                                                        <a key={`${url}-${index}`} href={url} target="_blank" rel="noreferrer" className="underline mr-2">
+                                                            Lihat
+                                                        </a>
+                                                    ))}
+                                                </>
                                             ) : (
                                                 "—"
                                             )}
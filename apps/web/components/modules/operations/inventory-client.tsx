@@ -38,6 +38,8 @@ type InventoryRow = {
 	status: "OK" | "WARNING" | "CRITICAL" | string;
 	thresholds?: Thresholds;
 	recentMovements?: Movement[];
+	inTransitInTons?: number;
+	inTransitOutTons?: number;
 };
 
 function formatThresholdValue(value: number | null | undefined) {
@@ -211,7 +213,15 @@ export function InventoryClient({ role }: { role: string }) {
 										<TD>
 											<Badge variant="secondary">{r.cementType}</Badge>
 										</TD>
-										<TD className="text-right font-mono font-semibold">{Number(r.quantityTons).toLocaleString("id")}</TD>
+										<TD className="text-right font-mono font-semibold">
+											{Number(r.quantityTons).toLocaleString("id")}
+											{r.inTransitInTons ? (
+												<div className="text-xs font-normal text-muted-foreground">+{Number(r.inTransitInTons).toLocaleString("id")} in transit</div>
+											) : null}
+											{r.inTransitOutTons ? (
+												<div className="text-xs font-normal text-muted-foreground">−{Number(r.inTransitOutTons).toLocaleString("id")} outbound</div>
+											) : null}
+										</TD>
 										<TD className="text-right text-xs text-muted-foreground">
 											<div>
 												<span className="font-medium text-foreground">Min</span>: {formatThresholdValue(r.thresholds?.minStock)}
@@ -23,6 +23,14 @@ export type IssueItem = {
     resolvedAt?: string | null;
     resolutionNotes?: string | null;
     metadata?: { evidenceUrls?: string[] };
+    attachments?: {
+        id: number;
+        url: string;
+        thumbnailUrl: string | null;
+        filename: string;
+        contentType: string;
+        sizeBytes: number;
+    }[];
 };
 
 export function OpsIssuesClient({ role }: { role: string }) {
@@ -217,6 +225,33 @@ export function OpsIssuesClient({ role }: { role: string }) {
                                     {statusBadge(selected.status)}
                                 </div>
 
+                                {(selected.attachments ?? []).length > 0 ? (
+                                    <div className="space-y-1">
+                                        <div className="text-xs text-muted-foreground">Lampiran:</div>
+                                        <div className="flex flex-wrap gap-2 text-xs">
+                                            {(selected.attachments ?? []).map((a) => (
+                                                <a
+                                                    key={a.id}
+                                                    href={a.url}
+                                                    target="_blank"
+                                                    rel="noreferrer"
+                                                    title={a.filename}
+                                                    className="flex h-20 w-20 items-center justify-center overflow-hidden rounded-md border border-border bg-muted/30"
+                                                >
+                                                    {a.thumbnailUrl ? (
+                                                        // eslint-disable-next-line @next/next/no-img-element
+                                                        <img src={a.thumbnailUrl} alt={a.filename} className="h-full w-full object-cover" />
+                                                    ) : (
+                                                        <span className="px-1 text-center font-medium">
+                                                            {a.contentType === "application/pdf" ? "PDF" : a.filename || "File"}
+                                                        </span>
+                                                    )}
+                                                </a>
+                                            ))}
+                                        </div>
+                                    </div>
+                                ) : null}
+
                                 {(selected.metadata?.evidenceUrls ?? []).length > 0 ? (
                                     <div className="space-y-1">
                                         <div className="text-xs text-muted-foreground">Bukti foto:</div>
@@ -14,6 +14,28 @@ type LogisticsMap = {
     warehouses: { id: number; name: string; lat: number; lng: number }[];
     distributors: { id: number; name: string; lat: number; lng: number }[];
     routes: { polyline: { lat: number; lng: number }[] }[];
+    activeTransfers?: TransferSummary[];
+    activeDispatches?: DispatchSummary[];
+};
+
+type DispatchSummary = {
+    id: number;
+    status: string;
+    cementType: string;
+    quantityTons: number;
+    etaMinutes: number;
+    plant: { id: number; name: string };
+    toWarehouse: { id: number; name: string };
+};
+
+type TransferSummary = {
+    id: number;
+    status: string;
+    cementType: string;
+    quantityTons: number;
+    etaMinutes: number;
+    fromWarehouse: { id: number; name: string };
+    toWarehouse: { id: number; name: string };
 };
 
 type ShipmentSummary = {
@@ -39,6 +61,7 @@ function statusBadge(s: string) {
     if (s === "ON_DELIVERY") return <Badge variant="default">ON DELIVERY</Badge>;
     if (s === "DELAYED") return <Badge variant="warning">DELAYED</Badge>;
     if (s === "SCHEDULED") return <Badge variant="secondary">SCHEDULED</Badge>;
+    if (s === "IN_TRANSIT") return <Badge variant="default">IN TRANSIT</Badge>;
     return <Badge variant="secondary">{s}</Badge>;
 }
 
@@ -139,6 +162,57 @@ export function LogisticsMapClient() {
                             ) : null}
                         </CardContent>
                     </Card>
+
+                    <Card className="mt-4">
+                        <CardHeader>
+                            <CardTitle>Transfer &amp; Kiriman Pabrik</CardTitle>
+                        </CardHeader>
+                        <CardContent>
+                            <div className="max-h-[320px] overflow-auto rounded-md border border-border">
+                                <Table>
+                                    <THead>
+                                        <TR>
+                                            <TH>ID</TH>
+                                            <TH>Rute</TH>
+                                            <TH>Status</TH>
+                                            <TH className="text-right">Ton</TH>
+                                        </TR>
+                                    </THead>
+                                    <TBody>
+                                        {(logistics?.activeTransfers ?? []).map((t) => (
+                                            <TR key={t.id}>
+                                                <TD className="font-medium">#{t.id}</TD>
+                                                <TD className="text-xs">
+                                                    {t.fromWarehouse.name} → {t.toWarehouse.name}
+                                                    <div className="text-muted-foreground">{t.cementType}</div>
+                                                </TD>
+                                                <TD>{statusBadge(t.status)}</TD>
+                                                <TD className="text-right font-mono">{t.quantityTons.toLocaleString("id")}</TD>
+                                            </TR>
+                                        ))}
+                                        {(logistics?.activeDispatches ?? []).map((d) => (
+                                            <TR key={`dispatch-${d.id}`}>
+                                                <TD className="font-medium">P#{d.id}</TD>
+                                                <TD className="text-xs">
+                                                    {d.plant.name} → {d.toWarehouse.name}
+                                                    <div className="text-muted-foreground">{d.cementType}</div>
+                                                </TD>
+                                                <TD>{statusBadge(d.status)}</TD>
+                                                <TD className="text-right font-mono">{d.quantityTons.toLocaleString("id")}</TD>
+                                            </TR>
+                                        ))}
+                                        {(logistics?.activeTransfers ?? []).length + (logistics?.activeDispatches ?? []).length === 0 ? (
+                                            <TR>
+                                                <TD colSpan={4} className="py-6 text-center text-sm text-muted-foreground">
+                                                    Tidak ada transfer aktif.
+                                                </TD>
+                                            </TR>
+                                        ) : null}
+                                    </TBody>
+                                </Table>
+                            </div>
+                        </CardContent>
+                    </Card>
                 </div>
             </div>
         </div>
@@ -13,6 +13,13 @@ L.Icon.Default.mergeOptions({
         "https://cdnjs.cloudflare.com/ajax/libs/leaflet/1.9.4/images/marker-shadow.png",
 });
 
+type MovingStock = {
+    id: number;
+    status: string;
+    position?: { lat: number | null; lng: number | null };
+    polyline: { lat: number; lng: number }[];
+};
+
 export default function OpsMap({
     logistics,
     shipment,
@@ -39,6 +46,8 @@ export default function OpsMap({
             warehouses?: { id: number; lat: number; lng: number }[];
             distributors?: { id: number; lat: number; lng: number }[];
             routes?: { polyline: { lat: number; lng: number }[] }[];
+            activeTransfers?: MovingStock[];
+            activeDispatches?: MovingStock[];
         }
         | null;
     const center: [number, number] = [-6.25, 106.9];
@@ -47,6 +56,10 @@ export default function OpsMap({
     const plant = typed?.plant;
     const warehouses = typed?.warehouses ?? [];
     const distributors = typed?.distributors ?? [];
+    const moving = [
+        ...(typed?.activeTransfers ?? []).map((t) => ({ ...t, key: `transfer-${t.id}`, color: "#7c3aed" })),
+        ...(typed?.activeDispatches ?? []).map((d) => ({ ...d, key: `dispatch-${d.id}`, color: "#b45309" })),
+    ];
 
     const truckPos = (() => {
         if (!shipment || typeof shipment !== "object") return null;
@@ -104,6 +117,28 @@ export default function OpsMap({
                     }
                 />
             ))}
+            {moving.map((t) => (
+                <Polyline
+                    key={t.key}
+                    positions={t.polyline.map((p) => [p.lat, p.lng])}
+                    pathOptions={{
+                        color: t.color,
+                        weight: 3,
+                        opacity: t.status === "IN_TRANSIT" ? 0.9 : 0.5,
+                        dashArray: "6 6",
+                    }}
+                />
+            ))}
+            {moving.map((t) =>
+                t.position?.lat != null && t.position?.lng != null ? (
+                    <CircleMarker
+                        key={`${t.key}-pos`}
+                        center={[t.position.lat, t.position.lng]}
+                        radius={5}
+                        pathOptions={{ color: t.color, fillOpacity: 0.9 }}
+                    />
+                ) : null,
+            )}
             {truckPos ? <Marker position={truckPos} /> : null}
         </MapContainer>
     );
@@ -0,0 +1,38 @@
+// The API rejects cookie-authenticated POST/PUT/PATCH/DELETE requests unless
+// they echo the readable `cementops_csrf` cookie in the X-CSRF-Token header.
+// Components call fetch directly, so the header is added here once for every
+// same-origin request instead of at each call site.
+
+const CSRF_COOKIE = "cementops_csrf";
+const CSRF_HEADER = "X-CSRF-Token";
+const SAFE_METHODS = new Set(["GET", "HEAD", "OPTIONS"]);
+
+function readCsrfCookie(): string | null {
+    const prefix = `${CSRF_COOKIE}=`;
+    for (const part of document.cookie.split(";")) {
+        const c = part.trim();
+        if (c.startsWith(prefix)) return decodeURIComponent(c.slice(prefix.length));
+    }
+    return null;
+}
+
+let installed = false;
+
+export function installCsrfFetch() {
+    if (installed || typeof window === "undefined") return;
+    installed = true;
+
+    const originalFetch = window.fetch.bind(window);
+    window.fetch = (input: RequestInfo | URL, init?: RequestInit) => {
+        const request = input instanceof Request ? input : null;
+        const method = (init?.method ?? request?.method ?? "GET").toUpperCase();
+        const url = new URL(request?.url ?? input.toString(), window.location.href);
+        const token = readCsrfCookie();
+        if (SAFE_METHODS.has(method) || url.origin !== window.location.origin || !token) {
+            return originalFetch(input, init);
+        }
+        const headers = new Headers(init?.headers ?? request?.headers);
+        headers.set(CSRF_HEADER, token);
+        return originalFetch(input, { ...init, headers });
+    };
+}
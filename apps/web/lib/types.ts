@@ -9,4 +9,5 @@ export type Me = {
     name: string;
     email: string;
     role: Role;
+    roleKind?: "ADMIN" | "STAFF" | "DISTRIBUTOR";
 };
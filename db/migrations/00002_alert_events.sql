@@ -0,0 +1,49 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Alert evaluation ────────────────────────────────────────────────────────
+
+-- rule_type tells the evaluator which check an alert config drives; names are
+-- free-form and editable from the admin UI, so they cannot be used for dispatch.
+ALTER TABLE alert_configs
+  ADD COLUMN IF NOT EXISTS rule_type TEXT NOT NULL DEFAULT '';
+
+UPDATE alert_configs
+SET rule_type = CASE name
+  WHEN 'Stock Critical' THEN 'STOCK_CRITICAL'
+  WHEN 'Shipment Delay' THEN 'SHIPMENT_DELAY'
+  WHEN 'Demand Spike'   THEN 'DEMAND_SPIKE'
+  ELSE ''
+END
+WHERE rule_type = '';
+
+CREATE TABLE IF NOT EXISTS alert_events (
+  id                 BIGSERIAL PRIMARY KEY,
+  alert_config_id    BIGINT NOT NULL REFERENCES alert_configs(id) ON DELETE CASCADE,
+  rule_type          TEXT NOT NULL,
+  dedup_key          TEXT NOT NULL,
+  severity           TEXT NOT NULL,
+  title              TEXT NOT NULL,
+  message            TEXT NOT NULL DEFAULT '',
+  entity_type        TEXT NOT NULL DEFAULT '',
+  entity_id          TEXT NOT NULL DEFAULT '',
+  metadata           JSONB NOT NULL DEFAULT '{}'::jsonb,
+  recipient_user_ids BIGINT[] NOT NULL DEFAULT '{}'::bigint[],
+  first_seen_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_seen_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+  resolved_at        TIMESTAMPTZ
+);
+
+-- At most one open event per rule + subject; a resolved event may fire again later.
+CREATE UNIQUE INDEX IF NOT EXISTS alert_events_open_dedup_idx
+  ON alert_events(alert_config_id, dedup_key)
+  WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS alert_events_first_seen_at_idx ON alert_events(first_seen_at DESC);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS alert_events;
+ALTER TABLE alert_configs DROP COLUMN IF EXISTS rule_type;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Email outbox ────────────────────────────────────────────────────────────
+
+-- Rows are written in the same transaction as the change that triggers them and
+-- drained by the API's background mailer worker.
+CREATE TABLE IF NOT EXISTS email_outbox (
+  id              BIGSERIAL PRIMARY KEY,
+  template        TEXT NOT NULL,
+  to_address      TEXT NOT NULL,
+  subject         TEXT NOT NULL,
+  body_text       TEXT NOT NULL,
+  attempts        INT NOT NULL DEFAULT 0,
+  last_error      TEXT NOT NULL DEFAULT '',
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  sent_at         TIMESTAMPTZ,
+  failed_at       TIMESTAMPTZ,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx
+  ON email_outbox(next_attempt_at)
+  WHERE sent_at IS NULL AND failed_at IS NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS email_outbox;
+-- +goose StatementEnd
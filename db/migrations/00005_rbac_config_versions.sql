@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── RBAC config history ─────────────────────────────────────────────────────
+
+-- rbac_config holds the live document; every saved document (including rollbacks)
+-- is appended here with a per-role version number.
+ALTER TABLE rbac_config
+  ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS rbac_config_versions (
+  id                 BIGSERIAL PRIMARY KEY,
+  role               TEXT NOT NULL,
+  version            INT NOT NULL,
+  config             JSONB NOT NULL,
+  created_by_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  rolled_back_from   INT,
+  created_at         TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (role, version)
+);
+
+INSERT INTO rbac_config_versions (role, version, config, created_at)
+SELECT role, version, config, updated_at
+FROM rbac_config
+ON CONFLICT (role, version) DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS rbac_config_versions;
+ALTER TABLE rbac_config DROP COLUMN IF EXISTS version;
+-- +goose StatementEnd
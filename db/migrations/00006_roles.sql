@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Roles ───────────────────────────────────────────────────────────────────
+
+-- kind decides how the API treats a role:
+--   ADMIN       bypasses the rbac_config permission matrix (SUPER_ADMIN only)
+--   STAFF       internal users, access resolved from rbac_config
+--   DISTRIBUTOR portal users, must be linked to a distributor
+CREATE TABLE IF NOT EXISTS roles (
+  key         TEXT PRIMARY KEY,
+  name        TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  kind        TEXT NOT NULL DEFAULT 'STAFF',
+  system      BOOLEAN NOT NULL DEFAULT false,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT roles_key_check CHECK (key ~ '^[A-Z][A-Z0-9_]{1,39}$'),
+  CONSTRAINT roles_kind_check CHECK (kind IN ('ADMIN','STAFF','DISTRIBUTOR'))
+);
+
+INSERT INTO roles (key, name, description, kind, system)
+VALUES
+  ('SUPER_ADMIN', 'Super Admin', 'Full access to every module.', 'ADMIN', true),
+  ('MANAGEMENT',  'Management',  'Monitors operations and executive reporting.', 'STAFF', true),
+  ('OPERATOR',    'Operator',    'Runs day-to-day operations.', 'STAFF', true),
+  ('DISTRIBUTOR', 'Distributor', 'Distributor portal user.', 'DISTRIBUTOR', true)
+ON CONFLICT (key) DO NOTHING;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users
+  ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(key);
+
+ALTER TABLE rbac_config DROP CONSTRAINT IF EXISTS rbac_config_role_check;
+ALTER TABLE rbac_config
+  ADD CONSTRAINT rbac_config_role_fkey FOREIGN KEY (role) REFERENCES roles(key) ON DELETE CASCADE;
+
+ALTER TABLE rbac_config_versions
+  ADD CONSTRAINT rbac_config_versions_role_fkey FOREIGN KEY (role) REFERENCES roles(key) ON DELETE CASCADE;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE rbac_config_versions DROP CONSTRAINT IF EXISTS rbac_config_versions_role_fkey;
+ALTER TABLE rbac_config DROP CONSTRAINT IF EXISTS rbac_config_role_fkey;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_fkey;
+DELETE FROM rbac_config WHERE role NOT IN ('SUPER_ADMIN','MANAGEMENT','OPERATOR','DISTRIBUTOR');
+ALTER TABLE rbac_config
+  ADD CONSTRAINT rbac_config_role_check CHECK (role IN ('SUPER_ADMIN','MANAGEMENT','OPERATOR','DISTRIBUTOR'));
+ALTER TABLE users
+  ADD CONSTRAINT users_role_check CHECK (role IN ('SUPER_ADMIN','MANAGEMENT','OPERATOR','DISTRIBUTOR'));
+DROP TABLE IF EXISTS roles;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── User scopes ─────────────────────────────────────────────────────────────
+
+-- Restrict staff users to a subset of warehouses and/or distributors (their
+-- territory). A user with no rows in a table is unrestricted on that axis, so
+-- warehouse/distributor references deliberately do not cascade: dropping a
+-- user's last assignment must never silently widen their access.
+CREATE TABLE IF NOT EXISTS user_warehouse_scopes (
+  user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  warehouse_id BIGINT NOT NULL REFERENCES warehouses(id),
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, warehouse_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_distributor_scopes (
+  user_id        BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  distributor_id BIGINT NOT NULL REFERENCES distributors(id),
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, distributor_id)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_distributor_scopes;
+DROP TABLE IF EXISTS user_warehouse_scopes;
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Login attempts & lockouts ───────────────────────────────────────────────
+
+-- Every password login attempt, successful or not. email is what was submitted,
+-- user_id is set only when it matched an account.
+CREATE TABLE IF NOT EXISTS login_attempts (
+  id         BIGSERIAL PRIMARY KEY,
+  email      TEXT NOT NULL,
+  user_id    BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  ip         TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  success    BOOLEAN NOT NULL,
+  reason     TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_created_idx ON login_attempts(created_at DESC);
+CREATE INDEX IF NOT EXISTS login_attempts_email_idx ON login_attempts(email, created_at DESC);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts(ip, created_at DESC);
+
+-- Failure counters per account (scope EMAIL) and per client address (scope IP).
+-- A row is locked while locked_until is in the future; the counter restarts once
+-- window_start falls outside the failure window.
+CREATE TABLE IF NOT EXISTS login_lockouts (
+  scope         TEXT NOT NULL,
+  subject       TEXT NOT NULL,
+  failures      INT NOT NULL DEFAULT 0,
+  window_start  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  locked_until  TIMESTAMPTZ,
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (scope, subject),
+  CONSTRAINT login_lockouts_scope_check CHECK (scope IN ('EMAIL','IP'))
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_lockouts;
+DROP TABLE IF EXISTS login_attempts;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Session activity ────────────────────────────────────────────────────────
+
+-- last_seen_at drives the idle timeout; ip and user_agent let users recognise
+-- their own sessions when reviewing or revoking them.
+ALTER TABLE sessions
+  ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ADD COLUMN IF NOT EXISTS ip           TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS user_agent   TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS sessions_last_seen_at_idx ON sessions(last_seen_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS sessions_last_seen_at_idx;
+ALTER TABLE sessions
+  DROP COLUMN IF EXISTS user_agent,
+  DROP COLUMN IF EXISTS ip,
+  DROP COLUMN IF EXISTS last_seen_at;
+-- +goose StatementEnd
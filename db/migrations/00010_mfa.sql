@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Two-factor authentication ───────────────────────────────────────────────
+
+-- Per-role policy: when true, users of the role must complete TOTP (enrolling
+-- first if needed) before a session is issued. Off by default so existing
+-- accounts keep working until an administrator turns it on.
+ALTER TABLE rbac_config
+  ADD COLUMN IF NOT EXISTS mfa_required BOOLEAN NOT NULL DEFAULT false;
+
+-- secret is the AES-GCM sealed base32 TOTP seed. enabled_at stays NULL until the
+-- first code is verified. last_step is the last accepted time step, so a code
+-- cannot be used twice.
+CREATE TABLE IF NOT EXISTS user_mfa (
+  user_id    BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  secret     TEXT NOT NULL,
+  enabled_at TIMESTAMPTZ,
+  last_step  BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Single-use recovery codes, stored as SHA-256 hex digests.
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+  id         BIGSERIAL PRIMARY KEY,
+  user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash  TEXT NOT NULL,
+  used_at    TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_recovery_codes_user_idx ON user_recovery_codes(user_id);
+
+-- Pending second login step, created after the password check succeeds.
+CREATE TABLE IF NOT EXISTS login_challenges (
+  id         UUID PRIMARY KEY,
+  user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  attempts   INT NOT NULL DEFAULT 0,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_challenges_expires_idx ON login_challenges(expires_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_challenges;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
+ALTER TABLE rbac_config DROP COLUMN IF EXISTS mfa_required;
+-- +goose StatementEnd
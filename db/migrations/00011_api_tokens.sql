@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── API tokens ──────────────────────────────────────────────────────────────
+
+-- Bearer tokens for integrations. A token acts as its user and is further
+-- limited to its scopes ("Module.action", e.g. "Operations.view"). Only the
+-- SHA-256 hex digest is stored; token_prefix is kept so tokens can be told apart.
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id                 BIGSERIAL PRIMARY KEY,
+  user_id            BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name               TEXT NOT NULL,
+  token_prefix       TEXT NOT NULL,
+  token_hash         TEXT NOT NULL UNIQUE,
+  scopes             TEXT[] NOT NULL DEFAULT '{}',
+  expires_at         TIMESTAMPTZ,
+  last_used_at       TIMESTAMPTZ,
+  last_used_ip       TEXT NOT NULL DEFAULT '',
+  revoked_at         TIMESTAMPTZ,
+  created_by_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON api_tokens(user_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS api_tokens;
+-- +goose StatementEnd
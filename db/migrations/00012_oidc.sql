@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── OIDC single sign-on ─────────────────────────────────────────────────────
+
+-- Links an identity-provider account (issuer + subject) to a local user. The
+-- subject, not the email, is what identifies the account on later sign-ins.
+CREATE TABLE IF NOT EXISTS user_identities (
+  issuer        TEXT NOT NULL,
+  subject       TEXT NOT NULL,
+  user_id       BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email         TEXT NOT NULL DEFAULT '',
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities(user_id);
+
+-- Pending authorization requests, consumed by the callback. The state value is
+-- also set as a cookie so a callback only completes in the browser that started it.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+  state         TEXT PRIMARY KEY,
+  nonce         TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  redirect_to   TEXT NOT NULL DEFAULT '/dashboard',
+  expires_at    TIMESTAMPTZ NOT NULL,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- How the first factor was satisfied, so the session audit entry after 2FA
+-- still says whether the user came through a password or SSO.
+ALTER TABLE login_challenges
+  ADD COLUMN IF NOT EXISTS method TEXT NOT NULL DEFAULT 'password';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE login_challenges DROP COLUMN IF EXISTS method;
+DROP TABLE IF EXISTS oidc_login_states;
+DROP TABLE IF EXISTS user_identities;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Password reset ──────────────────────────────────────────────────────────
+
+-- Self-service reset links. Only the SHA-256 hex digest of the token is
+-- stored; a token is single use (used_at) and expires at expires_at.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+  id         BIGSERIAL PRIMARY KEY,
+  user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash TEXT NOT NULL UNIQUE,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at    TIMESTAMPTZ,
+  ip         TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user_idx ON password_reset_tokens(user_id, created_at DESC);
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMPTZ;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
+DROP TABLE IF EXISTS password_reset_tokens;
+-- +goose StatementEnd
@@ -0,0 +1,119 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Audit hash chain ────────────────────────────────────────────────────────
+
+-- Every audit_logs row carries the SHA-256 of its own fields chained to the
+-- previous row's hash (chain_seq order), so editing, deleting or reordering
+-- history is detectable by GET /api/admin/logs/verify. The hashed payload is
+-- each field as "<byte length>:<value>"; auditChainHash in the API computes the
+-- same thing and the two must stay in sync.
+
+-- Audit rows must never change, so deleting a user no longer nulls out
+-- actor_user_id; the id stays as a plain reference.
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_actor_user_id_fkey;
+
+ALTER TABLE audit_logs
+  ADD COLUMN IF NOT EXISTS chain_seq BIGINT,
+  ADD COLUMN IF NOT EXISTS prev_hash TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS hash      TEXT NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION audit_log_hash(
+  prev TEXT, seq BIGINT, ts TIMESTAMPTZ, actor BIGINT, action TEXT,
+  entity_type TEXT, entity_id TEXT, metadata JSONB, ip TEXT
+) RETURNS TEXT LANGUAGE sql STABLE AS $$
+  SELECT encode(sha256(convert_to(string_agg(octet_length(f)::text || ':' || f, '' ORDER BY n), 'UTF8')), 'hex')
+  FROM unnest(ARRAY[
+    prev,
+    seq::text,
+    to_char(ts AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+    COALESCE(actor::text, ''),
+    action,
+    entity_type,
+    entity_id,
+    metadata::text,
+    ip
+  ]) WITH ORDINALITY AS t(f, n)
+$$;
+
+-- Backfill the chain over existing rows in id order.
+DO $$
+DECLARE
+  r    RECORD;
+  seq  BIGINT := 0;
+  prev TEXT := '';
+BEGIN
+  FOR r IN SELECT * FROM audit_logs ORDER BY id LOOP
+    seq := seq + 1;
+    UPDATE audit_logs
+    SET chain_seq = seq,
+        prev_hash = prev,
+        hash = audit_log_hash(prev, seq, r.ts, r.actor_user_id, r.action, r.entity_type, r.entity_id, r.metadata, r.ip)
+    WHERE id = r.id
+    RETURNING hash INTO prev;
+  END LOOP;
+END $$;
+
+ALTER TABLE audit_logs ALTER COLUMN chain_seq SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS audit_logs_chain_seq_idx ON audit_logs(chain_seq);
+
+-- Appends are serialised with a transaction-scoped advisory lock so each row
+-- links to the last committed one; the lock is held until the inserting
+-- transaction ends.
+CREATE OR REPLACE FUNCTION audit_logs_chain() RETURNS trigger LANGUAGE plpgsql AS $$
+DECLARE
+  last_seq  BIGINT;
+  last_hash TEXT;
+BEGIN
+  PERFORM pg_advisory_xact_lock(hashtext('audit_logs_chain'));
+  SELECT chain_seq, hash INTO last_seq, last_hash
+  FROM audit_logs
+  ORDER BY chain_seq DESC
+  LIMIT 1;
+  NEW.chain_seq := COALESCE(last_seq, 0) + 1;
+  NEW.prev_hash := COALESCE(last_hash, '');
+  NEW.hash := audit_log_hash(NEW.prev_hash, NEW.chain_seq, NEW.ts, NEW.actor_user_id, NEW.action,
+                             NEW.entity_type, NEW.entity_id, NEW.metadata, NEW.ip);
+  RETURN NEW;
+END $$;
+
+CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_logs is append-only (% rejected)', TG_OP;
+END $$;
+
+DROP TRIGGER IF EXISTS audit_logs_chain ON audit_logs;
+CREATE TRIGGER audit_logs_chain
+  BEFORE INSERT ON audit_logs
+  FOR EACH ROW EXECUTE FUNCTION audit_logs_chain();
+
+DROP TRIGGER IF EXISTS audit_logs_append_only ON audit_logs;
+CREATE TRIGGER audit_logs_append_only
+  BEFORE UPDATE OR DELETE ON audit_logs
+  FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only();
+
+DROP TRIGGER IF EXISTS audit_logs_no_truncate ON audit_logs;
+CREATE TRIGGER audit_logs_no_truncate
+  BEFORE TRUNCATE ON audit_logs
+  FOR EACH STATEMENT EXECUTE FUNCTION audit_logs_append_only();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS audit_logs_no_truncate ON audit_logs;
+DROP TRIGGER IF EXISTS audit_logs_append_only ON audit_logs;
+DROP TRIGGER IF EXISTS audit_logs_chain ON audit_logs;
+DROP FUNCTION IF EXISTS audit_logs_append_only();
+DROP FUNCTION IF EXISTS audit_logs_chain();
+DROP FUNCTION IF EXISTS audit_log_hash(TEXT, BIGINT, TIMESTAMPTZ, BIGINT, TEXT, TEXT, TEXT, JSONB, TEXT);
+DROP INDEX IF EXISTS audit_logs_chain_seq_idx;
+ALTER TABLE audit_logs
+  DROP COLUMN IF EXISTS hash,
+  DROP COLUMN IF EXISTS prev_hash,
+  DROP COLUMN IF EXISTS chain_seq;
+UPDATE audit_logs l SET actor_user_id = NULL
+WHERE actor_user_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id = l.actor_user_id);
+ALTER TABLE audit_logs
+  ADD CONSTRAINT audit_logs_actor_user_id_fkey FOREIGN KEY (actor_user_id) REFERENCES users(id) ON DELETE SET NULL;
+-- +goose StatementEnd
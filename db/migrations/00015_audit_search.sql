@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Audit log search ────────────────────────────────────────────────────────
+
+-- Keyset pagination walks (ts, id) newest first; this replaces the ts-only index.
+CREATE INDEX IF NOT EXISTS audit_logs_ts_id_idx ON audit_logs(ts DESC, id DESC);
+DROP INDEX IF EXISTS audit_logs_ts_idx;
+
+CREATE INDEX IF NOT EXISTS audit_logs_action_idx ON audit_logs(action, ts DESC);
+CREATE INDEX IF NOT EXISTS audit_logs_entity_idx ON audit_logs(entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_logs_ip_idx ON audit_logs(ip);
+
+-- Full-text search over metadata keys and values (the q filter).
+CREATE INDEX IF NOT EXISTS audit_logs_metadata_fts_idx
+  ON audit_logs USING GIN (jsonb_to_tsvector('simple', metadata, '["all"]'));
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS audit_logs_metadata_fts_idx;
+DROP INDEX IF EXISTS audit_logs_ip_idx;
+DROP INDEX IF EXISTS audit_logs_entity_idx;
+DROP INDEX IF EXISTS audit_logs_action_idx;
+CREATE INDEX IF NOT EXISTS audit_logs_ts_idx ON audit_logs(ts DESC);
+DROP INDEX IF EXISTS audit_logs_ts_id_idx;
+-- +goose StatementEnd
@@ -0,0 +1,106 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Retention archive ───────────────────────────────────────────────────────
+
+-- Rows past their retention period move here from audit_logs and
+-- inventory_movements (see internal/retention). Both archives are partitioned
+-- by calendar month (UTC) so old ranges can be scanned, backed up or detached
+-- one partition at a time; ensure_archive_partition creates them on demand.
+-- Audit rows keep chain_seq, prev_hash and hash unchanged, so the archive
+-- followed by the live table is still one verifiable chain.
+CREATE TABLE IF NOT EXISTS audit_logs_archive (
+  id            BIGINT NOT NULL,
+  ts            TIMESTAMPTZ NOT NULL,
+  actor_user_id BIGINT,
+  action        TEXT NOT NULL,
+  entity_type   TEXT NOT NULL,
+  entity_id     TEXT NOT NULL,
+  metadata      JSONB NOT NULL DEFAULT '{}'::jsonb,
+  ip            TEXT NOT NULL DEFAULT '',
+  chain_seq     BIGINT NOT NULL,
+  prev_hash     TEXT NOT NULL,
+  hash          TEXT NOT NULL,
+  archived_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+) PARTITION BY RANGE (ts);
+
+CREATE INDEX IF NOT EXISTS audit_logs_archive_chain_seq_idx ON audit_logs_archive(chain_seq);
+CREATE INDEX IF NOT EXISTS audit_logs_archive_ts_id_idx ON audit_logs_archive(ts DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_logs_archive_entity_idx ON audit_logs_archive(entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_logs_archive_metadata_fts_idx
+  ON audit_logs_archive USING GIN (jsonb_to_tsvector('simple', metadata, '["all"]'));
+
+CREATE TABLE IF NOT EXISTS inventory_movements_archive (
+  id            BIGINT NOT NULL,
+  ts            TIMESTAMPTZ NOT NULL,
+  actor_user_id BIGINT,
+  warehouse_id  BIGINT NOT NULL,
+  cement_type   TEXT NOT NULL,
+  movement_type TEXT NOT NULL,
+  quantity_tons DOUBLE PRECISION NOT NULL,
+  reason        TEXT NOT NULL DEFAULT '',
+  ref_type      TEXT NOT NULL DEFAULT '',
+  ref_id        TEXT NOT NULL DEFAULT '',
+  metadata      JSONB NOT NULL DEFAULT '{}'::jsonb,
+  archived_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+) PARTITION BY RANGE (ts);
+
+CREATE INDEX IF NOT EXISTS inventory_movements_archive_ts_id_idx ON inventory_movements_archive(ts DESC, id DESC);
+CREATE INDEX IF NOT EXISTS inventory_movements_archive_wh_ct_idx ON inventory_movements_archive(warehouse_id, cement_type, ts DESC);
+
+-- Creates (if needed) the monthly partition of parent that holds ts and
+-- returns its name, e.g. audit_logs_archive_2025_01.
+CREATE OR REPLACE FUNCTION ensure_archive_partition(parent TEXT, ts TIMESTAMPTZ) RETURNS TEXT LANGUAGE plpgsql AS $$
+DECLARE
+  month_start TIMESTAMP := date_trunc('month', ts AT TIME ZONE 'UTC');
+  part        TEXT := parent || '_' || to_char(month_start, 'YYYY_MM');
+BEGIN
+  EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+    part, parent,
+    month_start AT TIME ZONE 'UTC',
+    (month_start + INTERVAL '1 month') AT TIME ZONE 'UTC');
+  RETURN part;
+END $$;
+
+-- Archived audit rows are as immutable as live ones.
+DROP TRIGGER IF EXISTS audit_logs_archive_append_only ON audit_logs_archive;
+CREATE TRIGGER audit_logs_archive_append_only
+  BEFORE UPDATE OR DELETE ON audit_logs_archive
+  FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only();
+
+-- A live audit row may now be deleted, but only once an identical copy (same
+-- chain_seq and hash) is in the archive.
+CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+  IF TG_OP = 'DELETE' AND TG_TABLE_NAME = 'audit_logs' AND EXISTS (
+    SELECT 1 FROM audit_logs_archive a WHERE a.chain_seq = OLD.chain_seq AND a.hash = OLD.hash
+  ) THEN
+    RETURN OLD;
+  END IF;
+  RAISE EXCEPTION '% is append-only (% rejected)', TG_TABLE_NAME, TG_OP;
+END $$;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Put archived rows back before dropping the archive; audit rows keep their
+-- original chain fields, so the chain trigger is bypassed while they return.
+ALTER TABLE audit_logs DISABLE TRIGGER audit_logs_chain;
+INSERT INTO audit_logs (id, ts, actor_user_id, action, entity_type, entity_id, metadata, ip, chain_seq, prev_hash, hash)
+SELECT id, ts, actor_user_id, action, entity_type, entity_id, metadata, ip, chain_seq, prev_hash, hash
+FROM audit_logs_archive;
+ALTER TABLE audit_logs ENABLE TRIGGER audit_logs_chain;
+INSERT INTO inventory_movements (id, ts, actor_user_id, warehouse_id, cement_type, movement_type, quantity_tons, reason, ref_type, ref_id, metadata)
+SELECT id, ts, (SELECT u.id FROM users u WHERE u.id = a.actor_user_id), warehouse_id, cement_type, movement_type,
+       quantity_tons, reason, ref_type, ref_id, metadata
+FROM inventory_movements_archive a
+WHERE EXISTS (SELECT 1 FROM warehouses w WHERE w.id = a.warehouse_id);
+CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_logs is append-only (% rejected)', TG_OP;
+END $$;
+DROP FUNCTION IF EXISTS ensure_archive_partition(TEXT, TIMESTAMPTZ);
+DROP TABLE IF EXISTS inventory_movements_archive;
+DROP TABLE IF EXISTS audit_logs_archive;
+-- +goose StatementEnd
@@ -0,0 +1,63 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Master data history ─────────────────────────────────────────────────────
+
+-- Row snapshots of plants, warehouses, distributors, stores and projects,
+-- written by the entity_history trigger on every insert, update and delete.
+-- before is NULL for an insert and after is NULL for a delete. The acting user
+-- is taken from the transaction-local app.actor_user_id setting.
+CREATE TABLE IF NOT EXISTS entity_history (
+  id            BIGSERIAL PRIMARY KEY,
+  entity_type   TEXT NOT NULL,
+  entity_id     BIGINT NOT NULL,
+  op            TEXT NOT NULL CHECK (op IN ('INSERT','UPDATE','DELETE')),
+  before        JSONB,
+  after         JSONB,
+  actor_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  ts            TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS entity_history_entity_idx ON entity_history(entity_type, entity_id, id DESC);
+
+CREATE OR REPLACE FUNCTION entity_history() RETURNS trigger LANGUAGE plpgsql AS $$
+DECLARE
+  actor BIGINT := NULLIF(current_setting('app.actor_user_id', true), '')::BIGINT;
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    INSERT INTO entity_history (entity_type, entity_id, op, after, actor_user_id)
+    VALUES (TG_TABLE_NAME, NEW.id, TG_OP, to_jsonb(NEW), actor);
+  ELSIF TG_OP = 'UPDATE' THEN
+    IF to_jsonb(NEW) IS DISTINCT FROM to_jsonb(OLD) THEN
+      INSERT INTO entity_history (entity_type, entity_id, op, before, after, actor_user_id)
+      VALUES (TG_TABLE_NAME, NEW.id, TG_OP, to_jsonb(OLD), to_jsonb(NEW), actor);
+    END IF;
+  ELSE
+    INSERT INTO entity_history (entity_type, entity_id, op, before, actor_user_id)
+    VALUES (TG_TABLE_NAME, OLD.id, TG_OP, to_jsonb(OLD), actor);
+  END IF;
+  RETURN NULL;
+END $$;
+
+DO $$
+DECLARE
+  t TEXT;
+BEGIN
+  FOREACH t IN ARRAY ARRAY['plants','warehouses','distributors','stores','projects'] LOOP
+    EXECUTE format('DROP TRIGGER IF EXISTS %I ON %I', t || '_history', t);
+    EXECUTE format('CREATE TRIGGER %I AFTER INSERT OR UPDATE OR DELETE ON %I FOR EACH ROW EXECUTE FUNCTION entity_history()', t || '_history', t);
+  END LOOP;
+END $$;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS plants_history ON plants;
+DROP TRIGGER IF EXISTS warehouses_history ON warehouses;
+DROP TRIGGER IF EXISTS distributors_history ON distributors;
+DROP TRIGGER IF EXISTS stores_history ON stores;
+DROP TRIGGER IF EXISTS projects_history ON projects;
+DROP FUNCTION IF EXISTS entity_history();
+DROP TABLE IF EXISTS entity_history;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Master data soft delete ─────────────────────────────────────────────────
+
+-- Deleting master data through the admin API sets archived_at instead of
+-- removing the row, so shipments, orders, stock and sales keep their
+-- references. Archived rows are hidden from lists and planning until restored.
+ALTER TABLE plants       ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+ALTER TABLE warehouses   ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+ALTER TABLE distributors ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+ALTER TABLE stores       ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+ALTER TABLE projects     ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE projects     DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE stores       DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE distributors DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE warehouses   DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE plants       DROP COLUMN IF EXISTS archived_at;
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Attachments ─────────────────────────────────────────────────────────────
+
+-- Files kept in the configured object store under storage_key. An attachment
+-- belongs to at most one issue, shipment or order request; uploads start
+-- unlinked and are attached when the issue they were picked for is created.
+-- distributor_id and warehouse_id are copied from the uploader or the linked
+-- record and decide who may download the file.
+CREATE TABLE IF NOT EXISTS attachments (
+  id                  BIGSERIAL PRIMARY KEY,
+  storage_key         TEXT NOT NULL UNIQUE,
+  filename            TEXT NOT NULL DEFAULT '',
+  content_type        TEXT NOT NULL,
+  size_bytes          BIGINT NOT NULL CHECK (size_bytes >= 0),
+  sha256              TEXT NOT NULL,
+  uploaded_by_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  distributor_id      BIGINT REFERENCES distributors(id) ON DELETE SET NULL,
+  warehouse_id        BIGINT REFERENCES warehouses(id) ON DELETE SET NULL,
+  issue_id            BIGINT REFERENCES ops_issues(id) ON DELETE SET NULL,
+  shipment_id         BIGINT REFERENCES shipments(id) ON DELETE SET NULL,
+  order_request_id    BIGINT REFERENCES order_requests(id) ON DELETE SET NULL,
+  linked_at           TIMESTAMPTZ,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT attachments_single_target CHECK (num_nonnulls(issue_id, shipment_id, order_request_id) <= 1)
+);
+
+CREATE INDEX IF NOT EXISTS attachments_issue_idx ON attachments(issue_id) WHERE issue_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS attachments_shipment_idx ON attachments(shipment_id) WHERE shipment_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS attachments_order_idx ON attachments(order_request_id) WHERE order_request_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS attachments_uploader_idx ON attachments(uploaded_by_user_id, created_at DESC);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS attachments;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Preview image stored next to an attachment; NULL for types without one
+-- (PDF, WebP).
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_key TEXT UNIQUE;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE attachments DROP COLUMN IF EXISTS thumbnail_key;
+-- +goose StatementEnd
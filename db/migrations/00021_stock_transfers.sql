@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Inter-warehouse transfers ───────────────────────────────────────────────
+
+-- A transfer moves one cement type from one warehouse to another. Creating it
+-- reserves the stock by posting an OUT movement at the source right away, so
+-- the goods stop counting as available there; while SCHEDULED or IN_TRANSIT
+-- the quantity sits in no warehouse and is reported as in transit. Receiving
+-- posts the IN movement at the destination; cancelling a transfer that has not
+-- left returns the stock to the source. Every movement carries
+-- ref_type='stock_transfer' and ref_id=<id>.
+CREATE TABLE IF NOT EXISTS stock_transfers (
+  id                  BIGSERIAL PRIMARY KEY,
+  from_warehouse_id   BIGINT NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  to_warehouse_id     BIGINT NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  cement_type         TEXT NOT NULL,
+  quantity_tons       DOUBLE PRECISION NOT NULL CHECK (quantity_tons > 0),
+  status              TEXT NOT NULL DEFAULT 'SCHEDULED',
+  truck_id            BIGINT REFERENCES trucks(id) ON DELETE SET NULL,
+  reason              TEXT NOT NULL DEFAULT '',
+  depart_at           TIMESTAMPTZ,
+  arrive_eta          TIMESTAMPTZ,
+  received_at         TIMESTAMPTZ,
+  created_by_user_id  BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  received_by_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT stock_transfers_status_check CHECK (status IN ('SCHEDULED','IN_TRANSIT','RECEIVED','CANCELLED')),
+  CONSTRAINT stock_transfers_distinct_warehouses CHECK (from_warehouse_id <> to_warehouse_id)
+);
+
+CREATE INDEX IF NOT EXISTS stock_transfers_open_idx ON stock_transfers(status) WHERE status IN ('SCHEDULED','IN_TRANSIT');
+CREATE INDEX IF NOT EXISTS stock_transfers_from_idx ON stock_transfers(from_warehouse_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS stock_transfers_to_idx ON stock_transfers(to_warehouse_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS inventory_movements_ref_idx ON inventory_movements(ref_type, ref_id) WHERE ref_type <> '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS inventory_movements_ref_idx;
+DROP TABLE IF EXISTS stock_transfers;
+-- +goose StatementEnd
@@ -0,0 +1,83 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- ── Plant production and dispatch ───────────────────────────────────────────
+
+-- How many tons of each cement type a plant can produce per day. Production
+-- can only be recorded for cement types listed here.
+CREATE TABLE IF NOT EXISTS plant_capacities (
+  plant_id            BIGINT NOT NULL REFERENCES plants(id) ON DELETE CASCADE,
+  cement_type         TEXT NOT NULL,
+  daily_capacity_tons DOUBLE PRECISION NOT NULL CHECK (daily_capacity_tons > 0),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (plant_id, cement_type)
+);
+
+-- One row per plant, cement type and day. Re-recording a day replaces it.
+CREATE TABLE IF NOT EXISTS plant_production (
+  id                  BIGSERIAL PRIMARY KEY,
+  plant_id            BIGINT NOT NULL REFERENCES plants(id) ON DELETE RESTRICT,
+  cement_type         TEXT NOT NULL,
+  production_date     DATE NOT NULL,
+  quantity_tons       DOUBLE PRECISION NOT NULL CHECK (quantity_tons >= 0),
+  note                TEXT NOT NULL DEFAULT '',
+  recorded_by_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (plant_id, cement_type, production_date)
+);
+
+CREATE INDEX IF NOT EXISTS plant_production_date_idx ON plant_production(production_date DESC);
+
+-- Replenishment from a plant to a warehouse. The tons leave the plant's
+-- stock when the dispatch is created and reach the warehouse as an IN
+-- movement (ref_type='plant_dispatch', ref_id=<id>) when it is received.
+CREATE TABLE IF NOT EXISTS plant_dispatches (
+  id                  BIGSERIAL PRIMARY KEY,
+  plant_id            BIGINT NOT NULL REFERENCES plants(id) ON DELETE RESTRICT,
+  to_warehouse_id     BIGINT NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  cement_type         TEXT NOT NULL,
+  quantity_tons       DOUBLE PRECISION NOT NULL CHECK (quantity_tons > 0),
+  status              TEXT NOT NULL DEFAULT 'SCHEDULED',
+  truck_id            BIGINT REFERENCES trucks(id) ON DELETE SET NULL,
+  reason              TEXT NOT NULL DEFAULT '',
+  depart_at           TIMESTAMPTZ,
+  arrive_eta          TIMESTAMPTZ,
+  received_at         TIMESTAMPTZ,
+  created_by_user_id  BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  received_by_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT plant_dispatches_status_check CHECK (status IN ('SCHEDULED','IN_TRANSIT','RECEIVED','CANCELLED'))
+);
+
+CREATE INDEX IF NOT EXISTS plant_dispatches_open_idx ON plant_dispatches(status) WHERE status IN ('SCHEDULED','IN_TRANSIT');
+CREATE INDEX IF NOT EXISTS plant_dispatches_plant_idx ON plant_dispatches(plant_id, cement_type);
+CREATE INDEX IF NOT EXISTS plant_dispatches_to_idx ON plant_dispatches(to_warehouse_id, created_at DESC);
+
+-- What each plant still holds: everything produced minus everything
+-- dispatched and not cancelled.
+CREATE OR REPLACE VIEW plant_stock AS
+SELECT plant_id, cement_type,
+       SUM(produced) AS produced_tons,
+       SUM(dispatched) AS dispatched_tons,
+       SUM(produced) - SUM(dispatched) AS available_tons
+FROM (
+  SELECT plant_id, cement_type, quantity_tons AS produced, 0::double precision AS dispatched
+  FROM plant_production
+  UNION ALL
+  SELECT plant_id, cement_type, 0, quantity_tons
+  FROM plant_dispatches
+  WHERE status <> 'CANCELLED'
+) x
+GROUP BY plant_id, cement_type;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP VIEW IF EXISTS plant_stock;
+DROP TABLE IF EXISTS plant_dispatches;
+DROP TABLE IF EXISTS plant_production;
+DROP TABLE IF EXISTS plant_capacities;
+-- +goose StatementEnd